@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"startdb/internal/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var adaptiveHashCmd = &cobra.Command{
+	Use:   "adaptive-hash [on|off]",
+	Short: "Show or toggle the adaptive hash index",
+	Long: `Show the hash indexes built automatically for columns that are often looked
+up by equality but have no index, with how often they answered lookups.
+Adaptive indexes live in memory and are evicted when lookups stop.
+
+Pass on or off to enable or disable adaptive hash indexing for the database.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initStorage(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer Cleanup()
+
+		if len(args) == 1 {
+			if err := setAdaptiveHash(db, args[0]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+		}
+		printAdaptiveHash(db)
+	},
+}
+
+// setAdaptiveHash enables or disables adaptive hash indexing from "on" or
+// "off"
+func setAdaptiveHash(store *storage.Storage, setting string) error {
+	switch strings.ToLower(setting) {
+	case "on":
+		return store.GetAdaptiveHashIndexes().SetEnabled(true)
+	case "off":
+		return store.GetAdaptiveHashIndexes().SetEnabled(false)
+	}
+	return fmt.Errorf("invalid setting '%s' (use 'on' or 'off')", setting)
+}
+
+// printAdaptiveHash prints whether adaptive hash indexing is enabled and
+// each adaptive index
+func printAdaptiveHash(store *storage.Storage) {
+	adaptive := store.GetAdaptiveHashIndexes()
+	if !adaptive.Enabled() {
+		fmt.Println("Adaptive hash index: off")
+		return
+	}
+	stats := adaptive.Stats()
+	fmt.Printf("Adaptive hash index: on, %d index(es)\n", len(stats))
+	for _, stat := range stats {
+		fmt.Printf("%s.%s: %d key(s), %d of %d lookup(s) answered (%.1f%% hit rate), heat %.1f\n",
+			stat.Table, stat.Column, stat.Keys, stat.Hits, stat.Lookups, stat.HitRate*100, stat.Heat)
+	}
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"startdb/internal/sql"
+	"startdb/internal/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	adviseApply    bool
+	adviseAuto     string
+	adviseBudget   int
+	adviseReset    bool
+	adviseWorkload bool
+)
+
+var adviseCmd = &cobra.Command{
+	Use:   "advise",
+	Short: "Recommend indexes for the recorded query workload",
+	Long: `Recommend indexes to create or drop, ranked by how much they are estimated
+to reduce the cost of the queries recorded so far, less the cost of
+maintaining them on writes. Candidates are single-column indexes on the
+columns queries filter by, and covering indexes that add the other columns
+a query reads with INCLUDE; multi-column keys are not recommended.
+
+With --apply the recommendations are carried out within the index budget.
+--auto on lets the database do this by itself as queries run. Indexes are
+only ever dropped automatically if they were created automatically.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initStorage(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer Cleanup()
+
+		if cmd.Flags().Changed("auto") || cmd.Flags().Changed("budget") {
+			config, err := sql.LoadAdvisorConfig(db)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			if cmd.Flags().Changed("auto") {
+				switch strings.ToLower(adviseAuto) {
+				case "on", "true", "yes", "1":
+					config.Auto = true
+				case "off", "false", "no", "0":
+					config.Auto = false
+				default:
+					fmt.Printf("Error: invalid --auto value '%s' (use 'on' or 'off')\n", adviseAuto)
+					return
+				}
+			}
+			if cmd.Flags().Changed("budget") {
+				config.Budget = adviseBudget
+			}
+			if err := sql.SaveAdvisorConfig(db, config); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+		}
+
+		if adviseReset {
+			db.GetWorkload().Clear()
+			fmt.Println("Recorded workload cleared")
+			return
+		}
+
+		if adviseWorkload {
+			printWorkload(db.GetWorkload().Shapes())
+			fmt.Println()
+		}
+
+		if adviseApply {
+			applied, err := sql.TuneIndexes(db)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			if len(applied) == 0 {
+				fmt.Println("No index changes to apply")
+			}
+			for _, rec := range applied {
+				fmt.Printf("Applied: %s\n", rec.Statement())
+			}
+			return
+		}
+
+		if err := printAdvice(db); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	adviseCmd.Flags().BoolVar(&adviseApply, "apply", false, "Create and drop indexes as recommended, within the index budget")
+	adviseCmd.Flags().StringVar(&adviseAuto, "auto", "off", "Tune indexes automatically as queries run: on or off")
+	adviseCmd.Flags().IntVar(&adviseBudget, "budget", 5, "Most indexes automatic tuning may create")
+	adviseCmd.Flags().BoolVar(&adviseReset, "reset", false, "Forget the recorded workload")
+	adviseCmd.Flags().BoolVar(&adviseWorkload, "workload", false, "Also show the recorded query shapes")
+}
+
+// printAdvice prints the advisor's recommendations and settings
+func printAdvice(store *storage.Storage) error {
+	config, err := sql.LoadAdvisorConfig(store)
+	if err != nil {
+		return err
+	}
+	recommendations, err := sql.Advise(store)
+	if err != nil {
+		return err
+	}
+
+	shapes := store.GetWorkload().Shapes()
+	if len(shapes) == 0 {
+		fmt.Println("No queries recorded yet")
+	} else if len(recommendations) == 0 {
+		fmt.Printf("No index changes recommended for %d recorded query shape(s)\n", len(shapes))
+	}
+	for i, rec := range recommendations {
+		fmt.Printf("%d. %s\n", i+1, rec.Statement())
+		fmt.Printf("   %s, estimated benefit %.1f\n", rec.Reason, rec.Benefit)
+	}
+
+	mode := "off"
+	if config.Auto {
+		mode = "on"
+	}
+	fmt.Printf("\nAutomatic tuning: %s, budget %d index(es), %d in use\n", mode, config.Budget, len(config.Indexes))
+	return nil
+}
+
+// printWorkload prints the recorded query shapes, most frequent first
+func printWorkload(shapes []storage.QueryShape) {
+	if len(shapes) == 0 {
+		fmt.Println("No queries recorded yet")
+		return
+	}
+	for _, shape := range shapes {
+		fmt.Printf("%s\n  %d run(s), mean %v, max %v\n", shape.Fingerprint, shape.Count, shape.MeanTime(), shape.MaxTime)
+	}
+}
@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+
+	"startdb/internal/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var bloomFiltersCmd = &cobra.Command{
+	Use:   "bloom-filters",
+	Short: "Show the Bloom filters of tables and indexes",
+	Long: `Show each Bloom filter with its size, its target and estimated false-positive
+rates, and how many lookups it answered without reading the table or index.
+Filters are created with WITH (bloom_fpr = <rate>) on CREATE TABLE or
+CREATE INDEX.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initStorage(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer Cleanup()
+
+		if printBloomFilters(db) == 0 {
+			fmt.Println("No Bloom filters found in database")
+		}
+	},
+}
+
+// printBloomFilters prints the Bloom filters of every table and index and
+// returns how many there are
+func printBloomFilters(store *storage.Storage) int {
+	count := 0
+	bloom := store.GetBloomEngine()
+	for _, table := range bloom.TableFilters() {
+		stats, _ := bloom.TableFilterStats(table)
+		fmt.Printf("table %s: %d bits, %d hashes, fpr %g (estimated %.4f), %d of %d lookups skipped\n",
+			table, stats.Bits, stats.Hashes, stats.TargetFPR, stats.EstimatedFPR, stats.Skipped, stats.Checks)
+		count++
+	}
+
+	indexStats := store.GetIndexManager().GetIndexStats()
+	names := make([]string, 0, len(indexStats))
+	for name := range indexStats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stat := indexStats[name]
+		if _, ok := stat["bloom_fpr"]; !ok {
+			continue
+		}
+		fmt.Printf("index %s: %d bits, %d hashes, fpr %g (estimated %.4f), %d of %d searches skipped\n",
+			name, stat["bloom_bits"], stat["bloom_hashes"], stat["bloom_fpr"], stat["bloom_estimated_fpr"], stat["bloom_skipped"], stat["bloom_checks"])
+		count++
+	}
+	return count
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"startdb/internal/sql"
+
+	"github.com/spf13/cobra"
+)
+
+var checkIndexesCmd = &cobra.Command{
+	Use:   "check-indexes",
+	Short: "Check indexes against their table data",
+	Long: `Compare every index with the rows of its table and report inconsistencies:
+  missing   a row with a value in the indexed column has no index entry
+  dangling  an index entry points at a row that does not exist
+  stale     an index entry holds a value the row no longer has
+Exits with status 1 when problems are found. Use REINDEX to rebuild an index.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initStorage(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer Cleanup()
+
+		reports, err := sql.CheckIndexes(db)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		if len(reports) == 0 {
+			fmt.Println("No indexes found in database")
+			return
+		}
+
+		problems := printIndexReports(reports)
+		if problems > 0 {
+			fmt.Printf("\nFound %d problem(s) in %d index(es)\n", problems, len(reports))
+			Cleanup()
+			os.Exit(1)
+		}
+		fmt.Printf("\nAll %d index(es) are consistent\n", len(reports))
+	},
+}
+
+// printIndexReports prints the result of each index check and returns the
+// total number of problems found
+func printIndexReports(reports []*sql.IndexReport) int {
+	problems := 0
+	for _, report := range reports {
+		status := "ok"
+		if len(report.Problems) > 0 {
+			status = fmt.Sprintf("%d problem(s)", len(report.Problems))
+		}
+		fmt.Printf("%s on %s.%s (%s): %d row(s), %d entries, %s\n",
+			report.Index, report.Table, report.Column, report.Type, report.Rows, report.Entries, status)
+		for _, problem := range report.Problems {
+			fmt.Printf("  %-8s %s: %s\n", problem.Kind, problem.RowKey, problem.Detail)
+		}
+		problems += len(report.Problems)
+	}
+	return problems
+}
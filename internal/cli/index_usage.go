@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+
+	"startdb/internal/sql"
+
+	"github.com/spf13/cobra"
+)
+
+var indexUsageCmd = &cobra.Command{
+	Use:   "index-usage",
+	Short: "Show index usage and flag indexes that are not needed",
+	Long: `Show how often each index has been used for lookups and range scans, the
+rows it returned and what maintaining it on writes has cost. Indexes that
+have never been used, or that another index on the same key makes redundant,
+are flagged as candidates for DROP INDEX.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initStorage(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer Cleanup()
+
+		reports, err := sql.IndexUsageReports(db)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		if len(reports) == 0 {
+			fmt.Println("No indexes found in database")
+			return
+		}
+
+		if flagged := printIndexUsage(reports); flagged > 0 {
+			fmt.Printf("\n%d of %d index(es) may not be needed\n", flagged, len(reports))
+		} else {
+			fmt.Printf("\nAll %d index(es) are in use\n", len(reports))
+		}
+	},
+}
+
+// printIndexUsage prints the usage of each index and returns how many were
+// flagged as unused or redundant
+func printIndexUsage(reports []*sql.IndexUsageReport) int {
+	flagged := 0
+	for _, report := range reports {
+		lastUsed := "never"
+		if !report.Usage.LastUsed.IsZero() {
+			lastUsed = report.Usage.LastUsed.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%s on %s.%s (%s): %d lookup(s), %d range scan(s), %d row(s) returned, last used %s, %d insert(s), %d delete(s), maintenance %v\n",
+			report.Index, report.Table, report.Key, report.Type, report.Usage.Lookups, report.Usage.RangeScans,
+			report.Usage.RowsReturned, lastUsed, report.Usage.Inserts, report.Usage.Deletes, report.Usage.MaintenanceTime)
+		if note := report.Note(); note != "" {
+			fmt.Printf("  %s\n", note)
+			flagged++
+		}
+	}
+	return flagged
+}
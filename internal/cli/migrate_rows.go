@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"fmt"
+
+	"startdb/internal/sql"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateRowsCmd = &cobra.Command{
+	Use:   "migrate-rows",
+	Short: "Rewrite rows stored in the old text format in the binary row format",
+	Long: `Rewrite every table row still stored in the pipe-delimited text format in
+the typed binary row format, and store the catalog record and column schema
+of tables created before those were kept. Old rows are readable without migrating, but their
+value types are guessed from their text until they are rewritten.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initStorage(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer Cleanup()
+
+		report, err := sql.MigrateRows(db)
+		if report != nil {
+			printRowMigration(report)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+	},
+}
+
+func printRowMigration(report *sql.RowMigrationReport) {
+	fmt.Printf("Migrated %d of %d row(s) in %d table(s)\n", report.Migrated, report.Rows, report.Tables)
+}
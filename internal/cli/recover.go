@@ -3,6 +3,8 @@ package cli
 import (
 	"fmt"
 
+	"startdb/internal/sql"
+
 	"github.com/spf13/cobra"
 )
 
@@ -35,6 +37,13 @@ to its last consistent state before the crash.`,
 			return
 		}
 
+		// Indexes were restored before the log was replayed, so they are
+		// rebuilt from the recovered rows
+		if _, err := sql.NewExecutor(db).Execute(&sql.ReindexStatement{}); err != nil {
+			fmt.Printf("Error rebuilding indexes: %v\n", err)
+			return
+		}
+
 		fmt.Println("Recovery completed successfully")
 		fmt.Printf("WAL file: %s\n", walStorage.GetWALPath())
 	},
@@ -5,6 +5,7 @@ import (
     "os"
     "strings"
 
+    "startdb/internal/sql"
     "startdb/internal/storage"
 
     "github.com/spf13/cobra"
@@ -80,6 +81,12 @@ func init() {
 	rootCmd.AddCommand(rollbackCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(sqlCmd)
+	rootCmd.AddCommand(checkIndexesCmd)
+	rootCmd.AddCommand(bloomFiltersCmd)
+	rootCmd.AddCommand(indexUsageCmd)
+	rootCmd.AddCommand(adviseCmd)
+	rootCmd.AddCommand(adaptiveHashCmd)
+	rootCmd.AddCommand(migrateRowsCmd)
 }
 
 func initStorage() error {
@@ -129,11 +136,32 @@ func initStorage() error {
 		return fmt.Errorf("invalid storage type: %s (use 'memory' or 'disk')", storageType)
 	}
 
+	_, skipped, err := sql.RestoreIndexes(db)
+	if err != nil {
+		return fmt.Errorf("failed to restore indexes: %w", err)
+	}
+	for _, err := range skipped {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if err := sql.LoadWorkload(db); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func Cleanup() {
 	if db != nil {
+		if err := sql.SaveIndexes(db); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		if err := sql.SaveIndexUsage(db); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		if err := sql.SaveWorkload(db); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
 		db.Close()
+		db = nil
 	}
 }
@@ -295,6 +295,79 @@ Type 'help' for available commands, 'quit' to exit.`,
 					PrintData("  DELETE %s\n", key)
 				}
 
+			case "bloom-filters":
+				if printBloomFilters(db) == 0 {
+					PrintWarning("No Bloom filters found in database\n")
+				}
+
+			case "index-usage":
+				reports, err := sql.IndexUsageReports(db)
+				if err != nil {
+					PrintError("Error: %v\n", err)
+					continue
+				}
+				if len(reports) == 0 {
+					PrintWarning("No indexes found in database\n")
+					continue
+				}
+				if flagged := printIndexUsage(reports); flagged > 0 {
+					PrintWarning("%d of %d index(es) may not be needed\n", flagged, len(reports))
+				} else {
+					PrintSuccess("All %d index(es) are in use\n", len(reports))
+				}
+
+			case "adaptive-hash":
+				if len(parts) > 1 {
+					if err := setAdaptiveHash(db, parts[1]); err != nil {
+						PrintError("Error: %v\n", err)
+						continue
+					}
+				}
+				printAdaptiveHash(db)
+
+			case "advise":
+				if len(parts) > 1 && strings.ToLower(parts[1]) == "apply" {
+					applied, err := sql.TuneIndexes(db)
+					if err != nil {
+						PrintError("Error: %v\n", err)
+					}
+					if len(applied) == 0 {
+						PrintInfo("No index changes to apply\n")
+					}
+					for _, rec := range applied {
+						PrintSuccess("Applied: %s\n", rec.Statement())
+					}
+					continue
+				}
+				if err := printAdvice(db); err != nil {
+					PrintError("Error: %v\n", err)
+				}
+
+			case "check-indexes":
+				reports, err := sql.CheckIndexes(db)
+				if err != nil {
+					PrintError("Error: %v\n", err)
+					continue
+				}
+				if len(reports) == 0 {
+					PrintWarning("No indexes found in database\n")
+					continue
+				}
+				if problems := printIndexReports(reports); problems > 0 {
+					PrintWarning("Found %d problem(s), use 'sql REINDEX' to rebuild\n", problems)
+				} else {
+					PrintSuccess("All %d index(es) are consistent\n", len(reports))
+				}
+
+			case "migrate-rows":
+				report, err := sql.MigrateRows(db)
+				if report != nil {
+					printRowMigration(report)
+				}
+				if err != nil {
+					PrintError("Error: %v\n", err)
+				}
+
 			case "sql":
 				if len(parts) < 2 {
 					PrintError("Usage: sql <query>\n")
@@ -329,7 +402,7 @@ Type 'help' for available commands, 'quit' to exit.`,
 						if i > 0 {
 							PrintMuted(" | ")
 						}
-						PrintHeader(col)
+						PrintHeader("%s", col)
 					}
 					fmt.Println()
 
@@ -378,6 +451,12 @@ func printHelp() {
 	PrintWarning("  rollback             - Rollback the current transaction\n")
 	PrintInfo("  status               - Show transaction status\n")
 	PrintSQL("  sql <query>          - Execute a SQL query\n")
+	PrintInfo("  check-indexes        - Check indexes against table data\n")
+	PrintInfo("  bloom-filters        - Show table and index Bloom filters\n")
+	PrintInfo("  index-usage          - Show index usage and flag unneeded indexes\n")
+	PrintInfo("  advise [apply]       - Recommend indexes for the recorded workload\n")
+	PrintInfo("  adaptive-hash [on|off] - Show or toggle the adaptive hash index\n")
+	PrintInfo("  migrate-rows         - Rewrite text-format rows in the binary format\n")
 	if walEnabled {
 		PrintInfo("  checkpoint           - Create a checkpoint (truncate WAL)\n")
 		PrintInfo("  recover              - Recover from crash (replay WAL)\n")
@@ -14,7 +14,8 @@ var sqlCmd = &cobra.Command{
 	Use:   "sql <query>",
 	Short: "Execute a SQL query",
 	Long: `Execute a SQL query against the database.
-Supports SELECT, INSERT, UPDATE, DELETE, CREATE TABLE, and DROP TABLE statements.`,
+Supports SELECT, INSERT, UPDATE, DELETE, CREATE TABLE, DROP TABLE, ANALYZE,
+EXPLAIN [ANALYZE], REINDEX and SHOW INDEX STATS statements.`,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := initStorage(); err != nil {
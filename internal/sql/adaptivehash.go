@@ -0,0 +1,123 @@
+package sql
+
+import (
+	"fmt"
+	"time"
+)
+
+// recordAdaptiveLookups reports the equality lookups of a single-table
+// SELECT on columns with no index to the adaptive hash index, and returns
+// the columns that have become hot enough to index. They can only be
+// indexed from a table scan, which reads every row.
+func (e *Executor) recordAdaptiveLookups(stmt *SelectStatement, plan *ExecutionPlan) []string {
+	if stmt.Where == nil {
+		return nil
+	}
+	columns, err := tableColumns(e.storage, stmt.Table)
+	if err != nil {
+		return nil
+	}
+	isColumn := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		isColumn[column] = true
+	}
+
+	adaptive := e.storage.GetAdaptiveHashIndexes()
+	var hot []string
+	seen := make(map[string]bool)
+	for _, eq := range e.planner.extractEqualities(stmt.Where) {
+		if !isColumn[eq.column] || seen[eq.column] || e.planner.findIndex(stmt.Table, eq.column, stmt.Where) != "" {
+			continue
+		}
+		seen[eq.column] = true
+		hit := plan.Type == PlanTypeAdaptiveHashScan && plan.IndexColumn == eq.column
+		if adaptive.RecordLookup(stmt.Table, eq.column, hit) && plan.Type == PlanTypeTableScan {
+			hot = append(hot, eq.column)
+		}
+	}
+	return hot
+}
+
+// buildAdaptiveIndexes installs adaptive hash indexes on the columns from
+// the rows of a table scan, which began at the table's version. Indexes are
+// not installed if the table changed during the scan.
+func (e *Executor) buildAdaptiveIndexes(table string, columns []string, version int64, rows [][]interface{}) {
+	adaptive := e.storage.GetAdaptiveHashIndexes()
+	for _, column := range columns {
+		collation := e.columnCollation(table, column)
+		entries := make(map[string][]string)
+		for _, row := range rows {
+			if value := rowColumnValue(row, column); value != nil {
+				key := e.indexKey(value, collation)
+				entries[key] = append(entries[key], rowKey(table, row))
+			}
+		}
+		adaptive.Install(table, column, version, entries)
+	}
+}
+
+// scanAdaptiveHash reads the rows an adaptive hash index holds under the
+// plan's value, falling back to a table scan if the index has been evicted
+// since the query was planned
+func (e *Executor) scanAdaptiveHash(plan *ExecutionPlan) ([][]interface{}, int, error) {
+	rowKeys, exists := e.storage.GetAdaptiveHashIndexes().Lookup(plan.Table, plan.IndexColumn, e.indexKey(plan.IndexValue, e.columnCollation(plan.Table, plan.IndexColumn)))
+	if !exists {
+		return e.scanTableRows(plan.Table)
+	}
+
+	rows, err := e.readRows(plan.Table, rowKeys)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rows, len(rowKeys), nil
+}
+
+// updateAdaptiveIndexes keeps a table's adaptive hash indexes in step with a
+// row change. oldRowData is nil for an insert and newRowData for a delete.
+func (e *Executor) updateAdaptiveIndexes(tableName, rowKey string, oldRowData, newRowData []interface{}) {
+	metadata, err := loadTableMetadata(e.storage, tableName)
+	if err != nil {
+		metadata = &TableMetadata{Name: tableName}
+	}
+	columnKey := func(rowData []interface{}) func(string) (string, bool) {
+		if rowData == nil {
+			return nil
+		}
+		return func(column string) (string, bool) {
+			value := rowColumnValue(rowData, column)
+			if value == nil {
+				return "", false
+			}
+			return e.indexKey(value, metadata.collation(column)), true
+		}
+	}
+	e.storage.GetAdaptiveHashIndexes().UpdateRow(tableName, rowKey, columnKey(oldRowData), columnKey(newRowData))
+}
+
+// adaptiveIndexStats returns a row of SHOW INDEX STATS for each adaptive
+// hash index
+func (e *Executor) adaptiveIndexStats() [][]interface{} {
+	var rows [][]interface{}
+	for _, stat := range e.storage.GetAdaptiveHashIndexes().Stats() {
+		var lastUsed interface{}
+		if !stat.LastUsed.IsZero() {
+			lastUsed = stat.LastUsed.Format(time.DateTime)
+		}
+		rows = append(rows, []interface{}{
+			fmt.Sprintf("adaptive:%s.%s", stat.Table, stat.Column),
+			stat.Table,
+			stat.Column,
+			"ADAPTIVE_HASH",
+			stat.Keys,
+			stat.Lookups,
+			int64(0),
+			nil,
+			lastUsed,
+			nil,
+			nil,
+			nil,
+			fmt.Sprintf("adaptive, %.1f%% hit rate", stat.HitRate*100),
+		})
+	}
+	return rows
+}
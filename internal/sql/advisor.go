@@ -0,0 +1,534 @@
+package sql
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"startdb/internal/storage"
+)
+
+const (
+	// advisorConfigKey is the key under which the advisor's settings are saved
+	advisorConfigKey = "_advisor_config"
+	// autoIndexInterval is the number of statements recorded between runs of
+	// automatic index tuning
+	autoIndexInterval = 100
+	// defaultIndexBudget is how many indexes automatic tuning may create when
+	// no budget has been configured
+	defaultIndexBudget = 5
+)
+
+// IndexRecommendation is an index the advisor suggests creating or dropping
+type IndexRecommendation struct {
+	Action  string // "CREATE" or "DROP"
+	Index   string
+	Table   string
+	Column  string   // what the index is keyed by: a column, columns separated by commas, or an expression
+	Columns []string // the key columns of a multi-column index to create
+	Include []string
+	Benefit float64 // estimated cost saved over the recorded workload, less the cost of maintaining the index
+	Queries int64   // recorded statements the index answers
+	Reason  string
+}
+
+// Statement returns the SQL that carries out the recommendation
+func (r *IndexRecommendation) Statement() string {
+	if r.Action == "DROP" {
+		return fmt.Sprintf("DROP INDEX %s", r.Index)
+	}
+	statement := fmt.Sprintf("CREATE INDEX %s ON %s (%s)", r.Index, r.Table, r.Column)
+	if len(r.Include) > 0 {
+		statement += fmt.Sprintf(" INCLUDE (%s)", strings.Join(r.Include, ", "))
+	}
+	return statement
+}
+
+// AdvisorConfig holds the settings of automatic index tuning
+type AdvisorConfig struct {
+	Auto    bool     `json:"auto"`              // create and drop indexes as the workload changes
+	Budget  int      `json:"budget"`            // the most indexes automatic tuning may have created at once
+	Indexes []string `json:"indexes,omitempty"` // indexes created by automatic tuning, the only ones it drops
+}
+
+// LoadAdvisorConfig reads the advisor's settings. Automatic tuning is off
+// until it is enabled.
+func LoadAdvisorConfig(store *storage.Storage) (*AdvisorConfig, error) {
+	config := &AdvisorConfig{Budget: defaultIndexBudget}
+	data, err := store.Get(advisorConfigKey)
+	if err != nil {
+		return config, nil
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("advisor settings are malformed: %w", err)
+	}
+	return config, nil
+}
+
+// SaveAdvisorConfig stores the advisor's settings
+func SaveAdvisorConfig(store *storage.Storage, config *AdvisorConfig) error {
+	if config.Budget < 0 {
+		return fmt.Errorf("index budget must not be negative, got %d", config.Budget)
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return store.Put(advisorConfigKey, data)
+}
+
+// Advise ranks the indexes that would most reduce the estimated cost of the
+// recorded workload, and flags existing indexes it does not need.
+//
+// A candidate is an index on a column some statement compares against a
+// value. A statement comparing several columns also gets a multi-column
+// candidate keyed by the columns it compares for equality, most selective
+// first, followed by its most selective range column, so one lookup finds
+// the rows matching all of them. For a SELECT reading several columns there
+// is also a covering candidate, keyed by its most selective predicate column
+// and storing the other columns it reads with INCLUDE, so the query can be
+// answered from the index alone. A candidate's benefit is the access cost it
+// saves each statement it answers, weighted by how often the statement ran,
+// less the cost of keeping the index up to date on the table's writes.
+func Advise(store *storage.Storage) ([]*IndexRecommendation, error) {
+	shapes := store.GetWorkload().Shapes()
+	existing, err := indexDefinitions(store)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*indexDefinition
+	seen := make(map[string]bool)
+	addCandidate := func(table string, columns, include []string) {
+		definition := newAdvisedDefinition(table, columns, include)
+		key := table + "." + definition.key() + "(" + strings.Join(include, ",") + ")"
+		if !seen[key] {
+			seen[key] = true
+			candidates = append(candidates, definition)
+		}
+	}
+	for _, shape := range shapes {
+		var best *storage.ShapePredicate
+		for i := range shape.Predicates {
+			predicate := &shape.Predicates[i]
+			addCandidate(shape.Table, []string{predicate.Column}, nil)
+			if best == nil || predicate.Selectivity < best.Selectivity {
+				best = predicate
+			}
+		}
+		if columns := compositeColumns(shape.Predicates); len(columns) > 1 {
+			addCandidate(shape.Table, columns, nil)
+		}
+		if best == nil || shape.Kind != "SELECT" {
+			continue
+		}
+		var include []string
+		for _, column := range shape.Columns {
+			if column != best.Column {
+				include = append(include, column)
+			}
+		}
+		if len(include) > 0 {
+			addCandidate(shape.Table, []string{best.Column}, include)
+		}
+	}
+
+	writes := tableWrites(shapes)
+	var creates []*IndexRecommendation
+	for _, candidate := range candidates {
+		benefit, queries := indexBenefit(candidate, shapes, writes, false)
+		if benefit <= 0 {
+			continue
+		}
+		creates = append(creates, &IndexRecommendation{
+			Action:  "CREATE",
+			Table:   candidate.Table,
+			Column:  candidate.key(),
+			Columns: candidate.Columns,
+			Include: candidate.Include,
+			Benefit: benefit,
+			Queries: queries,
+		})
+	}
+	sort.SliceStable(creates, func(i, j int) bool {
+		return creates[i].Benefit > creates[j].Benefit
+	})
+
+	// A candidate is not needed if an existing index, or a candidate ranked
+	// above it, answers every query it would
+	var recommendations []*IndexRecommendation
+	var accepted []*indexDefinition
+	taken := make(map[string]bool)
+	for name, definition := range existing {
+		taken[name] = true
+		accepted = append(accepted, definition)
+	}
+	for _, rec := range creates {
+		definition := rec.definition()
+		if answeredByAny(definition, accepted) {
+			continue
+		}
+		accepted = append(accepted, definition)
+		rec.Index = advisedIndexName(rec.Table, definition.keyColumns(), len(rec.Include) > 0, taken)
+		taken[rec.Index] = true
+		rec.Reason = fmt.Sprintf("answers %d recorded statement(s)", rec.Queries)
+		if len(rec.Include) > 0 {
+			rec.Reason += " from the index alone"
+		}
+		recommendations = append(recommendations, rec)
+	}
+
+	drops, err := advisedDrops(store, shapes, writes, existing)
+	if err != nil {
+		return nil, err
+	}
+	return append(recommendations, drops...), nil
+}
+
+// advisedDrops returns the existing indexes another index makes redundant,
+// and the unused column indexes no recorded statement would use
+func advisedDrops(store *storage.Storage, shapes []storage.QueryShape, writes map[string]int64, existing map[string]*indexDefinition) ([]*IndexRecommendation, error) {
+	reports, err := IndexUsageReports(store)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[string]bool)
+	for _, shape := range shapes {
+		recorded[shape.Table] = true
+	}
+
+	var drops []*IndexRecommendation
+	for _, report := range reports {
+		definition := existing[report.Index]
+		if definition == nil {
+			continue
+		}
+		benefit, queries := indexBenefit(definition, shapes, writes, true)
+		rec := &IndexRecommendation{
+			Action:  "DROP",
+			Index:   report.Index,
+			Table:   report.Table,
+			Column:  report.Key,
+			Include: definition.Include,
+			Benefit: benefit,
+			Queries: queries,
+			Reason:  report.Note(),
+		}
+		switch {
+		case report.RedundantWith != "":
+			drops = append(drops, rec)
+		case report.Unused && recorded[definition.Table] && advisable(definition) && queries == 0:
+			rec.Reason = "never used, and no recorded statement would use it"
+			drops = append(drops, rec)
+		}
+	}
+	return drops, nil
+}
+
+// newAdvisedDefinition returns the definition of a B-tree the advisor
+// considers, keyed by one column or by several in order
+func newAdvisedDefinition(table string, columns, include []string) *indexDefinition {
+	definition := &indexDefinition{Table: table, Column: columns[0], Include: include, Type: storage.IndexTypeBTree}
+	if len(columns) > 1 {
+		definition.Columns = columns
+	}
+	return definition
+}
+
+// definition returns the definition of the index a CREATE recommendation
+// creates
+func (r *IndexRecommendation) definition() *indexDefinition {
+	if len(r.Columns) > 0 {
+		return newAdvisedDefinition(r.Table, r.Columns, r.Include)
+	}
+	return newAdvisedDefinition(r.Table, []string{r.Column}, r.Include)
+}
+
+// compositeColumns returns the key of the multi-column index that answers
+// the most of a statement's predicates with one lookup: its equality
+// columns, most selective first, then its most selective range column
+func compositeColumns(predicates []storage.ShapePredicate) []string {
+	var equalities []storage.ShapePredicate
+	var bounded *storage.ShapePredicate
+	for i, predicate := range predicates {
+		if predicate.Operator == "=" {
+			equalities = append(equalities, predicate)
+		} else if bounded == nil || predicate.Selectivity < bounded.Selectivity {
+			bounded = &predicates[i]
+		}
+	}
+	sort.SliceStable(equalities, func(i, j int) bool {
+		return equalities[i].Selectivity < equalities[j].Selectivity
+	})
+
+	var columns []string
+	for _, predicate := range equalities {
+		columns = append(columns, predicate.Column)
+	}
+	if bounded != nil {
+		columns = append(columns, bounded.Column)
+	}
+	return columns
+}
+
+// shapePredicate returns a statement's predicate on a column, preferring an
+// equality, or nil if it has none
+func shapePredicate(shape storage.QueryShape, column string) *storage.ShapePredicate {
+	var found *storage.ShapePredicate
+	for i, predicate := range shape.Predicates {
+		if predicate.Column != column {
+			continue
+		}
+		if predicate.Operator == "=" {
+			return &shape.Predicates[i]
+		}
+		if found == nil {
+			found = &shape.Predicates[i]
+		}
+	}
+	return found
+}
+
+// advisable reports whether the advisor can estimate what an index is
+// worth: it must be a full B-tree or hash index on a column
+func advisable(definition *indexDefinition) bool {
+	return definition.Expression == nil && definition.Where == nil &&
+		(definition.Type == storage.IndexTypeBTree || definition.Type == storage.IndexTypeHash)
+}
+
+// indexBenefit estimates the cost an index saves over the recorded workload,
+// less the cost of maintaining it, and counts the statements it answers. A
+// statement's saving is measured against the access path it last ran with,
+// or for an index that already exists, against a table scan.
+func indexBenefit(definition *indexDefinition, shapes []storage.QueryShape, writes map[string]int64, exists bool) (float64, int64) {
+	if !advisable(definition) {
+		return 0, 0
+	}
+	covered := make(map[string]bool)
+	for _, column := range definition.coveredColumns() {
+		covered[column] = true
+	}
+
+	benefit, queries, rows := 0.0, int64(0), 0.0
+	for _, shape := range shapes {
+		if shape.Table != definition.Table {
+			continue
+		}
+		rows = shape.TableRows
+
+		// A lookup fixes the key columns the statement compares for
+		// equality, in key order, and can bound the next one by a range
+		selectivity, matched := 1.0, 0
+		for _, column := range definition.keyColumns() {
+			predicate := shapePredicate(shape, column)
+			if predicate == nil || (predicate.Operator != "=" && definition.Type != storage.IndexTypeBTree) {
+				break
+			}
+			selectivity *= predicate.Selectivity
+			matched++
+			if predicate.Operator != "=" {
+				break
+			}
+		}
+		if matched == 0 {
+			continue
+		}
+
+		indexOnly := shape.Kind == "SELECT" && shape.Columns != nil
+		for _, column := range shape.Columns {
+			indexOnly = indexOnly && covered[column]
+		}
+		cost := indexProbeCost(shape.TableRows) + shape.TableRows*selectivity*indexRowCost(indexOnly)
+		baseline := shape.AccessCost
+		if exists {
+			baseline = shape.TableRows * costRowScan
+		}
+		if cost < baseline {
+			benefit += float64(shape.Count) * (baseline - cost)
+			queries += shape.Count
+		}
+	}
+	if queries == 0 {
+		return 0, 0
+	}
+	return benefit - float64(writes[definition.Table])*indexProbeCost(rows), queries
+}
+
+// tableWrites counts the recorded statements writing to each table
+func tableWrites(shapes []storage.QueryShape) map[string]int64 {
+	writes := make(map[string]int64)
+	for _, shape := range shapes {
+		if shape.Kind != "SELECT" {
+			writes[shape.Table] += shape.Count
+		}
+	}
+	return writes
+}
+
+// indexDefinitions loads the definition of every index with metadata
+func indexDefinitions(store *storage.Storage) (map[string]*indexDefinition, error) {
+	names, err := indexNames(store)
+	if err != nil {
+		return nil, err
+	}
+	definitions := make(map[string]*indexDefinition, len(names))
+	for _, name := range names {
+		if definition, err := loadIndexDefinition(store, name); err == nil {
+			definitions[name] = definition
+		}
+	}
+	return definitions, nil
+}
+
+// answeredByAny reports whether any of the indexes answers every query the
+// definition would
+func answeredByAny(definition *indexDefinition, indexes []*indexDefinition) bool {
+	for _, other := range indexes {
+		if indexAnswers(other, definition) {
+			return true
+		}
+	}
+	return false
+}
+
+// advisedIndexName names a recommended index after its table and key
+// columns, adding a number if the name is taken
+func advisedIndexName(table string, columns []string, covering bool, taken map[string]bool) string {
+	base := fmt.Sprintf("auto_%s_%s", table, strings.Join(columns, "_"))
+	if covering {
+		base += "_covering"
+	}
+	name := base
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	return name
+}
+
+// TuneIndexes applies the advisor's recommendations within the configured
+// budget and returns those applied. Only indexes that tuning created itself
+// are ever dropped. Once the budget is used up, a new index replaces the
+// least beneficial tuned index if it is worth more.
+func TuneIndexes(store *storage.Storage) ([]*IndexRecommendation, error) {
+	return NewExecutor(store).tuneIndexes()
+}
+
+// autoIndex runs index tuning if automatic tuning is enabled. Failures are
+// not reported to the statement that triggered the run.
+func (e *Executor) autoIndex() {
+	config, err := LoadAdvisorConfig(e.storage)
+	if err != nil || !config.Auto {
+		return
+	}
+	e.tuneIndexes()
+}
+
+func (e *Executor) tuneIndexes() ([]*IndexRecommendation, error) {
+	config, err := LoadAdvisorConfig(e.storage)
+	if err != nil {
+		return nil, err
+	}
+	recommendations, err := Advise(e.storage)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := indexDefinitions(e.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tuned indexes dropped by hand are forgotten, and the rest are weighed
+	// against the workload as it is now
+	shapes := e.storage.GetWorkload().Shapes()
+	writes := tableWrites(shapes)
+	benefits := make(map[string]float64)
+	var managed []string
+	for _, name := range config.Indexes {
+		if definition, exists := existing[name]; exists {
+			managed = append(managed, name)
+			benefits[name], _ = indexBenefit(definition, shapes, writes, true)
+		}
+	}
+
+	var applied []*IndexRecommendation
+	drop := func(rec *IndexRecommendation) error {
+		if _, err := e.executeDropIndex(&DropIndexStatement{IndexName: rec.Index}); err != nil {
+			return err
+		}
+		for i, name := range managed {
+			if name == rec.Index {
+				managed = append(managed[:i], managed[i+1:]...)
+				break
+			}
+		}
+		applied = append(applied, rec)
+		return nil
+	}
+	isManaged := func(name string) bool {
+		for _, m := range managed {
+			if m == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, rec := range recommendations {
+		if rec.Action == "DROP" && isManaged(rec.Index) {
+			if err := drop(rec); err != nil {
+				return applied, err
+			}
+		}
+	}
+	for _, name := range append([]string(nil), managed...) {
+		if benefits[name] <= 0 {
+			rec := &IndexRecommendation{Action: "DROP", Index: name, Table: existing[name].Table, Column: existing[name].key(), Reason: "no longer pays for its maintenance"}
+			if err := drop(rec); err != nil {
+				return applied, err
+			}
+		}
+	}
+
+	for _, rec := range recommendations {
+		if rec.Action != "CREATE" {
+			continue
+		}
+		if len(managed) >= config.Budget {
+			weakest := ""
+			for _, name := range managed {
+				if weakest == "" || benefits[name] < benefits[weakest] {
+					weakest = name
+				}
+			}
+			if weakest == "" || benefits[weakest] >= rec.Benefit {
+				continue
+			}
+			replaced := &IndexRecommendation{Action: "DROP", Index: weakest, Table: existing[weakest].Table, Column: existing[weakest].key(), Reason: fmt.Sprintf("replaced by %s within the index budget", rec.Index)}
+			if err := drop(replaced); err != nil {
+				return applied, err
+			}
+		}
+		definition := rec.definition()
+		_, err := e.executeCreateIndex(&CreateIndexStatement{
+			IndexName: rec.Index,
+			Table:     rec.Table,
+			Column:    definition.Column,
+			Columns:   definition.Columns,
+			Include:   rec.Include,
+			IndexType: string(storage.IndexTypeBTree),
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to create index '%s': %w", rec.Index, err)
+		}
+		managed = append(managed, rec.Index)
+		benefits[rec.Index] = rec.Benefit
+		applied = append(applied, rec)
+	}
+
+	config.Indexes = managed
+	if err := SaveAdvisorConfig(e.storage, config); err != nil {
+		return applied, err
+	}
+	return applied, nil
+}
@@ -0,0 +1,454 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+// Operator names under which EXPLAIN ANALYZE records the aggregation of rows
+// into groups and the HAVING filter over them
+const (
+	opAggregate = "aggregate"
+	opHaving    = "having"
+)
+
+// aggregateFunctions holds the functions that compute one value over the
+// rows of a group, keyed by upper-case name
+var aggregateFunctions = map[string]bool{
+	"COUNT": true,
+	"SUM":   true,
+	"AVG":   true,
+	"MIN":   true,
+	"MAX":   true,
+}
+
+func isAggregateCall(call *FunctionCall) bool {
+	return aggregateFunctions[strings.ToUpper(call.Name)]
+}
+
+// containsAggregate reports whether an expression calls an aggregate
+// function
+func containsAggregate(expr Expression) bool {
+	switch e := expr.(type) {
+	case *BinaryExpression:
+		return containsAggregate(e.Left) || containsAggregate(e.Right)
+	case *FunctionCall:
+		if isAggregateCall(e) {
+			return true
+		}
+		for _, arg := range e.Args {
+			if containsAggregate(arg) {
+				return true
+			}
+		}
+	default:
+		for _, operand := range operands(expr) {
+			if containsAggregate(operand) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isAggregateQuery reports whether a SELECT returns one row per group rather
+// than one per row: it has a GROUP BY or HAVING clause, or calls an aggregate
+// function in its fields or ORDER BY
+func isAggregateQuery(stmt *SelectStatement) bool {
+	if len(stmt.GroupBy) > 0 || stmt.Having != nil {
+		return true
+	}
+	for _, expr := range append(append([]Expression{}, stmt.Fields...), stmt.OrderBy...) {
+		if containsAggregate(expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupedValue stands in for a GROUP BY key or an aggregate call in the
+// expressions evaluated over grouped rows, reading the value computed for
+// the group
+type groupedValue struct {
+	Expression Expression
+	column     string // the column of the grouped row holding the value
+}
+
+func (g *groupedValue) expressionNode() {}
+func (g *groupedValue) String() string {
+	return g.Expression.String()
+}
+
+// aggregation is how a SELECT groups its rows: the GROUP BY keys, the
+// aggregate calls computed for each group, and the statement with its
+// fields, HAVING, DISTINCT ON and ORDER BY rewritten to read them from
+// grouped rows
+type aggregation struct {
+	keys       []Expression
+	aggregates []*FunctionCall
+	stmt       *SelectStatement
+}
+
+// prepareAggregation checks the grouping of a SELECT and rewrites its
+// fields, HAVING, DISTINCT ON and ORDER BY over grouped rows. Outside of aggregate calls
+// they may only read columns the rows are grouped by. It returns nil for a
+// query that is not an aggregate query.
+func prepareAggregation(stmt *SelectStatement) (*aggregation, error) {
+	where := []Expression{stmt.Where}
+	for _, join := range stmt.Joins {
+		where = append(where, join.Condition)
+	}
+	for _, expr := range where {
+		if containsAggregate(expr) {
+			return nil, fmt.Errorf("aggregate functions are not allowed in WHERE or JOIN conditions")
+		}
+	}
+	if !isAggregateQuery(stmt) {
+		return nil, nil
+	}
+	for _, key := range stmt.GroupBy {
+		if containsAggregate(key) {
+			return nil, fmt.Errorf("aggregate functions are not allowed in GROUP BY")
+		}
+		if ident, ok := key.(*Identifier); ok && strings.HasSuffix(ident.Value, "*") {
+			return nil, fmt.Errorf("cannot GROUP BY %s", ident.Value)
+		}
+	}
+
+	agg := &aggregation{keys: stmt.GroupBy}
+	rewritten := *stmt
+	rewritten.Fields = make([]Expression, len(stmt.Fields))
+	for i, field := range stmt.Fields {
+		if ident, ok := field.(*Identifier); ok && strings.HasSuffix(ident.Value, "*") {
+			return nil, fmt.Errorf("%s cannot be selected in a query with GROUP BY or aggregate functions", ident.Value)
+		}
+		expr, err := agg.rewrite(field)
+		if err != nil {
+			return nil, err
+		}
+		rewritten.Fields[i] = expr
+	}
+	if stmt.Having != nil {
+		having, err := agg.rewrite(stmt.Having)
+		if err != nil {
+			return nil, err
+		}
+		rewritten.Having = having
+	}
+	rewritten.DistinctOn = make([]Expression, len(stmt.DistinctOn))
+	for i, expr := range stmt.DistinctOn {
+		key, err := agg.rewrite(expr)
+		if err != nil {
+			return nil, err
+		}
+		rewritten.DistinctOn[i] = key
+	}
+	rewritten.OrderBy = make([]Expression, len(stmt.OrderBy))
+	for i, expr := range stmt.OrderBy {
+		key, err := agg.rewrite(expr)
+		if err != nil {
+			return nil, err
+		}
+		rewritten.OrderBy[i] = key
+	}
+	agg.stmt = &rewritten
+	return agg, nil
+}
+
+// rewrite replaces the GROUP BY keys and aggregate calls in an expression
+// with the values computed for each group, recording the aggregate calls
+func (a *aggregation) rewrite(expr Expression) (Expression, error) {
+	if key := a.matchKey(expr); key != nil {
+		return &groupedValue{Expression: expr, column: key.String()}, nil
+	}
+	switch e := expr.(type) {
+	case *Identifier:
+		return nil, fmt.Errorf("column '%s' must appear in the GROUP BY clause or be used in an aggregate function", e.Value)
+	case *BinaryExpression:
+		left, err := a.rewrite(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := a.rewrite(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpression{Left: left, Operator: e.Operator, Right: right}, nil
+	case *FunctionCall:
+		if isAggregateCall(e) {
+			if err := checkAggregateCall(e); err != nil {
+				return nil, err
+			}
+			a.addAggregate(e)
+			return &groupedValue{Expression: e, column: e.String()}, nil
+		}
+		if e.Distinct {
+			return nil, fmt.Errorf("DISTINCT is only allowed in aggregate functions, not in %s", e.Name)
+		}
+		call := &FunctionCall{Name: e.Name, Args: make([]Expression, len(e.Args))}
+		for i, arg := range e.Args {
+			rewritten, err := a.rewrite(arg)
+			if err != nil {
+				return nil, err
+			}
+			call.Args[i] = rewritten
+		}
+		return call, nil
+	default:
+		ops := operands(expr)
+		if ops == nil {
+			return expr, nil
+		}
+		for i, operand := range ops {
+			rewritten, err := a.rewrite(operand)
+			if err != nil {
+				return nil, err
+			}
+			ops[i] = rewritten
+		}
+		return withOperands(expr, ops), nil
+	}
+}
+
+// matchKey returns the GROUP BY key an expression is, or nil. A column
+// matches a key naming the same column with or without its table.
+func (a *aggregation) matchKey(expr Expression) Expression {
+	for _, key := range a.keys {
+		if key.String() == expr.String() {
+			return key
+		}
+		keyIdent, okKey := key.(*Identifier)
+		ident, ok := expr.(*Identifier)
+		if !okKey || !ok {
+			continue
+		}
+		keyTable, keyColumn := splitColumnName(keyIdent.Value)
+		table, column := splitColumnName(ident.Value)
+		if keyColumn == column && (keyTable == "" || table == "") {
+			return key
+		}
+	}
+	return nil
+}
+
+func (a *aggregation) addAggregate(call *FunctionCall) {
+	for _, existing := range a.aggregates {
+		if existing.String() == call.String() {
+			return
+		}
+	}
+	a.aggregates = append(a.aggregates, call)
+}
+
+// checkAggregateCall checks an aggregate call's arguments: one expression,
+// or * for COUNT, holding no aggregate call itself
+func checkAggregateCall(call *FunctionCall) error {
+	name := strings.ToUpper(call.Name)
+	if len(call.Args) != 1 {
+		return fmt.Errorf("%s expects 1 argument, got %d", name, len(call.Args))
+	}
+	if ident, ok := call.Args[0].(*Identifier); ok && strings.HasSuffix(ident.Value, "*") {
+		if name != "COUNT" {
+			return fmt.Errorf("%s(%s) is not supported, only COUNT accepts *", name, ident.Value)
+		}
+		if call.Distinct {
+			return fmt.Errorf("COUNT(DISTINCT %s) is not supported", ident.Value)
+		}
+		return nil
+	}
+	if containsAggregate(call.Args[0]) {
+		return fmt.Errorf("aggregate function calls cannot be nested in %s", call.String())
+	}
+	return nil
+}
+
+// aggregateRows groups rows by the values of the GROUP BY keys, hashing each
+// row to its group, and computes the aggregates of every group. Groups come
+// out in the order their first row was read. A query without GROUP BY has
+// one group, even over no rows. Each grouped row names its values by the
+// text of the key or aggregate call they belong to.
+func (e *Executor) aggregateRows(agg *aggregation, rows [][]interface{}) ([][]interface{}, error) {
+	start := time.Now()
+	collations := make([]storage.Collation, len(agg.keys))
+	for i, key := range agg.keys {
+		collations[i] = e.expressionCollation(key)
+	}
+
+	type group struct {
+		values       []interface{}
+		accumulators []*accumulator
+	}
+	newGroup := func(values []interface{}) *group {
+		g := &group{values: values}
+		for _, call := range agg.aggregates {
+			g.accumulators = append(g.accumulators, e.newAccumulator(call))
+		}
+		return g
+	}
+
+	groups := make(map[string]*group)
+	var order []*group
+	if len(agg.keys) == 0 {
+		g := newGroup(nil)
+		groups[""] = g
+		order = append(order, g)
+	}
+
+	var key strings.Builder
+	for _, row := range rows {
+		values := make([]interface{}, len(agg.keys))
+		key.Reset()
+		for i, expr := range agg.keys {
+			value, err := e.evaluate(row, expr)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+			key.WriteString(groupKey(value, collations[i]))
+		}
+		g, exists := groups[key.String()]
+		if !exists {
+			g = newGroup(values)
+			groups[key.String()] = g
+			order = append(order, g)
+		}
+		for i, call := range agg.aggregates {
+			var value interface{} = true // COUNT(*) counts every row
+			if ident, ok := call.Args[0].(*Identifier); !ok || !strings.HasSuffix(ident.Value, "*") {
+				var err error
+				if value, err = e.evaluate(row, call.Args[0]); err != nil {
+					return nil, err
+				}
+			}
+			if err := g.accumulators[i].add(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	grouped := make([][]interface{}, 0, len(order))
+	for _, g := range order {
+		row := []interface{}{nil}
+		for i, expr := range agg.keys {
+			row = append(row, expr.String(), g.values[i])
+		}
+		for i, call := range agg.aggregates {
+			row = append(row, call.String(), g.accumulators[i].result())
+		}
+		grouped = append(grouped, row)
+	}
+	e.profile.record(opAggregate, len(grouped), 0, time.Since(start))
+	return grouped, nil
+}
+
+// groupKey encodes a value so values SQL considers equal, including under
+// the collation of their column, share a key. NULLs share a key too, as
+// GROUP BY and DISTINCT treat them as one value.
+func groupKey(value interface{}, collation storage.Collation) string {
+	key := storage.EncodeKey(collation.KeyValue(value))
+	return fmt.Sprintf("%d:%s", len(key), key)
+}
+
+// groupedRowValue returns the value a grouped row holds for a GROUP BY key
+// or aggregate call
+func groupedRowValue(rowData []interface{}, value *groupedValue) interface{} {
+	for i := 1; i+1 < len(rowData); i += 2 {
+		if rowData[i] == value.column {
+			return rowData[i+1]
+		}
+	}
+	return nil
+}
+
+// accumulator computes one aggregate call over the rows of a group. NULL
+// values are skipped, except by COUNT(*), which counts rows.
+type accumulator struct {
+	name      string
+	collation storage.Collation
+	seen      map[string]bool // the values counted so far, for DISTINCT
+	count     int64
+	intSum    int64
+	floatSum  float64
+	isFloat   bool
+	extreme   interface{}
+}
+
+func (e *Executor) newAccumulator(call *FunctionCall) *accumulator {
+	a := &accumulator{
+		name:      strings.ToUpper(call.Name),
+		collation: e.expressionCollation(call.Args[0]),
+	}
+	if call.Distinct {
+		a.seen = make(map[string]bool)
+	}
+	return a
+}
+
+func (a *accumulator) add(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	if a.seen != nil {
+		key := groupKey(value, a.collation)
+		if a.seen[key] {
+			return nil
+		}
+		a.seen[key] = true
+	}
+
+	switch a.name {
+	case "SUM", "AVG":
+		switch v := normalizeValue(value).(type) {
+		case int64:
+			sum := a.intSum + v
+			if (v > 0 && sum < a.intSum) || (v < 0 && sum > a.intSum) {
+				return fmt.Errorf("%s is out of range for a 64-bit integer", a.name)
+			}
+			a.intSum = sum
+		case float64:
+			a.floatSum += v
+			a.isFloat = true
+		default:
+			return fmt.Errorf("%s expects numbers, got '%s'", a.name, columnText(value))
+		}
+	case "MIN", "MAX":
+		if a.extreme == nil {
+			a.extreme = value
+			break
+		}
+		cmp := compareCollated(normalizeValue(value), normalizeValue(a.extreme), a.collation)
+		if (a.name == "MIN" && cmp < 0) || (a.name == "MAX" && cmp > 0) {
+			a.extreme = value
+		}
+	}
+	a.count++
+	return nil
+}
+
+// result returns the aggregate of the values added. SUM, AVG, MIN and MAX of
+// no values are NULL; COUNT of no values is 0. SUM of integers is an
+// integer, and AVG is always a float.
+func (a *accumulator) result() interface{} {
+	switch a.name {
+	case "COUNT":
+		return a.count
+	case "SUM":
+		if a.count == 0 {
+			return nil
+		}
+		if a.isFloat {
+			return float64(a.intSum) + a.floatSum
+		}
+		return a.intSum
+	case "AVG":
+		if a.count == 0 {
+			return nil
+		}
+		return (float64(a.intSum) + a.floatSum) / float64(a.count)
+	default:
+		return a.extreme
+	}
+}
@@ -2,7 +2,10 @@ package sql
 
 import (
 	"fmt"
+	"strings"
 	"time"
+
+	"startdb/internal/storage"
 )
 
 // Node represents a node in the Abstract Syntax Tree
@@ -35,18 +38,36 @@ const (
 type JoinClause struct {
 	Type      JoinType
 	Table     string
-	Condition Expression // ON condition
+	Alias     string           // the name the table is referred to by, if given
+	Subquery  *SelectStatement // the SELECT of a derived table, read under the name in Table
+	Condition Expression       // ON condition
 }
 
 // SelectStatement represents a SELECT statement
 type SelectStatement struct {
-	Fields    []Expression
-	Table     string
-	Joins     []*JoinClause
-	Where     Expression
-	OrderBy   []Expression
-	Limit     int
-	Offset    int
+	Distinct   bool         // duplicate result rows are removed
+	DistinctOn []Expression // with DISTINCT ON, the keys whose first row is kept
+	Fields     []Expression
+	Aliases    []string // the AS name of each field, empty if none
+	Table      string
+	TableAlias string           // the name the table is referred to by, if given
+	Subquery   *SelectStatement // the SELECT of a derived table, read under the name in Table
+	Joins      []*JoinClause
+	Where      Expression
+	GroupBy    []Expression
+	Having     Expression
+	OrderBy    []Expression
+	Ordering   []SortOrder // the direction of each ORDER BY key
+	Limit      int
+	HasLimit   bool // a LIMIT was given, so a Limit of 0 returns no rows
+	Offset     int
+}
+
+// SortOrder is the direction and NULL placement of an ORDER BY key. The zero
+// value sorts ascending with NULLs last.
+type SortOrder struct {
+	Descending bool
+	NullsFirst bool
 }
 
 func (s *SelectStatement) statementNode() {}
@@ -54,6 +75,68 @@ func (s *SelectStatement) String() string {
 	return "SELECT statement"
 }
 
+// selectText renders a SELECT statement as SQL
+func selectText(s *SelectStatement) string {
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	if s.Distinct {
+		b.WriteString("DISTINCT ")
+		if len(s.DistinctOn) > 0 {
+			b.WriteString("ON (" + expressionList(s.DistinctOn) + ") ")
+		}
+	}
+	fields := make([]string, len(s.Fields))
+	for i, field := range s.Fields {
+		fields[i] = field.String()
+		if i < len(s.Aliases) && s.Aliases[i] != "" {
+			fields[i] += " AS " + s.Aliases[i]
+		}
+	}
+	b.WriteString(strings.Join(fields, ", "))
+	b.WriteString(" FROM " + sourceText(s.Table, s.TableAlias, s.Subquery))
+	for _, join := range s.Joins {
+		fmt.Fprintf(&b, " %s JOIN %s ON %s", join.Type, sourceText(join.Table, join.Alias, join.Subquery), join.Condition.String())
+	}
+	if s.Where != nil {
+		b.WriteString(" WHERE " + s.Where.String())
+	}
+	if len(s.GroupBy) > 0 {
+		b.WriteString(" GROUP BY " + expressionList(s.GroupBy))
+	}
+	if s.Having != nil {
+		b.WriteString(" HAVING " + s.Having.String())
+	}
+	if len(s.OrderBy) > 0 {
+		b.WriteString(" ORDER BY " + orderText(s.OrderBy, s.Ordering))
+	}
+	if s.HasLimit {
+		fmt.Fprintf(&b, " LIMIT %d", s.Limit)
+	}
+	if s.Offset > 0 {
+		fmt.Fprintf(&b, " OFFSET %d", s.Offset)
+	}
+	return b.String()
+}
+
+// sourceText renders a table of a FROM clause with its alias
+func sourceText(table, alias string, subquery *SelectStatement) string {
+	if subquery != nil {
+		table = "(" + selectText(subquery) + ")"
+	}
+	if alias != "" {
+		return table + " " + alias
+	}
+	return table
+}
+
+func expressionList(exprs []Expression) string {
+	items := make([]string, len(exprs))
+	for i, expr := range exprs {
+		items[i] = expr.String()
+	}
+	return strings.Join(items, ", ")
+}
+
 // InsertStatement represents an INSERT statement
 type InsertStatement struct {
 	Table   string
@@ -93,6 +176,7 @@ func (d *DeleteStatement) String() string {
 type CreateTableStatement struct {
 	Table   string
 	Columns []ColumnDefinition
+	Options map[string]string // WITH (name = value, ...) options
 }
 
 func (c *CreateTableStatement) statementNode() {}
@@ -102,10 +186,12 @@ func (c *CreateTableStatement) String() string {
 
 // ColumnDefinition represents a column definition
 type ColumnDefinition struct {
-	Name     string
-	Type     string
-	Nullable bool
-	Default  Expression
+	Name      string
+	Type      string
+	Nullable  bool
+	Default   Expression
+	Collation string // COLLATE name, empty for the default binary collation
+	Unique    bool
 }
 
 // DropTableStatement represents a DROP TABLE statement
@@ -120,10 +206,15 @@ func (d *DropTableStatement) String() string {
 
 // CreateIndexStatement represents a CREATE INDEX statement
 type CreateIndexStatement struct {
-	IndexName string
-	Table     string
-	Column    string
-	IndexType string // "BTREE" or "HASH", defaults to "BTREE"
+	IndexName  string
+	Table      string
+	Column     string
+	Columns    []string          // every key column of a multi-column index, starting with Column
+	Expression Expression        // the indexed expression, such as lower(email), when not a plain column
+	Include    []string          // INCLUDE (cols) stored in each entry of a covering index
+	IndexType  string            // "BTREE", "HASH", "FULLTEXT" or "HNSW", defaults to "BTREE"
+	Options    map[string]string // WITH (name = value, ...) settings, such as the HNSW metric
+	Where      Expression        // predicate of a partial index
 }
 
 func (c *CreateIndexStatement) statementNode() {}
@@ -141,6 +232,48 @@ func (d *DropIndexStatement) String() string {
 	return "DROP INDEX statement"
 }
 
+// AnalyzeStatement represents an ANALYZE statement. An empty Table analyzes
+// every table.
+type AnalyzeStatement struct {
+	Table string
+}
+
+func (a *AnalyzeStatement) statementNode() {}
+func (a *AnalyzeStatement) String() string {
+	return "ANALYZE statement"
+}
+
+// ReindexStatement represents a REINDEX statement. Name is an index or a
+// table, or empty to rebuild every index.
+type ReindexStatement struct {
+	Name string
+}
+
+func (r *ReindexStatement) statementNode() {}
+func (r *ReindexStatement) String() string {
+	return "REINDEX statement"
+}
+
+// ShowIndexStatsStatement represents a SHOW INDEX STATS statement
+type ShowIndexStatsStatement struct{}
+
+func (s *ShowIndexStatsStatement) statementNode() {}
+func (s *ShowIndexStatsStatement) String() string {
+	return "SHOW INDEX STATS statement"
+}
+
+// ExplainStatement represents an EXPLAIN statement. With Analyze set the
+// statement is executed and the plan reports actual figures.
+type ExplainStatement struct {
+	Statement Statement
+	Analyze   bool
+}
+
+func (e *ExplainStatement) statementNode() {}
+func (e *ExplainStatement) String() string {
+	return "EXPLAIN statement"
+}
+
 // Expression types
 
 // Identifier represents a column or table name
@@ -163,14 +296,19 @@ func (s *StringLiteral) String() string {
 	return "'" + s.Value + "'"
 }
 
-// NumberLiteral represents a numeric literal
+// NumberLiteral represents a numeric literal. Value is an int64 for a whole
+// number written without a decimal point or exponent, and a float64 otherwise.
 type NumberLiteral struct {
-	Value float64
+	Value interface{}
+	Text  string // the literal as written
 }
 
 func (n *NumberLiteral) expressionNode() {}
 func (n *NumberLiteral) String() string {
-	return fmt.Sprintf("%g", n.Value)
+	if n.Text != "" {
+		return n.Text
+	}
+	return columnText(n.Value)
 }
 
 // BooleanLiteral represents a boolean literal
@@ -194,6 +332,16 @@ func (n *NullLiteral) String() string {
 	return "NULL"
 }
 
+// VectorLiteral represents a vector literal (e.g., [0.1, 0.2, 0.3])
+type VectorLiteral struct {
+	Values []float64
+}
+
+func (v *VectorLiteral) expressionNode() {}
+func (v *VectorLiteral) String() string {
+	return storage.FormatVector(v.Values)
+}
+
 // BinaryExpression represents a binary operation (e.g., a = b, a > b)
 type BinaryExpression struct {
 	Left     Expression
@@ -203,18 +351,272 @@ type BinaryExpression struct {
 
 func (b *BinaryExpression) expressionNode() {}
 func (b *BinaryExpression) String() string {
-	return b.Left.String() + " " + b.Operator + " " + b.Right.String()
+	precedence := getOperatorPrecedence(b.Operator)
+	return operandText(b.Left, precedence) + " " + b.Operator + " " + operandText(b.Right, precedence+1)
+}
+
+// operandText renders an operand of a binary operator, parenthesized if it
+// binds looser than precedence. Operators associate to the left, so a right
+// operand of equal precedence is parenthesized too.
+func operandText(operand Expression, precedence int) string {
+	if b, ok := operand.(*BinaryExpression); ok && getOperatorPrecedence(b.Operator) < precedence {
+		return "(" + operand.String() + ")"
+	}
+	return operand.String()
+}
+
+// UnaryExpression represents an operator applied to one operand (e.g., NOT a)
+type UnaryExpression struct {
+	Operator string
+	Operand  Expression
+}
+
+func (u *UnaryExpression) expressionNode() {}
+func (u *UnaryExpression) String() string {
+	if u.Operator == "NOT" {
+		return "NOT (" + u.Operand.String() + ")"
+	}
+	return u.Operator + operandText(u.Operand, getOperatorPrecedence("*")+1)
+}
+
+// CaseExpression represents a CASE expression, either simple
+// (CASE a WHEN 1 THEN 'one' END) or searched (CASE WHEN a > 1 THEN 'many' END)
+type CaseExpression struct {
+	Operand Expression // nil for a searched CASE
+	Whens   []WhenClause
+	Else    Expression // nil when there is no ELSE
+}
+
+// WhenClause is one WHEN ... THEN ... of a CASE expression
+type WhenClause struct {
+	Condition Expression
+	Result    Expression
+}
+
+func (c *CaseExpression) expressionNode() {}
+func (c *CaseExpression) String() string {
+	text := "CASE"
+	if c.Operand != nil {
+		text += " " + c.Operand.String()
+	}
+	for _, when := range c.Whens {
+		text += " WHEN " + when.Condition.String() + " THEN " + when.Result.String()
+	}
+	if c.Else != nil {
+		text += " ELSE " + c.Else.String()
+	}
+	return text + " END"
+}
+
+// InExpression represents a membership test (e.g., a IN (1, 2, 3) or
+// a IN (SELECT b FROM t))
+type InExpression struct {
+	Left     Expression
+	List     []Expression
+	Subquery *SubqueryExpression // the SELECT giving the values in place of List, if any
+	Not      bool
+}
+
+func (i *InExpression) expressionNode() {}
+func (i *InExpression) String() string {
+	if i.Subquery != nil {
+		return i.Left.String() + notText(i.Not) + " IN " + i.Subquery.String()
+	}
+	return i.Left.String() + notText(i.Not) + " IN (" + expressionList(i.List) + ")"
+}
+
+// SubqueryExpression represents a SELECT nested in an expression. On its own
+// it is a scalar subquery, whose value is that of its one column in its one
+// row.
+type SubqueryExpression struct {
+	Select *SelectStatement
+	// Params holds the values of the enclosing query the subquery refers
+	// to, evaluated over the enclosing query's rows. They are gathered when
+	// the subquery is prepared; a subquery without any is uncorrelated.
+	Params []Expression
+}
+
+func (s *SubqueryExpression) expressionNode() {}
+func (s *SubqueryExpression) String() string {
+	return "(" + selectText(s.Select) + ")"
+}
+
+// ExistsExpression represents a test for rows (e.g., NOT EXISTS (SELECT ...))
+type ExistsExpression struct {
+	Subquery *SubqueryExpression
+	Not      bool
+}
+
+func (e *ExistsExpression) expressionNode() {}
+func (e *ExistsExpression) String() string {
+	if e.Not {
+		return "NOT EXISTS " + e.Subquery.String()
+	}
+	return "EXISTS " + e.Subquery.String()
+}
+
+// BetweenExpression represents a range test (e.g., a BETWEEN 1 AND 10)
+type BetweenExpression struct {
+	Expr  Expression
+	Lower Expression
+	Upper Expression
+	Not   bool
+}
+
+func (b *BetweenExpression) expressionNode() {}
+func (b *BetweenExpression) String() string {
+	return b.Expr.String() + notText(b.Not) + " BETWEEN " + b.Lower.String() + " AND " + b.Upper.String()
+}
+
+// LikeExpression represents a pattern match (e.g., a LIKE 'x%' ESCAPE '!')
+type LikeExpression struct {
+	Left            Expression
+	Pattern         Expression
+	Escape          Expression // nil when there is no ESCAPE clause
+	CaseInsensitive bool       // ILIKE
+	Not             bool
+}
+
+func (l *LikeExpression) expressionNode() {}
+func (l *LikeExpression) String() string {
+	operator := " LIKE "
+	if l.CaseInsensitive {
+		operator = " ILIKE "
+	}
+	text := l.Left.String() + notText(l.Not) + operator + l.Pattern.String()
+	if l.Escape != nil {
+		text += " ESCAPE " + l.Escape.String()
+	}
+	return text
+}
+
+// IsNullExpression represents a NULL test (e.g., a IS NOT NULL)
+type IsNullExpression struct {
+	Expr Expression
+	Not  bool
+}
+
+func (i *IsNullExpression) expressionNode() {}
+func (i *IsNullExpression) String() string {
+	if i.Not {
+		return i.Expr.String() + " IS NOT NULL"
+	}
+	return i.Expr.String() + " IS NULL"
+}
+
+// notText returns the NOT of a negated predicate
+func notText(not bool) string {
+	if not {
+		return " NOT"
+	}
+	return ""
+}
+
+// operands returns the expressions an operator or predicate applies to, in
+// the order they are written. Columns, literals and function calls have none.
+// A subquery's operands are its parameters, the values of the enclosing query
+// it reads.
+func operands(expr Expression) []Expression {
+	switch e := expr.(type) {
+	case *BinaryExpression:
+		return []Expression{e.Left, e.Right}
+	case *UnaryExpression:
+		return []Expression{e.Operand}
+	case *InExpression:
+		if e.Subquery != nil {
+			return append([]Expression{e.Left}, e.Subquery.Params...)
+		}
+		return append([]Expression{e.Left}, e.List...)
+	case *SubqueryExpression:
+		return append([]Expression(nil), e.Params...)
+	case *ExistsExpression:
+		return append([]Expression(nil), e.Subquery.Params...)
+	case *BetweenExpression:
+		return []Expression{e.Expr, e.Lower, e.Upper}
+	case *LikeExpression:
+		if e.Escape != nil {
+			return []Expression{e.Left, e.Pattern, e.Escape}
+		}
+		return []Expression{e.Left, e.Pattern}
+	case *IsNullExpression:
+		return []Expression{e.Expr}
+	case *CaseExpression:
+		var ops []Expression
+		if e.Operand != nil {
+			ops = append(ops, e.Operand)
+		}
+		for _, when := range e.Whens {
+			ops = append(ops, when.Condition, when.Result)
+		}
+		if e.Else != nil {
+			ops = append(ops, e.Else)
+		}
+		return ops
+	}
+	return nil
+}
+
+// withOperands returns a copy of an operator or predicate applied to new
+// operands, given in the order operands returns them
+func withOperands(expr Expression, ops []Expression) Expression {
+	switch e := expr.(type) {
+	case *BinaryExpression:
+		return &BinaryExpression{Left: ops[0], Operator: e.Operator, Right: ops[1]}
+	case *UnaryExpression:
+		return &UnaryExpression{Operator: e.Operator, Operand: ops[0]}
+	case *InExpression:
+		if e.Subquery != nil {
+			return &InExpression{Left: ops[0], Subquery: &SubqueryExpression{Select: e.Subquery.Select, Params: ops[1:]}, Not: e.Not}
+		}
+		return &InExpression{Left: ops[0], List: ops[1:], Not: e.Not}
+	case *SubqueryExpression:
+		return &SubqueryExpression{Select: e.Select, Params: ops}
+	case *ExistsExpression:
+		return &ExistsExpression{Subquery: &SubqueryExpression{Select: e.Subquery.Select, Params: ops}, Not: e.Not}
+	case *BetweenExpression:
+		return &BetweenExpression{Expr: ops[0], Lower: ops[1], Upper: ops[2], Not: e.Not}
+	case *LikeExpression:
+		like := &LikeExpression{Left: ops[0], Pattern: ops[1], CaseInsensitive: e.CaseInsensitive, Not: e.Not}
+		if len(ops) > 2 {
+			like.Escape = ops[2]
+		}
+		return like
+	case *IsNullExpression:
+		return &IsNullExpression{Expr: ops[0], Not: e.Not}
+	case *CaseExpression:
+		c := &CaseExpression{}
+		if e.Operand != nil {
+			c.Operand, ops = ops[0], ops[1:]
+		}
+		for range e.Whens {
+			c.Whens = append(c.Whens, WhenClause{Condition: ops[0], Result: ops[1]})
+			ops = ops[2:]
+		}
+		if e.Else != nil {
+			c.Else = ops[0]
+		}
+		return c
+	}
+	return expr
 }
 
 // FunctionCall represents a function call (e.g., COUNT(*), MAX(column))
 type FunctionCall struct {
-	Name string
-	Args []Expression
+	Name     string
+	Args     []Expression
+	Distinct bool // the arguments of an aggregate are counted once each
 }
 
 func (f *FunctionCall) expressionNode() {}
 func (f *FunctionCall) String() string {
-	return f.Name + "()"
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = arg.String()
+	}
+	if f.Distinct {
+		return f.Name + "(DISTINCT " + strings.Join(args, ", ") + ")"
+	}
+	return f.Name + "(" + strings.Join(args, ", ") + ")"
 }
 
 // QueryResult represents the result of a query execution
@@ -234,8 +636,10 @@ type TableMetadata struct {
 
 // ColumnMetadata represents metadata about a column
 type ColumnMetadata struct {
-	Name     string
-	Type     string
-	Nullable bool
-	Default  interface{}
+	Name      string
+	Type      string
+	Nullable  bool
+	Default   interface{}
+	Collation storage.Collation
+	Unique    bool
 }
@@ -0,0 +1,178 @@
+package sql
+
+import (
+	"fmt"
+)
+
+// expressionJSON is the form in which an expression AST is stored in
+// metadata, such as the key expression and predicate of an index
+type expressionJSON struct {
+	Kind     string            `json:"kind"`
+	Name     string            `json:"name,omitempty"`
+	Operator string            `json:"op,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Number   float64           `json:"number,omitempty"`
+	Bool     bool              `json:"bool,omitempty"`
+	Not      bool              `json:"not,omitempty"`
+	Vector   []float64         `json:"vector,omitempty"`
+	Left     *expressionJSON   `json:"left,omitempty"`
+	Right    *expressionJSON   `json:"right,omitempty"`
+	Args     []*expressionJSON `json:"args,omitempty"`
+}
+
+// encodeExpression converts an expression to its stored form. A nil
+// expression encodes as nil.
+func encodeExpression(expr Expression) (*expressionJSON, error) {
+	switch e := expr.(type) {
+	case nil:
+		return nil, nil
+	case *Identifier:
+		return &expressionJSON{Kind: "identifier", Name: e.Value}, nil
+	case *StringLiteral:
+		return &expressionJSON{Kind: "string", Text: e.Value}, nil
+	case *NumberLiteral:
+		return &expressionJSON{Kind: "number", Number: toFloat(e.Value), Text: e.String()}, nil
+	case *BooleanLiteral:
+		return &expressionJSON{Kind: "boolean", Bool: e.Value}, nil
+	case *NullLiteral:
+		return &expressionJSON{Kind: "null"}, nil
+	case *VectorLiteral:
+		return &expressionJSON{Kind: "vector", Vector: e.Values}, nil
+	case *BinaryExpression:
+		left, err := encodeExpression(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := encodeExpression(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &expressionJSON{Kind: "binary", Operator: e.Operator, Left: left, Right: right}, nil
+	case *FunctionCall:
+		node := &expressionJSON{Kind: "function", Name: e.Name}
+		for _, arg := range e.Args {
+			encoded, err := encodeExpression(arg)
+			if err != nil {
+				return nil, err
+			}
+			node.Args = append(node.Args, encoded)
+		}
+		return node, nil
+	case *UnaryExpression:
+		return encodePredicate(expr, "unary", e.Operator, false)
+	case *InExpression:
+		return encodePredicate(expr, "in", "", e.Not)
+	case *BetweenExpression:
+		return encodePredicate(expr, "between", "", e.Not)
+	case *LikeExpression:
+		operator := "LIKE"
+		if e.CaseInsensitive {
+			operator = "ILIKE"
+		}
+		return encodePredicate(expr, "like", operator, e.Not)
+	case *IsNullExpression:
+		return encodePredicate(expr, "is_null", "", e.Not)
+	default:
+		return nil, fmt.Errorf("cannot store expression %s", expr.String())
+	}
+}
+
+// encodePredicate stores a unary operator or a predicate with its operands
+// as arguments
+func encodePredicate(expr Expression, kind, operator string, not bool) (*expressionJSON, error) {
+	node := &expressionJSON{Kind: kind, Operator: operator, Not: not}
+	for _, operand := range operands(expr) {
+		encoded, err := encodeExpression(operand)
+		if err != nil {
+			return nil, err
+		}
+		node.Args = append(node.Args, encoded)
+	}
+	return node, nil
+}
+
+// decodeExpression converts a stored expression back to its AST. A nil node
+// decodes as a nil expression.
+func decodeExpression(node *expressionJSON) (Expression, error) {
+	if node == nil {
+		return nil, nil
+	}
+
+	switch node.Kind {
+	case "identifier":
+		return &Identifier{Value: node.Name}, nil
+	case "string":
+		return &StringLiteral{Value: node.Text}, nil
+	case "number":
+		// Numbers stored without their text were all read as floats
+		if node.Text == "" {
+			return &NumberLiteral{Value: node.Number}, nil
+		}
+		return parseNumber(node.Text)
+	case "boolean":
+		return &BooleanLiteral{Value: node.Bool}, nil
+	case "null":
+		return &NullLiteral{}, nil
+	case "vector":
+		return &VectorLiteral{Values: node.Vector}, nil
+	case "binary":
+		left, err := decodeExpression(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := decodeExpression(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		if left == nil || right == nil {
+			return nil, fmt.Errorf("binary expression is missing an operand")
+		}
+		return &BinaryExpression{Left: left, Operator: node.Operator, Right: right}, nil
+	case "function":
+		call := &FunctionCall{Name: node.Name}
+		for _, arg := range node.Args {
+			decoded, err := decodeExpression(arg)
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, decoded)
+		}
+		return call, nil
+	case "unary", "in", "between", "like", "is_null":
+		var ops []Expression
+		for _, arg := range node.Args {
+			decoded, err := decodeExpression(arg)
+			if err != nil {
+				return nil, err
+			}
+			if decoded == nil {
+				return nil, fmt.Errorf("%s expression is missing an operand", node.Kind)
+			}
+			ops = append(ops, decoded)
+		}
+
+		var predicate Expression
+		minimum, maximum := 1, 1
+		switch node.Kind {
+		case "unary":
+			predicate = &UnaryExpression{Operator: node.Operator}
+		case "in":
+			predicate = &InExpression{Not: node.Not}
+			minimum, maximum = 2, len(ops)
+		case "between":
+			predicate = &BetweenExpression{Not: node.Not}
+			minimum, maximum = 3, 3
+		case "like":
+			predicate = &LikeExpression{CaseInsensitive: node.Operator == "ILIKE", Not: node.Not}
+			minimum, maximum = 2, 3
+		case "is_null":
+			predicate = &IsNullExpression{Not: node.Not}
+		}
+		if len(ops) < minimum || len(ops) > maximum {
+			return nil, fmt.Errorf("%s expression has %d operands", node.Kind, len(ops))
+		}
+		return withOperands(predicate, ops), nil
+	default:
+		return nil, fmt.Errorf("unknown expression kind %q", node.Kind)
+	}
+}
@@ -0,0 +1,52 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+
+	"startdb/internal/storage"
+)
+
+// bloomOption is the WITH option giving the target false-positive rate of a
+// table's or index's Bloom filter. Tables and indexes without it have none.
+const bloomOption = "bloom_fpr"
+
+// parseFalsePositiveRate parses the value of the bloom_fpr option
+func parseFalsePositiveRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': expected a number", bloomOption, value)
+	}
+	if err := storage.ValidateFalsePositiveRate(rate); err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", bloomOption, err)
+	}
+	return rate, nil
+}
+
+// tableBloomRate returns the false-positive rate CREATE TABLE options ask
+// for, or 0 for no filter
+func tableBloomRate(options map[string]string) (float64, error) {
+	for option := range options {
+		if option != bloomOption {
+			return 0, fmt.Errorf("unknown option '%s' for table", option)
+		}
+	}
+	if value, ok := options[bloomOption]; ok {
+		return parseFalsePositiveRate(value)
+	}
+	return 0, nil
+}
+
+// applyIndexBloomFilter builds the Bloom filter an index's definition asks
+// for over the index's keys
+func (e *Executor) applyIndexBloomFilter(indexName string, definition *indexDefinition) error {
+	value, ok := definition.Options[bloomOption]
+	if !ok {
+		return nil
+	}
+	rate, err := parseFalsePositiveRate(value)
+	if err != nil {
+		return err
+	}
+	return e.storage.GetIndexManager().SetBloomFilter(indexName, rate)
+}
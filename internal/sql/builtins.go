@@ -0,0 +1,243 @@
+package sql
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"startdb/internal/storage"
+)
+
+// builtinFunctions are the scalar functions every database has
+var builtinFunctions = []Function{
+	{Name: "LOWER", Args: []string{"TEXT"}, Returns: "TEXT", Call: stringFunction(strings.ToLower)},
+	{Name: "UPPER", Args: []string{"TEXT"}, Returns: "TEXT", Call: stringFunction(strings.ToUpper)},
+	{Name: "TRIM", Args: []string{"TEXT"}, Returns: "TEXT", Call: stringFunction(strings.TrimSpace)},
+	{Name: "LENGTH", Args: []string{"TEXT"}, Returns: "INT", Call: lengthFunction},
+	{Name: "SUBSTR", Args: []string{"TEXT", "INT", "INT"}, Optional: 1, Returns: "TEXT", Call: substrFunction},
+	{Name: "REPLACE", Args: []string{"TEXT", "TEXT", "TEXT"}, Returns: "TEXT", Call: replaceFunction},
+
+	{Name: "ABS", Args: []string{TypeNumber}, Returns: TypeNumber, Call: absFunction},
+	{Name: "ROUND", Args: []string{TypeNumber, "INT"}, Optional: 1, Returns: TypeNumber, Call: roundFunction},
+	{Name: "FLOOR", Args: []string{TypeNumber}, Returns: TypeNumber, Call: floatFunction(math.Floor)},
+	{Name: "CEIL", Args: []string{TypeNumber}, Returns: TypeNumber, Call: floatFunction(math.Ceil)},
+	{Name: "MOD", Args: []string{TypeNumber, TypeNumber}, Returns: TypeNumber, Call: modFunction},
+
+	{Name: "COALESCE", Args: []string{TypeAny}, Variadic: true, NullInput: true, Returns: TypeAny, Call: coalesceFunction},
+	{Name: "NULLIF", Args: []string{TypeAny, TypeAny}, NullInput: true, Returns: TypeAny, Call: nullifFunction},
+
+	{Name: "NOW", Returns: "TIMESTAMP", Volatile: true, Call: nowFunction},
+	{Name: "DATE_TRUNC", Args: []string{"TEXT", "TIMESTAMP"}, Returns: "TIMESTAMP", Call: dateTruncFunction},
+	{Name: "EXTRACT", Args: []string{"TEXT", "TIMESTAMP"}, Returns: TypeNumber, Call: extractFunction},
+}
+
+func init() {
+	for _, fn := range builtinFunctions {
+		if err := RegisterFunction(fn); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// stringFunction adapts a string transformation to a one-argument function
+func stringFunction(transform func(string) string) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		return transform(args[0].(string)), nil
+	}
+}
+
+func lengthFunction(args []interface{}) (interface{}, error) {
+	return int64(utf8.RuneCountInString(args[0].(string))), nil
+}
+
+// substrFunction returns the characters of a string from a 1-based
+// position, all of them or as many as asked for. Positions outside the
+// string are skipped, so substr('abc', 0, 2) is 'a'.
+func substrFunction(args []interface{}) (interface{}, error) {
+	runes := []rune(args[0].(string))
+	start := args[1].(int64)
+	end := int64(len(runes)) + 1
+	if len(args) == 3 {
+		count := args[2].(int64)
+		if count < 0 {
+			return nil, fmt.Errorf("negative length %d", count)
+		}
+		if start <= end-count {
+			end = start + count
+		}
+	}
+	start = max(start, 1)
+	if start >= end {
+		return "", nil
+	}
+	return string(runes[start-1 : end-1]), nil
+}
+
+func replaceFunction(args []interface{}) (interface{}, error) {
+	return strings.ReplaceAll(args[0].(string), args[1].(string), args[2].(string)), nil
+}
+
+func absFunction(args []interface{}) (interface{}, error) {
+	if i, ok := args[0].(int64); ok {
+		if i == math.MinInt64 {
+			return nil, fmt.Errorf("%d is out of range for a 64-bit integer", i)
+		}
+		if i < 0 {
+			return -i, nil
+		}
+		return i, nil
+	}
+	return math.Abs(args[0].(float64)), nil
+}
+
+// roundFunction rounds a number half away from zero, to a number of decimal
+// places if given; a negative number of places rounds to tens, hundreds and
+// so on
+func roundFunction(args []interface{}) (interface{}, error) {
+	places := int64(0)
+	if len(args) == 2 {
+		places = args[1].(int64)
+	}
+	if i, ok := args[0].(int64); ok && places >= 0 {
+		return i, nil
+	}
+
+	scale := math.Pow(10, float64(places))
+	rounded := math.Round(toFloat(args[0])*scale) / scale
+	if _, isInt := args[0].(int64); isInt {
+		if rounded < math.MinInt64 || rounded >= math.MaxInt64 {
+			return nil, fmt.Errorf("%g is out of range for a 64-bit integer", rounded)
+		}
+		return int64(rounded), nil
+	}
+	return rounded, nil
+}
+
+// floatFunction adapts a rounding function on floats to a function that
+// leaves integers as they are
+func floatFunction(round func(float64) float64) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if f, ok := args[0].(float64); ok {
+			return round(f), nil
+		}
+		return args[0], nil
+	}
+}
+
+// modFunction returns the remainder of dividing two numbers, which has the
+// sign of the dividend
+func modFunction(args []interface{}) (interface{}, error) {
+	a, leftInt := args[0].(int64)
+	b, rightInt := args[1].(int64)
+	if leftInt && rightInt {
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return a % b, nil
+	}
+	divisor := toFloat(args[1])
+	if divisor == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return math.Mod(toFloat(args[0]), divisor), nil
+}
+
+// coalesceFunction returns its first argument that is not NULL
+func coalesceFunction(args []interface{}) (interface{}, error) {
+	for _, arg := range args {
+		if arg != nil {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
+
+// nullifFunction returns NULL if its arguments are equal, else the first
+func nullifFunction(args []interface{}) (interface{}, error) {
+	if args[0] == nil || args[1] == nil {
+		return args[0], nil
+	}
+	a, b, err := coerceOperands(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if compareCollated(a, b, storage.CollationBinary) == 0 {
+		return nil, nil
+	}
+	return args[0], nil
+}
+
+func nowFunction(args []interface{}) (interface{}, error) {
+	return time.Now().UTC(), nil
+}
+
+// dateTruncFunction truncates a timestamp to the start of its second,
+// minute, hour, day, week (starting on Monday), month, quarter or year
+func dateTruncFunction(args []interface{}) (interface{}, error) {
+	unit := strings.ToLower(args[0].(string))
+	t := args[1].(time.Time)
+	year, month, day := t.Date()
+
+	switch unit {
+	case "microsecond", "microseconds":
+		return t.Truncate(time.Microsecond), nil
+	case "millisecond", "milliseconds":
+		return t.Truncate(time.Millisecond), nil
+	case "second", "seconds":
+		return t.Truncate(time.Second), nil
+	case "minute", "minutes":
+		return t.Truncate(time.Minute), nil
+	case "hour", "hours":
+		return t.Truncate(time.Hour), nil
+	case "day", "days":
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC), nil
+	case "week", "weeks":
+		sinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-sinceMonday, 0, 0, 0, 0, time.UTC), nil
+	case "month", "months":
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC), nil
+	case "quarter", "quarters":
+		return time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, time.UTC), nil
+	case "year", "years":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return nil, fmt.Errorf("unknown unit '%s'", args[0])
+}
+
+// extractFunction returns a field of a timestamp: year, quarter, month,
+// week (of the ISO year), day, hour, minute, second (with its fraction),
+// dow (the day of the week, 0 for Sunday), doy (the day of the year) or
+// epoch (seconds since 1970)
+func extractFunction(args []interface{}) (interface{}, error) {
+	field := strings.ToLower(args[0].(string))
+	t := args[1].(time.Time)
+
+	switch field {
+	case "year":
+		return int64(t.Year()), nil
+	case "quarter":
+		return int64(t.Month()-1)/3 + 1, nil
+	case "month":
+		return int64(t.Month()), nil
+	case "week":
+		_, week := t.ISOWeek()
+		return int64(week), nil
+	case "day":
+		return int64(t.Day()), nil
+	case "hour":
+		return int64(t.Hour()), nil
+	case "minute":
+		return int64(t.Minute()), nil
+	case "second":
+		return float64(t.Second()) + float64(t.Nanosecond())/1e9, nil
+	case "dow":
+		return int64(t.Weekday()), nil
+	case "doy":
+		return int64(t.YearDay()), nil
+	case "epoch":
+		return float64(t.Unix()) + float64(t.Nanosecond())/1e9, nil
+	}
+	return nil, fmt.Errorf("unknown field '%s'", args[0])
+}
@@ -0,0 +1,241 @@
+package sql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"startdb/internal/storage"
+)
+
+// The catalog records each table under _table_metadata:<table> as a JSON
+// tableRecord holding its columns with their declared types, NOT NULL
+// constraints, defaults, collations and UNIQUE flags. Index definitions are
+// recorded under _index_metadata:<index> (see indexdef.go).
+
+func tableMetadataKey(table string) string {
+	return fmt.Sprintf("_table_metadata:%s", table)
+}
+
+// tableRecord is the stored form of a table's metadata
+type tableRecord struct {
+	Name    string         `json:"name"`
+	Created int64          `json:"created"`
+	Columns []columnRecord `json:"columns"`
+}
+
+// columnRecord is the stored form of a column's metadata
+type columnRecord struct {
+	Name      string            `json:"name"`
+	Type      string            `json:"type,omitempty"`
+	NotNull   bool              `json:"not_null,omitempty"`
+	Default   *defaultRecord    `json:"default,omitempty"`
+	Collation storage.Collation `json:"collation,omitempty"`
+	Unique    bool              `json:"unique,omitempty"`
+}
+
+// defaultRecord is a column's default value, kept as text with its value
+// type so it reads back as the type it was stored as
+type defaultRecord struct {
+	Type  storage.ValueType `json:"type"`
+	Value string            `json:"value"`
+}
+
+// saveTableMetadata records a table in the catalog
+func saveTableMetadata(store *storage.Storage, table *TableMetadata) error {
+	record := tableRecord{Name: table.Name, Created: table.Created.Unix()}
+	for _, column := range table.Columns {
+		stored := columnRecord{
+			Name:      column.Name,
+			Type:      column.Type,
+			NotNull:   !column.Nullable,
+			Collation: column.Collation,
+			Unique:    column.Unique,
+		}
+		if column.Default != nil {
+			valueType, ok := storage.ValueTypeOf(column.Default)
+			if !ok {
+				return fmt.Errorf("column '%s' has a default of unsupported type %T", column.Name, column.Default)
+			}
+			stored.Default = &defaultRecord{Type: valueType, Value: columnText(column.Default)}
+		}
+		record.Columns = append(record.Columns, stored)
+	}
+
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+	return store.Put(tableMetadataKey(table.Name), data)
+}
+
+// loadTableMetadata reads a table's metadata from the catalog. Tables
+// created before the catalog are recorded as
+// "table:<table>:created:<unix>:columns:<a>,<b>"; their columns are nullable
+// and have no declared types or options.
+func loadTableMetadata(store *storage.Storage, name string) (*TableMetadata, error) {
+	data, err := store.Get(tableMetadataKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("table '%s' does not exist", name)
+	}
+	if !strings.HasPrefix(string(data), "{") {
+		return parseLegacyTableMetadata(name, string(data)), nil
+	}
+
+	var record tableRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("table '%s' has malformed metadata: %w", name, err)
+	}
+	table := &TableMetadata{Name: name, Created: time.Unix(record.Created, 0)}
+	for _, stored := range record.Columns {
+		column := ColumnMetadata{
+			Name:      stored.Name,
+			Type:      stored.Type,
+			Nullable:  !stored.NotNull,
+			Collation: stored.Collation,
+			Unique:    stored.Unique,
+		}
+		if stored.Default != nil {
+			column.Default = coerceValue(stored.Default.Value, stored.Default.Type.String())
+		}
+		table.Columns = append(table.Columns, column)
+	}
+	return table, nil
+}
+
+func parseLegacyTableMetadata(name, data string) *TableMetadata {
+	table := &TableMetadata{Name: name}
+	if i := strings.Index(data, ":created:"); i >= 0 {
+		created := data[i+len(":created:"):]
+		if end := strings.Index(created, ":"); end >= 0 {
+			created = created[:end]
+		}
+		if seconds, err := strconv.ParseInt(created, 10, 64); err == nil {
+			table.Created = time.Unix(seconds, 0)
+		}
+	}
+
+	parts := strings.SplitN(data, "columns:", 2)
+	if len(parts) < 2 {
+		return table
+	}
+	for _, column := range strings.Split(parts[1], ",") {
+		table.Columns = append(table.Columns, ColumnMetadata{Name: column, Nullable: true})
+	}
+	return table
+}
+
+// column returns the table's column with the given name, or nil
+func (t *TableMetadata) column(name string) *ColumnMetadata {
+	for i := range t.Columns {
+		if t.Columns[i].Name == name {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// collation returns the collation declared for a column of the table,
+// binary if it declared none
+func (t *TableMetadata) collation(name string) storage.Collation {
+	if column := t.column(name); column != nil && column.Collation != "" {
+		return column.Collation
+	}
+	return storage.CollationBinary
+}
+
+// columnNames returns the names of the table's columns in declared order
+func (t *TableMetadata) columnNames() []string {
+	names := make([]string, len(t.Columns))
+	for i, column := range t.Columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+// tableColumns returns the column names recorded for a table
+func tableColumns(store *storage.Storage, table string) ([]string, error) {
+	metadata, err := loadTableMetadata(store, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata.Columns) == 0 {
+		return nil, nil
+	}
+	return metadata.columnNames(), nil
+}
+
+// convertValue converts a value to a column's declared type, failing when
+// it has no lossless conversion or is too long for a text type declared
+// with a length, such as VARCHAR(20). NULL and values of columns with no
+// known type are returned unchanged.
+func convertValue(value interface{}, columnType string) (interface{}, error) {
+	valueType, ok := columnValueType(columnType)
+	if !ok || value == nil {
+		return value, nil
+	}
+
+	converted := coerceValue(value, columnType)
+	if actual, _ := storage.ValueTypeOf(converted); actual != valueType {
+		if actual, ok := storage.ValueTypeOf(value); ok {
+			return nil, fmt.Errorf("%s '%s' is not a valid %s", actual, columnText(value), strings.ToUpper(columnType))
+		}
+		return nil, fmt.Errorf("'%v' is not a valid %s", value, strings.ToUpper(columnType))
+	}
+
+	switch v := converted.(type) {
+	case []float64:
+		if dimensions, isVector := vectorDimensions(columnType); isVector && len(v) != dimensions {
+			return nil, fmt.Errorf("expected %d dimensions, got %d", dimensions, len(v))
+		}
+	case string:
+		if length, declared := textLength(columnType); declared && utf8.RuneCountInString(v) > length {
+			return nil, fmt.Errorf("value of %d characters is too long for %s", utf8.RuneCountInString(v), strings.ToUpper(columnType))
+		}
+	}
+	return converted, nil
+}
+
+// textLength returns n for a text type declared with a length, such as
+// VARCHAR(n)
+func textLength(columnType string) (int, bool) {
+	if valueType, ok := columnValueType(columnType); !ok || valueType != storage.ValueText {
+		return 0, false
+	}
+	open := strings.Index(columnType, "(")
+	if open < 0 || !strings.HasSuffix(columnType, ")") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(columnType[open+1 : len(columnType)-1]))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// checkRow converts the values of a row written by INSERT or UPDATE to
+// their columns' declared types and checks every NOT NULL column has a
+// value. A column the row does not hold is NULL.
+func checkRow(table *TableMetadata, rowData []interface{}) error {
+	for _, column := range table.Columns {
+		var value interface{}
+		for i := 1; i+1 < len(rowData); i += 2 {
+			if rowData[i] != column.Name {
+				continue
+			}
+			converted, err := convertValue(rowData[i+1], column.Type)
+			if err != nil {
+				return fmt.Errorf("invalid value for column '%s' of table '%s': %w", column.Name, table.Name, err)
+			}
+			rowData[i+1] = converted
+			value = converted
+		}
+		if value == nil && !column.Nullable {
+			return fmt.Errorf("NULL value for NOT NULL column '%s' of table '%s'", column.Name, table.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+
+	"startdb/internal/storage"
+)
+
+// columnOptions holds the COLLATE and UNIQUE clauses of a column definition
+type columnOptions struct {
+	Collation storage.Collation
+	Unique    bool
+}
+
+// useCollations records the collations of the columns of the tables a
+// statement reads, for comparing their values. Rows of joined tables hold
+// bare column names, so a name declared by more than one table takes the
+// collation of the first.
+func (e *Executor) useCollations(tables ...string) {
+	e.collations = make(map[string]storage.Collation)
+	for _, table := range tables {
+		metadata, err := loadTableMetadata(e.storage, table)
+		if err != nil {
+			continue
+		}
+		for _, column := range metadata.Columns {
+			if _, exists := e.collations[column.Name]; !exists && column.Collation != "" {
+				e.collations[column.Name] = column.Collation
+			}
+		}
+	}
+}
+
+// expressionCollation returns the collation of a column reference, binary
+// for any other expression
+func (e *Executor) expressionCollation(expr Expression) storage.Collation {
+	if grouped, ok := expr.(*groupedValue); ok {
+		expr = grouped.Expression
+	}
+	if ident, ok := expr.(*Identifier); ok {
+		_, column := splitColumnName(ident.Value)
+		if collation, exists := e.collations[column]; exists {
+			return collation
+		}
+	}
+	return storage.CollationBinary
+}
+
+// comparisonCollation returns the collation two operands are compared
+// under: that of the left operand's column, else that of the right
+// operand's, else binary
+func (e *Executor) comparisonCollation(left, right Expression) storage.Collation {
+	if collation := e.expressionCollation(left); collation != storage.CollationBinary {
+		return collation
+	}
+	return e.expressionCollation(right)
+}
+
+// columnCollation returns the collation declared for a column of a table
+func (e *Executor) columnCollation(table, column string) storage.Collation {
+	metadata, err := loadTableMetadata(e.storage, table)
+	if err != nil {
+		return storage.CollationBinary
+	}
+	return metadata.collation(column)
+}
+
+// definitionCollation returns the collation of an index's keys: that of its
+// column, or binary for an expression index and for a multi-column index,
+// whose keys already hold the sort key of each column
+func (e *Executor) definitionCollation(definition *indexDefinition) storage.Collation {
+	if definition.Expression != nil || len(definition.Columns) > 0 {
+		return storage.CollationBinary
+	}
+	return e.columnCollation(definition.Table, definition.Column)
+}
+
+// hashJoinCollation returns the collation of the equality a hash join
+// matches rows on, so rows land in the same bucket exactly when the join
+// condition holds
+func (e *Executor) hashJoinCollation(step *JoinPlan) storage.Collation {
+	for _, condition := range step.Conditions {
+		b, ok := condition.(*BinaryExpression)
+		if !ok || b.Operator != "=" {
+			continue
+		}
+		left, okLeft := b.Left.(*Identifier)
+		right, okRight := b.Right.(*Identifier)
+		if !okLeft || !okRight {
+			continue
+		}
+		_, leftColumn := splitColumnName(left.Value)
+		_, rightColumn := splitColumnName(right.Value)
+		if (leftColumn == step.LeftColumn && rightColumn == step.RightColumn) ||
+			(leftColumn == step.RightColumn && rightColumn == step.LeftColumn) {
+			return e.comparisonCollation(b.Left, b.Right)
+		}
+	}
+	return storage.CollationBinary
+}
+
+// evaluateLike evaluates [NOT] LIKE under the collation of its operands.
+// ILIKE lower-cases the value, the pattern and the escape character first,
+// so it ignores case in every script whatever the collation. The result is
+// unknown if any of them is NULL.
+func (e *Executor) evaluateLike(rowData []interface{}, like *LikeExpression) (interface{}, error) {
+	value, err := e.evaluate(rowData, like.Left)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := e.evaluate(rowData, like.Pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var escape rune
+	if like.Escape != nil {
+		escapeValue, err := e.evaluate(rowData, like.Escape)
+		if err != nil || escapeValue == nil {
+			return nil, err
+		}
+		runes := []rune(columnText(escapeValue))
+		if like.CaseInsensitive {
+			runes = []rune(strings.ToLower(string(runes)))
+		}
+		if len(runes) != 1 {
+			return nil, fmt.Errorf("ESCAPE expects a single character, got '%s'", columnText(escapeValue))
+		}
+		escape = runes[0]
+	}
+	if value == nil || pattern == nil {
+		return nil, nil
+	}
+
+	valueText, patternText := columnText(value), columnText(pattern)
+	if like.CaseInsensitive {
+		valueText, patternText = strings.ToLower(valueText), strings.ToLower(patternText)
+	}
+	collation := e.comparisonCollation(like.Left, like.Pattern)
+	return logicNot(collation.Like(valueText, patternText, escape), like.Not), nil
+}
+
+// uniqueValues tracks the values held by the UNIQUE columns of a table while
+// a statement writes to it, keyed as an index would key them so values that
+// are equal under the column's collation collide
+type uniqueValues struct {
+	table      string
+	collations map[string]storage.Collation
+	rows       map[string]map[string]string // column -> value key -> row key
+}
+
+// loadUniqueValues reads the values of a table's UNIQUE columns, returning
+// nil if it has none
+func (e *Executor) loadUniqueValues(table string) (*uniqueValues, error) {
+	unique := &uniqueValues{
+		table:      table,
+		collations: make(map[string]storage.Collation),
+		rows:       make(map[string]map[string]string),
+	}
+	metadata, err := loadTableMetadata(e.storage, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range metadata.Columns {
+		if column.Unique {
+			unique.collations[column.Name] = metadata.collation(column.Name)
+			unique.rows[column.Name] = make(map[string]string)
+		}
+	}
+	if len(unique.collations) == 0 {
+		return nil, nil
+	}
+
+	rows, _, err := e.scanTableRows(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table '%s': %w", table, err)
+	}
+	for _, row := range rows {
+		unique.add(e, rowKey(table, row), row)
+	}
+	return unique, nil
+}
+
+// check returns an error if a row would repeat the value of a UNIQUE column
+// held by another row. NULLs never collide.
+func (u *uniqueValues) check(e *Executor, key string, rowData []interface{}) error {
+	if u == nil {
+		return nil
+	}
+	for column, collation := range u.collations {
+		value := rowColumnValue(rowData, column)
+		if value == nil {
+			continue
+		}
+		if holder, exists := u.rows[column][e.indexKey(value, collation)]; exists && holder != key {
+			return fmt.Errorf("duplicate value '%s' for UNIQUE column '%s' of table '%s'", columnText(value), column, u.table)
+		}
+	}
+	return nil
+}
+
+// add records the values of a row
+func (u *uniqueValues) add(e *Executor, key string, rowData []interface{}) {
+	if u == nil {
+		return
+	}
+	for column, collation := range u.collations {
+		if value := rowColumnValue(rowData, column); value != nil {
+			u.rows[column][e.indexKey(value, collation)] = key
+		}
+	}
+}
+
+// remove forgets the values of a row
+func (u *uniqueValues) remove(e *Executor, key string, rowData []interface{}) {
+	if u == nil {
+		return
+	}
+	for column, collation := range u.collations {
+		if value := rowColumnValue(rowData, column); value != nil {
+			valueKey := e.indexKey(value, collation)
+			if u.rows[column][valueKey] == key {
+				delete(u.rows[column], valueKey)
+			}
+		}
+	}
+}
+
+// parseColumnOptions validates the COLLATE and UNIQUE clauses of a table's
+// column definitions, returning the options of the columns that have any
+func parseColumnOptions(columns []ColumnDefinition) (map[string]columnOptions, error) {
+	options := make(map[string]columnOptions)
+	for _, column := range columns {
+		collation, err := storage.ParseCollation(column.Collation)
+		if err != nil {
+			return nil, fmt.Errorf("column '%s': %w", column.Name, err)
+		}
+		if _, isVector := vectorDimensions(column.Type); isVector && collation != storage.CollationBinary {
+			return nil, fmt.Errorf("column '%s' of type %s cannot have a collation", column.Name, column.Type)
+		}
+		if column.Collation == "" && !column.Unique {
+			continue
+		}
+		options[column.Name] = columnOptions{Collation: collation, Unique: column.Unique}
+	}
+	return options, nil
+}
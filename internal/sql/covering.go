@@ -0,0 +1,192 @@
+package sql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// coveredColumns returns the columns whose values an index entry stores:
+// the key columns, if the index is not on an expression, followed by the
+// INCLUDE columns. Only indexes with INCLUDE columns store values.
+func (d *indexDefinition) coveredColumns() []string {
+	if len(d.Include) == 0 {
+		return nil
+	}
+	columns := append([]string(nil), d.keyColumns()...)
+	stored := make(map[string]bool)
+	for _, column := range columns {
+		stored[column] = true
+	}
+	for _, column := range d.Include {
+		if !stored[column] {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// indexEntry returns the value stored under a row's key in a value-keyed
+// index: the row key, or for a covering index a JSON array of the row key
+// followed by the text of each covered column
+func (e *Executor) indexEntry(definition *indexDefinition, rowKey string, rowData []interface{}) []byte {
+	columns := definition.coveredColumns()
+	if len(columns) == 0 {
+		return []byte(rowKey)
+	}
+
+	entry := make([]*string, 0, len(columns)+1)
+	entry = append(entry, &rowKey)
+	for _, column := range columns {
+		var text *string
+		if value := rowColumnValue(rowData, column); value != nil {
+			s := columnText(value)
+			text = &s
+		}
+		entry = append(entry, text)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return []byte(rowKey)
+	}
+	return data
+}
+
+// decodeIndexEntry splits an index entry into the row key and the values of
+// the covered columns, nil where the row had no value
+func decodeIndexEntry(value []byte) (string, []*string) {
+	if len(value) == 0 || value[0] != '[' {
+		return string(value), nil
+	}
+	var entry []*string
+	if err := json.Unmarshal(value, &entry); err != nil || len(entry) == 0 || entry[0] == nil {
+		return string(value), nil
+	}
+	return *entry[0], entry[1:]
+}
+
+// coversQuery reports whether the index stores every column a single-table
+// SELECT reads, so it can be answered without reading the base rows
+func (p *Planner) coversQuery(indexName string, stmt *SelectStatement) bool {
+	if len(stmt.Joins) > 0 {
+		return false
+	}
+	definition, err := loadIndexDefinition(p.storage, indexName)
+	if err != nil {
+		return false
+	}
+	covered := make(map[string]bool)
+	for _, column := range definition.coveredColumns() {
+		covered[column] = true
+	}
+	if len(covered) == 0 {
+		return false
+	}
+
+	var needed []string
+	for _, field := range stmt.Fields {
+		if ident, ok := field.(*Identifier); ok && ident.Value == "*" {
+			columns, err := tableColumns(p.storage, stmt.Table)
+			if err != nil || columns == nil {
+				return false
+			}
+			needed = append(needed, columns...)
+			continue
+		}
+		needed = append(needed, referencedColumns(field)...)
+	}
+	needed = append(needed, referencedColumns(stmt.Where)...)
+	needed = append(needed, referencedColumns(stmt.Having)...)
+	for _, expr := range append(append(append([]Expression{}, stmt.DistinctOn...), stmt.GroupBy...), stmt.OrderBy...) {
+		needed = append(needed, referencedColumns(expr)...)
+	}
+
+	for _, column := range needed {
+		if !covered[column] {
+			return false
+		}
+	}
+	return true
+}
+
+// referencedColumns returns the names of the columns an expression reads
+func referencedColumns(expr Expression) []string {
+	switch e := expr.(type) {
+	case *Identifier:
+		if e.Value == "*" {
+			return nil
+		}
+		return []string{e.Value}
+	case *BinaryExpression:
+		return append(referencedColumns(e.Left), referencedColumns(e.Right)...)
+	case *FunctionCall:
+		var columns []string
+		for _, arg := range e.Args {
+			columns = append(columns, referencedColumns(arg)...)
+		}
+		return columns
+	default:
+		var columns []string
+		for _, operand := range operands(expr) {
+			columns = append(columns, referencedColumns(operand)...)
+		}
+		return columns
+	}
+}
+
+// scanIndexOnly answers an index scan or range from the covered column
+// values stored in a covering index, without reading the base rows
+func (e *Executor) scanIndexOnly(plan *ExecutionPlan) ([][]interface{}, int, error) {
+	definition, err := loadIndexDefinition(e.storage, plan.IndexName)
+	if err != nil {
+		return nil, 0, err
+	}
+	columns := definition.coveredColumns()
+	order, err := tableColumns(e.storage, plan.Table)
+	if err != nil || order == nil {
+		order = columns
+	}
+	schema, err := e.tableSchema(plan.Table)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	indexManager := e.storage.GetIndexManager()
+	var values [][]byte
+	if plan.Type == PlanTypeIndexScan {
+		for _, entry := range e.indexEntries(plan) {
+			values = append(values, entry.Value)
+		}
+	} else {
+		start, end := e.indexRangeBounds(plan)
+		entries, err := indexManager.Range(plan.IndexName, start, end)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan index '%s': %w", plan.IndexName, err)
+		}
+		for _, entry := range entries {
+			values = append(values, entry.Value)
+		}
+	}
+
+	var rows [][]interface{}
+	tablePrefix := plan.Table + ":"
+	for _, value := range values {
+		rowKey, covered := decodeIndexEntry(value)
+		if !strings.HasPrefix(rowKey, tablePrefix) || len(covered) != len(columns) {
+			continue
+		}
+		byColumn := make(map[string]*string, len(columns))
+		for i, column := range columns {
+			byColumn[column] = covered[i]
+		}
+		// Columns are laid out in table order, as in rows read from storage
+		rowData := []interface{}{strings.TrimPrefix(rowKey, tablePrefix)}
+		for _, column := range order {
+			if value := byColumn[column]; value != nil {
+				rowData = append(rowData, column, schema.textValue(column, *value))
+			}
+		}
+		rows = append(rows, rowData)
+	}
+	return rows, len(values), nil
+}
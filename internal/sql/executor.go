@@ -1,8 +1,13 @@
 package sql
 
 import (
+	"bytes"
+	"cmp"
 	"fmt"
+	"math"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,19 +15,40 @@ import (
 )
 
 type Executor struct {
-	storage *storage.Storage
-	planner *Planner
+	storage    *storage.Storage
+	planner    *Planner
+	profile    *queryProfile                     // set while running EXPLAIN ANALYZE
+	lastPlan   *ExecutionPlan                    // plan of the statement being executed, for the workload store
+	collations map[string]storage.Collation      // collations of the columns the statement compares, by name
+	schemas    map[string]*tableSchema           // schemas of the tables whose rows were encoded or decoded
+	queries    map[*SelectStatement]*nestedQuery // subqueries and derived tables of the statement being executed
+	derived    map[string]*SelectStatement       // derived tables of the statement being executed, by the name they are read under
+	params     []interface{}                     // values of the parameters of the subquery being run
+	sortMemory int                               // bytes of rows a sort fed by a table scan holds before spilling runs to disk
 }
 
 func NewExecutor(storage *storage.Storage) *Executor {
 	return &Executor{
-		storage: storage,
-		planner: NewPlanner(storage),
+		storage:    storage,
+		planner:    NewPlanner(storage),
+		sortMemory: sortMemoryBytes,
 	}
 }
 
-// Execute executes a SQL statement
+// Execute executes a SQL statement. Statements that run successfully are
+// recorded in the database's workload.
 func (e *Executor) Execute(stmt Statement) (*QueryResult, error) {
+	e.lastPlan = nil
+	e.queries, e.derived, e.planner.derived = nil, nil, nil
+	start := time.Now()
+	result, err := e.execute(stmt)
+	if err == nil {
+		e.recordWorkload(stmt, time.Since(start))
+	}
+	return result, err
+}
+
+func (e *Executor) execute(stmt Statement) (*QueryResult, error) {
 	switch s := stmt.(type) {
 	case *SelectStatement:
 		return e.executeSelect(s)
@@ -40,331 +66,679 @@ func (e *Executor) Execute(stmt Statement) (*QueryResult, error) {
 		return e.executeCreateIndex(s)
 	case *DropIndexStatement:
 		return e.executeDropIndex(s)
+	case *AnalyzeStatement:
+		return e.executeAnalyze(s)
+	case *ExplainStatement:
+		return e.executeExplain(s)
+	case *ShowIndexStatsStatement:
+		return e.executeShowIndexStats(s)
+	case *ReindexStatement:
+		return e.executeReindex(s)
 	default:
 		return nil, fmt.Errorf("unsupported statement type: %T", stmt)
 	}
 }
 
 func (e *Executor) executeSelect(stmt *SelectStatement) (*QueryResult, error) {
-	tableKey := fmt.Sprintf("_table_metadata:%s", stmt.Table)
-	_, err := e.storage.Get(tableKey)
+	sources, err := e.prepareSelect(stmt)
 	if err != nil {
-		return nil, fmt.Errorf("table '%s' does not exist", stmt.Table)
+		return nil, err
+	}
+	columns, rows, err := e.runSelect(stmt, sources)
+	if err != nil {
+		return nil, err
 	}
+	return &QueryResult{
+		Columns: columns,
+		Rows:    rows,
+		Count:   len(rows),
+	}, nil
+}
 
-	// Check if joined tables exist
-	for _, join := range stmt.Joins {
-		joinTableKey := fmt.Sprintf("_table_metadata:%s", join.Table)
-		_, err := e.storage.Get(joinTableKey)
-		if err != nil {
-			return nil, fmt.Errorf("table '%s' does not exist", join.Table)
+// runSelect executes a prepared SELECT, returning its column names and rows
+func (e *Executor) runSelect(stmt *SelectStatement, sources []selectSource) ([]string, [][]interface{}, error) {
+	tables := make([]string, len(sources))
+	for i, source := range sources {
+		tables[i] = source.table
+	}
+	e.useCollations(tables...)
+
+	agg, err := prepareAggregation(stmt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matches, err := collectMatches(stmt.Where)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, expr := range stmt.OrderBy {
+		if _, _, err := parseDistance(expr); err != nil {
+			return nil, nil, err
 		}
 	}
 
 	plan, err := e.planner.PlanSelect(stmt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to plan query: %w", err)
+		return nil, nil, fmt.Errorf("failed to plan query: %w", err)
 	}
+	e.lastPlan = plan
 
 	var rows [][]interface{}
+	sorted := false
 
 	// If there are JOINs, process them
 	if len(stmt.Joins) > 0 {
-		rows, err = e.executeSelectWithJoins(stmt, plan)
+		rows, err = e.executeSelectWithJoins(stmt, plan, sources)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	} else {
-		// No JOINs, use original logic
-		tablePrefix := stmt.Table + ":"
-		indexManager := e.storage.GetIndexManager()
-
-		if plan.Type == PlanTypeIndexScan && plan.IndexName != "" {
-			indexKey := fmt.Sprintf("%v", plan.IndexValue)
-			rowKey, found := indexManager.Search(plan.IndexName, indexKey)
-			if found {
-				keyStr := string(rowKey)
-				if strings.HasPrefix(keyStr, tablePrefix) {
-					value, err := e.storage.Get(keyStr)
-					if err == nil {
-						rowData, err := e.parseRowData(string(value))
-						if err == nil {
-							matches, err := e.evaluateWhere(rowData, stmt.Where)
-							if err == nil && matches {
-								rows = append(rows, rowData)
-							}
-						}
-					}
-				}
+		// No JOINs: read candidate rows through the chosen access path. A
+		// table scan also builds the adaptive hash indexes of hot columns.
+		hot := e.recordAdaptiveLookups(stmt, plan)
+		version := e.storage.GetAdaptiveHashIndexes().TableVersion(stmt.Table)
+		if e.spillsSort(stmt, plan, agg, hot) {
+			rows, err = e.scanSorted(stmt, plan)
+			if err != nil {
+				return nil, nil, err
 			}
-		} else {
-			keys, err := e.storage.Keys()
+			sorted = true
+		} else if rows, err = e.readPlannedRows(plan); err != nil {
+			return nil, nil, err
+		}
+		if len(hot) > 0 {
+			e.buildAdaptiveIndexes(stmt.Table, hot, version, rows)
+		}
+		if stmt.Where != nil && !sorted {
+			rows, err = e.filterRows(rows, stmt.Where, opFilter)
 			if err != nil {
-				return nil, fmt.Errorf("failed to get keys: %w", err)
+				return nil, nil, err
 			}
+		}
+		if plan.RankByRelevance {
+			rows, err = e.rankByRelevance(stmt.Table, stmt.Where, rows, matches)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
 
-			for _, key := range keys {
-				if strings.HasPrefix(key, tablePrefix) {
-					value, err := e.storage.Get(key)
-					if err != nil {
-						continue
-					}
+	// An aggregate query goes on with one row per group, and evaluates its
+	// fields, HAVING and ORDER BY over them
+	if agg != nil {
+		rows, err = e.aggregateRows(agg, rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		stmt = agg.stmt
+		if stmt.Having != nil {
+			rows, err = e.filterRows(rows, stmt.Having, opHaving)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
 
-					rowData, err := e.parseRowData(string(value))
-					if err != nil {
-						continue
-					}
+	if len(stmt.OrderBy) > 0 && !plan.Ordered && !sorted {
+		start := time.Now()
+		if plan.TopN {
+			rows, err = e.topRows(rows, stmt, stmt.Offset+stmt.Limit)
+		} else {
+			err = e.sortRows(rows, stmt)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		e.profile.record(opSort, len(rows), 0, time.Since(start))
+	}
 
-					if stmt.Where != nil {
-						matches, err := e.evaluateWhere(rowData, stmt.Where)
-						if err != nil || !matches {
-							continue
-						}
-					}
+	if stmt.Distinct {
+		rows, err = e.distinctRows(stmt, sources, rows)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
-					rows = append(rows, rowData)
-				}
-			}
+	if stmt.HasLimit || stmt.Offset > 0 {
+		rows = rows[min(stmt.Offset, len(rows)):]
+		if stmt.HasLimit && stmt.Limit < len(rows) {
+			rows = rows[:stmt.Limit]
 		}
+		e.profile.record(opLimit, len(rows), 0, 0)
 	}
 
-	if len(stmt.OrderBy) > 0 {
-		sort.Slice(rows, func(i, j int) bool {
-			if len(rows[i]) > 0 && len(rows[j]) > 0 {
-				return e.compareValues(rows[i][0], rows[j][0]) < 0
-			}
-			return false
-		})
+	return e.projectRows(stmt, sources, rows)
+}
+
+// readPlannedRows reads the rows of the plan's table through its access path.
+// Rows are not filtered by the WHERE clause.
+func (e *Executor) readPlannedRows(plan *ExecutionPlan) ([][]interface{}, error) {
+	start := time.Now()
+	var rows [][]interface{}
+	var keysScanned int
+	var err error
+
+	switch {
+	case plan.IndexOnly && plan.IndexName != "":
+		rows, keysScanned, err = e.scanIndexOnly(plan)
+	case plan.Type == PlanTypeIndexScan && plan.IndexName != "":
+		rows, keysScanned, err = e.scanIndex(plan)
+	case plan.Type == PlanTypeIndexRange && plan.IndexName != "":
+		rows, keysScanned, err = e.scanIndexRange(plan)
+	case plan.Type == PlanTypeFullTextScan && plan.IndexName != "":
+		rows, keysScanned, err = e.scanFullText(plan)
+	case plan.Type == PlanTypeVectorScan && plan.IndexName != "":
+		rows, keysScanned, err = e.scanVector(plan)
+	case plan.Type == PlanTypeAdaptiveHashScan:
+		rows, keysScanned, err = e.scanAdaptiveHash(plan)
+	default:
+		rows, keysScanned, err = e.scanTableRows(plan.Table)
+		if err != nil {
+			err = fmt.Errorf("failed to get keys: %w", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if plan.Reverse {
+		slices.Reverse(rows)
+	}
+	if plan.Type == PlanTypeIndexRange && plan.RangeLower == nil && plan.RangeUpper == nil && len(plan.PrefixColumns) == 0 {
+		rows, err = e.addUnindexedRows(plan, rows)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if stmt.Limit > 0 && stmt.Limit < len(rows) {
-		rows = rows[:stmt.Limit]
+	e.profile.record(opScan, len(rows), keysScanned, time.Since(start))
+	return rows, nil
+}
+
+// addUnindexedRows adds the rows of the plan's table that have no entry in
+// the index read in full, which are NULL in the indexed column, where the
+// ORDER BY places NULLs
+func (e *Executor) addUnindexedRows(plan *ExecutionPlan, rows [][]interface{}) ([][]interface{}, error) {
+	indexed := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		indexed[rowKey(plan.Table, row)] = true
+	}
+	keys, err := e.storage.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
 	}
 
-	columns := []string{"id"}
-	if len(rows) > 0 {
-		for i := 1; i < len(rows[0]); i += 2 {
-			if i+1 < len(rows[0]) {
-				columns = append(columns, rows[0][i].(string))
-			}
+	var missing []string
+	for _, key := range keys {
+		if !indexed[key] {
+			missing = append(missing, key)
 		}
 	}
+	unindexed, err := e.readRows(plan.Table, missing)
+	if err != nil {
+		return nil, err
+	}
 
-	return &QueryResult{
-		Columns: columns,
-		Rows:    rows,
-		Count:   len(rows),
-	}, nil
+	var order SortOrder
+	if len(plan.Ordering) > 0 {
+		order = plan.Ordering[0]
+	}
+	if order.NullsFirst {
+		return append(unindexed, rows...), nil
+	}
+	return append(rows, unindexed...), nil
+}
+
+// scanIndex reads the rows matching the plan's index value
+func (e *Executor) scanIndex(plan *ExecutionPlan) ([][]interface{}, int, error) {
+	rowKeys, keysScanned := e.indexRowKeys(plan)
+	rows, err := e.readRows(plan.Table, rowKeys)
+	return rows, keysScanned, err
+}
+
+// indexEntries returns the entries of the plan's index for its index value,
+// one per row holding the value
+func (e *Executor) indexEntries(plan *ExecutionPlan) []storage.KeyValue {
+	valueKey := e.indexKey(plan.IndexValue, e.columnCollation(plan.Table, plan.IndexColumn))
+	return e.storage.GetIndexManager().SearchEntries(plan.IndexName, valueKey)
+}
+
+// indexRowKeys returns the keys of the rows of the plan's table holding its
+// index value, and the number of index entries read to find them
+func (e *Executor) indexRowKeys(plan *ExecutionPlan) ([]string, int) {
+	entries := e.indexEntries(plan)
+	var rowKeys []string
+	for _, entry := range entries {
+		rowKey, _ := decodeIndexEntry(entry.Value)
+		if strings.HasPrefix(rowKey, plan.Table+":") {
+			rowKeys = append(rowKeys, rowKey)
+		}
+	}
+	return rowKeys, len(entries)
+}
+
+// scanIndexRange reads the rows whose indexed column lies within the plan's
+// bounds, in index order
+func (e *Executor) scanIndexRange(plan *ExecutionPlan) ([][]interface{}, int, error) {
+	start, end := e.indexRangeBounds(plan)
+	entries, err := e.storage.GetIndexManager().Range(plan.IndexName, start, end)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan index '%s': %w", plan.IndexName, err)
+	}
+
+	rowKeys := make([]string, len(entries))
+	for i, entry := range entries {
+		rowKeys[i], _ = decodeIndexEntry(entry.Value)
+	}
+	rows, err := e.readRows(plan.Table, rowKeys)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return rows, len(entries), nil
+}
+
+// indexRangeBounds returns the index keys bounding the plan's range. An open
+// bound stops at the keys of the other bound's type, and a range open on
+// both sides spans the whole index. In a multi-column index the bounds
+// follow the key of the values the leading columns are fixed to.
+func (e *Executor) indexRangeBounds(plan *ExecutionPlan) (string, string) {
+	if len(plan.PrefixColumns) > 0 {
+		prefix := make(compositeKey, len(plan.PrefixColumns))
+		for i, column := range plan.PrefixColumns {
+			prefix[i] = e.columnCollation(plan.Table, column).KeyValue(plan.PrefixValues[i])
+		}
+		rest := *plan
+		rest.PrefixColumns, rest.PrefixValues = nil, nil
+		start, end := e.indexRangeBounds(&rest)
+		key := e.indexKey(prefix, storage.CollationBinary)
+		return key + start, key + end
+	}
+	if plan.RangeLower == nil && plan.RangeUpper == nil {
+		return "", "\xff"
+	}
+	var start, end string
+	collation := e.columnCollation(plan.Table, plan.IndexColumn)
+	// The entries of rows holding a bound's value sort after its key and
+	// before the end of its entry bounds
+	if plan.RangeLower != nil {
+		start = e.indexKey(plan.RangeLower, collation)
+		if plan.LowerExclusive {
+			_, start = storage.IndexEntryBounds(start)
+		}
+	}
+	if plan.RangeUpper != nil {
+		end = e.indexKey(plan.RangeUpper, collation)
+		if !plan.UpperExclusive {
+			_, end = storage.IndexEntryBounds(end)
+		}
+	}
+	if plan.RangeLower == nil {
+		start, _ = storage.KeyTypeBounds(end)
+	}
+	if plan.RangeUpper == nil {
+		_, end = storage.KeyTypeBounds(start)
+	}
+	return start, end
+}
+
+// filterRows keeps the rows satisfying a WHERE or HAVING condition,
+// profiling them under op
+func (e *Executor) filterRows(rows [][]interface{}, where Expression, op string) ([][]interface{}, error) {
+	start := time.Now()
+	var filteredRows [][]interface{}
+	for _, row := range rows {
+		matches, err := e.evaluateWhere(row, where)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			filteredRows = append(filteredRows, row)
+		}
+	}
+	e.profile.record(op, len(filteredRows), 0, time.Since(start))
+	return filteredRows, nil
 }
 
-// executeSelectWithJoins handles SELECT queries with JOIN clauses
-func (e *Executor) executeSelectWithJoins(stmt *SelectStatement, plan *ExecutionPlan) ([][]interface{}, error) {
-	// Load rows from base table
-	baseRows, err := e.loadTableRows(stmt.Table)
+// executeSelectWithJoins handles SELECT queries with JOIN clauses, joining
+// tables in the order and with the strategies chosen by the planner
+func (e *Executor) executeSelectWithJoins(stmt *SelectStatement, plan *ExecutionPlan, sources []selectSource) ([][]interface{}, error) {
+	names := make([]string, len(sources))
+	for i, source := range sources {
+		names[i] = source.name
+	}
+
+	// Load rows from the table the plan starts with
+	baseRows, err := e.profiledTableRows(plan.Table, opScan)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load rows from table '%s': %w", stmt.Table, err)
+		return nil, fmt.Errorf("failed to load rows from table '%s': %w", plan.Table, err)
 	}
 
-	// Process each JOIN sequentially
-	currentRows := baseRows
-	tableAliases := map[string]string{stmt.Table: stmt.Table}
+	// Each joined row keeps one part per table, indexed by FROM clause
+	// position, so output columns stay in query order whatever the join order
+	tableCount := len(stmt.Joins) + 1
+	basePosition := 0
+	planned := make(map[int]bool)
+	for _, step := range plan.Joins {
+		planned[step.Position] = true
+	}
+	for planned[basePosition] {
+		basePosition++
+	}
+
+	currentRows := make([][][]interface{}, 0, len(baseRows))
+	for _, row := range baseRows {
+		parts := make([][]interface{}, tableCount)
+		parts[basePosition] = row
+		currentRows = append(currentRows, parts)
+	}
 
-	for _, join := range stmt.Joins {
+	for _, step := range plan.Joins {
 		// Load rows from joined table
-		joinRows, err := e.loadTableRows(join.Table)
+		joinRows, err := e.profiledTableRows(step.Table, joinScanOperator(step.Position))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rows from table '%s': %w", step.Table, err)
+		}
+		start := time.Now()
+		currentRows, err = e.joinRows(currentRows, joinRows, step, names)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load rows from table '%s': %w", join.Table, err)
+			return nil, err
 		}
+		e.profile.record(joinOperator(step.Position), len(currentRows), 0, time.Since(start))
+	}
+
+	var rows [][]interface{}
+	for _, parts := range currentRows {
+		rows = append(rows, assembleRow(parts, names))
+	}
 
-		tableAliases[join.Table] = join.Table
+	// Apply WHERE clause to joined results
+	if stmt.Where != nil {
+		return e.filterRows(rows, stmt.Where, opFilter)
+	}
 
-		// Perform the JOIN
-		var joinedRows [][]interface{}
-		for _, leftRow := range currentRows {
-			matched := false
-			for _, rightRow := range joinRows {
-				// Evaluate JOIN condition with both rows
-				matches, err := e.evaluateJoinCondition(leftRow, rightRow, stmt.Table, join.Table, join.Condition)
-				if err != nil {
-					continue
-				}
+	return rows, nil
+}
 
-				if matches {
-					matched = true
-					// Combine rows
-					combinedRow := e.combineRows(leftRow, rightRow)
-					joinedRows = append(joinedRows, combinedRow)
-				}
+// joinRows performs one join step of the plan. names holds the name each
+// table is referred to by, by FROM clause position.
+func (e *Executor) joinRows(leftRows [][][]interface{}, rightRows [][]interface{}, step *JoinPlan, names []string) ([][][]interface{}, error) {
+	// Build a hash table on the joined table's column for hash joins
+	var buckets map[string][]int
+	collation := e.hashJoinCollation(step)
+	if step.Strategy == JoinStrategyHash {
+		buckets = make(map[string][]int)
+		for i, row := range rightRows {
+			if value := e.findColumnValue(row, step.RightColumn); value != nil {
+				key := e.joinKey(value, collation)
+				buckets[key] = append(buckets[key], i)
 			}
+		}
+	}
 
-			// Handle LEFT JOIN: include left row even if no match
-			if join.Type == JoinTypeLeft && !matched {
-				// Create a row with NULL values for right table
-				rightRowNull := e.createNullRow(joinRows)
-				combinedRow := e.combineRows(leftRow, rightRowNull)
-				joinedRows = append(joinedRows, combinedRow)
+	allRight := make([]int, len(rightRows))
+	for i := range allRight {
+		allRight[i] = i
+	}
+
+	var joinedRows [][][]interface{}
+	matchedRight := make([]bool, len(rightRows))
+	for _, parts := range leftRows {
+		candidates := allRight
+		if buckets != nil {
+			candidates = nil
+			if value := e.findColumnValue(parts[step.LeftPosition], step.LeftColumn); value != nil {
+				candidates = buckets[e.joinKey(value, collation)]
 			}
 		}
 
-		// Handle RIGHT JOIN: include right rows that didn't match
-		if join.Type == JoinTypeRight {
-			for _, rightRow := range joinRows {
-				matched := false
-				for _, leftRow := range currentRows {
-					matches, err := e.evaluateJoinCondition(leftRow, rightRow, stmt.Table, join.Table, join.Condition)
-					if err == nil && matches {
-						matched = true
-						break
+		matched := false
+		for _, i := range candidates {
+			combined := withRowPart(parts, step.Position, rightRows[i])
+			holds, err := e.joinConditionsHold(combined, step.Conditions, names)
+			if err != nil {
+				return nil, err
+			}
+			if holds {
+				matched = true
+				matchedRight[i] = true
+				joinedRows = append(joinedRows, combined)
+			}
+		}
+
+		// Handle LEFT JOIN: include left row even if no match
+		if step.Type == JoinTypeLeft && !matched {
+			joinedRows = append(joinedRows, withRowPart(parts, step.Position, e.createNullRow(rightRows)))
+		}
+	}
+
+	// Handle RIGHT JOIN: include right rows that didn't match
+	if step.Type == JoinTypeRight {
+		for i, rightRow := range rightRows {
+			if matchedRight[i] {
+				continue
+			}
+			// Create NULL values for every table joined so far
+			var parts [][]interface{}
+			if len(leftRows) > 0 {
+				parts = make([][]interface{}, len(leftRows[0]))
+				for pos, part := range leftRows[0] {
+					if part != nil {
+						parts[pos] = e.createNullRow([][]interface{}{part})
 					}
 				}
-				if !matched {
-					// Create a row with NULL values for left table
-					leftRowNull := e.createNullRow(currentRows)
-					combinedRow := e.combineRows(leftRowNull, rightRow)
-					joinedRows = append(joinedRows, combinedRow)
-				}
 			}
+			joinedRows = append(joinedRows, withRowPart(parts, step.Position, rightRow))
 		}
+	}
 
-		currentRows = joinedRows
+	return joinedRows, nil
+}
+
+// joinConditionsHold evaluates the join conditions of a step on a joined row
+func (e *Executor) joinConditionsHold(parts [][]interface{}, conditions []Expression, names []string) (bool, error) {
+	if len(conditions) == 0 {
+		return true, nil
+	}
+	row := assembleRow(parts, names)
+	for _, condition := range conditions {
+		matches, err := e.evaluateWhere(row, condition)
+		if err != nil || !matches {
+			return false, err
+		}
 	}
+	return true, nil
+}
 
-	// Apply WHERE clause to joined results
-	if stmt.Where != nil {
-		var filteredRows [][]interface{}
-		for _, row := range currentRows {
-			matches, err := e.evaluateWhere(row, stmt.Where)
-			if err == nil && matches {
-				filteredRows = append(filteredRows, row)
-			}
+// assembleRow combines the parts of a joined row in FROM clause order into
+// one row with the ID of the first part. Each column is named
+// "table.column" after the name of the table it came from.
+func assembleRow(parts [][]interface{}, names []string) []interface{} {
+	var row []interface{}
+	for pos, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		if row == nil {
+			row = append(row, part[0])
+		}
+		for i := 1; i+1 < len(part); i += 2 {
+			column, _ := part[i].(string)
+			row = append(row, names[pos]+"."+column, part[i+1])
 		}
-		currentRows = filteredRows
 	}
+	return row
+}
 
-	return currentRows, nil
+// withRowPart returns a copy of parts with the part at position set to row
+func withRowPart(parts [][]interface{}, position int, row []interface{}) [][]interface{} {
+	size := len(parts)
+	if position >= size {
+		size = position + 1
+	}
+	combined := make([][]interface{}, size)
+	copy(combined, parts)
+	combined[position] = row
+	return combined
 }
 
 // loadTableRows loads all rows from a table
 func (e *Executor) loadTableRows(tableName string) ([][]interface{}, error) {
-	var rows [][]interface{}
-	tablePrefix := tableName + ":"
-	
+	rows, _, err := e.scanTableRows(tableName)
+	return rows, err
+}
+
+// profiledTableRows loads all rows from a table, recording the scan under op
+func (e *Executor) profiledTableRows(tableName, op string) ([][]interface{}, error) {
+	start := time.Now()
+	rows, keysScanned, err := e.scanTableRows(tableName)
+	e.profile.record(op, len(rows), keysScanned, time.Since(start))
+	return rows, err
+}
+
+// scanTableRows loads all rows from a table and reports how many storage
+// keys were examined to find them
+func (e *Executor) scanTableRows(tableName string) ([][]interface{}, int, error) {
+	if subquery, ok := e.derived[tableName]; ok {
+		rows, err := e.derivedRows(subquery)
+		return rows, len(rows), err
+	}
+
 	keys, err := e.storage.Keys()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	for _, key := range keys {
-		if strings.HasPrefix(key, tablePrefix) {
-			value, err := e.storage.Get(key)
-			if err != nil {
-				continue
-			}
-
-			rowData, err := e.parseRowData(string(value))
-			if err != nil {
-				continue
-			}
-
-			rows = append(rows, rowData)
-		}
+	rows, err := e.readRows(tableName, keys)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return rows, nil
+	return rows, len(keys), nil
 }
 
-// evaluateJoinCondition evaluates a JOIN condition with two rows
-func (e *Executor) evaluateJoinCondition(leftRow, rightRow []interface{}, leftTable, rightTable string, condition Expression) (bool, error) {
-	// Create a combined row context for evaluation
-	combinedRow := e.combineRows(leftRow, rightRow)
-	
-	// Evaluate the condition
-	return e.evaluateWhere(combinedRow, condition)
-}
+// forEachTableRow calls fn with each row of a table as it is read, rather
+// than reading them all first, and reports how many storage keys were
+// examined to find them
+func (e *Executor) forEachTableRow(tableName string, fn func([]interface{}) error) (int, error) {
+	keys, err := e.storage.Keys()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get keys: %w", err)
+	}
 
-// combineRows combines two rows into one
-func (e *Executor) combineRows(leftRow, rightRow []interface{}) []interface{} {
-	combined := make([]interface{}, 0, len(leftRow)+len(rightRow))
-	// Append left row (including ID)
-	combined = append(combined, leftRow...)
-	// Append right row values (skip first element which is the ID)
-	if len(rightRow) > 1 {
-		combined = append(combined, rightRow[1:]...)
+	tablePrefix := tableName + ":"
+	for _, key := range keys {
+		if !strings.HasPrefix(key, tablePrefix) {
+			continue
+		}
+		rowData, ok, err := e.readRow(tableName, key)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		if err := fn(rowData); err != nil {
+			return 0, err
+		}
 	}
-	return combined
+
+	return len(keys), nil
 }
 
-// createNullRow creates a row with NULL values matching the structure of existing rows
+// createNullRow creates a row with the columns of existing rows, all NULL
 func (e *Executor) createNullRow(sampleRows [][]interface{}) []interface{} {
 	if len(sampleRows) == 0 {
 		return []interface{}{nil}
 	}
 	
-	// Create a row with NULL values matching the structure
+	// Keep the column names and set every value to NULL
 	nullRow := make([]interface{}, len(sampleRows[0]))
-	for i := range nullRow {
-		nullRow[i] = nil
+	for i := 1; i+1 < len(nullRow); i += 2 {
+		nullRow[i] = sampleRows[0][i]
 	}
 	return nullRow
 }
 
 func (e *Executor) executeInsert(stmt *InsertStatement) (*QueryResult, error) {
-	// Check if table exists
-	tableKey := fmt.Sprintf("_table_metadata:%s", stmt.Table)
-	_, err := e.storage.Get(tableKey)
+	table, err := loadTableMetadata(e.storage, stmt.Table)
 	if err != nil {
-		return nil, fmt.Errorf("table '%s' does not exist", stmt.Table)
+		return nil, err
+	}
+	e.useCollations(stmt.Table)
+
+	// Values are given for the listed columns, or else for the table's
+	// columns in declared order
+	columnNames := table.columnNames()
+	if len(stmt.Columns) > 0 {
+		columnNames = stmt.Columns
+		listed := make(map[string]bool)
+		for _, column := range stmt.Columns {
+			if table.column(column) == nil {
+				return nil, fmt.Errorf("column '%s' does not exist in table '%s'", column, stmt.Table)
+			}
+			if listed[column] {
+				return nil, fmt.Errorf("column '%s' is listed more than once", column)
+			}
+			listed[column] = true
+		}
 	}
 
+	unique, err := e.loadUniqueValues(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
 	insertedCount := 0
 
 	for _, valueList := range stmt.Values {
+		if len(valueList) > len(columnNames) {
+			return nil, fmt.Errorf("INSERT has %d values but table '%s' has %d columns", len(valueList), stmt.Table, len(columnNames))
+		}
+		if len(stmt.Columns) > 0 && len(valueList) < len(columnNames) {
+			return nil, fmt.Errorf("INSERT lists %d columns but has %d values", len(columnNames), len(valueList))
+		}
+
 		// Generate a unique ID
 		id := fmt.Sprintf("%d", time.Now().UnixNano())
 		key := fmt.Sprintf("%s:%s", stmt.Table, id)
 
-		// Build the row data
+		values := make(map[string]interface{}, len(valueList))
+		for i, expr := range valueList {
+			value, err := e.evaluateExpression(expr)
+			if err != nil {
+				return nil, err
+			}
+			values[columnNames[i]] = value
+		}
+
+		// Build the row data, with the default of each column given no value
 		var rowData []interface{}
 		rowData = append(rowData, id)
-
-		// Get table metadata to determine column names
-		tableKey := fmt.Sprintf("_table_metadata:%s", stmt.Table)
-		tableMetadata, err := e.storage.Get(tableKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get table metadata: %w", err)
-		}
-		
-		// Parse table metadata to get column names
-		tableInfo := string(tableMetadata)
-		// Extract column names from metadata
-		columnNames := []string{"id", "name", "email"} // Default fallback
-		if strings.Contains(tableInfo, "columns:") {
-			parts := strings.Split(tableInfo, "columns:")
-			if len(parts) > 1 {
-				columnNames = strings.Split(parts[1], ",")
+		for _, column := range table.Columns {
+			value, given := values[column.Name]
+			if !given {
+				value = column.Default
 			}
+			rowData = append(rowData, column.Name, value)
+		}
+		if err := checkRow(table, rowData); err != nil {
+			return nil, err
 		}
-		if len(stmt.Columns) > 0 {
-			columnNames = stmt.Columns
+		encoded, err := e.encodeRow(stmt.Table, rowData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert row: %w", err)
 		}
-		
-		for i, value := range valueList {
-			var columnName string
-			if i < len(columnNames) {
-				columnName = columnNames[i]
-			} else {
-				columnName = fmt.Sprintf("column_%d", i+1)
-			}
-			rowData = append(rowData, columnName, e.evaluateExpression(value))
+		if err := unique.check(e, key, rowData); err != nil {
+			return nil, err
 		}
 
-		rowStr := e.serializeRowData(rowData)
-		err = e.storage.Put(key, []byte(rowStr))
+		err = e.storage.Put(key, encoded)
 		if err != nil {
 			return nil, fmt.Errorf("failed to insert row: %w", err)
 		}
 
 		e.updateIndexesOnInsert(stmt.Table, key, rowData)
+		unique.add(e, key, rowData)
 		insertedCount++
 	}
 
@@ -376,80 +750,88 @@ func (e *Executor) executeInsert(stmt *InsertStatement) (*QueryResult, error) {
 }
 
 func (e *Executor) executeUpdate(stmt *UpdateStatement) (*QueryResult, error) {
-	tableKey := fmt.Sprintf("_table_metadata:%s", stmt.Table)
-	_, err := e.storage.Get(tableKey)
+	table, err := loadTableMetadata(e.storage, stmt.Table)
 	if err != nil {
-		return nil, fmt.Errorf("table '%s' does not exist", stmt.Table)
+		return nil, err
 	}
+	for column := range stmt.Set {
+		if table.column(column) == nil {
+			return nil, fmt.Errorf("column '%s' does not exist in table '%s'", column, stmt.Table)
+		}
+	}
+	scope := &queryScope{sources: []selectSource{{table: stmt.Table, name: stmt.Table, columns: table.columnNames()}}}
+	if err := e.prepareSubqueries(stmt.Where, scope); err != nil {
+		return nil, err
+	}
+	for _, expr := range stmt.Set {
+		if err := e.prepareSubqueries(expr, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	e.useCollations(stmt.Table)
 
 	plan, err := e.planner.PlanUpdate(stmt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to plan query: %w", err)
 	}
+	e.lastPlan = plan
 
-	keys, err := e.storage.Keys()
+	unique, err := e.loadUniqueValues(stmt.Table)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get keys: %w", err)
-	}
-
-	updatedCount := 0
-	tablePrefix := stmt.Table + ":"
-	indexManager := e.storage.GetIndexManager()
-
-	if plan.Type == PlanTypeIndexScan && plan.IndexName != "" {
-		indexKey := fmt.Sprintf("%v", plan.IndexValue)
-		rowKey, found := indexManager.Search(plan.IndexName, indexKey)
-		if found {
-			keyStr := string(rowKey)
-			if strings.HasPrefix(keyStr, tablePrefix) {
-				value, err := e.storage.Get(keyStr)
-				if err == nil {
-					rowData, err := e.parseRowData(string(value))
-					if err == nil {
-						matches, err := e.evaluateWhere(rowData, stmt.Where)
-						if err == nil && matches {
-							updatedRowData := e.updateRowData(rowData, stmt.Set)
-							updatedRowStr := e.serializeRowData(updatedRowData)
-							err = e.storage.Put(keyStr, []byte(updatedRowStr))
-							if err == nil {
-								e.updateIndexesOnUpdate(stmt.Table, keyStr, rowData, updatedRowData)
-								updatedCount++
-							}
-						}
-					}
-				}
-			}
-		}
-	} else {
-		for _, key := range keys {
-			if strings.HasPrefix(key, tablePrefix) {
-				value, err := e.storage.Get(key)
-				if err != nil {
-					continue
-				}
+		return nil, err
+	}
 
-				rowData, err := e.parseRowData(string(value))
-				if err != nil {
-					continue
-				}
+	keys, err := e.modifiedRowKeys(plan)
+	if err != nil {
+		return nil, err
+	}
 
-				if stmt.Where != nil {
-					matches, err := e.evaluateWhere(rowData, stmt.Where)
-					if err != nil || !matches {
-						continue
-					}
-				}
+	updatedCount := 0
+	tablePrefix := stmt.Table + ":"
+	for _, key := range keys {
+		if strings.HasPrefix(key, tablePrefix) {
+			rowData, ok, err := e.readRow(stmt.Table, key)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
 
-				updatedRowData := e.updateRowData(rowData, stmt.Set)
-				updatedRowStr := e.serializeRowData(updatedRowData)
-				err = e.storage.Put(key, []byte(updatedRowStr))
+			if stmt.Where != nil {
+				matches, err := e.evaluateWhere(rowData, stmt.Where)
 				if err != nil {
-					return nil, fmt.Errorf("failed to update row: %w", err)
+					return nil, err
+				}
+				if !matches {
+					continue
 				}
+			}
 
-				e.updateIndexesOnUpdate(stmt.Table, key, rowData, updatedRowData)
-				updatedCount++
+			updatedRowData, err := e.updateRowData(rowData, stmt.Set)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkRow(table, updatedRowData); err != nil {
+				return nil, err
+			}
+			encoded, err := e.encodeRow(stmt.Table, updatedRowData)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update row: %w", err)
+			}
+			if err := unique.check(e, key, updatedRowData); err != nil {
+				return nil, err
+			}
+			err = e.storage.Put(key, encoded)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update row: %w", err)
 			}
+
+			e.updateIndexesOnUpdate(stmt.Table, key, rowData, updatedRowData)
+			unique.remove(e, key, rowData)
+			unique.add(e, key, updatedRowData)
+			updatedCount++
 		}
 	}
 
@@ -460,77 +842,78 @@ func (e *Executor) executeUpdate(stmt *UpdateStatement) (*QueryResult, error) {
 	}, nil
 }
 
+// modifiedRowKeys returns the keys an UPDATE or DELETE reads rows from: those
+// of the rows holding the plan's index value for an index scan, else every
+// key. The rows are still checked against the whole WHERE clause.
+func (e *Executor) modifiedRowKeys(plan *ExecutionPlan) ([]string, error) {
+	if plan.Type == PlanTypeIndexScan && plan.IndexName != "" {
+		rowKeys, _ := e.indexRowKeys(plan)
+		return rowKeys, nil
+	}
+	keys, err := e.storage.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+	return keys, nil
+}
+
 func (e *Executor) executeDelete(stmt *DeleteStatement) (*QueryResult, error) {
 	tableKey := fmt.Sprintf("_table_metadata:%s", stmt.Table)
 	_, err := e.storage.Get(tableKey)
 	if err != nil {
 		return nil, fmt.Errorf("table '%s' does not exist", stmt.Table)
 	}
+	columns, err := tableColumns(e.storage, stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	scope := &queryScope{sources: []selectSource{{table: stmt.Table, name: stmt.Table, columns: columns}}}
+	if err := e.prepareSubqueries(stmt.Where, scope); err != nil {
+		return nil, err
+	}
+
+	e.useCollations(stmt.Table)
 
 	plan, err := e.planner.PlanDelete(stmt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to plan query: %w", err)
 	}
+	e.lastPlan = plan
 
-	keys, err := e.storage.Keys()
+	keys, err := e.modifiedRowKeys(plan)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get keys: %w", err)
+		return nil, err
 	}
 
 	deletedCount := 0
 	tablePrefix := stmt.Table + ":"
-	indexManager := e.storage.GetIndexManager()
-
-	if plan.Type == PlanTypeIndexScan && plan.IndexName != "" {
-		indexKey := fmt.Sprintf("%v", plan.IndexValue)
-		rowKey, found := indexManager.Search(plan.IndexName, indexKey)
-		if found {
-			keyStr := string(rowKey)
-			if strings.HasPrefix(keyStr, tablePrefix) {
-				value, err := e.storage.Get(keyStr)
-				if err == nil {
-					rowData, err := e.parseRowData(string(value))
-					if err == nil {
-						matches, err := e.evaluateWhere(rowData, stmt.Where)
-						if err == nil && matches {
-							err = e.storage.Delete(keyStr)
-							if err == nil {
-								e.updateIndexesOnDelete(stmt.Table, keyStr, rowData)
-								deletedCount++
-							}
-						}
-					}
-				}
+	for _, key := range keys {
+		if strings.HasPrefix(key, tablePrefix) {
+			rowData, ok, err := e.readRow(stmt.Table, key)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
 			}
-		}
-	} else {
-		for _, key := range keys {
-			if strings.HasPrefix(key, tablePrefix) {
-				value, err := e.storage.Get(key)
-				if err != nil {
-					continue
-				}
 
-				rowData, err := e.parseRowData(string(value))
+			if stmt.Where != nil {
+				matches, err := e.evaluateWhere(rowData, stmt.Where)
 				if err != nil {
-					continue
-				}
-
-				if stmt.Where != nil {
-					matches, err := e.evaluateWhere(rowData, stmt.Where)
-					if err != nil || !matches {
-						continue
-					}
+					return nil, err
 				}
-
-				err = e.storage.Delete(key)
-				if err != nil {
-					return nil, fmt.Errorf("failed to delete row: %w", err)
+				if !matches {
+					continue
 				}
+			}
 
-				e.updateIndexesOnDelete(stmt.Table, key, rowData)
-				deletedCount++
+			err = e.storage.Delete(key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete row: %w", err)
 			}
+
+			e.updateIndexesOnDelete(stmt.Table, key, rowData)
+			deletedCount++
 		}
 	}
 
@@ -549,6 +932,15 @@ func (e *Executor) executeCreateTable(stmt *CreateTableStatement) (*QueryResult,
 		return nil, fmt.Errorf("table '%s' already exists", stmt.Table)
 	}
 
+	bloomRate, err := tableBloomRate(stmt.Options)
+	if err != nil {
+		return nil, err
+	}
+	options, err := parseColumnOptions(stmt.Columns)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create table metadata
 	table := &TableMetadata{
 		Name:    stmt.Table,
@@ -556,27 +948,54 @@ func (e *Executor) executeCreateTable(stmt *CreateTableStatement) (*QueryResult,
 	}
 
 	for _, colDef := range stmt.Columns {
+		if table.column(colDef.Name) != nil {
+			return nil, fmt.Errorf("column '%s' is declared more than once", colDef.Name)
+		}
+		if dimensions, isVector := vectorDimensions(colDef.Type); isVector {
+			if dimensions <= 0 {
+				return nil, fmt.Errorf("column '%s' must have a positive number of dimensions", colDef.Name)
+			}
+		} else if strings.HasPrefix(strings.ToUpper(colDef.Type), "VECTOR") {
+			return nil, fmt.Errorf("column '%s' has an invalid type %s, expected VECTOR(n)", colDef.Name, colDef.Type)
+		} else if _, known := columnValueType(colDef.Type); !known {
+			return nil, fmt.Errorf("column '%s' has an unknown type %s", colDef.Name, colDef.Type)
+		}
 		column := ColumnMetadata{
-			Name:     colDef.Name,
-			Type:     colDef.Type,
-			Nullable: colDef.Nullable,
+			Name:      colDef.Name,
+			Type:      colDef.Type,
+			Nullable:  colDef.Nullable,
+			Collation: options[colDef.Name].Collation,
+			Unique:    colDef.Unique,
 		}
 		if colDef.Default != nil {
-			column.Default = e.evaluateExpression(colDef.Default)
+			value, err := e.evaluateExpression(colDef.Default)
+			if err == nil {
+				value, err = convertValue(value, colDef.Type)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid DEFAULT for column '%s': %w", colDef.Name, err)
+			}
+			if value == nil && !colDef.Nullable {
+				return nil, fmt.Errorf("column '%s' is NOT NULL but defaults to NULL", colDef.Name)
+			}
+			column.Default = value
 		}
 		table.Columns = append(table.Columns, column)
 	}
 
-	// Store table metadata in storage with column names
-	var columnNames []string
-	for _, col := range stmt.Columns {
-		columnNames = append(columnNames, col.Name)
-	}
-	tableData := fmt.Sprintf("table:%s:created:%d:columns:%s", stmt.Table, table.Created.Unix(), strings.Join(columnNames, ","))
-	err = e.storage.Put(tableKey, []byte(tableData))
-	if err != nil {
+	if err := saveTableMetadata(e.storage, table); err != nil {
 		return nil, fmt.Errorf("failed to store table metadata: %w", err)
 	}
+	schema := newTableSchema(stmt.Columns)
+	if err := saveTableSchema(e.storage, stmt.Table, schema); err != nil {
+		return nil, fmt.Errorf("failed to store table schema: %w", err)
+	}
+	delete(e.schemas, stmt.Table)
+	if bloomRate > 0 {
+		if err := e.storage.GetBloomEngine().EnableTableFilter(stmt.Table, bloomRate); err != nil {
+			return nil, fmt.Errorf("failed to create Bloom filter: %w", err)
+		}
+	}
 
 	return &QueryResult{
 		Columns: []string{"message"},
@@ -593,6 +1012,10 @@ func (e *Executor) executeDropTable(stmt *DropTableStatement) (*QueryResult, err
 		return nil, fmt.Errorf("table '%s' does not exist", stmt.Table)
 	}
 
+	if err := e.dropTableIndexes(stmt.Table); err != nil {
+		return nil, fmt.Errorf("failed to drop indexes of table '%s': %w", stmt.Table, err)
+	}
+
 	// Delete all rows for this table
 	keys, err := e.storage.Keys()
 	if err != nil {
@@ -607,6 +1030,11 @@ func (e *Executor) executeDropTable(stmt *DropTableStatement) (*QueryResult, err
 	}
 
 	e.storage.Delete(tableKey)
+	e.storage.Delete(tableStatisticsKey(stmt.Table))
+	e.storage.Delete(tableSchemaKey(stmt.Table))
+	delete(e.schemas, stmt.Table)
+	e.storage.GetBloomEngine().DisableTableFilter(stmt.Table)
+	e.storage.GetAdaptiveHashIndexes().DropTable(stmt.Table)
 
 	return &QueryResult{
 		Columns: []string{"message"},
@@ -615,6 +1043,34 @@ func (e *Executor) executeDropTable(stmt *DropTableStatement) (*QueryResult, err
 	}, nil
 }
 
+// deleteIndexRecords removes what is stored about a dropped index: its
+// definition, saved entries and usage
+func (e *Executor) deleteIndexRecords(indexName string) {
+	e.storage.Delete(fmt.Sprintf("_index_metadata:%s", indexName))
+	e.storage.Delete(indexSnapshotKey(indexName))
+	e.storage.Delete(indexUsageKey(indexName))
+}
+
+// dropTableIndexes drops every index on a table
+func (e *Executor) dropTableIndexes(table string) error {
+	names, err := indexNames(e.storage)
+	if err != nil {
+		return err
+	}
+	indexManager := e.storage.GetIndexManager()
+	for _, indexName := range names {
+		definition, err := loadIndexDefinition(e.storage, indexName)
+		if err != nil || definition.Table != table {
+			continue
+		}
+		if indexManager.Exists(indexName) {
+			indexManager.DropIndex(indexName)
+		}
+		e.deleteIndexRecords(indexName)
+	}
+	return nil
+}
+
 func (e *Executor) executeCreateIndex(stmt *CreateIndexStatement) (*QueryResult, error) {
 	tableKey := fmt.Sprintf("_table_metadata:%s", stmt.Table)
 	_, err := e.storage.Get(tableKey)
@@ -624,59 +1080,79 @@ func (e *Executor) executeCreateIndex(stmt *CreateIndexStatement) (*QueryResult,
 
 	indexManager := e.storage.GetIndexManager()
 
-	if indexManager.Exists(stmt.IndexName) {
+	// An index being built has metadata but is not yet in the index manager
+	_, err = e.storage.Get(fmt.Sprintf("_index_metadata:%s", stmt.IndexName))
+	if indexManager.Exists(stmt.IndexName) || err == nil {
 		return nil, fmt.Errorf("index '%s' already exists", stmt.IndexName)
 	}
 
-	err = indexManager.CreateIndex(stmt.IndexName, 3)
+	definition := &indexDefinition{
+		Table:      stmt.Table,
+		Column:     stmt.Column,
+		Columns:    stmt.Columns,
+		Expression: stmt.Expression,
+		Include:    stmt.Include,
+		Where:      stmt.Where,
+		Type:       storage.IndexType(stmt.IndexType),
+		Options:    stmt.Options,
+	}
+	if definition.Type == "" {
+		definition.Type = storage.IndexTypeBTree
+	}
+	columns, err := tableColumns(e.storage, stmt.Table)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create index: %w", err)
+		return nil, err
+	}
+	if err := validateIndexDefinition(definition, columns); err != nil {
+		return nil, err
 	}
 
-	indexMetadataKey := fmt.Sprintf("_index_metadata:%s", stmt.IndexName)
-	indexMetadata := fmt.Sprintf("table:%s:column:%s", stmt.Table, stmt.Column)
-	err = e.storage.Put(indexMetadataKey, []byte(indexMetadata))
+	indexedCount, err := e.buildIndex(stmt.IndexName, definition)
 	if err != nil {
-		indexManager.DropIndex(stmt.IndexName)
-		return nil, fmt.Errorf("failed to store index metadata: %w", err)
+		e.storage.Delete(fmt.Sprintf("_index_metadata:%s", stmt.IndexName))
+		return nil, err
 	}
 
-	keys, err := e.storage.Keys()
+	return &QueryResult{
+		Columns: []string{"message"},
+		Rows:    [][]interface{}{{fmt.Sprintf("Index '%s' created successfully on %s.%s (%d rows indexed)", stmt.IndexName, stmt.Table, definition.key(), indexedCount)}},
+		Count:   1,
+	}, nil
+}
+
+// buildIndex records an index's metadata and builds the index from the
+// table's rows, returning the number of rows indexed
+func (e *Executor) buildIndex(indexName string, definition *indexDefinition) (int, error) {
+	index, err := e.newIndex(definition)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get keys: %w", err)
+		return 0, err
 	}
 
-	tablePrefix := stmt.Table + ":"
-	indexedCount := 0
-
-	for _, key := range keys {
-		if strings.HasPrefix(key, tablePrefix) {
-			value, err := e.storage.Get(key)
-			if err != nil {
-				continue
-			}
+	metadata, err := definition.encode()
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode index metadata: %w", err)
+	}
+	indexMetadataKey := fmt.Sprintf("_index_metadata:%s", indexName)
+	err = e.storage.Put(indexMetadataKey, metadata)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store index metadata: %w", err)
+	}
 
-			rowData, err := e.parseRowData(string(value))
-			if err != nil {
-				continue
-			}
+	indexedCount, err := e.populateIndex(indexName, definition, index)
+	if err != nil {
+		return 0, err
+	}
+	if err := e.applyIndexBloomFilter(indexName, definition); err != nil {
+		return indexedCount, fmt.Errorf("failed to create Bloom filter: %w", err)
+	}
 
-			columnValue := e.findColumnValue(rowData, stmt.Column)
-			if columnValue != nil {
-				indexKey := fmt.Sprintf("%v", columnValue)
-				err = indexManager.Insert(stmt.IndexName, indexKey, []byte(key))
-				if err == nil {
-					indexedCount++
-				}
-			}
+	if definition.Type == storage.IndexTypeVector {
+		if err := e.saveIndexSnapshot(indexName); err != nil {
+			return indexedCount, fmt.Errorf("failed to save index: %w", err)
 		}
 	}
 
-	return &QueryResult{
-		Columns: []string{"message"},
-		Rows:    [][]interface{}{{fmt.Sprintf("Index '%s' created successfully on %s.%s (%d rows indexed)", stmt.IndexName, stmt.Table, stmt.Column, indexedCount)}},
-		Count:   1,
-	}, nil
+	return indexedCount, nil
 }
 
 func (e *Executor) executeDropIndex(stmt *DropIndexStatement) (*QueryResult, error) {
@@ -690,9 +1166,7 @@ func (e *Executor) executeDropIndex(stmt *DropIndexStatement) (*QueryResult, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to drop index: %w", err)
 	}
-
-	indexMetadataKey := fmt.Sprintf("_index_metadata:%s", stmt.IndexName)
-	e.storage.Delete(indexMetadataKey)
+	e.deleteIndexRecords(stmt.IndexName)
 
 	return &QueryResult{
 		Columns: []string{"message"},
@@ -701,129 +1175,124 @@ func (e *Executor) executeDropIndex(stmt *DropIndexStatement) (*QueryResult, err
 	}, nil
 }
 
-// Helper methods
-
-func (e *Executor) parseRowData(data string) ([]interface{}, error) {
-	// Simple CSV-like parsing for now
-	parts := strings.Split(data, "|")
-	var rowData []interface{}
-	for _, part := range parts {
-		if part == "" {
-			continue
+// executeAnalyze collects statistics for one table, or every table when none
+// is named, and stores them for the planner
+func (e *Executor) executeAnalyze(stmt *AnalyzeStatement) (*QueryResult, error) {
+	tables := []string{stmt.Table}
+	if stmt.Table == "" {
+		var err error
+		tables, err = e.tableNames()
+		if err != nil {
+			return nil, err
 		}
-		rowData = append(rowData, part)
+	} else if _, err := e.storage.Get(fmt.Sprintf("_table_metadata:%s", stmt.Table)); err != nil {
+		return nil, fmt.Errorf("table '%s' does not exist", stmt.Table)
 	}
-	return rowData, nil
-}
 
-func (e *Executor) serializeRowData(rowData []interface{}) string {
-	var parts []string
-	for _, value := range rowData {
-		parts = append(parts, fmt.Sprintf("%v", value))
+	result := &QueryResult{
+		Columns: []string{"table", "rows", "columns"},
+	}
+
+	for _, table := range tables {
+		columns, err := tableColumns(e.storage, table)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := e.loadTableRows(table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rows from table '%s': %w", table, err)
+		}
+
+		stats := buildTableStatistics(table, columns, rows)
+		if err := saveTableStatistics(e.storage, stats); err != nil {
+			return nil, fmt.Errorf("failed to store statistics for table '%s': %w", table, err)
+		}
+		result.Rows = append(result.Rows, []interface{}{table, stats.RowCount, len(stats.Columns)})
 	}
-	return strings.Join(parts, "|")
+
+	result.Count = len(result.Rows)
+	return result, nil
 }
 
-func (e *Executor) evaluateExpression(expr Expression) interface{} {
-	switch e := expr.(type) {
-	case *StringLiteral:
-		return e.Value
-	case *NumberLiteral:
-		return e.Value
-	case *BooleanLiteral:
-		return e.Value
-	case *NullLiteral:
-		return nil
-	case *Identifier:
-		return e.Value
-	default:
-		return fmt.Sprintf("%v", expr)
+// Helper methods
+
+// evaluateExpression computes an expression that is not evaluated over a
+// row, such as an INSERT value or a column's DEFAULT, so it cannot refer to
+// columns
+func (e *Executor) evaluateExpression(expr Expression) (interface{}, error) {
+	err := visitIdentifiers(expr, func(ident *Identifier) error {
+		return fmt.Errorf("column '%s' cannot be referred to here", ident.Value)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return e.evaluate(nil, expr)
 }
 
+// evaluateExpressionWithRowData computes the value of an expression over a
+// row, or nil if it cannot be computed
 func (e *Executor) evaluateExpressionWithRowData(rowData []interface{}, expr Expression) interface{} {
-	switch e := expr.(type) {
-	case *StringLiteral:
-		return e.Value
-	case *NumberLiteral:
-		return e.Value
-	case *BooleanLiteral:
-		return e.Value
-	case *NullLiteral:
-		return nil
-	case *Identifier:
-		// Look up the column value in the row data
-		columnName := e.Value
-		for i := 1; i < len(rowData); i += 2 {
-			if i+1 < len(rowData) {
-				if rowData[i] == columnName {
-					return rowData[i+1]
-				}
-			}
-		}
+	value, err := e.evaluate(rowData, expr)
+	if err != nil {
 		return nil
-	default:
-		return fmt.Sprintf("%v", expr)
 	}
+	return value
 }
 
+// evaluateWhere reports whether a condition holds for a row. A condition
+// that is unknown, as comparisons with NULL are, does not hold.
 func (e *Executor) evaluateWhere(rowData []interface{}, where Expression) (bool, error) {
-	switch w := where.(type) {
-	case *BinaryExpression:
-		left := e.evaluateExpressionWithRowData(rowData, w.Left)
-		right := e.evaluateExpressionWithRowData(rowData, w.Right)
-
-		switch w.Operator {
-		case "=":
-			return e.compareValues(left, right) == 0, nil
-		case "!=", "<>":
-			return e.compareValues(left, right) != 0, nil
-		case "<":
-			return e.compareValues(left, right) < 0, nil
-		case ">":
-			return e.compareValues(left, right) > 0, nil
-		case "<=":
-			return e.compareValues(left, right) <= 0, nil
-		case ">=":
-			return e.compareValues(left, right) >= 0, nil
-		case "AND":
-			leftResult, err := e.evaluateWhere(rowData, w.Left)
-			if err != nil {
-				return false, err
-			}
-			rightResult, err := e.evaluateWhere(rowData, w.Right)
-			if err != nil {
-				return false, err
-			}
-			return leftResult && rightResult, nil
-		case "OR":
-			leftResult, err := e.evaluateWhere(rowData, w.Left)
-			if err != nil {
-				return false, err
+	value, err := e.evaluateCondition(rowData, where)
+	if err != nil {
+		return false, err
+	}
+	return value == true, nil
+}
+
+// compareCollated compares two values, comparing strings under a collation.
+// Values of types that do not compare with each other order by type, so
+// sorting a mix of types is consistent; comparisons in expressions go
+// through compareOperands, which rejects them instead.
+func compareCollated(a, b interface{}, collation storage.Collation) int {
+	// Integers compare exactly with each other and with floats, and as
+	// floats with anything else
+	if aInt, ok := a.(int64); ok {
+		switch bVal := b.(type) {
+		case int64:
+			return cmp.Compare(aInt, bVal)
+		case float64:
+			return compareIntFloat(aInt, bVal)
+		}
+		a = float64(aInt)
+	}
+	if bInt, ok := b.(int64); ok {
+		if aVal, ok := a.(float64); ok {
+			return -compareIntFloat(bInt, aVal)
+		}
+		b = float64(bInt)
+	}
+
+	// Rows stored before values were typed hold numbers as strings; compare
+	// them numerically against numeric operands, as indexKey does
+	if aStr, ok := a.(string); ok {
+		if _, isNum := b.(float64); isNum {
+			if f, err := strconv.ParseFloat(aStr, 64); err == nil {
+				a = f
 			}
-			rightResult, err := e.evaluateWhere(rowData, w.Right)
-			if err != nil {
-				return false, err
+		}
+	}
+	if bStr, ok := b.(string); ok {
+		if _, isNum := a.(float64); isNum {
+			if f, err := strconv.ParseFloat(bStr, 64); err == nil {
+				b = f
 			}
-			return leftResult || rightResult, nil
-		default:
-			return false, fmt.Errorf("unsupported operator: %s", w.Operator)
 		}
-	default:
-		return false, fmt.Errorf("unsupported where expression: %T", where)
 	}
-}
 
-func (e *Executor) compareValues(a, b interface{}) int {
 	switch aVal := a.(type) {
 	case string:
 		if bVal, ok := b.(string); ok {
-			if aVal < bVal {
-				return -1
-			} else if aVal > bVal {
-				return 1
-			}
-			return 0
+			return collation.Compare(aVal, bVal)
 		}
 	case float64:
 		if bVal, ok := b.(float64); ok {
@@ -834,6 +1303,14 @@ func (e *Executor) compareValues(a, b interface{}) int {
 			}
 			return 0
 		}
+	case time.Time:
+		if bVal, ok := b.(time.Time); ok {
+			return aVal.Compare(bVal)
+		}
+	case []byte:
+		if bVal, ok := b.([]byte); ok {
+			return bytes.Compare(aVal, bVal)
+		}
 	case bool:
 		if bVal, ok := b.(bool); ok {
 			if !aVal && bVal {
@@ -843,11 +1320,38 @@ func (e *Executor) compareValues(a, b interface{}) int {
 			}
 			return 0
 		}
+	case []float64:
+		if bVal, ok := b.([]float64); ok {
+			return slices.Compare(aVal, bVal)
+		}
+	}
+	aType, _ := storage.ValueTypeOf(a)
+	bType, _ := storage.ValueTypeOf(b)
+	return cmp.Compare(aType, bType)
+}
+
+// compareIntFloat compares an integer with a float without rounding the
+// integer, which a float cannot hold exactly above 2^53. NaN compares equal,
+// as it does with floats.
+func compareIntFloat(i int64, f float64) int {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f >= math.MaxInt64:
+		return -1
+	case f < math.MinInt64:
+		return 1
 	}
-	return 0
+	whole := math.Trunc(f)
+	if c := cmp.Compare(i, int64(whole)); c != 0 {
+		return c
+	}
+	return cmp.Compare(whole, f)
 }
 
-func (e *Executor) updateRowData(rowData []interface{}, setMap map[string]Expression) []interface{} {
+// updateRowData applies the SET clause of an UPDATE to a row. Every
+// expression sees the row as it was before the update.
+func (e *Executor) updateRowData(rowData []interface{}, setMap map[string]Expression) ([]interface{}, error) {
 	// Create a map for easier column access
 	columnMap := make(map[string]interface{})
 	for i := 1; i < len(rowData); i += 2 {
@@ -858,7 +1362,11 @@ func (e *Executor) updateRowData(rowData []interface{}, setMap map[string]Expres
 
 	// Update columns
 	for column, expr := range setMap {
-		columnMap[column] = e.evaluateExpression(expr)
+		value, err := e.evaluate(rowData, expr)
+		if err != nil {
+			return nil, err
+		}
+		columnMap[column] = value
 	}
 
 	// Rebuild row data
@@ -868,7 +1376,7 @@ func (e *Executor) updateRowData(rowData []interface{}, setMap map[string]Expres
 		newRowData = append(newRowData, column, value)
 	}
 
-	return newRowData
+	return newRowData, nil
 }
 
 func (e *Executor) extractIndexableColumn(where Expression) (string, interface{}, bool) {
@@ -877,15 +1385,15 @@ func (e *Executor) extractIndexableColumn(where Expression) (string, interface{}
 		if w.Operator == "=" {
 			leftIdent, okLeft := w.Left.(*Identifier)
 			if okLeft {
-				rightVal := e.evaluateExpression(w.Right)
-				if rightVal != nil {
+				rightVal, err := e.evaluateExpression(w.Right)
+				if err == nil && rightVal != nil {
 					return leftIdent.Value, rightVal, true
 				}
 			}
 			rightIdent, okRight := w.Right.(*Identifier)
 			if okRight {
-				leftVal := e.evaluateExpression(w.Left)
-				if leftVal != nil {
+				leftVal, err := e.evaluateExpression(w.Left)
+				if err == nil && leftVal != nil {
 					return rightIdent.Value, leftVal, true
 				}
 			}
@@ -895,119 +1403,160 @@ func (e *Executor) extractIndexableColumn(where Expression) (string, interface{}
 }
 
 func (e *Executor) updateIndexesOnInsert(tableName, rowKey string, rowData []interface{}) {
+	e.logIndexBuilds(tableName, rowKey, rowData)
+	e.updateAdaptiveIndexes(tableName, rowKey, nil, rowData)
+
 	indexManager := e.storage.GetIndexManager()
 	indexNames := indexManager.ListIndexes()
 
 	for _, indexName := range indexNames {
-		var columnName string
-		indexMetadataKey := fmt.Sprintf("_index_metadata:%s", indexName)
-		indexMetadata, err := e.storage.Get(indexMetadataKey)
-		if err == nil {
-			metadata := string(indexMetadata)
-			if strings.Contains(metadata, fmt.Sprintf("table:%s", tableName)) {
-				parts := strings.Split(metadata, ":")
-				if len(parts) >= 4 {
-					columnName = parts[3]
-				}
-			}
+		definition := e.tableIndexDefinition(indexName, tableName)
+		if definition == nil {
+			continue
 		}
-		if columnName == "" {
-			if strings.HasPrefix(indexName, fmt.Sprintf("%s_", tableName)) && strings.HasSuffix(indexName, "_idx") {
-				parts := strings.Split(indexName, "_")
-				if len(parts) >= 2 {
-					columnName = parts[1]
-				}
-			}
+		e.indexChanged(indexName)
+		value := e.indexedValue(definition, rowData)
+		if value == nil {
+			continue
 		}
-		if columnName != "" {
-			columnValue := e.findColumnValue(rowData, columnName)
-			if columnValue != nil {
-				indexKey := fmt.Sprintf("%v", columnValue)
-				indexManager.Insert(indexName, indexKey, []byte(rowKey))
-			}
+		if rowKeyedIndex(definition.Type) {
+			indexManager.Insert(indexName, rowKey, []byte(columnText(value)))
+		} else {
+			indexManager.Insert(indexName, e.indexEntryKey(value, e.definitionCollation(definition), rowKey), e.indexEntry(definition, rowKey, rowData))
 		}
 	}
 }
 
 func (e *Executor) updateIndexesOnUpdate(tableName, rowKey string, oldRowData, newRowData []interface{}) {
+	e.logIndexBuilds(tableName, rowKey, newRowData)
+	e.updateAdaptiveIndexes(tableName, rowKey, oldRowData, newRowData)
+
 	indexManager := e.storage.GetIndexManager()
 	indexNames := indexManager.ListIndexes()
 
 	for _, indexName := range indexNames {
-		var columnName string
-		if strings.HasPrefix(indexName, fmt.Sprintf("%s_", tableName)) && strings.HasSuffix(indexName, "_idx") {
-			parts := strings.Split(indexName, "_")
-			if len(parts) >= 2 {
-				columnName = parts[1]
-			}
-		}
-		indexMetadataKey := fmt.Sprintf("_index_metadata:%s", indexName)
-		indexMetadata, err := e.storage.Get(indexMetadataKey)
-		if err == nil {
-			metadata := string(indexMetadata)
-			if strings.Contains(metadata, fmt.Sprintf("table:%s", tableName)) {
-				parts := strings.Split(metadata, ":")
-				if len(parts) >= 4 {
-					columnName = parts[3]
-				}
-			}
+		definition := e.tableIndexDefinition(indexName, tableName)
+		if definition == nil {
+			continue
 		}
-		if columnName != "" {
-			oldValue := e.findColumnValue(oldRowData, columnName)
-			newValue := e.findColumnValue(newRowData, columnName)
+		e.indexChanged(indexName)
+		// A row moving in or out of a partial index has only one of the two
+		oldValue := e.indexedValue(definition, oldRowData)
+		newValue := e.indexedValue(definition, newRowData)
 
-			if oldValue != nil {
-				oldIndexKey := fmt.Sprintf("%v", oldValue)
-				indexManager.Delete(indexName, oldIndexKey)
-			}
+		if rowKeyedIndex(definition.Type) {
+			// The new value replaces the old one under the same row key
 			if newValue != nil {
-				newIndexKey := fmt.Sprintf("%v", newValue)
-				indexManager.Insert(indexName, newIndexKey, []byte(rowKey))
+				indexManager.Insert(indexName, rowKey, []byte(columnText(newValue)))
+			} else if oldValue != nil {
+				indexManager.Delete(indexName, rowKey)
 			}
+			continue
+		}
+
+		collation := e.definitionCollation(definition)
+		if oldValue != nil {
+			indexManager.Delete(indexName, e.indexEntryKey(oldValue, collation, rowKey))
+		}
+		if newValue != nil {
+			indexManager.Insert(indexName, e.indexEntryKey(newValue, collation, rowKey), e.indexEntry(definition, rowKey, newRowData))
 		}
 	}
 }
 
 func (e *Executor) updateIndexesOnDelete(tableName, rowKey string, rowData []interface{}) {
+	e.logIndexBuilds(tableName, rowKey, nil)
+	e.updateAdaptiveIndexes(tableName, rowKey, rowData, nil)
+
 	indexManager := e.storage.GetIndexManager()
 	indexNames := indexManager.ListIndexes()
 
 	for _, indexName := range indexNames {
-		var columnName string
-		if strings.HasPrefix(indexName, fmt.Sprintf("%s_", tableName)) && strings.HasSuffix(indexName, "_idx") {
-			parts := strings.Split(indexName, "_")
-			if len(parts) >= 2 {
-				columnName = parts[1]
-			}
-		}
-		indexMetadataKey := fmt.Sprintf("_index_metadata:%s", indexName)
-		indexMetadata, err := e.storage.Get(indexMetadataKey)
-		if err == nil {
-			metadata := string(indexMetadata)
-			if strings.Contains(metadata, fmt.Sprintf("table:%s", tableName)) {
-				parts := strings.Split(metadata, ":")
-				if len(parts) >= 4 {
-					columnName = parts[3]
-				}
-			}
+		definition := e.tableIndexDefinition(indexName, tableName)
+		if definition == nil {
+			continue
 		}
-		if columnName != "" {
-			columnValue := e.findColumnValue(rowData, columnName)
-			if columnValue != nil {
-				indexKey := fmt.Sprintf("%v", columnValue)
-				indexManager.Delete(indexName, indexKey)
-			}
+		e.indexChanged(indexName)
+		if rowKeyedIndex(definition.Type) {
+			indexManager.Delete(indexName, rowKey)
+			continue
+		}
+		value := e.indexedValue(definition, rowData)
+		if value != nil {
+			indexManager.Delete(indexName, e.indexEntryKey(value, e.definitionCollation(definition), rowKey))
 		}
 	}
 }
 
+// rowKeyedIndex reports whether an index type stores entries under the row
+// key, holding the column value, rather than under the encoded column value
+func rowKeyedIndex(indexType storage.IndexType) bool {
+	return indexType == storage.IndexTypeFullText || indexType == storage.IndexTypeVector
+}
+
 func (e *Executor) findColumnValue(rowData []interface{}, columnName string) interface{} {
-	for i := 1; i < len(rowData); i += 2 {
-		if i+1 < len(rowData) {
-			if rowData[i] == columnName {
+	return rowColumnValue(rowData, columnName)
+}
+
+// indexKey encodes a column value as an index key, keeping the type it was
+// stored as, so the text '10' and the number 10 have different keys. Strings
+// are encoded by their sort key under the column's collation. The key of a
+// multi-column index encodes its values in order.
+func (e *Executor) indexKey(value interface{}, collation storage.Collation) string {
+	if key, ok := value.(compositeKey); ok {
+		return storage.EncodeKey(key...)
+	}
+	return storage.EncodeKey(collation.KeyValue(value))
+}
+
+// joinKey encodes a value for matching in a hash join or semi-join. An
+// equality between text and a number compares the text as a number, so
+// numeric and boolean text is encoded as the value it represents.
+func (e *Executor) joinKey(value interface{}, collation storage.Collation) string {
+	return storage.EncodeKey(collation.KeyValue(normalizeValue(value)))
+}
+
+// indexEntryKey returns the key of a row's entry in an index keyed by
+// value: the value's index key followed by the row key, so each row holding
+// a value has an entry of its own
+func (e *Executor) indexEntryKey(value interface{}, collation storage.Collation, rowKey string) string {
+	return storage.IndexEntryKey(e.indexKey(value, collation), rowKey)
+}
+
+// rowColumnValue returns the value of a named column in an interleaved row.
+// The columns of joined rows are named "table.column": an unqualified name
+// finds the column of whichever table has it. A qualified name also finds
+// the column in the row of a single table, which holds bare names.
+func rowColumnValue(rowData []interface{}, columnName string) interface{} {
+	qualifier, column := splitColumnName(columnName)
+	for i := 1; i+1 < len(rowData); i += 2 {
+		name, _ := rowData[i].(string)
+		if name == columnName {
+			return rowData[i+1]
+		}
+		if qualifier != "" {
+			if name == column {
 				return rowData[i+1]
 			}
+		} else if len(name) > len(column) && name[len(name)-len(column)-1] == '.' && strings.HasSuffix(name, column) {
+			return rowData[i+1]
 		}
 	}
 	return nil
 }
+
+// tableNames returns the names of all tables, sorted
+func (e *Executor) tableNames() ([]string, error) {
+	keys, err := e.storage.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+	var names []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, "_table_metadata:") {
+			names = append(names, strings.TrimPrefix(key, "_table_metadata:"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
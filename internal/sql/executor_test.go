@@ -0,0 +1,1548 @@
+package sql
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+// newTestExecutor returns an executor over an empty in-memory database
+func newTestExecutor(t *testing.T) *Executor {
+	t.Helper()
+	return NewExecutor(storage.New(storage.NewMemoryEngine()))
+}
+
+// execute parses and runs one statement
+func execute(e *Executor, query string) (*QueryResult, error) {
+	stmt, err := NewParser(query).Parse()
+	if err != nil {
+		return nil, err
+	}
+	return e.Execute(stmt)
+}
+
+// mustExec runs statements, failing the test on the first that fails
+func mustExec(t *testing.T, e *Executor, queries ...string) {
+	t.Helper()
+	for _, query := range queries {
+		if _, err := execute(e, query); err != nil {
+			t.Fatalf("%s failed: %v", query, err)
+		}
+	}
+}
+
+// queryRows runs a query and returns its rows, each written as its values
+// separated by "|", with NULL for a missing value
+func queryRows(t *testing.T, e *Executor, query string) []string {
+	t.Helper()
+	result, err := execute(e, query)
+	if err != nil {
+		t.Fatalf("%s failed: %v", query, err)
+	}
+	rows := make([]string, len(result.Rows))
+	for i, row := range result.Rows {
+		values := make([]string, len(row))
+		for j, value := range row {
+			if value == nil {
+				values[j] = "NULL"
+			} else {
+				values[j] = columnText(value)
+			}
+		}
+		rows[i] = strings.Join(values, "|")
+	}
+	return rows
+}
+
+// putLegacyTable stores a table as the text formats before the catalog and
+// the binary row format wrote it: the metadata string and one
+// pipe-delimited row per entry of rows, each holding a value per column
+func putLegacyTable(t *testing.T, store *storage.Storage, table string, columns []string, rows ...[]string) {
+	t.Helper()
+	metadata := fmt.Sprintf("table:%s:created:1700000000:columns:%s", table, strings.Join(columns, ","))
+	if err := store.Put(tableMetadataKey(table), []byte(metadata)); err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range rows {
+		id := fmt.Sprintf("%d", 1700000000000000000+i)
+		parts := []string{id}
+		for j, value := range row {
+			parts = append(parts, columns[j], value)
+		}
+		if err := store.Put(table+":"+id, []byte(strings.Join(parts, "|"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expectRows checks that a query returns exactly the given rows, in order
+func expectRows(t *testing.T, e *Executor, query string, want ...string) {
+	t.Helper()
+	got := queryRows(t, e, query)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("%s returned %q, expected %q", query, got, want)
+	}
+}
+
+// expectColumns checks the column names of a query's result
+func expectColumns(t *testing.T, e *Executor, query string, want ...string) {
+	t.Helper()
+	result, err := execute(e, query)
+	if err != nil {
+		t.Fatalf("%s failed: %v", query, err)
+	}
+	if fmt.Sprint(result.Columns) != fmt.Sprint(want) {
+		t.Fatalf("%s has columns %q, expected %q", query, result.Columns, want)
+	}
+}
+
+// expectError checks that a query fails with an error containing text
+func expectError(t *testing.T, e *Executor, query, text string) {
+	t.Helper()
+	_, err := execute(e, query)
+	if err == nil {
+		t.Fatalf("%s succeeded, expected an error containing %q", query, text)
+	}
+	if !strings.Contains(err.Error(), text) {
+		t.Fatalf("%s failed with %q, expected an error containing %q", query, err, text)
+	}
+}
+
+// expectPlan checks that the EXPLAIN output of a query has an operator
+// starting with the given name
+func expectPlan(t *testing.T, e *Executor, query, operator string) {
+	t.Helper()
+	plan := queryRows(t, e, "EXPLAIN "+query)
+	for _, row := range plan {
+		name := strings.TrimLeft(strings.SplitN(row, "|", 2)[0], " ->")
+		if strings.HasPrefix(name, operator) {
+			return
+		}
+	}
+	t.Fatalf("EXPLAIN %s has no %s operator:\n%s", query, operator, strings.Join(plan, "\n"))
+}
+
+// expectConsistentIndexes checks that every index matches its table
+func expectConsistentIndexes(t *testing.T, e *Executor) {
+	t.Helper()
+	reports, err := CheckIndexes(e.storage)
+	if err != nil {
+		t.Fatalf("CheckIndexes failed: %v", err)
+	}
+	for _, report := range reports {
+		if len(report.Problems) > 0 {
+			t.Fatalf("Index %s has problems: %+v", report.Index, report.Problems)
+		}
+	}
+}
+
+func TestIndexDuplicateValues(t *testing.T) {
+	for _, using := range []string{"BTREE", "HASH"} {
+		e := newTestExecutor(t)
+		mustExec(t, e,
+			"CREATE TABLE t (id INT, v INT)",
+			"INSERT INTO t VALUES (1, 5), (2, 5), (3, 7)",
+			"CREATE INDEX t_v ON t (v) USING "+using,
+		)
+
+		expectPlan(t, e, "SELECT id FROM t WHERE v = 5", "index_scan")
+		expectRows(t, e, "SELECT id FROM t WHERE v = 5 ORDER BY id", "1", "2")
+		mustExec(t, e, "DELETE FROM t WHERE id = 2")
+		expectRows(t, e, "SELECT id FROM t WHERE v = 5", "1")
+		expectConsistentIndexes(t, e)
+
+		mustExec(t, e,
+			"INSERT INTO t VALUES (4, 5), (5, 5)",
+			"UPDATE t SET v = 6 WHERE v = 5",
+		)
+		expectRows(t, e, "SELECT id FROM t WHERE v = 5")
+		expectRows(t, e, "SELECT id FROM t WHERE v = 6 ORDER BY id", "1", "4", "5")
+		mustExec(t, e, "DELETE FROM t WHERE v = 6")
+		expectRows(t, e, "SELECT id FROM t ORDER BY id", "3")
+		expectConsistentIndexes(t, e)
+	}
+}
+
+func TestIndexRangeIncludesDuplicateBounds(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT)",
+		"INSERT INTO t VALUES (1, 5), (2, 5), (3, 7), (4, 9)",
+		"CREATE INDEX t_v ON t (v)",
+	)
+	expectPlan(t, e, "SELECT id FROM t WHERE v <= 7", "index_range")
+	expectRows(t, e, "SELECT id FROM t WHERE v <= 7 ORDER BY id", "1", "2", "3")
+	expectRows(t, e, "SELECT id FROM t WHERE v >= 5 AND v <= 5 ORDER BY id", "1", "2")
+}
+
+func TestIndexKeysKeepStoredTypes(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE c (id INT, code TEXT)",
+		"INSERT INTO c VALUES (1, '10'), (2, '9'), (3, 'abc'), (4, 'Infinity'), (5, '1')",
+	)
+	queries := map[string][]string{
+		"SELECT id FROM c WHERE code > '5' ORDER BY id": {"2", "3", "4"},
+		"SELECT id FROM c WHERE code = '1'":             {"5"},
+		"SELECT id FROM c WHERE code = '10'":            {"1"},
+	}
+	for query, want := range queries {
+		expectRows(t, e, query, want...)
+	}
+
+	mustExec(t, e, "CREATE INDEX c_code ON c (code)")
+	expectPlan(t, e, "SELECT id FROM c WHERE code > '5'", "index_range")
+	expectPlan(t, e, "SELECT id FROM c WHERE code = '1'", "index_scan")
+	for query, want := range queries {
+		expectRows(t, e, query, want...)
+	}
+	// A number compared with text matches any text form of it, which an
+	// index on the text cannot find
+	expectPlan(t, e, "SELECT id FROM c WHERE code = 10", "filter")
+}
+
+func TestIndexRangeStrictBounds(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT)",
+		"INSERT INTO t VALUES (1, 5), (2, 5), (3, 7), (4, 9), (5, 9)",
+		"CREATE INDEX t_v ON t (v)",
+	)
+	plan := queryRows(t, e, "EXPLAIN SELECT id FROM t WHERE v > 5 AND v < 9")
+	if len(plan) != 2 || !strings.HasPrefix(plan[1], "-> index_range|t|t_v|v > 5 AND v < 9|") {
+		t.Fatalf("Expected an index range with strict bounds, got:\n%s", strings.Join(plan, "\n"))
+	}
+
+	// Without the WHERE clause to filter them, only the rows strictly
+	// inside the range are read from the index
+	stmt, err := NewParser("SELECT id FROM t WHERE v > 5 AND v < 9").Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	planned, err := e.planner.PlanSelect(stmt.(*SelectStatement))
+	if err != nil {
+		t.Fatalf("PlanSelect failed: %v", err)
+	}
+	rows, _, err := e.scanIndexRange(planned)
+	if err != nil {
+		t.Fatalf("scanIndexRange failed: %v", err)
+	}
+	if len(rows) != 1 || rowColumnValue(rows[0], "v") != int64(7) {
+		t.Fatalf("Expected only the row with v = 7, got %v", rows)
+	}
+
+	expectRows(t, e, "SELECT id FROM t WHERE v > 5 ORDER BY v DESC, id", "4", "5", "3")
+	expectRows(t, e, "SELECT id FROM t WHERE v < 9 AND v >= 5 ORDER BY v, id", "1", "2", "3")
+}
+
+func TestCoveringIndexNeedsEveryColumn(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE u (id INT, age INT, email TEXT)",
+		"INSERT INTO u VALUES (1, 50, 'a@x'), (2, 40, 'b@x'), (3, 50, 'new@x')",
+		"CREATE INDEX ci ON u (age) INCLUDE (email)",
+	)
+	expectPlan(t, e, "SELECT email FROM u WHERE age = 50", "index_only_scan")
+	expectRows(t, e, "SELECT email FROM u WHERE age = 50 ORDER BY email", "a@x", "new@x")
+
+	expectPlan(t, e, "SELECT email FROM u WHERE age = 50 AND id = 3", "index_scan")
+	expectRows(t, e, "SELECT email FROM u WHERE age = 50 AND id = 3", "new@x")
+	expectRows(t, e, "SELECT id, email FROM u WHERE age = 40", "2|b@x")
+}
+
+func TestReindexAfterWrites(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT)",
+		"INSERT INTO t VALUES (1, 5), (2, 5), (3, 7)",
+		"CREATE INDEX t_v ON t (v)",
+		"INSERT INTO t VALUES (4, 7)",
+		"DELETE FROM t WHERE id = 1",
+		"REINDEX t_v",
+	)
+	expectConsistentIndexes(t, e)
+	expectRows(t, e, "SELECT id FROM t WHERE v = 7 ORDER BY id", "3", "4")
+}
+
+func TestFailedIndexBuildStopsLogging(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT)",
+		"INSERT INTO t VALUES (1, 5)",
+		"CREATE INDEX t_v ON t (v)",
+	)
+	definition, err := loadIndexDefinition(e.storage, "t_v")
+	if err != nil {
+		t.Fatalf("loadIndexDefinition failed: %v", err)
+	}
+	// A B-tree with a minimum degree below 2 cannot be bulk-loaded
+	if _, err := e.populateIndex("t_v", definition, storage.NewBTree(1)); err == nil {
+		t.Fatal("Expected the build to fail")
+	}
+	if building := e.storage.GetIndexManager().BuildingIndexes(); len(building) != 0 {
+		t.Fatalf("Expected no index to be building after the failure, got %v", building)
+	}
+	mustExec(t, e, "INSERT INTO t VALUES (2, 5)")
+	expectConsistentIndexes(t, e)
+}
+
+func TestCatalogEnforcesColumns(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE c (id INT, n INT NOT NULL, s TEXT DEFAULT 'x')",
+		"INSERT INTO c (id, n) VALUES (1, 2)",
+	)
+	expectRows(t, e, "SELECT id, n, s FROM c", "1|2|x")
+	expectError(t, e, "INSERT INTO c (id, s) VALUES (2, 'y')", "NOT NULL column 'n'")
+	expectError(t, e, "INSERT INTO c (id, n, bogus) VALUES (3, 1, 2)", "column 'bogus' does not exist")
+	expectError(t, e, "INSERT INTO c (id, n) VALUES (4, 'abc')", "is not a valid INT")
+	expectError(t, e, "UPDATE c SET n = NULL WHERE id = 1", "NOT NULL column 'n'")
+	expectError(t, e, "UPDATE c SET zz = 1 WHERE id = 1", "column 'zz' does not exist")
+	expectRows(t, e, "SELECT id, n, s FROM c", "1|2|x")
+}
+
+func TestDropTableDropsIndexes(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT)",
+		"INSERT INTO t VALUES (1, 5)",
+		"CREATE INDEX dv ON t (v)",
+		"SELECT id FROM t WHERE v = 5",
+		"DROP TABLE t",
+		"CREATE TABLE t (id INT, w TEXT)",
+		"INSERT INTO t VALUES (1, 'a')",
+		"CREATE INDEX dv ON t (w)",
+	)
+	stats := queryRows(t, e, "SHOW INDEX STATS")
+	if len(stats) != 1 || !strings.HasPrefix(stats[0], "dv|t|w|") {
+		t.Fatalf("Expected only dv on the new column in the index stats, got %q", stats)
+	}
+	expectRows(t, e, "SELECT id FROM t WHERE w = 'a'", "1")
+	expectConsistentIndexes(t, e)
+
+	mustExec(t, e, "DROP TABLE t")
+	if names, _ := indexNames(e.storage); len(names) != 0 {
+		t.Fatalf("Expected no index definitions after dropping the table, got %v", names)
+	}
+	if e.storage.GetIndexManager().Exists("dv") {
+		t.Fatal("Expected the index to be dropped with its table")
+	}
+}
+
+func TestOrderByTypedValues(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE c (id INT, code TEXT, n INT)",
+		"INSERT INTO c VALUES (1, '10', 3), (2, '9', NULL), (3, 'abc', 1), (4, 'Infinity', 3), (5, NULL, 2)",
+	)
+	expectRows(t, e, "SELECT code FROM c ORDER BY code", "10", "9", "Infinity", "abc", "NULL")
+	expectRows(t, e, "SELECT id FROM c ORDER BY n DESC, id", "2", "1", "4", "5", "3")
+	expectRows(t, e, "SELECT id FROM c ORDER BY n DESC NULLS LAST, id DESC", "4", "1", "5", "3", "2")
+	expectRows(t, e, "SELECT id FROM c ORDER BY n NULLS FIRST, id", "2", "3", "5", "1", "4")
+}
+
+func TestOrderByIndexedColumn(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT)",
+		"INSERT INTO t VALUES (1, 30), (2, NULL), (3, 10), (4, 20)",
+		"CREATE INDEX t_v ON t (v)",
+	)
+	expectPlan(t, e, "SELECT id FROM t ORDER BY v", "index_range")
+	orders := map[string][]string{
+		"SELECT id FROM t ORDER BY v":                  {"3", "4", "1", "2"},
+		"SELECT id FROM t ORDER BY v DESC":             {"2", "1", "4", "3"},
+		"SELECT id FROM t ORDER BY v NULLS FIRST":      {"2", "3", "4", "1"},
+		"SELECT id FROM t ORDER BY v DESC NULLS LAST":  {"1", "4", "3", "2"},
+		"SELECT id FROM t ORDER BY v LIMIT 2 OFFSET 1": {"4", "1"},
+	}
+	for query, want := range orders {
+		plan := queryRows(t, e, "EXPLAIN "+query)
+		for _, row := range plan {
+			if strings.HasPrefix(row, "sort") {
+				t.Fatalf("EXPLAIN %s sorts rows the index already orders:\n%s", query, strings.Join(plan, "\n"))
+			}
+		}
+		expectRows(t, e, query, want...)
+	}
+	expectRows(t, e, "SELECT id FROM t WHERE id > 1 ORDER BY v", "3", "4", "2")
+}
+
+func TestLimitOffsetAndDistinct(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE p (id INT, g TEXT, n INT)",
+		"INSERT INTO p VALUES (1, 'a', 5), (2, 'b', 3), (3, 'a', 4), (4, 'c', 3), (5, 'b', 1)",
+	)
+	expectRows(t, e, "SELECT id FROM p LIMIT 0")
+	expectRows(t, e, "SELECT id FROM p ORDER BY n LIMIT 0")
+	expectRows(t, e, "SELECT id FROM p ORDER BY n LIMIT 0 OFFSET 2")
+	expectRows(t, e, "SELECT DISTINCT g FROM p ORDER BY g LIMIT 0")
+	expectRows(t, e, "SELECT id FROM p ORDER BY n, id LIMIT 2", "5", "2")
+	expectRows(t, e, "SELECT id FROM p ORDER BY n, id LIMIT 2 OFFSET 2", "4", "3")
+	expectRows(t, e, "SELECT id FROM p ORDER BY n, id OFFSET 3 LIMIT 5", "3", "1")
+	expectRows(t, e, "SELECT id FROM p ORDER BY id OFFSET 4", "5")
+	expectRows(t, e, "SELECT id FROM p ORDER BY id OFFSET 9")
+	expectRows(t, e, "SELECT id FROM p ORDER BY n DESC, id LIMIT 3", "1", "3", "2")
+	expectRows(t, e, "SELECT DISTINCT g FROM p ORDER BY g", "a", "b", "c")
+	expectRows(t, e, "SELECT DISTINCT g FROM p ORDER BY g LIMIT 2 OFFSET 1", "b", "c")
+	expectRows(t, e, "SELECT DISTINCT ON (g) g, id FROM p ORDER BY g, n", "a|3", "b|5", "c|4")
+	expectPlan(t, e, "SELECT id FROM p ORDER BY n LIMIT 2", "top_n_sort")
+	expectError(t, e, "SELECT id FROM p LIMIT 0 LIMIT 1", "more than once")
+}
+
+func TestNumericLiterals(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE n (id INT, a INT, f FLOAT)",
+		"INSERT INTO n VALUES (1, 9007199254740992, 1.5), (2, 9007199254740993, 2)",
+		"CREATE INDEX n_a ON n (a)",
+	)
+	expectRows(t, e, "SELECT 7 / 2, 7.0 / 2, 7 / 2.0, 1e3, 1.5e-3, -9223372036854775808 FROM n WHERE id = 1",
+		"3|3.5|3.5|1000|0.0015|-9223372036854775808")
+	expectRows(t, e, "SELECT 7 % 3, -7 % 3, 7.5 % 2, 2 + 3 % 2 * 4 FROM n WHERE id = 1", "1|-1|1.5|6")
+	expectError(t, e, "SELECT 1 % 0 FROM n", "division by zero")
+	expectError(t, e, "SELECT 9223372036854775807 + 1 FROM n", "out of range")
+
+	expectColumns(t, e, "SELECT 7.0 / 2, a % 10, -1.50 FROM n", "7.0 / 2", "a % 10", "-1.50")
+
+	// Integers above 2^53 are stored, compared and computed exactly
+	expectRows(t, e, "SELECT a FROM n ORDER BY id", "9007199254740992", "9007199254740993")
+	expectRows(t, e, "SELECT id FROM n WHERE a = 9007199254740993", "2")
+	expectPlan(t, e, "SELECT id FROM n WHERE a = 9007199254740993", "index")
+	expectRows(t, e, "SELECT id FROM n WHERE a > 9007199254740992.0", "2")
+	expectRows(t, e, "SELECT id FROM n WHERE f = 2", "2")
+	mustExec(t, e, "UPDATE n SET a = a + 1 WHERE id = 1")
+	expectRows(t, e, "SELECT a FROM n WHERE id = 1", "9007199254740993")
+	expectRows(t, e, "SELECT id FROM n WHERE a = 9007199254740993 ORDER BY id", "1", "2")
+	expectConsistentIndexes(t, e)
+}
+
+func TestAnalyzeGuidesPlanner(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e, "CREATE TABLE s (id INT, v INT, u INT, w TEXT)")
+	var values []string
+	for i := 0; i < 200; i++ {
+		w := "NULL"
+		if i%4 == 0 {
+			w = fmt.Sprintf("'w%d'", i)
+		}
+		values = append(values, fmt.Sprintf("(%d, %d, %d, %s)", i, i%2, i, w))
+	}
+	mustExec(t, e,
+		"INSERT INTO s VALUES "+strings.Join(values, ", "),
+		"CREATE INDEX s_v ON s (v)",
+		"CREATE INDEX s_u ON s (u)",
+		"CREATE TABLE k (id INT, s_id INT)",
+		"INSERT INTO k VALUES (1, 5), (2, 7)",
+	)
+
+	// Without statistics a range is guessed to match a third of the rows
+	expectPlan(t, e, "SELECT id FROM s WHERE u < 190", "index_range")
+
+	expectRows(t, e, "ANALYZE s", "s|200|4")
+	expectRows(t, e, "ANALYZE", "k|2|2", "s|200|4")
+	expectError(t, e, "ANALYZE missing", "does not exist")
+
+	stats := loadTableStatistics(e.storage, "s")
+	if stats == nil || stats.RowCount != 200 {
+		t.Fatalf("Expected statistics for 200 rows, got %+v", stats)
+	}
+	if v := stats.Columns["v"]; v.DistinctCount != 2 || v.NullFraction != 0 {
+		t.Fatalf("Expected 2 distinct values of v and no NULLs, got %+v", v)
+	}
+	w := stats.Columns["w"]
+	if w.DistinctCount != 50 || w.NullFraction != 0.75 {
+		t.Fatalf("Expected 50 distinct values of w and 75%% NULLs, got %+v", w)
+	}
+	counted := 0
+	for _, bucket := range w.Histogram {
+		counted += bucket.Count
+	}
+	if counted != 50 {
+		t.Fatalf("Expected the histogram of w to hold its 50 values, got %d", counted)
+	}
+
+	estimates := map[string]string{
+		"SELECT id FROM s WHERE v = 1":     "100",
+		"SELECT id FROM s WHERE u = 5":     "1",
+		"SELECT id FROM s WHERE w IS NULL": "150",
+	}
+	for query, want := range estimates {
+		plan := queryRows(t, e, "EXPLAIN "+query)
+		if got := strings.Split(plan[0], "|")[5]; got != want {
+			t.Fatalf("EXPLAIN %s estimates %s rows, expected %s:\n%s", query, got, want, strings.Join(plan, "\n"))
+		}
+	}
+	expectPlan(t, e, "SELECT id FROM s WHERE u < 190", "table_scan")
+	expectPlan(t, e, "SELECT id FROM s WHERE u < 10", "index_range")
+
+	// The small table is read first and probes the large one
+	plan := queryRows(t, e, "EXPLAIN SELECT k.id FROM s JOIN k ON s.id = k.s_id")
+	if !strings.HasPrefix(strings.TrimLeft(plan[1], " ->"), "table_scan|k") {
+		t.Fatalf("Expected the join to read k first:\n%s", strings.Join(plan, "\n"))
+	}
+	expectRows(t, e, "SELECT k.id, s.u FROM s JOIN k ON s.id = k.s_id ORDER BY k.id", "1|5", "2|7")
+}
+
+func TestExplainAnalyzeAndAliases(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE small (id INT, v INT)",
+		"INSERT INTO small VALUES (1, 10), (2, 20), (3, 30)",
+	)
+
+	// A self-join names each side by its alias
+	query := "SELECT s1.v FROM small s1 JOIN small s2 ON s1.id = s2.id"
+	expectPlan(t, e, query, "hash_join")
+	plan := queryRows(t, e, "EXPLAIN "+query)
+	if len(plan) != 3 || strings.Split(plan[0], "|")[3] != "s1.id = s2.id" ||
+		!strings.HasPrefix(strings.TrimLeft(plan[1], " ->"), "table_scan|small s1|") ||
+		!strings.HasPrefix(strings.TrimLeft(plan[2], " ->"), "table_scan|small s2|") {
+		t.Fatalf("Expected the join of s1 and s2 by their aliases:\n%s", strings.Join(plan, "\n"))
+	}
+	expectRows(t, e, query+" ORDER BY s1.v", "10", "20", "30")
+
+	// ANALYZE runs the query and reports what each operator produced
+	query = "SELECT id FROM small WHERE v > 10 ORDER BY id"
+	expectPlan(t, e, "ANALYZE "+query, "total")
+	expectColumns(t, e, "EXPLAIN ANALYZE "+query,
+		"operator", "table", "index", "index_condition", "condition", "estimated_rows", "estimated_cost",
+		"actual_rows", "keys_scanned", "time")
+	plan = queryRows(t, e, "EXPLAIN ANALYZE "+query)
+	actual := map[string]string{}
+	for _, row := range plan {
+		fields := strings.Split(row, "|")
+		operator := strings.TrimLeft(fields[0], " ->")
+		actual[operator] = fields[7]
+		if _, err := time.ParseDuration(fields[9]); err != nil {
+			t.Fatalf("Expected %s to report its time, got %q", operator, fields[9])
+		}
+		if operator == "table_scan" && fields[8] == "0" {
+			t.Fatalf("Expected the scan to report the keys it read:\n%s", strings.Join(plan, "\n"))
+		}
+	}
+	if actual["table_scan"] != "3" || actual["filter"] != "2" || actual["sort"] != "2" || actual["total"] != "" {
+		t.Fatalf("Expected 3 rows scanned and 2 filtered and sorted:\n%s", strings.Join(plan, "\n"))
+	}
+
+	plan = queryRows(t, e, "EXPLAIN ANALYZE SELECT s1.v FROM small s1 JOIN small s2 ON s1.id = s2.id")
+	if fields := strings.Split(plan[0], "|"); fields[0] != "hash_join (inner)" || fields[1] != "small s2" || fields[7] != "3" {
+		t.Fatalf("Expected the join of s1 and s2 to produce 3 rows:\n%s", strings.Join(plan, "\n"))
+	}
+}
+
+func TestCheckIndexesFindsDrift(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT)",
+		"INSERT INTO t VALUES (1, 10), (2, 20), (3, 30)",
+		"CREATE INDEX t_v ON t (v)",
+		"CREATE INDEX t_h ON t (v) USING HASH",
+	)
+	expectConsistentIndexes(t, e)
+
+	rows, err := e.loadTableRows("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]string)
+	for _, row := range rows {
+		keys[columnText(rowColumnValue(row, "id"))] = rowKey("t", row)
+	}
+
+	// Lose row 1's entry, point an entry at a row that does not exist and
+	// index row 2 under a value it does not have
+	im := e.storage.GetIndexManager()
+	collation := storage.CollationBinary
+	if err := im.Delete("t_v", e.indexEntryKey(int64(10), collation, keys["1"])); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.Insert("t_v", e.indexEntryKey(int64(90), collation, "t:9"), []byte("t:9")); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.Delete("t_v", e.indexEntryKey(int64(20), collation, keys["2"])); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.Insert("t_v", e.indexEntryKey(int64(77), collation, keys["2"]), []byte(keys["2"])); err != nil {
+		t.Fatal(err)
+	}
+
+	reports, err := CheckIndexes(e.storage)
+	if err != nil {
+		t.Fatalf("CheckIndexes failed: %v", err)
+	}
+	var found []string
+	for _, report := range reports {
+		if report.Index == "t_h" && len(report.Problems) > 0 {
+			t.Fatalf("Expected t_h to be consistent, got %+v", report.Problems)
+		}
+		if report.Index != "t_v" {
+			continue
+		}
+		if report.Rows != 3 || report.Entries != 3 {
+			t.Fatalf("Expected 3 rows and 3 entries, got %d and %d", report.Rows, report.Entries)
+		}
+		for _, problem := range report.Problems {
+			found = append(found, problem.Kind+" "+problem.RowKey)
+		}
+	}
+	sort.Strings(found)
+	want := []string{"dangling t:9", "missing " + keys["1"], "stale " + keys["2"]}
+	if fmt.Sprint(found) != fmt.Sprint(want) {
+		t.Fatalf("Expected problems %s, got %v", want, found)
+	}
+
+	expectRows(t, e, "REINDEX t_v", "t_v|t|3")
+	expectConsistentIndexes(t, e)
+	expectRows(t, e, "SELECT id FROM t WHERE v = 10", "1")
+	expectRows(t, e, "SELECT id FROM t WHERE v = 77")
+
+	expectRows(t, e, "REINDEX t", "t_h|t|3", "t_v|t|3")
+	mustExec(t, e, "CREATE TABLE u (id INT, w TEXT)", "CREATE INDEX u_w ON u (w)")
+	expectRows(t, e, "REINDEX", "t_h|t|3", "t_v|t|3", "u_w|u|0")
+	expectError(t, e, "REINDEX missing", "does not exist")
+}
+
+func TestPartialAndExpressionIndexes(t *testing.T) {
+	engine := storage.NewMemoryEngine()
+	e := NewExecutor(storage.New(engine))
+	mustExec(t, e,
+		"CREATE TABLE u (id INT, email TEXT, v INT)",
+		"INSERT INTO u VALUES (1, 'Ann@X.org', 5), (2, 'bob@x.org', 15), (3, 'CAT@x.org', 25)",
+		"CREATE INDEX u_big ON u (v) WHERE v > 10",
+		"CREATE INDEX u_email ON u (LOWER(email))",
+	)
+
+	check := func(e *Executor) {
+		t.Helper()
+		expectPlan(t, e, "SELECT id FROM u WHERE v > 20", "index_range")
+		expectRows(t, e, "SELECT id FROM u WHERE v > 20", "3")
+		// v > 5 does not imply v > 10, so the partial index would miss rows
+		expectPlan(t, e, "SELECT id FROM u WHERE v > 5", "table_scan")
+		expectRows(t, e, "SELECT id FROM u WHERE v > 5 ORDER BY id", "2", "3")
+		expectPlan(t, e, "SELECT id FROM u WHERE LOWER(email) = 'cat@x.org'", "index_scan")
+		expectRows(t, e, "SELECT id FROM u WHERE LOWER(email) = 'cat@x.org'", "3")
+		expectPlan(t, e, "SELECT id FROM u WHERE email = 'cat@x.org'", "table_scan")
+		expectConsistentIndexes(t, e)
+	}
+	check(e)
+
+	// Rows outside the predicate are not indexed, and leave or join the
+	// index when an update moves them across it
+	entries, err := e.storage.GetIndexManager().GetAll("u_big")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected 2 entries in u_big, got %d (%v)", len(entries), err)
+	}
+	mustExec(t, e,
+		"UPDATE u SET v = 30 WHERE id = 1",
+		"UPDATE u SET v = 1 WHERE id = 3",
+		"UPDATE u SET email = 'Dan@X.org' WHERE id = 2",
+	)
+	expectRows(t, e, "SELECT id FROM u WHERE v > 20", "1")
+	expectRows(t, e, "SELECT id FROM u WHERE LOWER(email) = 'dan@x.org'", "2")
+	expectRows(t, e, "SELECT id FROM u WHERE LOWER(email) = 'bob@x.org'")
+	mustExec(t, e,
+		"UPDATE u SET v = 25 WHERE id = 3",
+		"UPDATE u SET v = 5 WHERE id = 1",
+		"UPDATE u SET email = 'bob@x.org' WHERE id = 2",
+	)
+
+	// The definitions are stored as expressions and read back the same
+	definition, err := loadIndexDefinition(e.storage, "u_big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definition.Where == nil || definition.Where.String() != "v > 10" {
+		t.Fatalf("Expected u_big to have the predicate v > 10, got %v", definition.Where)
+	}
+	definition, err = loadIndexDefinition(e.storage, "u_email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definition.Column != "" || definition.key() != "LOWER(email)" {
+		t.Fatalf("Expected u_email to be keyed by LOWER(email), got %q", definition.key())
+	}
+
+	e, _ = reopen(t, engine)
+	check(e)
+}
+
+func TestColumnCollations(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE c (id INT, b TEXT, n TEXT COLLATE nocase UNIQUE, u TEXT COLLATE unicode)",
+		"INSERT INTO c VALUES (1, 'apple', 'Apple', 'Élan'), (2, 'Banana', 'banana', 'elan'), (3, 'cherry', 'CHERRY', 'Zoë')",
+	)
+
+	check := func() {
+		t.Helper()
+		expectRows(t, e, "SELECT id FROM c ORDER BY b", "2", "1", "3")
+		expectRows(t, e, "SELECT id FROM c ORDER BY n", "1", "2", "3")
+		expectRows(t, e, "SELECT id FROM c ORDER BY u DESC, id", "3", "1", "2")
+		expectRows(t, e, "SELECT id FROM c WHERE b = 'APPLE'")
+		expectRows(t, e, "SELECT id FROM c WHERE n = 'APPLE'", "1")
+		expectRows(t, e, "SELECT id FROM c WHERE n > 'b' ORDER BY n", "2", "3")
+		expectRows(t, e, "SELECT id FROM c WHERE n IN ('CHERRY', 'x')", "3")
+		expectRows(t, e, "SELECT id FROM c WHERE u = 'ELAN' ORDER BY id", "1", "2")
+		expectRows(t, e, "SELECT id FROM c WHERE b LIKE 'b%'")
+		expectRows(t, e, "SELECT id FROM c WHERE n LIKE 'b%'", "2")
+		expectRows(t, e, "SELECT id FROM c WHERE u LIKE 'zoe'", "3")
+		// Which spelling a group shows is not defined, only its size
+		expectRows(t, e, "SELECT COUNT(*) FROM c GROUP BY u ORDER BY COUNT(*) DESC", "2", "1")
+	}
+	check()
+
+	// Index keys are encoded under the column's collation
+	mustExec(t, e,
+		"CREATE INDEX c_n ON c (n)",
+		"CREATE INDEX c_u ON c (u) USING HASH",
+	)
+	expectPlan(t, e, "SELECT id FROM c WHERE n = 'APPLE'", "index_scan")
+	expectPlan(t, e, "SELECT id FROM c WHERE u = 'ELAN'", "index_scan")
+	check()
+	expectConsistentIndexes(t, e)
+
+	expectError(t, e, "INSERT INTO c VALUES (4, 'x', 'APPLE', 'x')", "duplicate value 'APPLE'")
+	expectError(t, e, "UPDATE c SET n = 'CHERRY' WHERE id = 2", "duplicate value 'CHERRY'")
+	expectError(t, e, "CREATE TABLE d (a TEXT COLLATE klingon)", "unknown collation 'klingon'")
+}
+
+func TestProjectionAndQualifiedNames(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE users (id INT, name TEXT)",
+		"CREATE TABLE orders (id INT, user_id INT, total INT)",
+		"INSERT INTO users VALUES (1, 'ann'), (2, 'bob'), (3, 'cat')",
+		"INSERT INTO orders VALUES (10, 1, 5), (11, 1, 7), (12, 2, 3)",
+	)
+
+	expectColumns(t, e, "SELECT * FROM users", "id", "name")
+	expectRows(t, e, "SELECT * FROM users ORDER BY id", "1|ann", "2|bob", "3|cat")
+	expectColumns(t, e, "SELECT name AS who, id FROM users", "who", "id")
+	expectRows(t, e, "SELECT name AS who, id FROM users ORDER BY id", "ann|1", "bob|2", "cat|3")
+	expectRows(t, e, "SELECT name AS who FROM users ORDER BY who DESC", "cat", "bob", "ann")
+	expectRows(t, e, "SELECT users.name FROM users WHERE users.id = 2", "bob")
+
+	join := " FROM users u JOIN orders o ON u.id = o.user_id ORDER BY o.id"
+	expectColumns(t, e, "SELECT u.name, o.total"+join, "name", "total")
+	expectRows(t, e, "SELECT u.name, o.total"+join, "ann|5", "ann|7", "bob|3")
+	expectColumns(t, e, "SELECT *"+join, "id", "name", "id", "user_id", "total")
+	expectRows(t, e, "SELECT *"+join, "1|ann|10|1|5", "1|ann|11|1|7", "2|bob|12|2|3")
+	expectRows(t, e, "SELECT u.*, o.total"+join, "1|ann|5", "1|ann|7", "2|bob|3")
+	expectRows(t, e, "SELECT u.name, o.total FROM users u LEFT JOIN orders o ON u.id = o.user_id ORDER BY u.id, o.id",
+		"ann|5", "ann|7", "bob|3", "cat|NULL")
+
+	expectError(t, e, "SELECT id FROM users u JOIN orders o ON u.id = o.user_id", "ambiguous")
+	expectError(t, e, "SELECT missing FROM users", "column 'missing' does not exist")
+	expectError(t, e, "SELECT x.name FROM users u", "'x' is not in the FROM clause")
+}
+
+func TestAggregates(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE s (id INT, g TEXT, h TEXT, v INT, f FLOAT)",
+		"INSERT INTO s VALUES (1, 'a', 'x', 5, 1.5), (2, 'a', 'y', NULL, 2.5), (3, 'b', 'x', 7, NULL), (4, 'b', 'x', 7, 1), (5, NULL, 'y', 1, 0.5)",
+		"CREATE TABLE k (g TEXT, label TEXT)",
+		"INSERT INTO k VALUES ('a', 'first'), ('b', 'second')",
+	)
+
+	// Aggregates other than COUNT(*) skip NULLs
+	expectRows(t, e, "SELECT COUNT(*), COUNT(v), COUNT(DISTINCT v), SUM(v), AVG(v), MIN(v), MAX(v), SUM(f), AVG(f) FROM s",
+		"5|4|3|20|5|1|7|5.5|1.375")
+	expectRows(t, e, "SELECT MIN(g), MAX(h) FROM s", "a|y")
+	expectRows(t, e, "SELECT COUNT(*), SUM(v), MAX(v) FROM s WHERE id > 10", "0|NULL|NULL")
+
+	// NULL keys form a group of their own
+	expectPlan(t, e, "SELECT g, COUNT(*) FROM s GROUP BY g", "hash_aggregate")
+	expectRows(t, e, "SELECT g, COUNT(*), SUM(v) FROM s GROUP BY g ORDER BY g", "a|2|5", "b|2|14", "NULL|1|1")
+	expectRows(t, e, "SELECT g, h, COUNT(*) FROM s GROUP BY g, h ORDER BY g, h", "a|x|1", "a|y|1", "b|x|2", "NULL|y|1")
+	expectRows(t, e, "SELECT v % 2, COUNT(*) FROM s GROUP BY v % 2 ORDER BY v % 2", "1|4", "NULL|1")
+	expectRows(t, e, "SELECT g FROM s GROUP BY g HAVING COUNT(*) > 1 ORDER BY g", "a", "b")
+	expectRows(t, e, "SELECT g, SUM(v) AS total FROM s GROUP BY g HAVING SUM(v) > 6 ORDER BY total", "b|14")
+	expectRows(t, e, "SELECT k.label, COUNT(*) FROM s JOIN k ON s.g = k.g GROUP BY k.label ORDER BY k.label", "first|2", "second|2")
+
+	expectError(t, e, "SELECT g, v FROM s GROUP BY g", "must appear in the GROUP BY clause")
+	expectError(t, e, "SELECT SUM(g) FROM s", "SUM expects numbers")
+	expectError(t, e, "SELECT SUM(9223372036854775807) FROM s", "out of range")
+}
+
+func TestPredicates(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE p (id INT, v INT, s TEXT, b BOOL)",
+		"INSERT INTO p VALUES (1, 5, 'abc', TRUE), (2, NULL, 'a_c', FALSE), (3, 15, '50%', NULL), (4, 10, NULL, TRUE)",
+	)
+	predicates := map[string][]string{
+		"v IN (5, 10)":                  {"1", "4"},
+		"v NOT IN (5, 10)":              {"3"},
+		"v NOT IN (5, NULL)":            nil,
+		"v BETWEEN 5 AND 10":            {"1", "4"},
+		"v NOT BETWEEN 5 AND 10":        {"3"},
+		"s LIKE 'a%'":                   {"1", "2"},
+		"s LIKE 'a!_c' ESCAPE '!'":      {"2"},
+		"s LIKE '50!%' ESCAPE '!'":      {"3"},
+		"s ILIKE 'ABC'":                 {"1"},
+		"s NOT LIKE 'a%'":               {"3"},
+		"v IS NULL":                     {"2"},
+		"s IS NOT NULL":                 {"1", "2", "3"},
+		"NOT v = 5":                     {"3", "4"},
+		"NOT (v > 5 OR s = 'abc')":      nil,
+		"v > 5 OR b":                    {"1", "3", "4"},
+		"NOT b":                         {"2"},
+		"v = 15 OR v = 5 AND s = 'x'":   {"3"},
+		"(v = 15 OR v = 5) AND s = 'x'": nil,
+		"v = '5'":                       {"1"},
+	}
+	for predicate, want := range predicates {
+		expectRows(t, e, "SELECT id FROM p WHERE "+predicate+" ORDER BY id", want...)
+	}
+
+	// Comparisons with NULL are unknown, which AND and OR carry through
+	expectRows(t, e, "SELECT v > 5, v IN (5), NULL AND FALSE, NULL OR TRUE, NULL AND TRUE FROM p WHERE id = 2",
+		"NULL|NULL|false|true|NULL")
+	expectError(t, e, "SELECT id FROM p WHERE v = 'abc'", "cannot compare TEXT 'abc' with INT")
+	expectError(t, e, "SELECT id FROM p WHERE s > 5", "cannot compare")
+}
+
+func TestScalarFunctions(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE f (id INT, s TEXT, n FLOAT, t TIMESTAMP)",
+		"INSERT INTO f VALUES (1, '  Hello ', -2.5, '2024-03-15 10:20:30'), (2, NULL, 7, NULL)",
+	)
+	expectRows(t, e, "SELECT LOWER(s), UPPER(s), TRIM(s), LENGTH(s), SUBSTR(TRIM(s), 2, 3), REPLACE(TRIM(s), 'l', 'L') FROM f WHERE id = 1",
+		"  hello |  HELLO |Hello|8|ell|HeLLo")
+	expectRows(t, e, "SELECT SUBSTR('hello', 2), ABS(n), ROUND(n), ROUND(2.345, 2), FLOOR(n), CEIL(n), MOD(7, 3), ABS(-4) FROM f WHERE id = 1",
+		"ello|2.5|-3|2.35|-3|-2|1|4")
+	expectRows(t, e, "SELECT COALESCE(s, 'none'), NULLIF(id, 2), LOWER(s) FROM f WHERE id = 2", "none|NULL|NULL")
+	expectRows(t, e, "SELECT EXTRACT('year', t), EXTRACT('month', t), EXTRACT('hour', DATE_TRUNC('day', t)) FROM f WHERE id = 1",
+		"2024|3|0")
+	expectRows(t, e, "SELECT NOW() IS NOT NULL FROM f WHERE id = 1", "true")
+	expectError(t, e, "SELECT nope(1) FROM f", "unknown function 'nope'")
+	expectError(t, e, "SELECT LOWER(1, 2) FROM f", "LOWER expects 1 argument, got 2")
+	expectError(t, e, "SELECT ABS('x') FROM f WHERE id = 1", "is not a number")
+
+	// A registered Go function gets arguments of its declared types
+	err := RegisterFunction(Function{
+		Name:    "twice",
+		Args:    []string{"INT"},
+		Returns: "INT",
+		Call: func(args []interface{}) (interface{}, error) {
+			return args[0].(int64) * 2, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunction failed: %v", err)
+	}
+	t.Cleanup(func() {
+		functionsMu.Lock()
+		delete(scalarFunctions, "TWICE")
+		functionsMu.Unlock()
+	})
+	expectRows(t, e, "SELECT TWICE(id), twice('21'), twice(NULL) FROM f ORDER BY id", "2|42|NULL", "4|42|NULL")
+	expectRows(t, e, "SELECT id FROM f WHERE twice(id) = 4", "2")
+	expectError(t, e, "SELECT twice(s) FROM f WHERE id = 1", "is not a valid INT")
+
+	rejected := map[string]Function{
+		"already exists":        {Name: "twice", Args: []string{"INT"}, Returns: "INT", Call: func([]interface{}) (interface{}, error) { return nil, nil }},
+		"invalid function name": {Name: "two words", Returns: "INT", Call: func([]interface{}) (interface{}, error) { return nil, nil }},
+		"no implementation":     {Name: "empty", Returns: "INT"},
+		"unknown type 'BLOB9'":  {Name: "odd", Args: []string{"BLOB9"}, Returns: "INT", Call: func([]interface{}) (interface{}, error) { return nil, nil }},
+	}
+	for want, fn := range rejected {
+		if err := RegisterFunction(fn); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("Registering %s returned %v, expected an error containing %q", fn.Name, err, want)
+		}
+	}
+	if err := RegisterFunction(Function{Name: "count", Returns: "INT", Call: func([]interface{}) (interface{}, error) { return nil, nil }}); err == nil {
+		t.Fatal("Expected the name of an aggregate function to be rejected")
+	}
+}
+
+func TestSubqueries(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE users (id INT, name TEXT)",
+		"CREATE TABLE orders (id INT, user_id INT, total INT)",
+		"INSERT INTO users VALUES (1, 'ann'), (2, 'bob'), (3, 'cat')",
+		"INSERT INTO orders VALUES (10, 1, 5), (11, 1, 7), (12, 2, 3), (13, NULL, 1)",
+	)
+
+	expectRows(t, e, "SELECT name FROM users WHERE id IN (SELECT user_id FROM orders) ORDER BY id", "ann", "bob")
+	// A NULL in the subquery makes NOT IN unknown for every other row
+	expectRows(t, e, "SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders)")
+	expectRows(t, e, "SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders WHERE user_id IS NOT NULL)", "cat")
+	expectRows(t, e, "SELECT name FROM users WHERE id = (SELECT MIN(user_id) FROM orders)", "ann")
+	expectRows(t, e, "SELECT name, (SELECT MAX(total) FROM orders o WHERE o.user_id = u.id) FROM users u ORDER BY id",
+		"ann|7", "bob|3", "cat|NULL")
+
+	exists := "SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u.id) ORDER BY id"
+	expectPlan(t, e, exists, "semi_join")
+	expectRows(t, e, exists, "ann", "bob")
+	expectRows(t, e, "SELECT name FROM users u WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u.id)", "cat")
+	expectRows(t, e, "SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u.id AND o.total > 4)", "ann")
+
+	expectRows(t, e, "SELECT t.n FROM (SELECT name AS n, id FROM users WHERE id > 1) AS t ORDER BY t.id", "bob", "cat")
+	expectRows(t, e, "SELECT t.user_id, t.c FROM (SELECT user_id, COUNT(*) AS c FROM orders GROUP BY user_id) AS t WHERE t.c > 1", "1|2")
+
+	expectError(t, e, "SELECT name FROM users WHERE id = (SELECT user_id FROM orders)", "more than one row")
+	expectError(t, e, "SELECT (SELECT id, name FROM users) FROM users", "must return only one column")
+}
+
+func TestIndexOnUntypedColumn(t *testing.T) {
+	e := newTestExecutor(t)
+	// The text format reads 10 and 7 back as integers but keeps 007 as text
+	putLegacyTable(t, e.storage, "u", []string{"id", "name"},
+		[]string{"1", "10"}, []string{"2", "007"}, []string{"3", "20"}, []string{"4", "7"})
+	putLegacyTable(t, e.storage, "w", []string{"id", "word"},
+		[]string{"1", "abc"}, []string{"2", "def"})
+
+	queries := map[string][]string{
+		"SELECT id FROM u WHERE name = '10' ORDER BY id":  {"1"},
+		"SELECT id FROM u WHERE name = '007' ORDER BY id": {"2", "4"},
+		"SELECT id FROM u WHERE name = 7 ORDER BY id":     {"2", "4"},
+		"SELECT id FROM u WHERE name > '8' ORDER BY id":   {"1", "3"},
+		"SELECT id FROM w WHERE word = 'def'":             {"2"},
+	}
+	for query, want := range queries {
+		expectRows(t, e, query, want...)
+	}
+
+	// The indexes must not change what the queries return
+	mustExec(t, e,
+		"CREATE INDEX u_name ON u (name)",
+		"CREATE INDEX w_word ON w (word)",
+	)
+	for query, want := range queries {
+		expectRows(t, e, query, want...)
+	}
+	expectPlan(t, e, "SELECT id FROM u WHERE name = '10'", "table_scan")
+	expectPlan(t, e, "SELECT id FROM w WHERE word = 'def'", "index_scan")
+	expectConsistentIndexes(t, e)
+}
+
+func TestFullTextSearch(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE docs (id INT, body TEXT)",
+		"INSERT INTO docs VALUES (1, 'the quick brown fox'), (2, 'a lazy brown dog sleeps'), "+
+			"(3, 'fox fox fox jumps'), (4, 'quick thinking wins'), (5, 'brown quick fox runs far')",
+	)
+
+	// The same answers, in BM25 order, with and without the index: more
+	// occurrences and shorter documents rank first
+	check := func() {
+		t.Helper()
+		expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'fox')", "3", "1", "5")
+		expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'fox') ORDER BY id", "1", "3", "5")
+		expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'fox') AND id > 1", "3", "5")
+		expectRows(t, e, `SELECT id FROM docs WHERE MATCH(body, '"quick brown"')`, "1")
+		expectRows(t, e, `SELECT id FROM docs WHERE MATCH(body, '"brown quick"')`, "5")
+		expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'brown -fox')", "2")
+		expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'quick NOT fox')", "4")
+		expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'quick AND brown') ORDER BY id", "1", "5")
+		expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'elephant')")
+	}
+	check()
+	expectPlan(t, e, "SELECT id FROM docs WHERE MATCH(body, 'fox')", "rank")
+	expectError(t, e, "SELECT id FROM docs WHERE MATCH(body, '\"quick')", "unterminated phrase")
+
+	mustExec(t, e, "CREATE INDEX docs_body ON docs (body) USING FULLTEXT")
+	expectPlan(t, e, "SELECT id FROM docs WHERE MATCH(body, 'fox')", "fulltext_scan")
+	check()
+
+	// Writes keep the index in step with the table
+	mustExec(t, e,
+		"UPDATE docs SET body = 'no animals here' WHERE id = 3",
+		"DELETE FROM docs WHERE id = 5",
+		"INSERT INTO docs VALUES (6, 'grey fox')",
+	)
+	expectPlan(t, e, "SELECT id FROM docs WHERE MATCH(body, 'fox')", "fulltext_scan")
+	expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'fox')", "6", "1")
+	expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'animals')", "3")
+	expectRows(t, e, "SELECT id FROM docs WHERE MATCH(body, 'runs')")
+	expectConsistentIndexes(t, e)
+}
+
+func TestVectorSearch(t *testing.T) {
+	engine := storage.NewMemoryEngine()
+	e := NewExecutor(storage.New(engine))
+	mustExec(t, e,
+		"CREATE TABLE p (id INT, name TEXT, v VECTOR(2))",
+		"INSERT INTO p VALUES (1, 'a', [2, 0]), (2, 'b', [0.9, 0.1]), (3, 'c', [5, 5]), (4, 'd', [0, 1]), (5, 'e', [-1, 0])",
+	)
+	expectError(t, e, "INSERT INTO p VALUES (6, 'f', [1, 2, 3])", "expected 2 dimensions, got 3")
+
+	// Each metric ranks the points differently
+	nearest := map[string][]string{
+		"l2":     {"b", "a", "d"},
+		"cosine": {"a", "b", "c"},
+		"dot":    {"c", "a", "b"},
+	}
+	query := func(metric string) string {
+		return fmt.Sprintf("SELECT name FROM p ORDER BY distance(v, [1, 0], '%s') LIMIT 3", metric)
+	}
+	for metric, want := range nearest {
+		expectPlan(t, e, query(metric), "table_scan")
+		expectRows(t, e, query(metric), want...)
+	}
+
+	for metric := range nearest {
+		mustExec(t, e, fmt.Sprintf("CREATE INDEX p_%s ON p (v) USING HNSW WITH (metric=%s)", metric, metric))
+	}
+	expectError(t, e, "CREATE INDEX p_bad ON p (v) USING HNSW WITH (metric=manhattan)", "unknown vector metric")
+	expectError(t, e, "CREATE INDEX p_name ON p (name) USING HNSW", "not a VECTOR column")
+	for metric, want := range nearest {
+		expectPlan(t, e, query(metric), "vector_scan")
+		expectRows(t, e, query(metric), want...)
+	}
+	expectRows(t, e, "SELECT name, distance(v, [1, 0]) FROM p ORDER BY distance(v, [1, 0]) LIMIT 1", "b|0.1414213562373095")
+	expectRows(t, e, "SELECT name FROM p WHERE id > 2 ORDER BY distance(v, [1, 0], 'l2') LIMIT 2", "d", "e")
+	expectError(t, e, "SELECT name FROM p ORDER BY distance(v, [1, 0, 0]) LIMIT 3", "expected a query vector of 2 dimensions, got 3")
+
+	// The indexes and the column's dimensions are still there after a reopen
+	e, skipped := reopen(t, engine)
+	if len(skipped) != 0 {
+		t.Fatalf("Expected every index to be restored, got %v", skipped)
+	}
+	for metric, want := range nearest {
+		expectPlan(t, e, query(metric), "vector_scan")
+		expectRows(t, e, query(metric), want...)
+	}
+	expectError(t, e, "INSERT INTO p VALUES (6, 'f', [1, 2, 3])", "expected 2 dimensions, got 3")
+	mustExec(t, e, "INSERT INTO p VALUES (6, 'f', [1, 0.05])", "DELETE FROM p WHERE name = 'a'")
+	expectRows(t, e, query("l2"), "f", "b", "d")
+	expectRows(t, e, query("cosine"), "f", "b", "c")
+	expectConsistentIndexes(t, e)
+}
+
+func TestIndexBloomFilter(t *testing.T) {
+	for _, using := range []string{"BTREE", "HASH"} {
+		e := newTestExecutor(t)
+		mustExec(t, e,
+			"CREATE TABLE t (id INT, v INT, body TEXT)",
+			"INSERT INTO t VALUES (1, 5, 'red'), (2, 5, 'green'), (3, 7, 'blue')",
+		)
+		expectError(t, e, "CREATE INDEX t_v ON t (v) USING "+using+" WITH (bloom_fpr=1.5)", "between 0 and 1")
+		expectError(t, e, "CREATE INDEX t_v ON t (v) USING "+using+" WITH (bloom_fpr=0)", "between 0 and 1")
+		expectError(t, e, "CREATE INDEX t_v ON t (v) USING "+using+" WITH (bloom_fpr=often)", "expected a number")
+		expectError(t, e, "CREATE INDEX t_body ON t (body) USING FULLTEXT WITH (bloom_fpr=0.01)", "unknown option 'bloom_fpr'")
+
+		mustExec(t, e, "CREATE INDEX t_v ON t (v) USING "+using+" WITH (bloom_fpr=0.01)")
+		info, err := e.storage.GetIndexManager().GetIndexInfo("t_v")
+		if err != nil {
+			t.Fatalf("GetIndexInfo failed: %v", err)
+		}
+		if info["bloom_fpr"] != 0.01 {
+			t.Fatalf("Expected a Bloom filter with a 0.01 rate on the %s index, got %v", using, info)
+		}
+
+		// Missing values are answered by the filter; present ones still read
+		expectPlan(t, e, "SELECT id FROM t WHERE v = 999", "index_scan")
+		expectRows(t, e, "SELECT id FROM t WHERE v = 999")
+		if info, _ := e.storage.GetIndexManager().GetIndexInfo("t_v"); info["bloom_skipped"] == int64(0) {
+			t.Fatalf("Expected the %s index's filter to skip the missing value, got %v", using, info)
+		}
+		expectRows(t, e, "SELECT id FROM t WHERE v = 5 ORDER BY id", "1", "2")
+		mustExec(t, e, "INSERT INTO t VALUES (4, 999, 'grey')")
+		expectRows(t, e, "SELECT id FROM t WHERE v = 999", "4")
+		mustExec(t, e, "DELETE FROM t WHERE v = 999")
+		expectRows(t, e, "SELECT id FROM t WHERE v = 999")
+		expectConsistentIndexes(t, e)
+	}
+}
+
+func TestShowIndexStats(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT)",
+		"INSERT INTO t VALUES (1, 5), (2, 5), (3, 7)",
+		"CREATE INDEX t_v ON t (v)",
+		"CREATE INDEX t_id ON t (id) USING HASH",
+		"CREATE INDEX t_v_hash ON t (v) USING HASH",
+	)
+	expectColumns(t, e, "SHOW INDEX STATS",
+		"index", "table", "key", "type", "size", "lookups", "range_scans", "rows_returned",
+		"last_used", "inserts", "deletes", "maintenance_ms", "note")
+
+	// The counters leave out the time columns, which vary from run to run
+	stats := func() []string {
+		var rows []string
+		for _, row := range queryRows(t, e, "SHOW INDEX STATS") {
+			fields := strings.Split(row, "|")
+			rows = append(rows, strings.Join(append(fields[:8:8], fields[9], fields[10], fields[12]), "|"))
+		}
+		return rows
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		if got := stats(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("Expected index stats:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+		}
+	}
+	expect(
+		"t_id|t|id|HASH|3|0|0|0|0|0|never used",
+		"t_v|t|v|BTREE|3|0|0|0|0|0|never used",
+		"t_v_hash|t|v|HASH|3|0|0|0|0|0|redundant with t_v",
+	)
+
+	// Two lookups return 3 rows and a range scan 1 more
+	expectRows(t, e, "SELECT id FROM t WHERE v = 5 ORDER BY id", "1", "2")
+	expectRows(t, e, "SELECT id FROM t WHERE v = 7", "3")
+	expectRows(t, e, "SELECT id FROM t WHERE v > 5", "3")
+	// An insert and a delete each touch every index once
+	mustExec(t, e, "INSERT INTO t VALUES (4, 9)", "DELETE FROM t WHERE id = 1")
+	expect(
+		"t_id|t|id|HASH|3|1|0|1|1|1|",
+		"t_v|t|v|BTREE|3|2|1|4|1|1|",
+		"t_v_hash|t|v|HASH|3|0|0|0|1|1|redundant with t_v",
+	)
+	for _, row := range queryRows(t, e, "SHOW INDEX STATS") {
+		fields := strings.Split(row, "|")
+		if used := fields[5] != "0"; used != (fields[8] != "NULL") {
+			t.Fatalf("Expected last_used to be set only for used indexes, got %s", row)
+		}
+	}
+}
+
+func TestMultiColumnIndex(t *testing.T) {
+	engine := storage.NewMemoryEngine()
+	e := NewExecutor(storage.New(engine))
+	mustExec(t, e, "CREATE TABLE o (id INT, region TEXT COLLATE nocase, status TEXT, amount INT)")
+	regions := []string{"north", "South", "east"}
+	statuses := []string{"open", "paid"}
+	var values []string
+	for i := 0; i < 60; i++ {
+		values = append(values, fmt.Sprintf("(%d, '%s', '%s', %d)", i, regions[i%3], statuses[i%2], i*10))
+	}
+	mustExec(t, e,
+		"INSERT INTO o VALUES "+strings.Join(values, ", "),
+		"INSERT INTO o VALUES (100, 'north', NULL, 5), (101, NULL, 'open', 5)",
+	)
+	expectError(t, e, "CREATE INDEX o_bad ON o (region, status) USING HASH", "only supported for BTREE")
+	expectError(t, e, "CREATE INDEX o_bad ON o (lower(region), status)", "must only list columns")
+	expectError(t, e, "CREATE INDEX o_bad ON o (region, missing)", "does not exist")
+
+	queries := map[string][]string{
+		"SELECT id FROM o WHERE region = 'NORTH' AND status = 'open' ORDER BY id":                  {"0", "6", "12", "18", "24", "30", "36", "42", "48", "54"},
+		"SELECT id FROM o WHERE status = 'open' AND region = 'north' AND amount < 100 ORDER BY id": {"0", "6"},
+		"SELECT id FROM o WHERE region = 'north' AND status = 'open' AND amount > 300 ORDER BY id": {"36", "42", "48", "54"},
+		"SELECT id FROM o WHERE region = 'south' AND amount BETWEEN 100 AND 200 ORDER BY id":       {"10", "13", "16", "19"},
+		"SELECT id FROM o WHERE region = 'north' AND status IS NULL":                               {"100"},
+	}
+	check := func() {
+		t.Helper()
+		for query, want := range queries {
+			expectRows(t, e, query, want...)
+		}
+	}
+	check()
+	mustExec(t, e, "CREATE INDEX o_rsa ON o (region, status, amount)", "ANALYZE o")
+	for query := range queries {
+		expectPlan(t, e, query, "index_range")
+	}
+	check()
+
+	// Equalities fix the leading columns and a range bounds the next one
+	plan := queryRows(t, e, "EXPLAIN SELECT id FROM o WHERE status = 'open' AND region = 'north' AND amount < 100")
+	if !strings.Contains(plan[len(plan)-1], "|o_rsa|region = 'north' AND status = 'open' AND amount < 100|") {
+		t.Fatalf("Expected a lookup on region and status bounded on amount:\n%s", strings.Join(plan, "\n"))
+	}
+	// A column after a gap in the key is left to the filter
+	plan = queryRows(t, e, "EXPLAIN SELECT id FROM o WHERE region = 'south' AND amount BETWEEN 100 AND 200")
+	if !strings.Contains(plan[len(plan)-1], "|o_rsa|region = 'south'|") {
+		t.Fatalf("Expected a lookup on region alone:\n%s", strings.Join(plan, "\n"))
+	}
+	expectPlan(t, e, "SELECT id FROM o WHERE status = 'open' AND amount > 500", "table_scan")
+	// The entries of a range on the last fixed column are in its order
+	query := "SELECT amount FROM o WHERE region = 'north' AND status = 'open' AND amount > 300 ORDER BY amount DESC"
+	for _, row := range queryRows(t, e, "EXPLAIN "+query) {
+		if strings.HasPrefix(row, "sort") {
+			t.Fatalf("EXPLAIN %s sorts rows the index already orders", query)
+		}
+	}
+	expectRows(t, e, query, "540", "480", "420", "360")
+
+	mustExec(t, e,
+		"UPDATE o SET status = 'paid' WHERE id = 6",
+		"UPDATE o SET region = NULL WHERE id = 12",
+		"UPDATE o SET region = 'north' WHERE id = 101",
+		"DELETE FROM o WHERE id = 18",
+	)
+	queries["SELECT id FROM o WHERE region = 'NORTH' AND status = 'open' ORDER BY id"] = []string{"0", "24", "30", "36", "42", "48", "54", "101"}
+	queries["SELECT id FROM o WHERE status = 'open' AND region = 'north' AND amount < 100 ORDER BY id"] = []string{"0", "101"}
+	check()
+	expectConsistentIndexes(t, e)
+
+	e, skipped := reopen(t, engine)
+	if len(skipped) != 0 {
+		t.Fatalf("Expected every index to be restored, got %v", skipped)
+	}
+	if stats := queryRows(t, e, "SHOW INDEX STATS"); !strings.HasPrefix(stats[0], "o_rsa|o|region, status, amount|BTREE|60|") {
+		t.Fatalf("Expected the index to hold the 60 rows with a region, got %v", stats)
+	}
+	check()
+}
+
+func TestIndexAdvisor(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e, "CREATE TABLE o (id INT, region TEXT, status TEXT, amount INT)")
+	var values []string
+	for i := 0; i < 400; i++ {
+		values = append(values, fmt.Sprintf("(%d, 'r%d', '%s', %d)", i, i%8, []string{"open", "paid"}[i/8%2], i))
+	}
+	mustExec(t, e, "INSERT INTO o VALUES "+strings.Join(values, ", "), "ANALYZE o")
+	e.storage.GetWorkload().Clear()
+	// Adaptive hash indexes would answer the repeated equalities and hide
+	// what the statements cost without an index
+	if err := e.storage.GetAdaptiveHashIndexes().SetEnabled(false); err != nil {
+		t.Fatalf("SetEnabled failed: %v", err)
+	}
+
+	filtered := func(i int) string {
+		return fmt.Sprintf("SELECT id FROM o WHERE status = 'open' AND region = 'r%d' AND amount > %d ORDER BY id", i%8, 250+i%50)
+	}
+	for i := 0; i < 30; i++ {
+		queryRows(t, e, filtered(i))
+	}
+	for i := 0; i < 5; i++ {
+		expectRows(t, e, fmt.Sprintf("SELECT region FROM o WHERE id = %d", i), fmt.Sprintf("r%d", i))
+	}
+
+	// Statements differing only in their literals share a shape
+	shapes := e.storage.GetWorkload().Shapes()
+	counts := make(map[string]int64)
+	for _, shape := range shapes {
+		counts[shape.Fingerprint] = shape.Count
+	}
+	if len(shapes) != 2 ||
+		counts["SELECT id FROM o WHERE status = ? AND region = ? AND amount > ? ORDER BY id"] != 30 ||
+		counts["SELECT region FROM o WHERE id = ?"] != 5 {
+		t.Fatalf("Expected two recorded shapes run 30 and 5 times, got %+v", shapes)
+	}
+
+	// The statement filtering on three columns is best answered by one
+	// index on them all: the equality columns, most selective first, then
+	// the range column
+	recommendations, err := Advise(e.storage)
+	if err != nil {
+		t.Fatalf("Advise failed: %v", err)
+	}
+	var statements []string
+	for i, rec := range recommendations {
+		statements = append(statements, rec.Statement())
+		if i > 0 && rec.Benefit > recommendations[i-1].Benefit {
+			t.Fatalf("Expected recommendations ranked by benefit, got %s above %s", statements[i-1], statements[i])
+		}
+	}
+	want := []string{
+		"CREATE INDEX auto_o_region_status_amount ON o (region, status, amount)",
+		"CREATE INDEX auto_o_region_covering ON o (region) INCLUDE (amount, id, status)",
+		"CREATE INDEX auto_o_amount ON o (amount)",
+		"CREATE INDEX auto_o_status ON o (status)",
+		"CREATE INDEX auto_o_id_covering ON o (id) INCLUDE (region)",
+	}
+	if strings.Join(statements, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Expected recommendations:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(statements, "\n"))
+	}
+	if recommendations[0].Queries != 30 {
+		t.Fatalf("Expected the multi-column index to answer 30 statements, got %d", recommendations[0].Queries)
+	}
+	// An index on region alone is answered by the one keyed by region first
+	for _, statement := range statements {
+		if strings.Contains(statement, "ON o (region)") && !strings.Contains(statement, "INCLUDE") {
+			t.Fatalf("Expected no index on region alone, got %s", statement)
+		}
+	}
+
+	// Automatic tuning runs every autoIndexInterval statements and creates
+	// the most beneficial index within its budget
+	if err := SaveAdvisorConfig(e.storage, &AdvisorConfig{Auto: true, Budget: 1}); err != nil {
+		t.Fatalf("SaveAdvisorConfig failed: %v", err)
+	}
+	before := queryRows(t, e, filtered(3))
+	indexManager := e.storage.GetIndexManager()
+	for i := 36; i < autoIndexInterval; i++ {
+		if indexManager.Exists("auto_o_region_status_amount") {
+			t.Fatalf("Expected no index before %d statements, got one after %d", autoIndexInterval, i)
+		}
+		queryRows(t, e, filtered(i))
+	}
+	if !indexManager.Exists("auto_o_region_status_amount") {
+		t.Fatalf("Expected automatic tuning to create auto_o_region_status_amount, got %v", indexManager.ListIndexes())
+	}
+	config, err := LoadAdvisorConfig(e.storage)
+	if err != nil || strings.Join(config.Indexes, ",") != "auto_o_region_status_amount" {
+		t.Fatalf("Expected tuning to record the index it created, got %+v (%v)", config, err)
+	}
+	expectPlan(t, e, filtered(3), "index_range")
+	expectRows(t, e, filtered(3), before...)
+	expectConsistentIndexes(t, e)
+
+	// A larger budget lets tuning add another index. The filtered statements
+	// now run through the new index, so the lookups by id gain the most.
+	config.Auto, config.Budget = false, 2
+	if err := SaveAdvisorConfig(e.storage, config); err != nil {
+		t.Fatalf("SaveAdvisorConfig failed: %v", err)
+	}
+	applied, err := TuneIndexes(e.storage)
+	if err != nil {
+		t.Fatalf("TuneIndexes failed: %v", err)
+	}
+	if len(applied) != 1 || applied[0].Statement() != "CREATE INDEX auto_o_id_covering ON o (id) INCLUDE (region)" {
+		var got []string
+		for _, rec := range applied {
+			got = append(got, rec.Statement())
+		}
+		t.Fatalf("Expected tuning to add the covering index on id, got %v", got)
+	}
+
+	// An index on the columns a multi-column index starts with is redundant
+	mustExec(t, e, "CREATE INDEX o_region ON o (region)")
+	recommendations, err = Advise(e.storage)
+	if err != nil {
+		t.Fatalf("Advise failed: %v", err)
+	}
+	last := recommendations[len(recommendations)-1]
+	if last.Statement() != "DROP INDEX o_region" || last.Reason != "redundant with auto_o_region_status_amount" {
+		t.Fatalf("Expected o_region to be dropped as redundant, got %s (%s)", last.Statement(), last.Reason)
+	}
+}
+
+func TestAdaptiveHashIndex(t *testing.T) {
+	engine := storage.NewMemoryEngine()
+	e := NewExecutor(storage.New(engine))
+	mustExec(t, e, "CREATE TABLE t (id INT, v INT, name TEXT COLLATE nocase)")
+	var values []string
+	for i := 0; i < 40; i++ {
+		values = append(values, fmt.Sprintf("(%d, %d, 'n%d')", i, i%10, i%4))
+	}
+	mustExec(t, e, "INSERT INTO t VALUES "+strings.Join(values, ", "))
+
+	queries := map[string][]string{
+		"SELECT id FROM t WHERE v = 3 ORDER BY id":                 {"3", "13", "23", "33"},
+		"SELECT id FROM t WHERE name = 'N1' AND v = 5 ORDER BY id": {"5", "25"},
+		"SELECT id FROM t WHERE v = 42":                            nil,
+		"SELECT id FROM t WHERE name = 'n2' ORDER BY id":           {"2", "6", "10", "14", "18", "22", "26", "30", "34", "38"},
+	}
+	// Each query runs enough times for its columns to become hot, and its
+	// answer does not change once an adaptive index serves it
+	check := func(adaptive bool) {
+		t.Helper()
+		for query, want := range queries {
+			for i := 0; i < 10; i++ {
+				expectRows(t, e, query, want...)
+			}
+			if adaptive {
+				expectPlan(t, e, query, "adaptive_hash_scan")
+			} else {
+				expectPlan(t, e, query, "table_scan")
+			}
+		}
+	}
+	check(true)
+	if stats := e.storage.GetAdaptiveHashIndexes().Stats(); len(stats) != 2 {
+		t.Fatalf("Expected adaptive indexes on v and name, got %+v", stats)
+	}
+
+	// Writes keep the adaptive indexes in step with the table
+	mustExec(t, e,
+		"INSERT INTO t VALUES (40, 3, 'N1')",
+		"UPDATE t SET v = 5 WHERE id = 1",
+		"UPDATE t SET name = 'n3' WHERE id = 2",
+		"DELETE FROM t WHERE id = 13",
+	)
+	queries["SELECT id FROM t WHERE v = 3 ORDER BY id"] = []string{"3", "23", "33", "40"}
+	queries["SELECT id FROM t WHERE name = 'N1' AND v = 5 ORDER BY id"] = []string{"1", "5", "25"}
+	queries["SELECT id FROM t WHERE name = 'n2' ORDER BY id"] = []string{"6", "10", "14", "18", "22", "26", "30", "34", "38"}
+	check(true)
+	expectConsistentIndexes(t, e)
+
+	// A B-tree on the column gives the same answers
+	mustExec(t, e, "CREATE INDEX t_v ON t (v)", "CREATE INDEX t_name ON t (name)")
+	for query, want := range queries {
+		expectPlan(t, e, query, "index_scan")
+		expectRows(t, e, query, want...)
+	}
+	mustExec(t, e, "DROP INDEX t_v", "DROP INDEX t_name")
+
+	// Turned off, the adaptive indexes are discarded and no more are built,
+	// also after the database is reopened
+	if err := e.storage.GetAdaptiveHashIndexes().SetEnabled(false); err != nil {
+		t.Fatalf("SetEnabled failed: %v", err)
+	}
+	check(false)
+	e, _ = reopen(t, engine)
+	if e.storage.GetAdaptiveHashIndexes().Enabled() {
+		t.Fatalf("Expected the adaptive hash index to stay off after a reopen")
+	}
+	check(false)
+
+	if err := e.storage.GetAdaptiveHashIndexes().SetEnabled(true); err != nil {
+		t.Fatalf("SetEnabled failed: %v", err)
+	}
+	e, _ = reopen(t, engine)
+	if !e.storage.GetAdaptiveHashIndexes().Enabled() {
+		t.Fatalf("Expected the adaptive hash index to stay on after a reopen")
+	}
+	check(true)
+	expectConsistentIndexes(t, e)
+}
+
+func TestMigrateRows(t *testing.T) {
+	store := storage.New(storage.NewMemoryEngine())
+	e := NewExecutor(store)
+	putLegacyTable(t, store, "p", []string{"id", "name", "score", "active"},
+		[]string{"1", "ann", "3.5", "true"}, []string{"2", "bob", "<nil>", "false"}, []string{"3", "007", "12", "true"})
+	putLegacyTable(t, store, "q", []string{"id", "tag"}, []string{"1", "x"})
+	mustExec(t, e, "CREATE TABLE r (id INT, note TEXT)", "INSERT INTO r VALUES (1, 'new')")
+
+	queries := map[string][]string{
+		"SELECT id, name, score, active FROM p ORDER BY id": {"1|ann|3.5|true", "2|bob|NULL|false", "3|007|12|true"},
+		"SELECT id, tag FROM q":                             {"1|x"},
+		"SELECT id, note FROM r":                            {"1|new"},
+	}
+	for query, want := range queries {
+		expectRows(t, e, query, want...)
+	}
+
+	report, err := MigrateRows(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tables != 3 || report.Rows != 5 || report.Migrated != 4 {
+		t.Fatalf("MigrateRows reported %+v, expected 3 tables, 5 rows and 4 migrated", *report)
+	}
+	keys, err := store.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "p:") && !strings.HasPrefix(key, "q:") {
+			continue
+		}
+		if data, _ := store.Get(key); !storage.IsEncodedRow(data) {
+			t.Fatalf("Row '%s' is still in the text format after migrating: %q", key, data)
+		}
+	}
+
+	for _, e := range []*Executor{e, NewExecutor(store)} {
+		for query, want := range queries {
+			expectRows(t, e, query, want...)
+		}
+	}
+	if report, err := MigrateRows(store); err != nil || report.Rows != 5 || report.Migrated != 0 {
+		t.Fatalf("Second MigrateRows reported %+v, %v, expected 5 rows and none migrated", report, err)
+	}
+}
+
+func TestCorruptRowIsReported(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e,
+		"CREATE TABLE c (id INT, name TEXT)",
+		"INSERT INTO c VALUES (1, 'ann')",
+		"INSERT INTO c VALUES (2, 'bob')",
+		"CREATE INDEX c_name ON c (name)",
+	)
+	rows, err := e.loadTableRows("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key string
+	for _, row := range rows {
+		if rowColumnValue(row, "name") == "bob" {
+			key = rowKey("c", row)
+		}
+	}
+	data, err := e.storage.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.storage.Put(key, data[:len(data)-1]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{
+		"SELECT * FROM c",
+		"SELECT * FROM c WHERE name = 'bob'",
+		"SELECT * FROM c WHERE name > 'a' ORDER BY name",
+		"UPDATE c SET name = 'cy' WHERE id = 2",
+		"DELETE FROM c WHERE id = 2",
+	} {
+		expectError(t, e, query, "corrupt row")
+	}
+	// Rows stored intact are still read through the index
+	expectRows(t, e, "SELECT id FROM c WHERE name = 'ann'", "1")
+}
+
+func TestExternalSort(t *testing.T) {
+	e := newTestExecutor(t)
+	mustExec(t, e, "CREATE TABLE s (id INT, grp INT, name TEXT)")
+	type row struct {
+		id  int
+		grp interface{}
+	}
+	var rows []row
+	var values []string
+	for i := 0; i < 600; i++ {
+		if i%25 == 0 {
+			rows = append(rows, row{i, nil})
+			values = append(values, fmt.Sprintf("(%d, NULL, 'name %03d')", i, i))
+			continue
+		}
+		rows = append(rows, row{i, i * 37 % 50})
+		values = append(values, fmt.Sprintf("(%d, %d, 'name %03d')", i, i*37%50, i))
+	}
+	mustExec(t, e, "INSERT INTO s VALUES "+strings.Join(values, ", "))
+
+	// NULLs first, then the groups largest first, each in id order
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if (a.grp == nil) != (b.grp == nil) {
+			return a.grp == nil
+		}
+		if a.grp != nil && a.grp != b.grp {
+			return a.grp.(int) > b.grp.(int)
+		}
+		return a.id < b.id
+	})
+	var want []string
+	for _, r := range rows {
+		if r.id < 100 {
+			continue
+		}
+		grp := "NULL"
+		if r.grp != nil {
+			grp = fmt.Sprint(r.grp)
+		}
+		want = append(want, fmt.Sprintf("%d|%s|name %03d", r.id, grp, r.id))
+	}
+	query := "SELECT id, grp, name FROM s WHERE id >= 100 ORDER BY grp DESC NULLS FIRST, id"
+	expectRows(t, e, query, want...)
+
+	// With a budget of a few kilobytes the sort spills dozens of runs,
+	// which must merge back into the same order and be removed afterwards
+	e.sortMemory = 4096
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	expectRows(t, e, query, want...)
+	if entries, err := os.ReadDir(tmp); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected the sort runs to be removed, found %v (%v)", entries, err)
+	}
+	expectRows(t, e, "SELECT id FROM s WHERE id < 3 ORDER BY id DESC", "2", "1", "0")
+
+	// A sort that spills fails when its runs cannot be written, and one
+	// that fits the budget does not touch the disk
+	t.Setenv("TMPDIR", filepath.Join(tmp, "missing"))
+	expectError(t, e, query, "failed to create sort directory")
+	expectRows(t, e, "SELECT id FROM s WHERE id < 3 ORDER BY id DESC", "2", "1", "0")
+
+	expectPlan(t, e, query, "sort")
+}
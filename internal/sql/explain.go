@@ -0,0 +1,584 @@
+package sql
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Operator names under which EXPLAIN ANALYZE records profiles
+const (
+	opScan     = "scan"
+	opFilter   = "filter"
+	opSort     = "sort"
+	opLimit    = "limit"
+	opDistinct = "distinct"
+	opRank     = "rank"
+	opModify   = "modify"
+)
+
+func joinOperator(position int) string {
+	return fmt.Sprintf("join:%d", position)
+}
+
+func joinScanOperator(position int) string {
+	return fmt.Sprintf("scan:%d", position)
+}
+
+// operatorProfile records what one plan operator did during EXPLAIN ANALYZE
+type operatorProfile struct {
+	rows        int
+	keysScanned int
+	duration    time.Duration
+}
+
+// queryProfile collects operator profiles while a statement runs under
+// EXPLAIN ANALYZE. A nil profile records nothing.
+type queryProfile struct {
+	operators map[string]*operatorProfile
+}
+
+func newQueryProfile() *queryProfile {
+	return &queryProfile{operators: make(map[string]*operatorProfile)}
+}
+
+func (q *queryProfile) record(op string, rows, keysScanned int, duration time.Duration) {
+	if q == nil {
+		return
+	}
+	profile, exists := q.operators[op]
+	if !exists {
+		profile = &operatorProfile{}
+		q.operators[op] = profile
+	}
+	profile.rows += rows
+	profile.keysScanned += keysScanned
+	profile.duration += duration
+}
+
+// planNode is one operator of the plan tree shown by EXPLAIN
+type planNode struct {
+	operator       string
+	table          string
+	index          string
+	indexCondition string
+	condition      string
+	estimatedRows  float64
+	estimatedCost  float64 // negative when not estimated
+	profileKey     string
+	children       []*planNode
+}
+
+// executeExplain returns the plan of a statement as rows, one per operator.
+// With ANALYZE the statement is also executed and each operator reports the
+// rows it produced, the keys it scanned and the time it took.
+func (e *Executor) executeExplain(stmt *ExplainStatement) (*QueryResult, error) {
+	root, err := e.explainPlan(stmt.Statement)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryResult{
+		Columns: []string{"operator", "table", "index", "index_condition", "condition", "estimated_rows", "estimated_cost"},
+	}
+
+	var profile *queryProfile
+	var total time.Duration
+	if stmt.Analyze {
+		profile = newQueryProfile()
+		e.profile = profile
+		start := time.Now()
+		inner, err := e.Execute(stmt.Statement)
+		total = time.Since(start)
+		e.profile = nil
+		if err != nil {
+			return nil, err
+		}
+		if root.profileKey == opModify {
+			affected := 0
+			if len(inner.Rows) > 0 && len(inner.Rows[0]) > 0 {
+				affected, _ = inner.Rows[0][0].(int)
+			}
+			profile.record(opModify, affected, 0, total)
+		}
+		result.Columns = append(result.Columns, "actual_rows", "keys_scanned", "time")
+	}
+
+	var walk func(node *planNode, depth int)
+	walk = func(node *planNode, depth int) {
+		operator := node.operator
+		if depth > 0 {
+			operator = strings.Repeat("  ", depth-1) + "-> " + operator
+		}
+		cost := ""
+		if node.estimatedCost >= 0 {
+			cost = fmt.Sprintf("%.2f", node.estimatedCost)
+		}
+		row := []interface{}{
+			operator,
+			node.table,
+			node.index,
+			node.indexCondition,
+			node.condition,
+			fmt.Sprintf("%.0f", node.estimatedRows),
+			cost,
+		}
+		if profile != nil {
+			if op, ok := profile.operators[node.profileKey]; ok {
+				row = append(row, op.rows, op.keysScanned, op.duration.String())
+			} else {
+				row = append(row, "", "", "")
+			}
+		}
+		result.Rows = append(result.Rows, row)
+		for _, child := range node.children {
+			walk(child, depth+1)
+		}
+	}
+	walk(root, 0)
+
+	if profile != nil {
+		result.Rows = append(result.Rows, []interface{}{"total", "", "", "", "", "", "", "", "", total.String()})
+	}
+
+	result.Count = len(result.Rows)
+	return result, nil
+}
+
+// explainPlan plans a statement and converts the plan into an operator tree
+func (e *Executor) explainPlan(stmt Statement) (*planNode, error) {
+	switch s := stmt.(type) {
+	case *SelectStatement:
+		if _, err := e.prepareSelect(s); err != nil {
+			return nil, err
+		}
+		return e.explainSelect(s)
+	case *InsertStatement:
+		plan, err := e.planner.PlanInsert(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan query: %w", err)
+		}
+		return &planNode{
+			operator:      "insert",
+			table:         plan.Table,
+			estimatedRows: float64(len(s.Values)),
+			estimatedCost: plan.EstimatedCost,
+			profileKey:    opModify,
+		}, nil
+	case *UpdateStatement:
+		plan, err := e.planner.PlanUpdate(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan query: %w", err)
+		}
+		return modifyPlanNode("update", plan), nil
+	case *DeleteStatement:
+		plan, err := e.planner.PlanDelete(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan query: %w", err)
+		}
+		return modifyPlanNode("delete", plan), nil
+	default:
+		return nil, fmt.Errorf("EXPLAIN is not supported for %s", stmt.String())
+	}
+}
+
+// explainSelect plans a prepared SELECT and converts the plan into an
+// operator tree. The scan of a derived table has the plan of its SELECT
+// below it, and the plans of the subqueries in the SELECT's expressions are
+// added below the root.
+func (e *Executor) explainSelect(stmt *SelectStatement) (*planNode, error) {
+	plan, err := e.planner.PlanSelect(stmt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan query: %w", err)
+	}
+	root := selectPlanNode(plan)
+
+	var walk func(node *planNode) error
+	walk = func(node *planNode) error {
+		for _, child := range node.children {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		if derived, ok := e.derived[node.table]; ok && node.operator == string(PlanTypeTableScan) {
+			inner, err := e.explainSelect(derived)
+			if err != nil {
+				return err
+			}
+			node.operator = "subquery_scan"
+			node.children = append(node.children, unprofiled(inner))
+		}
+		return nil
+	}
+	if err := walk(root); err != nil {
+		return nil, err
+	}
+
+	exprs := append([]Expression{stmt.Where, stmt.Having}, stmt.Fields...)
+	for _, join := range stmt.Joins {
+		exprs = append(exprs, join.Condition)
+	}
+	exprs = append(append(exprs, stmt.GroupBy...), stmt.OrderBy...)
+	seen := make(map[*SelectStatement]bool)
+	for _, expr := range exprs {
+		nodes, err := e.explainSubqueries(expr, seen)
+		if err != nil {
+			return nil, err
+		}
+		root.children = append(root.children, nodes...)
+	}
+	return root, nil
+}
+
+// explainSubqueries returns the operator trees of the subqueries in an
+// expression that are not in seen
+func (e *Executor) explainSubqueries(expr Expression, seen map[*SelectStatement]bool) ([]*planNode, error) {
+	var nodes []*planNode
+	add := func(sub *SubqueryExpression, exists *ExistsExpression) error {
+		if seen[sub.Select] {
+			return nil
+		}
+		seen[sub.Select] = true
+		node, err := e.explainSubquery(sub, exists)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		return nil
+	}
+
+	var err error
+	switch x := expr.(type) {
+	case *SubqueryExpression:
+		err = add(x, nil)
+	case *ExistsExpression:
+		err = add(x.Subquery, x)
+	case *FunctionCall:
+		for _, arg := range x.Args {
+			inner, err := e.explainSubqueries(arg, seen)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, inner...)
+		}
+	default:
+		for _, operand := range operands(expr) {
+			inner, err := e.explainSubqueries(operand, seen)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, inner...)
+		}
+		if in, ok := expr.(*InExpression); ok && in.Subquery != nil {
+			err = add(in.Subquery, nil)
+		}
+	}
+	return nodes, err
+}
+
+// explainSubquery builds the operator tree of a subquery. A correlated
+// subquery names the columns of the enclosing query it runs with, and an
+// EXISTS run as a semi-join the equalities its rows are matched on.
+func (e *Executor) explainSubquery(sub *SubqueryExpression, exists *ExistsExpression) (*planNode, error) {
+	query, err := e.subquery(sub, exists == nil)
+	if err != nil {
+		return nil, err
+	}
+	if join := planSemiJoin(sub); exists != nil && join != nil {
+		probe := &SelectStatement{
+			Fields:     join.columns,
+			Table:      sub.Select.Table,
+			TableAlias: sub.Select.TableAlias,
+			Subquery:   sub.Select.Subquery,
+			Where:      join.filter,
+		}
+		inner, err := e.explainSelect(probe)
+		if err != nil {
+			return nil, err
+		}
+		conditions := make([]string, len(join.columns))
+		for i, column := range join.columns {
+			_, name := splitColumnName(column.String())
+			conditions[i] = fmt.Sprintf("%s.%s = %s", query.sources[0].name, name, join.params[i].String())
+		}
+		operator := "semi_join"
+		if exists.Not {
+			operator = "anti_join"
+		}
+		return &planNode{
+			operator:       operator,
+			indexCondition: strings.Join(conditions, " AND "),
+			estimatedRows:  inner.estimatedRows,
+			estimatedCost:  -1,
+			children:       []*planNode{unprofiled(inner)},
+		}, nil
+	}
+
+	inner, err := e.explainSelect(sub.Select)
+	if err != nil {
+		return nil, err
+	}
+	node := &planNode{
+		operator:      "subquery",
+		estimatedRows: inner.estimatedRows,
+		estimatedCost: -1,
+		children:      []*planNode{unprofiled(inner)},
+	}
+	if len(sub.Params) > 0 {
+		params := make([]string, len(sub.Params))
+		for i, param := range sub.Params {
+			params[i] = param.String()
+		}
+		node.operator = "correlated_subquery"
+		node.condition = strings.Join(params, ", ")
+	}
+	return node, nil
+}
+
+// unprofiled clears the profile keys of the operators of a nested query,
+// whose runs EXPLAIN ANALYZE does not record
+func unprofiled(node *planNode) *planNode {
+	node.profileKey = ""
+	for _, child := range node.children {
+		unprofiled(child)
+	}
+	return node
+}
+
+// tableText names a table in a plan, followed by the alias the query refers
+// to it by, if any
+func tableText(table, name string) string {
+	if name == "" || name == table {
+		return table
+	}
+	return table + " " + name
+}
+
+// selectPlanNode builds the operator tree of a SELECT plan
+func selectPlanNode(plan *ExecutionPlan) *planNode {
+	node := accessPlanNode(plan)
+
+	for _, step := range plan.Joins {
+		var conditions []string
+		for _, condition := range step.Conditions {
+			conditions = append(conditions, condition.String())
+		}
+		node = &planNode{
+			operator:      string(step.Strategy) + " (" + strings.ToLower(string(step.Type)) + ")",
+			table:         tableText(step.Table, step.Name),
+			condition:     strings.Join(conditions, " AND "),
+			estimatedRows: step.EstimatedRows,
+			estimatedCost: step.EstimatedCost,
+			profileKey:    joinOperator(step.Position),
+			children: []*planNode{
+				node,
+				{
+					operator:      string(PlanTypeTableScan),
+					table:         tableText(step.Table, step.Name),
+					estimatedRows: step.ScanRows,
+					estimatedCost: step.ScanRows * costRowScan,
+					profileKey:    joinScanOperator(step.Position),
+				},
+			},
+		}
+		if step.Strategy == JoinStrategyHash {
+			node.indexCondition = fmt.Sprintf("%s.%s = %s.%s", step.LeftName, step.LeftColumn, step.Name, step.RightColumn)
+		}
+	}
+
+	filteredRows := plan.EstimatedRows
+	if plan.Aggregate {
+		filteredRows = plan.InputRows
+	}
+	if plan.Where != nil {
+		node = &planNode{
+			operator:      "filter",
+			condition:     plan.Where.String(),
+			estimatedRows: filteredRows,
+			estimatedCost: -1,
+			profileKey:    opFilter,
+			children:      []*planNode{node},
+		}
+	}
+
+	if plan.RankByRelevance {
+		node = &planNode{
+			operator:      "rank",
+			condition:     "bm25",
+			estimatedRows: plan.EstimatedRows,
+			estimatedCost: -1,
+			profileKey:    opRank,
+			children:      []*planNode{node},
+		}
+	}
+
+	if plan.Aggregate {
+		var keys []string
+		for _, expr := range plan.GroupBy {
+			keys = append(keys, expr.String())
+		}
+		node = &planNode{
+			operator:      "hash_aggregate",
+			condition:     strings.Join(keys, ", "),
+			estimatedRows: plan.GroupRows,
+			estimatedCost: -1,
+			profileKey:    opAggregate,
+			children:      []*planNode{node},
+		}
+		if plan.Having != nil {
+			node = &planNode{
+				operator:      "having",
+				condition:     plan.Having.String(),
+				estimatedRows: plan.EstimatedRows,
+				estimatedCost: -1,
+				profileKey:    opHaving,
+				children:      []*planNode{node},
+			}
+		}
+	}
+
+	if len(plan.OrderBy) > 0 && !plan.Ordered {
+		node = &planNode{
+			operator:      "sort",
+			condition:     orderText(plan.OrderBy, plan.Ordering),
+			estimatedRows: plan.EstimatedRows,
+			estimatedCost: -1,
+			profileKey:    opSort,
+			children:      []*planNode{node},
+		}
+		if plan.TopN {
+			node.operator = "top_n_sort"
+			node.estimatedRows = math.Min(float64(plan.Limit+plan.Offset), plan.EstimatedRows)
+		}
+	}
+
+	if plan.Distinct {
+		var keys []string
+		for _, expr := range plan.DistinctOn {
+			keys = append(keys, expr.String())
+		}
+		node = &planNode{
+			operator:      "distinct",
+			condition:     strings.Join(keys, ", "),
+			estimatedRows: plan.EstimatedRows,
+			estimatedCost: -1,
+			profileKey:    opDistinct,
+			children:      []*planNode{node},
+		}
+	}
+
+	if plan.HasLimit || plan.Offset > 0 {
+		rows := math.Max(plan.EstimatedRows-float64(plan.Offset), 0)
+		condition := fmt.Sprintf("%d", plan.Limit)
+		if plan.HasLimit {
+			rows = math.Min(rows, float64(plan.Limit))
+		} else {
+			condition = "ALL"
+		}
+		if plan.Offset > 0 {
+			condition += fmt.Sprintf(" OFFSET %d", plan.Offset)
+		}
+		node = &planNode{
+			operator:      "limit",
+			condition:     condition,
+			estimatedRows: rows,
+			estimatedCost: -1,
+			profileKey:    opLimit,
+			children:      []*planNode{node},
+		}
+	}
+
+	// The root reports the cost of the whole plan
+	node.estimatedCost = plan.EstimatedCost
+	return node
+}
+
+// accessPlanNode describes how the plan reads its first table
+func accessPlanNode(plan *ExecutionPlan) *planNode {
+	operator := string(plan.Type)
+	if plan.IndexOnly {
+		operator = strings.Replace(operator, "index_", "index_only_", 1)
+	}
+	if plan.Reverse {
+		operator += " (descending)"
+	}
+	node := &planNode{
+		operator:      operator,
+		table:         tableText(plan.Table, plan.TableName),
+		index:         plan.IndexName,
+		estimatedRows: plan.ScanRows,
+		estimatedCost: plan.ScanCost,
+		profileKey:    opScan,
+	}
+
+	switch plan.Type {
+	case PlanTypeIndexScan:
+		node.indexCondition = fmt.Sprintf("%s = %s", plan.IndexColumn, formatLiteral(plan.IndexValue))
+	case PlanTypeIndexRange:
+		var bounds []string
+		for i, column := range plan.PrefixColumns {
+			bounds = append(bounds, fmt.Sprintf("%s = %s", column, formatLiteral(plan.PrefixValues[i])))
+		}
+		if plan.RangeLower != nil {
+			operator := ">="
+			if plan.LowerExclusive {
+				operator = ">"
+			}
+			bounds = append(bounds, fmt.Sprintf("%s %s %s", plan.IndexColumn, operator, formatLiteral(plan.RangeLower)))
+		}
+		if plan.RangeUpper != nil {
+			operator := "<="
+			if plan.UpperExclusive {
+				operator = "<"
+			}
+			bounds = append(bounds, fmt.Sprintf("%s %s %s", plan.IndexColumn, operator, formatLiteral(plan.RangeUpper)))
+		}
+		node.indexCondition = strings.Join(bounds, " AND ")
+	case PlanTypeFullTextScan:
+		node.indexCondition = fmt.Sprintf("MATCH(%s, %s)", plan.IndexColumn, formatLiteral(plan.IndexValue))
+	case PlanTypeVectorScan:
+		node.indexCondition = fmt.Sprintf("%v LIMIT %d", plan.IndexValue, plan.Limit+plan.Offset)
+	case PlanTypeAdaptiveHashScan:
+		node.indexCondition = fmt.Sprintf("%s = %s", plan.IndexColumn, formatLiteral(plan.IndexValue))
+	}
+
+	return node
+}
+
+// modifyPlanNode builds the operator tree of an UPDATE or DELETE plan
+func modifyPlanNode(operator string, plan *ExecutionPlan) *planNode {
+	access := &planNode{
+		operator:      string(plan.Type),
+		table:         plan.Table,
+		index:         plan.IndexName,
+		estimatedCost: -1,
+	}
+	if plan.Type == PlanTypeIndexScan {
+		access.indexCondition = fmt.Sprintf("%s = %s", plan.IndexColumn, formatLiteral(plan.IndexValue))
+	}
+
+	node := &planNode{
+		operator:      operator,
+		table:         plan.Table,
+		estimatedCost: plan.EstimatedCost,
+		profileKey:    opModify,
+		children:      []*planNode{access},
+	}
+	if plan.Where != nil {
+		node.condition = plan.Where.String()
+	}
+	return node
+}
+
+// formatLiteral renders a value the way it would be written in SQL
+func formatLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + v + "'"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,478 @@
+package sql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"startdb/internal/storage"
+)
+
+// evaluate computes the value of an expression over a row. Predicates give
+// true, false or nil for SQL's unknown: comparisons with NULL are unknown,
+// and NOT, AND and OR carry unknowns through by three-valued logic.
+func (e *Executor) evaluate(rowData []interface{}, expr Expression) (interface{}, error) {
+	switch x := expr.(type) {
+	case *StringLiteral:
+		return x.Value, nil
+	case *NumberLiteral:
+		return x.Value, nil
+	case *BooleanLiteral:
+		return x.Value, nil
+	case *NullLiteral:
+		return nil, nil
+	case *VectorLiteral:
+		return x.Values, nil
+	case *Identifier:
+		return rowColumnValue(rowData, x.Value), nil
+	case *groupedValue:
+		return groupedRowValue(rowData, x), nil
+	case *parameter:
+		return e.params[x.index], nil
+	case *SubqueryExpression:
+		return e.evaluateSubquery(rowData, x)
+	case *ExistsExpression:
+		return e.evaluateExists(rowData, x)
+	case *FunctionCall:
+		if call, isDistance, err := parseDistance(x); isDistance {
+			if err != nil {
+				return nil, err
+			}
+			return e.evaluateDistance(rowData, call), nil
+		}
+		match, isMatch, err := parseMatch(x)
+		if err != nil {
+			return nil, err
+		}
+		if isMatch {
+			return e.evaluateMatch(rowData, match)
+		}
+		return e.evaluateFunction(rowData, x)
+	case *BinaryExpression:
+		return e.evaluateBinary(rowData, x)
+	case *UnaryExpression:
+		return e.evaluateUnary(rowData, x)
+	case *InExpression:
+		if x.Subquery != nil {
+			return e.evaluateInSubquery(rowData, x)
+		}
+		return e.evaluateIn(rowData, x)
+	case *BetweenExpression:
+		return e.evaluateBetween(rowData, x)
+	case *LikeExpression:
+		return e.evaluateLike(rowData, x)
+	case *IsNullExpression:
+		value, err := e.evaluate(rowData, x.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return (value == nil) != x.Not, nil
+	case *CaseExpression:
+		return e.evaluateCase(rowData, x)
+	}
+	return nil, fmt.Errorf("unsupported expression: %s", expr.String())
+}
+
+func (e *Executor) evaluateBinary(rowData []interface{}, b *BinaryExpression) (interface{}, error) {
+	if b.Operator == "AND" || b.Operator == "OR" {
+		return e.evaluateLogic(rowData, b)
+	}
+
+	left, err := e.evaluate(rowData, b.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.evaluate(rowData, b.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.Operator {
+	case "=", "!=", "<>", "<", ">", "<=", ">=":
+		return e.compareOperands(left, right, b.Operator, e.comparisonCollation(b.Left, b.Right))
+	case "+", "-", "*", "/", "%":
+		return arithmetic(left, right, b.Operator)
+	case "||":
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return columnText(left) + columnText(right), nil
+	}
+	return nil, fmt.Errorf("unsupported operator: %s", b.Operator)
+}
+
+// evaluateLogic evaluates AND and OR. The right operand is not evaluated
+// when the left one decides the result.
+func (e *Executor) evaluateLogic(rowData []interface{}, b *BinaryExpression) (interface{}, error) {
+	left, err := e.evaluateCondition(rowData, b.Left)
+	if err != nil {
+		return nil, err
+	}
+	if left == (b.Operator == "OR") {
+		return left, nil
+	}
+	right, err := e.evaluateCondition(rowData, b.Right)
+	if err != nil {
+		return nil, err
+	}
+	if b.Operator == "OR" {
+		return logicOr(left, right), nil
+	}
+	return logicAnd(left, right), nil
+}
+
+func (e *Executor) evaluateUnary(rowData []interface{}, u *UnaryExpression) (interface{}, error) {
+	switch u.Operator {
+	case "NOT":
+		value, err := e.evaluateCondition(rowData, u.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return logicNot(value, true), nil
+	case "-":
+		value, err := e.evaluate(rowData, u.Operand)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		value, err = numericOperand(value, u.Operator)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := value.(int64); ok {
+			if i == math.MinInt64 {
+				return nil, fmt.Errorf("-%d is out of range for a 64-bit integer", i)
+			}
+			return -i, nil
+		}
+		return -value.(float64), nil
+	}
+	return nil, fmt.Errorf("unsupported operator: %s", u.Operator)
+}
+
+// evaluateCase evaluates a CASE expression: the result of the first WHEN
+// that holds, or for a simple CASE whose value equals the operand, else the
+// ELSE result or NULL
+func (e *Executor) evaluateCase(rowData []interface{}, c *CaseExpression) (interface{}, error) {
+	var operand interface{}
+	if c.Operand != nil {
+		value, err := e.evaluate(rowData, c.Operand)
+		if err != nil {
+			return nil, err
+		}
+		operand = value
+	}
+
+	for _, when := range c.Whens {
+		var matched interface{}
+		var err error
+		if c.Operand != nil {
+			var value interface{}
+			value, err = e.evaluate(rowData, when.Condition)
+			if err != nil {
+				return nil, err
+			}
+			matched, err = e.compareOperands(operand, value, "=", e.comparisonCollation(c.Operand, when.Condition))
+		} else {
+			matched, err = e.evaluateCondition(rowData, when.Condition)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if matched == true {
+			return e.evaluate(rowData, when.Result)
+		}
+	}
+
+	if c.Else != nil {
+		return e.evaluate(rowData, c.Else)
+	}
+	return nil, nil
+}
+
+// arithmetic applies +, -, *, / or % to two values. Integers give integers,
+// dividing with truncation, and an integer with a float gives a float. The
+// remainder takes the sign of the dividend. NULL gives NULL.
+func arithmetic(left, right interface{}, operator string) (interface{}, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	left, err := numericOperand(left, operator)
+	if err != nil {
+		return nil, err
+	}
+	right, err = numericOperand(right, operator)
+	if err != nil {
+		return nil, err
+	}
+
+	a, leftInt := left.(int64)
+	b, rightInt := right.(int64)
+	if leftInt && rightInt {
+		return integerArithmetic(a, b, operator)
+	}
+
+	x, y := toFloat(left), toFloat(right)
+	switch operator {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	}
+	if y == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	if operator == "%" {
+		return math.Mod(x, y), nil
+	}
+	return x / y, nil
+}
+
+// integerArithmetic applies an arithmetic operator to two integers, failing
+// rather than wrapping around when the result does not fit
+func integerArithmetic(a, b int64, operator string) (interface{}, error) {
+	var result int64
+	overflow := false
+	switch operator {
+	case "+":
+		result = a + b
+		overflow = (b > 0 && result < a) || (b < 0 && result > a)
+	case "-":
+		result = a - b
+		overflow = (b < 0 && result < a) || (b > 0 && result > a)
+	case "*":
+		result = a * b
+		overflow = a != 0 && (result/a != b || (a == -1 && b == math.MinInt64))
+	case "%":
+		// MinInt64 % -1 is 0 and does not overflow
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = a % b
+	default:
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		overflow = a == math.MinInt64 && b == -1
+		if !overflow {
+			result = a / b
+		}
+	}
+	if overflow {
+		return nil, fmt.Errorf("%d %s %d is out of range for a 64-bit integer", a, operator, b)
+	}
+	return result, nil
+}
+
+// numericOperand returns an operand of an arithmetic operator as an int64
+// or float64
+func numericOperand(value interface{}, operator string) (interface{}, error) {
+	if number, ok := toNumber(value); ok {
+		return number, nil
+	}
+	return nil, fmt.Errorf("operator %s expects numbers, got %s", operator, valueText(value))
+}
+
+// toNumber returns a value as an int64 or float64, reporting false if it is
+// not a number. Rows stored before values were typed hold numbers as text.
+func toNumber(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case int64, float64:
+		return v, true
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// valueText describes a value with its type for an error message, such as
+// TEXT 'abc'
+func valueText(value interface{}) string {
+	valueType, _ := storage.ValueTypeOf(value)
+	return fmt.Sprintf("%s '%s'", valueType, columnText(value))
+}
+
+func toFloat(value interface{}) float64 {
+	if i, ok := value.(int64); ok {
+		return float64(i)
+	}
+	return value.(float64)
+}
+
+// evaluateIn evaluates [NOT] IN. A value missing from a list that holds a
+// NULL is unknown rather than absent, so NOT IN over such a list holds for no
+// row.
+func (e *Executor) evaluateIn(rowData []interface{}, in *InExpression) (interface{}, error) {
+	value, err := e.evaluate(rowData, in.Left)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var found interface{} = false
+	for _, item := range in.List {
+		itemValue, err := e.evaluate(rowData, item)
+		if err != nil {
+			return nil, err
+		}
+		equal, err := e.compareOperands(value, itemValue, "=", e.comparisonCollation(in.Left, item))
+		if err != nil {
+			return nil, err
+		}
+		found = logicOr(found, equal)
+		if found == true {
+			break
+		}
+	}
+	return logicNot(found, in.Not), nil
+}
+
+// evaluateBetween evaluates [NOT] BETWEEN, whose bounds are inclusive
+func (e *Executor) evaluateBetween(rowData []interface{}, between *BetweenExpression) (interface{}, error) {
+	value, err := e.evaluate(rowData, between.Expr)
+	if err != nil {
+		return nil, err
+	}
+	lower, err := e.evaluate(rowData, between.Lower)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := e.evaluate(rowData, between.Upper)
+	if err != nil {
+		return nil, err
+	}
+
+	aboveLower, err := e.compareOperands(value, lower, ">=", e.comparisonCollation(between.Expr, between.Lower))
+	if err != nil {
+		return nil, err
+	}
+	belowUpper, err := e.compareOperands(value, upper, "<=", e.comparisonCollation(between.Expr, between.Upper))
+	if err != nil {
+		return nil, err
+	}
+	return logicNot(logicAnd(aboveLower, belowUpper), between.Not), nil
+}
+
+// evaluateCondition evaluates an operand of NOT, AND or OR, which must be a
+// boolean or NULL. Rows stored before values were typed hold booleans as
+// text.
+func (e *Executor) evaluateCondition(rowData []interface{}, expr Expression) (interface{}, error) {
+	value, err := e.evaluate(rowData, expr)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case nil, bool:
+		return v, nil
+	case string:
+		if v == "true" || v == "false" {
+			return v == "true", nil
+		}
+	}
+	return nil, fmt.Errorf("condition %s is not a boolean: '%s'", expr.String(), columnText(value))
+}
+
+// logicAnd is AND over true, false and unknown (nil): false if either side
+// is false, otherwise unknown if either side is
+func logicAnd(a, b interface{}) interface{} {
+	if a == false || b == false {
+		return false
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	return true
+}
+
+// logicOr is OR over true, false and unknown (nil): true if either side is
+// true, otherwise unknown if either side is
+func logicOr(a, b interface{}) interface{} {
+	if a == true || b == true {
+		return true
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	return false
+}
+
+// logicNot negates a truth value if not is set. Unknown stays unknown.
+func logicNot(value interface{}, not bool) interface{} {
+	if b, ok := value.(bool); ok && not {
+		return !b
+	}
+	return value
+}
+
+// compareOperands applies a comparison operator to two values. The result
+// is unknown if either value is NULL.
+func (e *Executor) compareOperands(left, right interface{}, operator string, collation storage.Collation) (interface{}, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	left, right, err := coerceOperands(left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	c := compareCollated(left, right, collation)
+	switch operator {
+	case "=":
+		return c == 0, nil
+	case "!=", "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return nil, fmt.Errorf("unsupported operator: %s", operator)
+}
+
+// coerceOperands brings two non-NULL values to types that compare with each
+// other. Values of one type compare, as do integers and floats. Text
+// compares with a value of another type by converting the text to that
+// type, as when a timestamp column is compared with '2024-01-31' or a number
+// stored as text before values were typed is compared with a number. Any
+// other pairing is an error rather than a guess.
+func coerceOperands(a, b interface{}) (interface{}, interface{}, error) {
+	aType, _ := storage.ValueTypeOf(a)
+	bType, _ := storage.ValueTypeOf(b)
+	switch {
+	case aType == bType, isNumericType(aType) && isNumericType(bType):
+		return a, b, nil
+	case aType == storage.ValueText:
+		converted, err := convertText(a.(string), bType)
+		return converted, b, err
+	case bType == storage.ValueText:
+		converted, err := convertText(b.(string), aType)
+		return a, converted, err
+	}
+	return nil, nil, fmt.Errorf("cannot compare %s with %s", aType, bType)
+}
+
+// convertText converts text compared with a value of another type to that
+// type. Text compared with an integer is read as any number, so '2.5' is
+// greater than 2.
+func convertText(text string, target storage.ValueType) (interface{}, error) {
+	columnType := target.String()
+	if target == storage.ValueInt {
+		columnType = storage.ValueFloat.String()
+	}
+	converted := coerceValue(text, columnType)
+	if convertedType, _ := storage.ValueTypeOf(converted); convertedType == storage.ValueText {
+		return nil, fmt.Errorf("cannot compare %s '%s' with %s", storage.ValueText, text, target)
+	}
+	return converted, nil
+}
+
+func isNumericType(valueType storage.ValueType) bool {
+	return valueType == storage.ValueInt || valueType == storage.ValueFloat
+}
@@ -0,0 +1,140 @@
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+// matchPredicate is a MATCH(column, 'query') full-text search condition
+type matchPredicate struct {
+	column string
+	query  string
+}
+
+// parseMatch returns the MATCH predicate expr represents. The second result
+// reports whether expr is a MATCH call at all.
+func parseMatch(expr Expression) (*matchPredicate, bool, error) {
+	call, ok := expr.(*FunctionCall)
+	if !ok || !strings.EqualFold(call.Name, "MATCH") {
+		return nil, false, nil
+	}
+	if len(call.Args) != 2 {
+		return nil, true, fmt.Errorf("MATCH expects a column and a query string")
+	}
+	column, okColumn := call.Args[0].(*Identifier)
+	query, okQuery := call.Args[1].(*StringLiteral)
+	if !okColumn || !okQuery {
+		return nil, true, fmt.Errorf("MATCH expects a column and a query string")
+	}
+	return &matchPredicate{column: column.Value, query: query.Value}, true, nil
+}
+
+// collectMatches returns every MATCH predicate in a WHERE clause
+func collectMatches(where Expression) ([]*matchPredicate, error) {
+	switch w := where.(type) {
+	case *BinaryExpression:
+		left, err := collectMatches(w.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := collectMatches(w.Right)
+		if err != nil {
+			return nil, err
+		}
+		return append(left, right...), nil
+	case *FunctionCall:
+		match, isMatch, err := parseMatch(w)
+		if err != nil || !isMatch {
+			return nil, err
+		}
+		return []*matchPredicate{match}, nil
+	}
+	return nil, nil
+}
+
+// evaluateMatch reports whether a row's column text satisfies a MATCH query
+func (e *Executor) evaluateMatch(rowData []interface{}, match *matchPredicate) (bool, error) {
+	query, err := storage.ParseTextQuery(match.query)
+	if err != nil {
+		return false, err
+	}
+	value := rowColumnValue(rowData, match.column)
+	if value == nil {
+		return false, nil
+	}
+	return query.Matches(fmt.Sprintf("%v", value)), nil
+}
+
+// scanFullText reads the rows matching the plan's MATCH query through a
+// full-text index, best match first
+func (e *Executor) scanFullText(plan *ExecutionPlan) ([][]interface{}, int, error) {
+	query, _ := plan.IndexValue.(string)
+	matches, err := e.storage.GetIndexManager().SearchText(plan.IndexName, query)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search index '%s': %w", plan.IndexName, err)
+	}
+
+	rowKeys := make([]string, len(matches))
+	for i, match := range matches {
+		rowKeys[i] = match.Key
+	}
+	rows, err := e.readRows(plan.Table, rowKeys)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return rows, len(matches), nil
+}
+
+// rankByRelevance orders rows by their combined BM25 score for the MATCH
+// predicates, best first. Scores come from a full-text index on the column
+// when one exists and are otherwise computed over the rows themselves.
+func (e *Executor) rankByRelevance(table string, where Expression, rows [][]interface{}, matches []*matchPredicate) ([][]interface{}, error) {
+	start := time.Now()
+	indexManager := e.storage.GetIndexManager()
+	scores := make(map[string]float64)
+
+	for _, match := range matches {
+		var results []storage.TextMatch
+		if idx := e.planner.findFullTextIndex(table, match.column, where); idx != "" {
+			var err error
+			results, err = indexManager.SearchText(idx, match.query)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			query, err := storage.ParseTextQuery(match.query)
+			if err != nil {
+				return nil, err
+			}
+			index := storage.NewFullTextIndex()
+			for _, row := range rows {
+				if value := rowColumnValue(row, match.column); value != nil {
+					index.Insert(rowKey(table, row), []byte(fmt.Sprintf("%v", value)))
+				}
+			}
+			results = index.Match(query)
+		}
+		for _, result := range results {
+			scores[result.Key] += result.Score
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return scores[rowKey(table, rows[i])] > scores[rowKey(table, rows[j])]
+	})
+	e.profile.record(opRank, len(rows), 0, time.Since(start))
+	return rows, nil
+}
+
+// rowKey returns the storage key of a row read from table
+func rowKey(table string, rowData []interface{}) string {
+	if len(rowData) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%v", table, rowData[0])
+}
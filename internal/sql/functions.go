@@ -0,0 +1,190 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Argument and return types a function can declare besides column types
+// such as INT, TEXT or TIMESTAMP
+const (
+	// TypeAny accepts any value, including NULL, unconverted
+	TypeAny = "ANY"
+	// TypeNumber accepts an integer or a float, keeping which it is
+	TypeNumber = "NUMBER"
+)
+
+// Function describes a Go function callable from SQL as a scalar function.
+// Arguments are converted to their declared types before Call sees them, as
+// values stored in a column of that type are, and the result is converted to
+// the declared return type.
+type Function struct {
+	Name string
+	// Args holds the type of each argument. The last Optional of them may
+	// be left out, and the last repeats any number of times if Variadic.
+	Args     []string
+	Optional int
+	Variadic bool
+	Returns  string
+	// NullInput passes NULL arguments to Call. Otherwise a NULL argument
+	// makes the result NULL without calling it.
+	NullInput bool
+	// Volatile marks a function whose result can change between calls with
+	// the same arguments, such as now(), so it cannot be used in an index
+	Volatile bool
+	Call     func(args []interface{}) (interface{}, error)
+}
+
+var (
+	functionsMu sync.RWMutex
+	// scalarFunctions holds the functions that can be used in expressions,
+	// keyed by upper-case name
+	scalarFunctions = make(map[string]*Function)
+)
+
+// RegisterFunction makes a Go function callable from SQL under its name,
+// which is not case-sensitive. Names of built-in and aggregate functions
+// cannot be reused.
+func RegisterFunction(fn Function) error {
+	if err := checkFunction(&fn); err != nil {
+		return err
+	}
+	name := strings.ToUpper(fn.Name)
+	if aggregateFunctions[name] || name == "DISTANCE" || name == "MATCH" {
+		return fmt.Errorf("function '%s' already exists", fn.Name)
+	}
+
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	if _, exists := scalarFunctions[name]; exists {
+		return fmt.Errorf("function '%s' already exists", fn.Name)
+	}
+	fn.Args = append([]string(nil), fn.Args...)
+	scalarFunctions[name] = &fn
+	return nil
+}
+
+// checkFunction validates a function's declaration
+func checkFunction(fn *Function) error {
+	// The name must read as one identifier, or SQL could not call it
+	if token := NewLexer(fn.Name).Next(); token.Type != TokenIdentifier || token.Literal != fn.Name {
+		return fmt.Errorf("invalid function name '%s'", fn.Name)
+	}
+	if fn.Call == nil {
+		return fmt.Errorf("function '%s' has no implementation", fn.Name)
+	}
+	if fn.Optional < 0 || fn.Optional > len(fn.Args) {
+		return fmt.Errorf("function '%s' has %d arguments, so %d cannot be optional", fn.Name, len(fn.Args), fn.Optional)
+	}
+	if fn.Variadic && len(fn.Args) == 0 {
+		return fmt.Errorf("variadic function '%s' must declare the type of its arguments", fn.Name)
+	}
+	for _, declared := range append(append([]string(nil), fn.Args...), fn.Returns) {
+		if !knownFunctionType(declared) {
+			return fmt.Errorf("function '%s' declares unknown type '%s'", fn.Name, declared)
+		}
+	}
+	return nil
+}
+
+func knownFunctionType(declared string) bool {
+	switch strings.ToUpper(declared) {
+	case TypeAny, TypeNumber:
+		return true
+	}
+	_, ok := columnValueType(declared)
+	return ok
+}
+
+// lookupFunction returns the scalar function with the given name, or nil
+func lookupFunction(name string) *Function {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	return scalarFunctions[strings.ToUpper(name)]
+}
+
+// call checks the number of arguments, converts them to their declared
+// types and calls the function, converting its result to the return type
+func (fn *Function) call(args []interface{}) (interface{}, error) {
+	required := len(fn.Args) - fn.Optional
+	if len(args) < required || (!fn.Variadic && len(args) > len(fn.Args)) {
+		return nil, fmt.Errorf("%s expects %s, got %d", fn.Name, fn.arityText(), len(args))
+	}
+
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg == nil && !fn.NullInput {
+			return nil, nil
+		}
+		declared := fn.Args[min(i, len(fn.Args)-1)]
+		value, err := convertArgument(arg, declared)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d of %s: %w", i+1, fn.Name, err)
+		}
+		converted[i] = value
+	}
+
+	result, err := fn.Call(converted)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name, err)
+	}
+	result, err = convertArgument(result, fn.Returns)
+	if err != nil {
+		return nil, fmt.Errorf("result of %s: %w", fn.Name, err)
+	}
+	return result, nil
+}
+
+// arityText describes how many arguments a function takes
+func (fn *Function) arityText() string {
+	required := len(fn.Args) - fn.Optional
+	switch {
+	case fn.Variadic:
+		return "at least " + argumentCount(required)
+	case fn.Optional > 0:
+		return fmt.Sprintf("%d to %d arguments", required, len(fn.Args))
+	}
+	return argumentCount(required)
+}
+
+func argumentCount(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// convertArgument converts a value to a declared function type
+func convertArgument(value interface{}, declared string) (interface{}, error) {
+	switch strings.ToUpper(declared) {
+	case TypeAny:
+		return value, nil
+	case TypeNumber:
+		if value == nil {
+			return nil, nil
+		}
+		if number, ok := toNumber(value); ok {
+			return number, nil
+		}
+		return nil, fmt.Errorf("%s is not a number", valueText(value))
+	}
+	return convertValue(value, declared)
+}
+
+// evaluateFunction evaluates a scalar function call against a row
+func (e *Executor) evaluateFunction(rowData []interface{}, call *FunctionCall) (interface{}, error) {
+	function := lookupFunction(call.Name)
+	if function == nil {
+		return nil, fmt.Errorf("unknown function '%s'", call.Name)
+	}
+	args := make([]interface{}, len(call.Args))
+	for i, arg := range call.Args {
+		value, err := e.evaluate(rowData, arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return function.call(args)
+}
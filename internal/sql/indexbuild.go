@@ -0,0 +1,214 @@
+package sql
+
+import (
+	"fmt"
+	"sort"
+
+	"startdb/internal/storage"
+)
+
+// btreeMinDegree is the minimum degree of the B-trees built for indexes
+const btreeMinDegree = 3
+
+// newIndex creates an empty index of the definition's type, outside the index
+// manager, so it can be filled before anything reads from it
+func (e *Executor) newIndex(definition *indexDefinition) (storage.Index, error) {
+	for option, value := range definition.Options {
+		switch {
+		case option == "metric" && definition.Type == storage.IndexTypeVector:
+		case option == bloomOption && !rowKeyedIndex(definition.Type):
+			if _, err := parseFalsePositiveRate(value); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown option '%s' for %s index", option, definition.Type)
+		}
+	}
+
+	switch definition.Type {
+	case storage.IndexTypeHash:
+		return storage.NewHashIndex(0), nil
+	case storage.IndexTypeFullText:
+		return storage.NewFullTextIndex(), nil
+	case storage.IndexTypeVector:
+		metadata, err := loadTableMetadata(e.storage, definition.Table)
+		if err != nil {
+			return nil, err
+		}
+		var columnType string
+		if column := metadata.column(definition.Column); column != nil {
+			columnType = column.Type
+		}
+		dimensions, isVector := vectorDimensions(columnType)
+		if !isVector {
+			return nil, fmt.Errorf("column '%s' is not a VECTOR column", definition.Column)
+		}
+		metric := storage.VectorMetricL2
+		if name, ok := definition.Options["metric"]; ok {
+			var err error
+			if metric, err = storage.ParseVectorMetric(name); err != nil {
+				return nil, err
+			}
+		}
+		definition.Options = map[string]string{"metric": string(metric)}
+		return storage.NewVectorIndex(dimensions, metric), nil
+	default:
+		return storage.NewBTree(btreeMinDegree), nil
+	}
+}
+
+// populateIndex builds an index from its table's rows and installs it under
+// indexName, returning the number of rows indexed. The build is online: the
+// table stays writable, and writes made while it runs are captured in the
+// index's side log and merged before the index is installed. A B-tree is
+// bulk-loaded from its sorted entries rather than filled one row at a time.
+func (e *Executor) populateIndex(indexName string, definition *indexDefinition, index storage.Index) (int, error) {
+	indexManager := e.storage.GetIndexManager()
+	if err := indexManager.BeginBuild(indexName, definition.Type); err != nil {
+		return 0, err
+	}
+	e.useCollations(definition.Table)
+
+	scanned, err := e.loadTableRows(definition.Table)
+	if err != nil {
+		indexManager.AbortBuild(indexName)
+		return 0, fmt.Errorf("failed to load rows from table '%s': %w", definition.Table, err)
+	}
+	rows := make(map[string][]interface{}, len(scanned))
+	for _, row := range scanned {
+		rows[rowKey(definition.Table, row)] = row
+	}
+
+	// Writes logged during the scan are merged before loading; the scan may
+	// or may not have seen them, and the logged row is the newer one
+	changes, err := indexManager.TakeRowChanges(indexName)
+	if err != nil {
+		indexManager.AbortBuild(indexName)
+		return 0, err
+	}
+	for _, change := range changes {
+		if row := e.changedRow(definition.Table, change); row != nil {
+			rows[change.Key] = row
+		} else {
+			delete(rows, change.Key)
+		}
+	}
+
+	keys := make([]string, 0, len(rows))
+	for key := range rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	indexed := 0
+	if tree, ok := index.(*storage.BTree); ok {
+		var entries []storage.KeyValue
+		collation := e.definitionCollation(definition)
+		for _, key := range keys {
+			if value := e.indexedValue(definition, rows[key]); value != nil {
+				entries = append(entries, storage.KeyValue{Key: e.indexEntryKey(value, collation, key), Value: e.indexEntry(definition, key, rows[key])})
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Key < entries[j].Key
+		})
+		if index, err = storage.BulkLoadBTree(tree.MinDegree, entries); err != nil {
+			indexManager.AbortBuild(indexName)
+			return 0, err
+		}
+		indexed = len(entries)
+	} else {
+		for _, key := range keys {
+			if e.insertIndexEntry(index, definition, key, rows[key]) {
+				indexed++
+			}
+		}
+	}
+
+	// Writes made while loading are applied to the finished index as they
+	// would have been to an index in use
+	err = indexManager.FinishBuild(indexName, index, func(index storage.Index, changes []storage.RowChange) {
+		for _, change := range changes {
+			if old, exists := rows[change.Key]; exists {
+				if e.removeIndexEntry(index, definition, change.Key, old) {
+					indexed--
+				}
+				delete(rows, change.Key)
+			}
+			if row := e.changedRow(definition.Table, change); row != nil {
+				if e.insertIndexEntry(index, definition, change.Key, row) {
+					indexed++
+				}
+				rows[change.Key] = row
+			}
+		}
+	})
+	if err != nil {
+		indexManager.AbortBuild(indexName)
+		return 0, err
+	}
+	e.indexChanged(indexName)
+	return indexed, nil
+}
+
+// logIndexBuilds records a write to a row in the side log of each index of
+// the table being built. rowData is nil when the row was deleted.
+func (e *Executor) logIndexBuilds(tableName, rowKey string, rowData []interface{}) {
+	indexManager := e.storage.GetIndexManager()
+	for _, indexName := range indexManager.BuildingIndexes() {
+		definition, err := loadIndexDefinition(e.storage, indexName)
+		if err != nil || definition.Table != tableName {
+			continue
+		}
+		var row []byte
+		if rowData != nil {
+			if row, err = e.encodeRow(tableName, rowData); err != nil {
+				continue
+			}
+		}
+		indexManager.LogRowChange(indexName, rowKey, row)
+	}
+}
+
+// changedRow decodes the row of a table a logged write left behind, nil if
+// it deleted the row
+func (e *Executor) changedRow(table string, change storage.RowChange) []interface{} {
+	if change.Row == nil {
+		return nil
+	}
+	row, err := e.decodeRow(table, change.Row)
+	if err != nil {
+		return nil
+	}
+	return row
+}
+
+// insertIndexEntry adds a row's entry to an index, reporting whether the row
+// has a value to index
+func (e *Executor) insertIndexEntry(index storage.Index, definition *indexDefinition, rowKey string, rowData []interface{}) bool {
+	value := e.indexedValue(definition, rowData)
+	if value == nil {
+		return false
+	}
+	if rowKeyedIndex(definition.Type) {
+		index.Insert(rowKey, []byte(columnText(value)))
+	} else {
+		index.Insert(e.indexEntryKey(value, e.definitionCollation(definition), rowKey), e.indexEntry(definition, rowKey, rowData))
+	}
+	return true
+}
+
+// removeIndexEntry removes a row's entry from an index, reporting whether the
+// row had one
+func (e *Executor) removeIndexEntry(index storage.Index, definition *indexDefinition, rowKey string, rowData []interface{}) bool {
+	value := e.indexedValue(definition, rowData)
+	if value == nil {
+		return false
+	}
+	if rowKeyedIndex(definition.Type) {
+		index.Delete(rowKey)
+	} else {
+		index.Delete(e.indexEntryKey(value, e.definitionCollation(definition), rowKey))
+	}
+	return true
+}
@@ -0,0 +1,436 @@
+package sql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"startdb/internal/storage"
+)
+
+// indexDefinition is the metadata recorded for an index by CREATE INDEX. An
+// index keys rows by a column, by several columns in order, or by an
+// expression over the row, and a partial index only holds the rows
+// satisfying its predicate.
+type indexDefinition struct {
+	Table      string
+	Column     string     // the indexed column, or the first of several; empty for an expression index
+	Columns    []string   // every key column of a multi-column index, nil otherwise
+	Expression Expression // the indexed expression, nil for a column index
+	Include    []string   // extra columns stored in each entry of a covering index
+	Where      Expression // the predicate of a partial index, nil otherwise
+	Type       storage.IndexType
+	Options    map[string]string
+}
+
+// indexMetadata is the stored form of an index definition
+type indexMetadata struct {
+	Table      string            `json:"table"`
+	Column     string            `json:"column,omitempty"`
+	Columns    []string          `json:"columns,omitempty"`
+	Expression *expressionJSON   `json:"expression,omitempty"`
+	Include    []string          `json:"include,omitempty"`
+	Where      *expressionJSON   `json:"where,omitempty"`
+	Type       storage.IndexType `json:"type"`
+	Options    map[string]string `json:"options,omitempty"`
+}
+
+// key returns what the index is keyed by: the column name, the column names
+// separated by commas, or the text of the indexed expression
+func (d *indexDefinition) key() string {
+	if d.Expression != nil {
+		return d.Expression.String()
+	}
+	if len(d.Columns) > 0 {
+		return strings.Join(d.Columns, ", ")
+	}
+	return d.Column
+}
+
+// keyColumns returns the columns the index is keyed by, in key order, or
+// nil for an expression index
+func (d *indexDefinition) keyColumns() []string {
+	if d.Expression != nil {
+		return nil
+	}
+	if len(d.Columns) > 0 {
+		return d.Columns
+	}
+	return []string{d.Column}
+}
+
+// encode returns the metadata stored for the definition
+func (d *indexDefinition) encode() ([]byte, error) {
+	expression, err := encodeExpression(d.Expression)
+	if err != nil {
+		return nil, err
+	}
+	where, err := encodeExpression(d.Where)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&indexMetadata{
+		Table:      d.Table,
+		Column:     d.Column,
+		Columns:    d.Columns,
+		Expression: expression,
+		Include:    d.Include,
+		Where:      where,
+		Type:       d.Type,
+		Options:    d.Options,
+	})
+}
+
+// loadIndexDefinition reads an index's metadata. Metadata is stored as JSON;
+// indexes created before expression indexes existed are stored as
+// "table:<table>:column:<column>:type:<type>" followed by any options as
+// further name:value pairs. Indexes recorded without a type are B-trees.
+func loadIndexDefinition(store *storage.Storage, indexName string) (*indexDefinition, error) {
+	data, err := store.Get(fmt.Sprintf("_index_metadata:%s", indexName))
+	if err != nil {
+		return nil, fmt.Errorf("index '%s' has no metadata", indexName)
+	}
+
+	definition := &indexDefinition{Type: storage.IndexTypeBTree}
+	if strings.HasPrefix(string(data), "{") {
+		var metadata indexMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("index '%s' has malformed metadata: %w", indexName, err)
+		}
+		definition.Table = metadata.Table
+		definition.Column = metadata.Column
+		definition.Columns = metadata.Columns
+		definition.Include = metadata.Include
+		definition.Options = metadata.Options
+		if metadata.Type != "" {
+			definition.Type = metadata.Type
+		}
+		if definition.Expression, err = decodeExpression(metadata.Expression); err != nil {
+			return nil, fmt.Errorf("index '%s' has malformed metadata: %w", indexName, err)
+		}
+		if definition.Where, err = decodeExpression(metadata.Where); err != nil {
+			return nil, fmt.Errorf("index '%s' has malformed metadata: %w", indexName, err)
+		}
+	} else {
+		parts := strings.Split(string(data), ":")
+		for i := 0; i+1 < len(parts); i += 2 {
+			switch parts[i] {
+			case "table":
+				definition.Table = parts[i+1]
+			case "column":
+				definition.Column = parts[i+1]
+			case "type":
+				definition.Type = storage.IndexType(parts[i+1])
+			default:
+				if definition.Options == nil {
+					definition.Options = make(map[string]string)
+				}
+				definition.Options[parts[i]] = parts[i+1]
+			}
+		}
+	}
+
+	if definition.Table == "" || definition.key() == "" {
+		return nil, fmt.Errorf("index '%s' has malformed metadata", indexName)
+	}
+	return definition, nil
+}
+
+// tableIndexDefinition returns the definition of an index if it belongs to
+// tableName, or nil. Indexes without metadata are matched by the
+// <table>_<column>_idx naming convention.
+func (e *Executor) tableIndexDefinition(indexName, tableName string) *indexDefinition {
+	indexType, err := e.storage.GetIndexManager().GetIndexType(indexName)
+	if err != nil {
+		return nil
+	}
+
+	definition, err := loadIndexDefinition(e.storage, indexName)
+	if err == nil {
+		if definition.Table != tableName {
+			return nil
+		}
+		definition.Type = indexType
+		return definition
+	}
+
+	if strings.HasPrefix(indexName, fmt.Sprintf("%s_", tableName)) && strings.HasSuffix(indexName, "_idx") {
+		parts := strings.Split(indexName, "_")
+		if len(parts) >= 2 {
+			return &indexDefinition{Table: tableName, Column: parts[1], Type: indexType}
+		}
+	}
+	return nil
+}
+
+// indexedValue returns the value a row is indexed under, or nil when the row
+// has none or falls outside a partial index's predicate
+func (e *Executor) indexedValue(definition *indexDefinition, rowData []interface{}) interface{} {
+	if definition.Where != nil {
+		if holds, err := e.evaluateWhere(rowData, definition.Where); err != nil || !holds {
+			return nil
+		}
+	}
+	if definition.Expression != nil {
+		return e.evaluateExpressionWithRowData(rowData, definition.Expression)
+	}
+	if len(definition.Columns) > 0 {
+		return e.compositeValue(definition.Table, definition.Columns, rowData)
+	}
+	return rowColumnValue(rowData, definition.Column)
+}
+
+// compositeKey is the value a row is indexed under in a multi-column index:
+// the row's value of each key column, as the sort key of its collation
+type compositeKey []interface{}
+
+// compositeValue returns a row's key in a multi-column index. A row NULL in
+// the first column is not indexed; NULLs in later columns are kept, so the
+// row is found by lookups on the columns before them.
+func (e *Executor) compositeValue(table string, columns []string, rowData []interface{}) interface{} {
+	if rowColumnValue(rowData, columns[0]) == nil {
+		return nil
+	}
+	key := make(compositeKey, len(columns))
+	for i, column := range columns {
+		key[i] = e.columnCollation(table, column).KeyValue(rowColumnValue(rowData, column))
+	}
+	return key
+}
+
+// validateIndexDefinition checks that an index's expression and predicate
+// only use the table's columns and functions that can be evaluated per row
+func validateIndexDefinition(definition *indexDefinition, columns []string) error {
+	known := make(map[string]bool)
+	for _, column := range columns {
+		known[column] = true
+	}
+
+	var check func(expr Expression) error
+	check = func(expr Expression) error {
+		switch e := expr.(type) {
+		case *Identifier:
+			if columns != nil && !known[e.Value] {
+				return fmt.Errorf("column '%s' does not exist in table '%s'", e.Value, definition.Table)
+			}
+		case *BinaryExpression:
+			if err := check(e.Left); err != nil {
+				return err
+			}
+			return check(e.Right)
+		case *FunctionCall:
+			if function := lookupFunction(e.Name); function == nil || function.Volatile {
+				return fmt.Errorf("function '%s' cannot be used in an index", e.Name)
+			}
+			for _, arg := range e.Args {
+				if err := check(arg); err != nil {
+					return err
+				}
+			}
+		case *SubqueryExpression, *ExistsExpression:
+			return fmt.Errorf("subqueries cannot be used in an index")
+		default:
+			if in, ok := expr.(*InExpression); ok && in.Subquery != nil {
+				return fmt.Errorf("subqueries cannot be used in an index")
+			}
+			for _, operand := range operands(expr) {
+				if err := check(operand); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if definition.Expression != nil {
+		if definition.Type != storage.IndexTypeBTree && definition.Type != storage.IndexTypeHash {
+			return fmt.Errorf("expression indexes are only supported for BTREE and HASH indexes")
+		}
+		if err := check(definition.Expression); err != nil {
+			return err
+		}
+	} else {
+		for _, column := range definition.keyColumns() {
+			if err := check(&Identifier{Value: column}); err != nil {
+				return err
+			}
+		}
+	}
+	if len(definition.Columns) > 0 && definition.Type != storage.IndexTypeBTree {
+		return fmt.Errorf("multi-column indexes are only supported for BTREE indexes")
+	}
+	if len(definition.Include) > 0 && rowKeyedIndex(definition.Type) {
+		return fmt.Errorf("INCLUDE is not supported for %s indexes", definition.Type)
+	}
+	for _, column := range definition.Include {
+		if err := check(&Identifier{Value: column}); err != nil {
+			return err
+		}
+	}
+	return check(definition.Where)
+}
+
+// indexApplies reports whether an index can answer a query with the given
+// WHERE clause: a partial index only holds the rows its predicate accepts,
+// so the query must only want such rows
+func indexApplies(definition *indexDefinition, where Expression) bool {
+	return definition.Where == nil || implies(where, definition.Where)
+}
+
+// implies reports whether every row satisfying where also satisfies
+// predicate. The check is conservative: false means the implication could
+// not be proven.
+func implies(where, predicate Expression) bool {
+	if where == nil {
+		return false
+	}
+	if b, ok := predicate.(*BinaryExpression); ok && b.Operator == "OR" {
+		return implies(where, b.Left) || implies(where, b.Right)
+	}
+
+	conditions := splitConjuncts(where)
+	for _, required := range splitConjuncts(predicate) {
+		if b, ok := required.(*BinaryExpression); ok && b.Operator == "OR" {
+			if !implies(where, b) {
+				return false
+			}
+			continue
+		}
+		proven := false
+		for _, condition := range conditions {
+			if conditionImplies(condition, required) {
+				proven = true
+				break
+			}
+		}
+		if !proven {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionImplies reports whether one conjunct of a WHERE clause implies a
+// required condition. A disjunction implies it when each of its sides does.
+func conditionImplies(condition, required Expression) bool {
+	if b, ok := condition.(*BinaryExpression); ok && b.Operator == "OR" {
+		return implies(b.Left, required) && implies(b.Right, required)
+	}
+	return condition.String() == required.String() || comparisonImplies(condition, required)
+}
+
+// comparisonImplies reports whether one comparison of an expression against
+// a literal implies another comparison of the same expression, such as
+// "age > 30" implying "age >= 18"
+func comparisonImplies(condition, required Expression) bool {
+	cKey, cValue, cOp, ok := predicateOperands(condition)
+	if !ok {
+		return false
+	}
+	rKey, rValue, rOp, ok := predicateOperands(required)
+	if !ok || cKey != rKey {
+		return false
+	}
+	cmp, ok := compareLiterals(cValue, rValue)
+	if !ok {
+		return false
+	}
+
+	switch cOp {
+	case "=":
+		return literalComparisonHolds(cmp, rOp)
+	case ">":
+		return (rOp == ">" || rOp == ">=" || rOp == "!=") && cmp >= 0
+	case ">=":
+		return (rOp == ">=" && cmp >= 0) || ((rOp == ">" || rOp == "!=") && cmp > 0)
+	case "<":
+		return (rOp == "<" || rOp == "<=" || rOp == "!=") && cmp <= 0
+	case "<=":
+		return (rOp == "<=" && cmp <= 0) || ((rOp == "<" || rOp == "!=") && cmp < 0)
+	case "!=":
+		return rOp == "!=" && cmp == 0
+	}
+	return false
+}
+
+// literalComparisonHolds reports whether "a op b" holds given cmp, the
+// result of comparing a with b
+func literalComparisonHolds(cmp int, op string) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// predicateOperands matches a comparison between an indexable expression and
+// a literal, flipping the operator so the expression is always on the left
+func predicateOperands(expr Expression) (string, interface{}, string, bool) {
+	w, ok := expr.(*BinaryExpression)
+	if !ok {
+		return "", nil, "", false
+	}
+	flipped := map[string]string{"=": "=", "!=": "!=", "<>": "!=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+	if _, ok := flipped[w.Operator]; !ok {
+		return "", nil, "", false
+	}
+	operator := w.Operator
+	if operator == "<>" {
+		operator = "!="
+	}
+	if key, ok := indexableKey(w.Left); ok {
+		if value, ok := literalValue(w.Right); ok {
+			return key, value, operator, true
+		}
+	}
+	if key, ok := indexableKey(w.Right); ok {
+		if value, ok := literalValue(w.Left); ok {
+			return key, value, flipped[w.Operator], true
+		}
+	}
+	return "", nil, "", false
+}
+
+// indexableKey returns the index key an expression could be looked up under:
+// a column name, or the text of a scalar function call over the row
+func indexableKey(expr Expression) (string, bool) {
+	switch e := expr.(type) {
+	case *Identifier:
+		return e.Value, true
+	case *FunctionCall:
+		if _, exists := scalarFunctions[strings.ToUpper(e.Name)]; exists {
+			return e.String(), true
+		}
+	}
+	return "", false
+}
+
+// compareLiterals compares two literal values of the same type, integers
+// and floats being both numbers
+func compareLiterals(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case int64, float64:
+		switch b.(type) {
+		case int64, float64:
+			return compareCollated(av, b, storage.CollationBinary), true
+		}
+		return 0, false
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	}
+	return 0, false
+}
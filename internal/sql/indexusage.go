@@ -0,0 +1,204 @@
+package sql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+// IndexUsageReport is how one index has been used, with the reasons it may
+// not be worth keeping
+type IndexUsageReport struct {
+	Index         string
+	Table         string
+	Key           string
+	Type          storage.IndexType
+	Size          int
+	Usage         storage.IndexUsage
+	Unused        bool   // the index has never answered a read
+	RedundantWith string // another index answering every query this one can
+}
+
+// Note summarises why an index may not be worth keeping, or is empty
+func (r *IndexUsageReport) Note() string {
+	switch {
+	case r.RedundantWith != "":
+		return fmt.Sprintf("redundant with %s", r.RedundantWith)
+	case r.Unused:
+		return "never used"
+	}
+	return ""
+}
+
+// indexUsageKey returns the key under which an index's usage is saved
+func indexUsageKey(indexName string) string {
+	return fmt.Sprintf("_index_usage:%s", indexName)
+}
+
+// SaveIndexUsage stores the usage of every index so it survives the database
+// being closed. Usage that has not changed since it was stored is not
+// rewritten.
+func SaveIndexUsage(store *storage.Storage) error {
+	indexManager := store.GetIndexManager()
+	for _, indexName := range indexManager.ListIndexes() {
+		data, err := json.Marshal(indexManager.GetIndexUsage(indexName))
+		if err != nil {
+			return err
+		}
+		if saved, err := store.Get(indexUsageKey(indexName)); err == nil && bytes.Equal(saved, data) {
+			continue
+		}
+		if err := store.Put(indexUsageKey(indexName), data); err != nil {
+			return fmt.Errorf("failed to save usage of index '%s': %w", indexName, err)
+		}
+	}
+	return nil
+}
+
+// restoreIndexUsage loads the saved usage of an index
+func restoreIndexUsage(store *storage.Storage, indexName string) {
+	data, err := store.Get(indexUsageKey(indexName))
+	if err != nil {
+		return
+	}
+	var usage storage.IndexUsage
+	if json.Unmarshal(data, &usage) == nil {
+		store.GetIndexManager().SetIndexUsage(indexName, usage)
+	}
+}
+
+// IndexUsageReports returns the usage of every index, flagging indexes that
+// have never been used and indexes another index makes redundant
+func IndexUsageReports(store *storage.Storage) ([]*IndexUsageReport, error) {
+	names, err := indexNames(store)
+	if err != nil {
+		return nil, err
+	}
+
+	indexManager := store.GetIndexManager()
+	definitions := make(map[string]*indexDefinition)
+	var reports []*IndexUsageReport
+	for _, name := range names {
+		definition, err := loadIndexDefinition(store, name)
+		if err != nil {
+			return nil, err
+		}
+		definitions[name] = definition
+
+		report := &IndexUsageReport{
+			Index: name,
+			Table: definition.Table,
+			Key:   definition.key(),
+			Type:  definition.Type,
+			Usage: indexManager.GetIndexUsage(name),
+		}
+		if info, err := indexManager.GetIndexInfo(name); err == nil {
+			report.Size, _ = info["size"].(int)
+		}
+		report.Unused = !report.Usage.Used()
+		reports = append(reports, report)
+	}
+
+	for _, report := range reports {
+		for _, other := range names {
+			if other == report.Index || !indexAnswers(definitions[other], definitions[report.Index]) {
+				continue
+			}
+			// Of two equivalent indexes only the one named last is redundant
+			if indexAnswers(definitions[report.Index], definitions[other]) && report.Index < other {
+				continue
+			}
+			report.RedundantWith = other
+			break
+		}
+	}
+	return reports, nil
+}
+
+// indexAnswers reports whether index a can answer every query index b can:
+// it is on the same table and key with the same predicate, stores every
+// column b stores, and supports the same lookups. A B-tree answers the
+// equality lookups of a hash index, and a multi-column B-tree answers the
+// lookups of an index on the columns its key starts with. A partial index
+// is never redundant with a full one, since it is smaller.
+func indexAnswers(a, b *indexDefinition) bool {
+	if a.Table != b.Table || (a.key() != b.key() && !keyPrefix(a, b)) {
+		return false
+	}
+	if a.Type != b.Type && !(a.Type == storage.IndexTypeBTree && b.Type == storage.IndexTypeHash) {
+		return false
+	}
+	if a.Type == storage.IndexTypeVector && a.Options["metric"] != b.Options["metric"] {
+		return false
+	}
+	if (a.Where == nil) != (b.Where == nil) || (a.Where != nil && a.Where.String() != b.Where.String()) {
+		return false
+	}
+
+	stored := make(map[string]bool)
+	for _, column := range a.coveredColumns() {
+		stored[column] = true
+	}
+	for _, column := range b.Include {
+		if !stored[column] {
+			return false
+		}
+	}
+	return true
+}
+
+// keyPrefix reports whether b is keyed by columns a multi-column B-tree a is
+// keyed by first
+func keyPrefix(a, b *indexDefinition) bool {
+	aColumns, bColumns := a.keyColumns(), b.keyColumns()
+	if a.Type != storage.IndexTypeBTree || len(a.Columns) == 0 || bColumns == nil || len(bColumns) >= len(aColumns) {
+		return false
+	}
+	for i, column := range bColumns {
+		if aColumns[i] != column {
+			return false
+		}
+	}
+	return true
+}
+
+// executeShowIndexStats reports the usage of every index, followed by the
+// adaptive hash indexes
+func (e *Executor) executeShowIndexStats(stmt *ShowIndexStatsStatement) (*QueryResult, error) {
+	reports, err := IndexUsageReports(e.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryResult{
+		Columns: []string{"index", "table", "key", "type", "size", "lookups", "range_scans", "rows_returned", "last_used", "inserts", "deletes", "maintenance_ms", "note"},
+	}
+	for _, report := range reports {
+		var lastUsed interface{}
+		if !report.Usage.LastUsed.IsZero() {
+			lastUsed = report.Usage.LastUsed.Format(time.DateTime)
+		}
+		result.Rows = append(result.Rows, []interface{}{
+			report.Index,
+			report.Table,
+			report.Key,
+			string(report.Type),
+			report.Size,
+			report.Usage.Lookups,
+			report.Usage.RangeScans,
+			report.Usage.RowsReturned,
+			lastUsed,
+			report.Usage.Inserts,
+			report.Usage.Deletes,
+			fmt.Sprintf("%.3f", float64(report.Usage.MaintenanceTime.Microseconds())/1000),
+			report.Note(),
+		})
+	}
+
+	result.Rows = append(result.Rows, e.adaptiveIndexStats()...)
+	result.Count = len(result.Rows)
+	return result, nil
+}
@@ -16,7 +16,10 @@ const (
 	TokenKeyword
 	TokenLeftParen
 	TokenRightParen
+	TokenLeftBracket
+	TokenRightBracket
 	TokenComma
+	TokenDot
 	TokenSemicolon
 	TokenEquals
 	TokenNotEquals
@@ -28,6 +31,8 @@ const (
 	TokenMinus
 	TokenAsterisk
 	TokenSlash
+	TokenPercent
+	TokenConcat
 	TokenAnd
 	TokenOr
 	TokenNot
@@ -87,10 +92,22 @@ func (l *Lexer) Next() Token {
 		tok.Type = TokenRightParen
 		tok.Literal = string(l.ch)
 		l.readChar()
+	case '[':
+		tok.Type = TokenLeftBracket
+		tok.Literal = string(l.ch)
+		l.readChar()
+	case ']':
+		tok.Type = TokenRightBracket
+		tok.Literal = string(l.ch)
+		l.readChar()
 	case ',':
 		tok.Type = TokenComma
 		tok.Literal = string(l.ch)
 		l.readChar()
+	case '.':
+		tok.Type = TokenDot
+		tok.Literal = string(l.ch)
+		l.readChar()
 	case ';':
 		tok.Type = TokenSemicolon
 		tok.Literal = string(l.ch)
@@ -149,6 +166,20 @@ func (l *Lexer) Next() Token {
 		tok.Type = TokenSlash
 		tok.Literal = string(l.ch)
 		l.readChar()
+	case '%':
+		tok.Type = TokenPercent
+		tok.Literal = string(l.ch)
+		l.readChar()
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok.Type = TokenConcat
+			tok.Literal = "||"
+		} else {
+			tok.Type = TokenIllegal
+			tok.Literal = string(l.ch)
+		}
+		l.readChar()
 	case '\'':
 		tok.Type = TokenString
 		tok.Literal = l.readString()
@@ -249,6 +280,21 @@ func (l *Lexer) readNumber() string {
 			l.readChar()
 		}
 	}
+	// An exponent, such as the e-3 of 1.5e-3
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.readPosition
+		if next < len(l.input) && (l.input[next] == '+' || l.input[next] == '-') {
+			next++
+		}
+		if next < len(l.input) && isDigit(l.input[next]) {
+			for l.readPosition <= next {
+				l.readChar()
+			}
+			for isDigit(l.ch) {
+				l.readChar()
+			}
+		}
+	}
 	return l.input[position:l.position]
 }
 
@@ -304,6 +350,70 @@ func lookupIdent(ident string) TokenType {
 		return TokenKeyword
 	case "OFFSET":
 		return TokenKeyword
+	case "JOIN":
+		return TokenKeyword
+	case "INNER":
+		return TokenKeyword
+	case "LEFT":
+		return TokenKeyword
+	case "RIGHT":
+		return TokenKeyword
+	case "ANALYZE":
+		return TokenKeyword
+	case "EXPLAIN":
+		return TokenKeyword
+	case "REINDEX":
+		return TokenKeyword
+	case "SHOW":
+		return TokenKeyword
+	case "INCLUDE":
+		return TokenKeyword
+	case "USING":
+		return TokenKeyword
+	case "WITH":
+		return TokenKeyword
+	case "COLLATE":
+		return TokenKeyword
+	case "UNIQUE":
+		return TokenKeyword
+	case "DEFAULT":
+		return TokenKeyword
+	case "AS":
+		return TokenKeyword
+	case "GROUP":
+		return TokenKeyword
+	case "HAVING":
+		return TokenKeyword
+	case "DISTINCT":
+		return TokenKeyword
+	case "ASC":
+		return TokenKeyword
+	case "DESC":
+		return TokenKeyword
+	case "LIKE":
+		return TokenKeyword
+	case "ILIKE":
+		return TokenKeyword
+	case "ESCAPE":
+		return TokenKeyword
+	case "IN":
+		return TokenKeyword
+	case "IS":
+		return TokenKeyword
+	case "BETWEEN":
+		return TokenKeyword
+	case "CASE":
+		return TokenKeyword
+	case "WHEN":
+		return TokenKeyword
+	case "THEN":
+		return TokenKeyword
+	case "ELSE":
+		return TokenKeyword
+	case "END":
+		return TokenKeyword
+	case "EXISTS":
+		return TokenKeyword
 	case "AND":
 		return TokenAnd
 	case "OR":
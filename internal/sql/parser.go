@@ -8,7 +8,8 @@ import (
 
 // Parser represents a SQL parser
 type Parser struct {
-	lexer *Lexer
+	lexer         *Lexer
+	derivedTables int // derived tables parsed so far, which number their names
 }
 
 // NewParser creates a new SQL parser
@@ -49,6 +50,14 @@ func (p *Parser) parseStatement() (Statement, error) {
 		return p.parseCreateStatement()
 	case "DROP":
 		return p.parseDropStatement()
+	case "ANALYZE":
+		return p.parseAnalyzeStatement()
+	case "EXPLAIN":
+		return p.parseExplainStatement()
+	case "REINDEX":
+		return p.parseReindexStatement()
+	case "SHOW":
+		return p.parseShowStatement()
 	default:
 		return nil, fmt.Errorf("unexpected statement: %s", token.Literal)
 	}
@@ -57,23 +66,55 @@ func (p *Parser) parseStatement() (Statement, error) {
 func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 	stmt := &SelectStatement{}
 
-	// Parse fields
-	fields, err := p.parseFieldList()
-	if err != nil {
-		return nil, err
+	// Parse DISTINCT or DISTINCT ON (keys)
+	if p.expectKeyword("DISTINCT") {
+		stmt.Distinct = true
+		if p.expectKeyword("ON") {
+			if !p.expectToken(TokenLeftParen) {
+				return nil, fmt.Errorf("expected ( after DISTINCT ON")
+			}
+			keys, err := p.parseFieldList()
+			if err != nil {
+				return nil, err
+			}
+			if !p.expectToken(TokenRightParen) {
+				return nil, fmt.Errorf("expected ) after DISTINCT ON keys")
+			}
+			stmt.DistinctOn = keys
+		}
+	}
+
+	// Parse fields, each optionally named by an alias
+	for {
+		field, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		alias, err := p.parseAlias()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Fields = append(stmt.Fields, field)
+		stmt.Aliases = append(stmt.Aliases, alias)
+
+		if p.lexer.Peek().Type != TokenComma {
+			break
+		}
+		p.lexer.Next() // consume comma
 	}
-	stmt.Fields = fields
 
 	// Parse FROM clause
 	if !p.expectKeyword("FROM") {
 		return nil, fmt.Errorf("expected FROM")
 	}
 
-	tableToken := p.lexer.Next()
-	if tableToken.Type != TokenIdentifier {
-		return nil, fmt.Errorf("expected table name")
+	table, tableAlias, subquery, err := p.parseTableReference("FROM")
+	if err != nil {
+		return nil, err
 	}
-	stmt.Table = tableToken.Literal
+	stmt.Table = table
+	stmt.TableAlias = tableAlias
+	stmt.Subquery = subquery
 
 	// Parse JOIN clauses
 	for {
@@ -121,9 +162,9 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		}
 		
 		// Parse table name
-		joinTableToken := p.lexer.Next()
-		if joinTableToken.Type != TokenIdentifier {
-			return nil, fmt.Errorf("expected table name after JOIN")
+		joinTable, joinAlias, joinSubquery, err := p.parseTableReference("JOIN")
+		if err != nil {
+			return nil, err
 		}
 		
 		// Parse ON condition
@@ -138,7 +179,9 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		
 		joinClause := &JoinClause{
 			Type:      joinType,
-			Table:     joinTableToken.Literal,
+			Table:     joinTable,
+			Alias:     joinAlias,
+			Subquery:  joinSubquery,
 			Condition: condition,
 		}
 		
@@ -155,31 +198,58 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		stmt.Where = where
 	}
 
+	// Parse GROUP BY and HAVING clauses
+	if p.expectKeyword("GROUP") {
+		if !p.expectKeyword("BY") {
+			return nil, fmt.Errorf("expected BY after GROUP")
+		}
+		groupBy, err := p.parseFieldList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.GroupBy = groupBy
+	}
+	if p.expectKeyword("HAVING") {
+		having, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Having = having
+	}
+
 	// Parse ORDER BY clause
 	if p.lexer.Peek().Type == TokenKeyword && strings.ToUpper(p.lexer.Peek().Literal) == "ORDER" {
 		p.lexer.Next() // consume ORDER
 		if !p.expectKeyword("BY") {
 			return nil, fmt.Errorf("expected BY after ORDER")
 		}
-		orderBy, err := p.parseFieldList()
-		if err != nil {
+		if err := p.parseOrderBy(stmt); err != nil {
 			return nil, err
 		}
-		stmt.OrderBy = orderBy
 	}
 
-	// Parse LIMIT clause
-	if p.lexer.Peek().Type == TokenKeyword && strings.ToUpper(p.lexer.Peek().Literal) == "LIMIT" {
-		p.lexer.Next() // consume LIMIT
-		limitToken := p.lexer.Next()
-		if limitToken.Type != TokenNumber {
-			return nil, fmt.Errorf("expected number after LIMIT")
+	// Parse LIMIT and OFFSET clauses, in either order
+	for _, keyword := range []string{"LIMIT", "OFFSET", "LIMIT"} {
+		if !p.expectKeyword(keyword) {
+			continue
 		}
-		limit, err := strconv.Atoi(limitToken.Literal)
+		countToken := p.lexer.Next()
+		if countToken.Type != TokenNumber {
+			return nil, fmt.Errorf("expected number after %s", keyword)
+		}
+		count, err := strconv.Atoi(countToken.Literal)
 		if err != nil {
-			return nil, fmt.Errorf("invalid LIMIT value: %s", limitToken.Literal)
+			return nil, fmt.Errorf("invalid %s value: %s", keyword, countToken.Literal)
+		}
+		if keyword == "LIMIT" {
+			if stmt.HasLimit {
+				return nil, fmt.Errorf("LIMIT is given more than once")
+			}
+			stmt.Limit = count
+			stmt.HasLimit = true
+		} else {
+			stmt.Offset = count
 		}
-		stmt.Limit = limit
 	}
 
 	return stmt, nil
@@ -352,6 +422,15 @@ func (p *Parser) parseCreateTable() (*CreateTableStatement, error) {
 		return nil, fmt.Errorf("expected )")
 	}
 
+	// Parse optional WITH (name = value, ...) clause
+	if p.expectKeyword("WITH") {
+		options, err := p.parseIndexOptions()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Options = options
+	}
+
 	return stmt, nil
 }
 
@@ -384,33 +463,131 @@ func (p *Parser) parseCreateIndex() (*CreateIndexStatement, error) {
 		return nil, fmt.Errorf("expected (")
 	}
 
-	columnToken := p.lexer.Next()
-	if columnToken.Type != TokenIdentifier {
-		return nil, fmt.Errorf("expected column name")
+	if p.lexer.Peek().Type != TokenIdentifier {
+		return nil, fmt.Errorf("expected column name or expression")
+	}
+	key, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if ident, ok := key.(*Identifier); ok {
+		stmt.Column = ident.Value
+	} else {
+		stmt.Expression = key
+	}
+
+	// Further columns make a multi-column key
+	for p.lexer.Peek().Type == TokenComma {
+		p.lexer.Next() // consume comma
+		if stmt.Expression != nil {
+			return nil, fmt.Errorf("a multi-column index key must only list columns")
+		}
+		columnToken := p.lexer.Next()
+		if columnToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected column name in index key")
+		}
+		if stmt.Columns == nil {
+			stmt.Columns = []string{stmt.Column}
+		}
+		stmt.Columns = append(stmt.Columns, columnToken.Literal)
 	}
-	stmt.Column = columnToken.Literal
 
 	if !p.expectToken(TokenRightParen) {
 		return nil, fmt.Errorf("expected )")
 	}
 
+	// Parse optional INCLUDE (columns) clause of a covering index
+	if p.expectKeyword("INCLUDE") {
+		if !p.expectToken(TokenLeftParen) {
+			return nil, fmt.Errorf("expected ( after INCLUDE")
+		}
+		for {
+			columnToken := p.lexer.Next()
+			if columnToken.Type != TokenIdentifier {
+				return nil, fmt.Errorf("expected column name in INCLUDE")
+			}
+			stmt.Include = append(stmt.Include, columnToken.Literal)
+			if p.lexer.Peek().Type == TokenComma {
+				p.lexer.Next() // consume comma
+			} else {
+				break
+			}
+		}
+		if !p.expectToken(TokenRightParen) {
+			return nil, fmt.Errorf("expected )")
+		}
+	}
+
 	// Parse optional USING clause
 	if p.lexer.Peek().Type == TokenKeyword && strings.ToUpper(p.lexer.Peek().Literal) == "USING" {
 		p.lexer.Next() // consume USING
 		typeToken := p.lexer.Next()
 		if typeToken.Type != TokenIdentifier {
-			return nil, fmt.Errorf("expected index type (BTREE or HASH) after USING")
+			return nil, fmt.Errorf("expected index type (BTREE, HASH, FULLTEXT or HNSW) after USING")
 		}
 		indexType := strings.ToUpper(typeToken.Literal)
-		if indexType != "BTREE" && indexType != "HASH" {
-			return nil, fmt.Errorf("invalid index type: %s (expected BTREE or HASH)", indexType)
+		if indexType != "BTREE" && indexType != "HASH" && indexType != "FULLTEXT" && indexType != "HNSW" {
+			return nil, fmt.Errorf("invalid index type: %s (expected BTREE, HASH, FULLTEXT or HNSW)", indexType)
 		}
 		stmt.IndexType = indexType
 	}
 
+	// Parse optional WITH (name = value, ...) clause
+	if p.expectKeyword("WITH") {
+		options, err := p.parseIndexOptions()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Options = options
+	}
+
+	// Parse optional WHERE clause of a partial index
+	if p.expectKeyword("WHERE") {
+		where, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Where = where
+	}
+
 	return stmt, nil
 }
 
+func (p *Parser) parseIndexOptions() (map[string]string, error) {
+	options := make(map[string]string)
+
+	if !p.expectToken(TokenLeftParen) {
+		return nil, fmt.Errorf("expected ( after WITH")
+	}
+
+	for {
+		nameToken := p.lexer.Next()
+		if nameToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected option name")
+		}
+		if !p.expectToken(TokenEquals) {
+			return nil, fmt.Errorf("expected = after option %s", nameToken.Literal)
+		}
+		valueToken := p.lexer.Next()
+		if valueToken.Type != TokenIdentifier && valueToken.Type != TokenString && valueToken.Type != TokenNumber {
+			return nil, fmt.Errorf("expected value for option %s", nameToken.Literal)
+		}
+		options[strings.ToLower(nameToken.Literal)] = valueToken.Literal
+
+		if p.lexer.Peek().Type == TokenComma {
+			p.lexer.Next() // consume comma
+		} else {
+			break
+		}
+	}
+
+	if !p.expectToken(TokenRightParen) {
+		return nil, fmt.Errorf("expected )")
+	}
+
+	return options, nil
+}
+
 func (p *Parser) parseDropStatement() (Statement, error) {
 	nextToken := p.lexer.Peek()
 	if nextToken.Type != TokenKeyword {
@@ -459,6 +636,124 @@ func (p *Parser) parseDropIndex() (*DropIndexStatement, error) {
 	return stmt, nil
 }
 
+func (p *Parser) parseAnalyzeStatement() (*AnalyzeStatement, error) {
+	stmt := &AnalyzeStatement{}
+
+	// Parse optional table name
+	if p.lexer.Peek().Type == TokenIdentifier {
+		stmt.Table = p.lexer.Next().Literal
+	}
+
+	return stmt, nil
+}
+
+func (p *Parser) parseReindexStatement() (*ReindexStatement, error) {
+	stmt := &ReindexStatement{}
+
+	// Parse optional index or table name
+	if p.lexer.Peek().Type == TokenIdentifier {
+		stmt.Name = p.lexer.Next().Literal
+	}
+
+	return stmt, nil
+}
+
+func (p *Parser) parseShowStatement() (Statement, error) {
+	if !p.expectKeyword("INDEX") {
+		return nil, fmt.Errorf("expected INDEX STATS after SHOW")
+	}
+	statsToken := p.lexer.Next()
+	if strings.ToUpper(statsToken.Literal) != "STATS" {
+		return nil, fmt.Errorf("expected STATS after SHOW INDEX")
+	}
+	return &ShowIndexStatsStatement{}, nil
+}
+
+func (p *Parser) parseExplainStatement() (*ExplainStatement, error) {
+	stmt := &ExplainStatement{}
+
+	// Parse optional ANALYZE
+	if strings.ToUpper(p.lexer.Peek().Literal) == "ANALYZE" {
+		p.lexer.Next()
+		stmt.Analyze = true
+	}
+
+	inner, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	if _, nested := inner.(*ExplainStatement); nested {
+		return nil, fmt.Errorf("cannot EXPLAIN an EXPLAIN statement")
+	}
+	stmt.Statement = inner
+
+	return stmt, nil
+}
+
+// parseTableReference parses a table named after FROM or JOIN with its
+// alias, or a derived table: a parenthesized SELECT, which must have an
+// alias. A derived table is given a name of its own that no stored table
+// can have.
+func (p *Parser) parseTableReference(clause string) (string, string, *SelectStatement, error) {
+	if !p.expectToken(TokenLeftParen) {
+		tableToken := p.lexer.Next()
+		if tableToken.Type != TokenIdentifier {
+			if clause == "FROM" {
+				return "", "", nil, fmt.Errorf("expected table name")
+			}
+			return "", "", nil, fmt.Errorf("expected table name after %s", clause)
+		}
+		alias, err := p.parseAlias()
+		return tableToken.Literal, alias, nil, err
+	}
+
+	subquery, err := p.parseSubquery()
+	if err != nil {
+		return "", "", nil, err
+	}
+	alias, err := p.parseAlias()
+	if err != nil {
+		return "", "", nil, err
+	}
+	if alias == "" {
+		return "", "", nil, fmt.Errorf("subquery in %s must have an alias", clause)
+	}
+	p.derivedTables++
+	return fmt.Sprintf("(subquery %d)", p.derivedTables), alias, subquery, nil
+}
+
+// parseSubquery parses a SELECT nested in parentheses, whose opening
+// parenthesis has been consumed
+func (p *Parser) parseSubquery() (*SelectStatement, error) {
+	if !p.expectKeyword("SELECT") {
+		return nil, fmt.Errorf("expected SELECT after (")
+	}
+	stmt, err := p.parseSelectStatement()
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectToken(TokenRightParen) {
+		return nil, fmt.Errorf("expected ) after subquery")
+	}
+	return stmt, nil
+}
+
+// parseAlias parses the name given to a field or table, written with or
+// without AS, returning "" if there is none
+func (p *Parser) parseAlias() (string, error) {
+	if p.expectKeyword("AS") {
+		aliasToken := p.lexer.Next()
+		if aliasToken.Type != TokenIdentifier {
+			return "", fmt.Errorf("expected name after AS")
+		}
+		return aliasToken.Literal, nil
+	}
+	if p.lexer.Peek().Type == TokenIdentifier {
+		return p.lexer.Next().Literal, nil
+	}
+	return "", nil
+}
+
 func (p *Parser) parseFieldList() ([]Expression, error) {
 	var fields []Expression
 
@@ -479,6 +774,41 @@ func (p *Parser) parseFieldList() ([]Expression, error) {
 	return fields, nil
 }
 
+// parseOrderBy parses the keys of an ORDER BY clause, each optionally
+// followed by ASC or DESC and by NULLS FIRST or NULLS LAST. NULLs sort as
+// larger than any value unless placed explicitly.
+func (p *Parser) parseOrderBy(stmt *SelectStatement) error {
+	for {
+		key, err := p.parseExpression()
+		if err != nil {
+			return err
+		}
+		var order SortOrder
+		if p.expectKeyword("DESC") {
+			order.Descending = true
+		} else {
+			p.expectKeyword("ASC")
+		}
+		order.NullsFirst = order.Descending
+		if p.expectWord("NULLS") {
+			switch {
+			case p.expectWord("FIRST"):
+				order.NullsFirst = true
+			case p.expectWord("LAST"):
+				order.NullsFirst = false
+			default:
+				return fmt.Errorf("expected FIRST or LAST after NULLS")
+			}
+		}
+		stmt.OrderBy = append(stmt.OrderBy, key)
+		stmt.Ordering = append(stmt.Ordering, order)
+
+		if !p.expectToken(TokenComma) {
+			return nil
+		}
+	}
+}
+
 func (p *Parser) parseExpression() (Expression, error) {
 	return p.parseBinaryExpression(0)
 }
@@ -491,11 +821,25 @@ func (p *Parser) parseBinaryExpression(precedence int) (Expression, error) {
 
 	for {
 		operator := p.lexer.Peek()
-		if !isBinaryOperator(operator.Literal) {
+		op := operator.Literal
+		if operator.Type == TokenKeyword || operator.Type == TokenAnd || operator.Type == TokenOr || operator.Type == TokenNot {
+			op = strings.ToUpper(op)
+		}
+		if isPredicateOperator(op) {
+			if getOperatorPrecedence(op) <= precedence {
+				break
+			}
+			left, err = p.parsePredicate(left)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !isBinaryOperator(op) {
 			break
 		}
 
-		opPrecedence := getOperatorPrecedence(operator.Literal)
+		opPrecedence := getOperatorPrecedence(op)
 		if opPrecedence <= precedence {
 			break
 		}
@@ -508,7 +852,7 @@ func (p *Parser) parseBinaryExpression(precedence int) (Expression, error) {
 
 		left = &BinaryExpression{
 			Left:     left,
-			Operator: operator.Literal,
+			Operator: op,
 			Right:    right,
 		}
 	}
@@ -516,23 +860,136 @@ func (p *Parser) parseBinaryExpression(precedence int) (Expression, error) {
 	return left, nil
 }
 
+// parsePredicate parses what follows the left operand of IS [NOT] NULL,
+// [NOT] IN (list), [NOT] BETWEEN low AND high, or [NOT] LIKE/ILIKE pattern
+// [ESCAPE character]
+func (p *Parser) parsePredicate(left Expression) (Expression, error) {
+	if p.expectKeyword("IS") {
+		not := p.expectToken(TokenNot)
+		if !p.expectToken(TokenNull) {
+			return nil, fmt.Errorf("expected NULL after IS")
+		}
+		return &IsNullExpression{Expr: left, Not: not}, nil
+	}
+
+	not := p.expectToken(TokenNot)
+	token := p.lexer.Next()
+	keyword := strings.ToUpper(token.Literal)
+	if token.Type != TokenKeyword {
+		keyword = ""
+	}
+
+	switch keyword {
+	case "IN":
+		if !p.expectToken(TokenLeftParen) {
+			return nil, fmt.Errorf("expected ( after IN")
+		}
+		if token := p.lexer.Peek(); token.Type == TokenKeyword && strings.EqualFold(token.Literal, "SELECT") {
+			subquery, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return &InExpression{Left: left, Subquery: &SubqueryExpression{Select: subquery}, Not: not}, nil
+		}
+		list, err := p.parseFieldList()
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectToken(TokenRightParen) {
+			return nil, fmt.Errorf("expected ) after IN list")
+		}
+		return &InExpression{Left: left, List: list, Not: not}, nil
+	case "BETWEEN":
+		// The bounds bind tighter than comparisons, so the AND between them
+		// is not read as a conjunction
+		lower, err := p.parseBinaryExpression(getOperatorPrecedence("BETWEEN"))
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectToken(TokenAnd) {
+			return nil, fmt.Errorf("expected AND after the lower bound of BETWEEN")
+		}
+		upper, err := p.parseBinaryExpression(getOperatorPrecedence("BETWEEN"))
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpression{Expr: left, Lower: lower, Upper: upper, Not: not}, nil
+	case "LIKE", "ILIKE":
+		like := &LikeExpression{Left: left, CaseInsensitive: keyword == "ILIKE", Not: not}
+		pattern, err := p.parseBinaryExpression(getOperatorPrecedence(keyword))
+		if err != nil {
+			return nil, err
+		}
+		like.Pattern = pattern
+		if p.expectKeyword("ESCAPE") {
+			escape, err := p.parseBinaryExpression(getOperatorPrecedence(keyword))
+			if err != nil {
+				return nil, err
+			}
+			like.Escape = escape
+		}
+		return like, nil
+	default:
+		return nil, fmt.Errorf("expected IN, BETWEEN, LIKE or ILIKE after NOT, got %s", token.Literal)
+	}
+}
+
 func (p *Parser) parseUnaryExpression() (Expression, error) {
 	token := p.lexer.Peek()
 
 	switch token.Type {
+	case TokenMinus:
+		// Unary minus binds tighter than any binary operator. A negative
+		// number is read as a literal.
+		p.lexer.Next()
+		operand, err := p.parseUnaryExpression()
+		if err != nil {
+			return nil, err
+		}
+		if number, ok := operand.(*NumberLiteral); ok {
+			if strings.HasPrefix(number.String(), "-") {
+				return parseNumber(strings.TrimPrefix(number.String(), "-"))
+			}
+			return parseNumber("-" + number.String())
+		}
+		return &UnaryExpression{Operator: "-", Operand: operand}, nil
+	case TokenNot:
+		// NOT binds looser than comparisons but tighter than AND
+		p.lexer.Next()
+		operand, err := p.parseBinaryExpression(getOperatorPrecedence("AND"))
+		if err != nil {
+			return nil, err
+		}
+		if exists, ok := operand.(*ExistsExpression); ok {
+			return &ExistsExpression{Subquery: exists.Subquery, Not: !exists.Not}, nil
+		}
+		return &UnaryExpression{Operator: "NOT", Operand: operand}, nil
 	case TokenIdentifier:
 		p.lexer.Next()
+		if p.lexer.Peek().Type == TokenLeftParen {
+			return p.parseFunctionCall(token.Literal)
+		}
+		// A column qualified by its table, or all of a table's columns
+		if p.expectToken(TokenDot) {
+			columnToken := p.lexer.Next()
+			if columnToken.Type != TokenIdentifier && columnToken.Type != TokenAsterisk {
+				return nil, fmt.Errorf("expected column name after %s.", token.Literal)
+			}
+			return &Identifier{Value: token.Literal + "." + columnToken.Literal}, nil
+		}
 		return &Identifier{Value: token.Literal}, nil
 	case TokenString:
 		p.lexer.Next()
 		return &StringLiteral{Value: token.Literal}, nil
+	case TokenNull:
+		p.lexer.Next()
+		return &NullLiteral{}, nil
+	case TokenTrue, TokenFalse:
+		p.lexer.Next()
+		return &BooleanLiteral{Value: token.Type == TokenTrue}, nil
 	case TokenNumber:
 		p.lexer.Next()
-		value, err := strconv.ParseFloat(token.Literal, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid number: %s", token.Literal)
-		}
-		return &NumberLiteral{Value: value}, nil
+		return parseNumber(token.Literal)
 	case TokenKeyword:
 		keyword := strings.ToUpper(token.Literal)
 		p.lexer.Next()
@@ -543,14 +1000,34 @@ func (p *Parser) parseUnaryExpression() (Expression, error) {
 			return &BooleanLiteral{Value: false}, nil
 		case "NULL":
 			return &NullLiteral{}, nil
+		case "CASE":
+			return p.parseCaseExpression()
+		case "EXISTS":
+			if !p.expectToken(TokenLeftParen) {
+				return nil, fmt.Errorf("expected ( after EXISTS")
+			}
+			subquery, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return &ExistsExpression{Subquery: &SubqueryExpression{Select: subquery}}, nil
 		default:
 			return nil, fmt.Errorf("unexpected keyword: %s", token.Literal)
 		}
 	case TokenAsterisk:
 		p.lexer.Next()
 		return &Identifier{Value: "*"}, nil
+	case TokenLeftBracket:
+		return p.parseVectorLiteral()
 	case TokenLeftParen:
 		p.lexer.Next() // consume (
+		if token := p.lexer.Peek(); token.Type == TokenKeyword && strings.EqualFold(token.Literal, "SELECT") {
+			subquery, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return &SubqueryExpression{Select: subquery}, nil
+		}
 		expr, err := p.parseExpression()
 		if err != nil {
 			return nil, err
@@ -564,6 +1041,137 @@ func (p *Parser) parseUnaryExpression() (Expression, error) {
 	}
 }
 
+// parseCaseExpression parses what follows CASE: an optional operand, one or
+// more WHEN ... THEN ... clauses, an optional ELSE and END
+func (p *Parser) parseCaseExpression() (*CaseExpression, error) {
+	expr := &CaseExpression{}
+	if token := p.lexer.Peek(); token.Type != TokenKeyword || !strings.EqualFold(token.Literal, "WHEN") {
+		operand, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		expr.Operand = operand
+	}
+
+	for p.expectKeyword("WHEN") {
+		condition, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectKeyword("THEN") {
+			return nil, fmt.Errorf("expected THEN after WHEN %s", condition.String())
+		}
+		result, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		expr.Whens = append(expr.Whens, WhenClause{Condition: condition, Result: result})
+	}
+	if len(expr.Whens) == 0 {
+		return nil, fmt.Errorf("expected WHEN in CASE")
+	}
+
+	if p.expectKeyword("ELSE") {
+		result, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		expr.Else = result
+	}
+	if !p.expectKeyword("END") {
+		return nil, fmt.Errorf("expected END after CASE")
+	}
+	return expr, nil
+}
+
+func (p *Parser) parseFunctionCall(name string) (*FunctionCall, error) {
+	call := &FunctionCall{Name: name}
+
+	p.lexer.Next() // consume (
+	if p.expectToken(TokenRightParen) {
+		return call, nil
+	}
+	call.Distinct = p.expectKeyword("DISTINCT")
+
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		if p.lexer.Peek().Type == TokenComma {
+			p.lexer.Next() // consume comma
+		} else if len(call.Args) == 1 && strings.EqualFold(name, "EXTRACT") && p.expectKeyword("FROM") {
+			// EXTRACT(field FROM timestamp) names its field by a bare word
+			if field, ok := arg.(*Identifier); ok {
+				call.Args[0] = &StringLiteral{Value: field.Value}
+			}
+		} else {
+			break
+		}
+	}
+
+	if !p.expectToken(TokenRightParen) {
+		return nil, fmt.Errorf("expected ) after arguments to %s", name)
+	}
+
+	return call, nil
+}
+
+// parseNumber reads a numeric literal. A whole number is an int64 unless it
+// is too large for one; a number with a decimal point or an exponent is a
+// float64.
+func parseNumber(text string) (*NumberLiteral, error) {
+	if !strings.ContainsAny(text, ".eE") {
+		if value, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return &NumberLiteral{Value: value, Text: text}, nil
+		}
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number: %s", text)
+	}
+	return &NumberLiteral{Value: value, Text: text}, nil
+}
+
+func (p *Parser) parseVectorLiteral() (*VectorLiteral, error) {
+	vector := &VectorLiteral{}
+
+	p.lexer.Next() // consume [
+	if p.expectToken(TokenRightBracket) {
+		return nil, fmt.Errorf("vector must have at least one component")
+	}
+
+	for {
+		negative := p.expectToken(TokenMinus)
+		token := p.lexer.Next()
+		if token.Type != TokenNumber {
+			return nil, fmt.Errorf("expected number in vector, got %s", token.Literal)
+		}
+		value, err := strconv.ParseFloat(token.Literal, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", token.Literal)
+		}
+		if negative {
+			value = -value
+		}
+		vector.Values = append(vector.Values, value)
+
+		if p.lexer.Peek().Type == TokenComma {
+			p.lexer.Next() // consume comma
+		} else {
+			break
+		}
+	}
+
+	if !p.expectToken(TokenRightBracket) {
+		return nil, fmt.Errorf("expected ] after vector components")
+	}
+
+	return vector, nil
+}
+
 func (p *Parser) parseIdentifierList() ([]string, error) {
 	var identifiers []string
 
@@ -645,23 +1253,66 @@ func (p *Parser) parseColumnDefinitions() ([]ColumnDefinition, error) {
 			Nullable: true,
 		}
 
-		// Parse NOT NULL if present
-		if p.lexer.Peek().Type == TokenKeyword && strings.ToUpper(p.lexer.Peek().Literal) == "NOT" {
-			p.lexer.Next() // consume NOT
-			if !p.expectKeyword("NULL") {
-				return nil, fmt.Errorf("expected NULL after NOT")
+		// Parse type parameters, such as the dimensions of VECTOR(3)
+		if p.expectToken(TokenLeftParen) {
+			var params []string
+			for {
+				paramToken := p.lexer.Next()
+				if paramToken.Type != TokenNumber {
+					return nil, fmt.Errorf("expected number in type %s", typeToken.Literal)
+				}
+				params = append(params, paramToken.Literal)
+
+				if p.lexer.Peek().Type == TokenComma {
+					p.lexer.Next() // consume comma
+				} else {
+					break
+				}
+			}
+			if !p.expectToken(TokenRightParen) {
+				return nil, fmt.Errorf("expected ) after parameters of type %s", typeToken.Literal)
 			}
-			column.Nullable = false
+			column.Type += "(" + strings.Join(params, ",") + ")"
 		}
 
-		// Parse DEFAULT if present
-		if p.lexer.Peek().Type == TokenKeyword && strings.ToUpper(p.lexer.Peek().Literal) == "DEFAULT" {
-			p.lexer.Next() // consume DEFAULT
-			defaultValue, err := p.parseExpression()
-			if err != nil {
-				return nil, err
+		// Parse NULL, NOT NULL, DEFAULT, COLLATE and UNIQUE, in any order
+		for {
+			token := p.lexer.Peek()
+			keyword := ""
+			if token.Type == TokenKeyword {
+				keyword = strings.ToUpper(token.Literal)
+			}
+			if token.Type == TokenNot {
+				p.lexer.Next() // consume NOT
+				if p.lexer.Next().Type != TokenNull {
+					return nil, fmt.Errorf("expected NULL after NOT")
+				}
+				column.Nullable = false
+			} else if token.Type == TokenNull {
+				p.lexer.Next() // consume NULL
+				column.Nullable = true
+			} else if keyword == "DEFAULT" {
+				p.lexer.Next() // consume DEFAULT
+				// Parsed above comparison precedence, so a NOT NULL that
+				// follows is not read as the start of NOT IN or NOT LIKE
+				defaultValue, err := p.parseBinaryExpression(getOperatorPrecedence("="))
+				if err != nil {
+					return nil, err
+				}
+				column.Default = defaultValue
+			} else if keyword == "COLLATE" {
+				p.lexer.Next() // consume COLLATE
+				nameToken := p.lexer.Next()
+				if nameToken.Type != TokenIdentifier && nameToken.Type != TokenString {
+					return nil, fmt.Errorf("expected collation name after COLLATE")
+				}
+				column.Collation = nameToken.Literal
+			} else if keyword == "UNIQUE" {
+				p.lexer.Next() // consume UNIQUE
+				column.Unique = true
+			} else {
+				break
 			}
-			column.Default = defaultValue
 		}
 
 		columns = append(columns, column)
@@ -687,6 +1338,17 @@ func (p *Parser) expectKeyword(keyword string) bool {
 	return false
 }
 
+// expectWord consumes a word that is only a keyword where it is expected,
+// such as FIRST in NULLS FIRST, and may otherwise name a column
+func (p *Parser) expectWord(word string) bool {
+	token := p.lexer.Peek()
+	if (token.Type == TokenIdentifier || token.Type == TokenKeyword) && strings.EqualFold(token.Literal, word) {
+		p.lexer.Next()
+		return true
+	}
+	return false
+}
+
 func (p *Parser) expectToken(tokenType TokenType) bool {
 	token := p.lexer.Peek()
 	if token.Type == tokenType {
@@ -697,7 +1359,7 @@ func (p *Parser) expectToken(tokenType TokenType) bool {
 }
 
 func isBinaryOperator(op string) bool {
-	operators := []string{"=", "!=", "<>", "<", ">", "<=", ">=", "AND", "OR", "+", "-", "*", "/"}
+	operators := []string{"=", "!=", "<>", "<", ">", "<=", ">=", "AND", "OR", "+", "-", "||", "*", "/", "%"}
 	for _, operator := range operators {
 		if op == operator {
 			return true
@@ -706,17 +1368,27 @@ func isBinaryOperator(op string) bool {
 	return false
 }
 
+// isPredicateOperator reports whether op starts a predicate parsed by
+// parsePredicate rather than a binary operator
+func isPredicateOperator(op string) bool {
+	switch op {
+	case "IS", "IN", "BETWEEN", "LIKE", "ILIKE", "NOT":
+		return true
+	}
+	return false
+}
+
 func getOperatorPrecedence(op string) int {
 	switch op {
 	case "OR":
 		return 1
 	case "AND":
 		return 2
-	case "=", "!=", "<>", "<", ">", "<=", ">=":
+	case "=", "!=", "<>", "<", ">", "<=", ">=", "IS", "IN", "BETWEEN", "LIKE", "ILIKE", "NOT":
 		return 3
-	case "+", "-":
+	case "+", "-", "||":
 		return 4
-	case "*", "/":
+	case "*", "/", "%":
 		return 5
 	default:
 		return 0
@@ -2,6 +2,8 @@ package sql
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"strings"
 
 	"startdb/internal/storage"
@@ -13,23 +15,89 @@ const (
 	PlanTypeIndexScan PlanType = "index_scan"
 	PlanTypeTableScan PlanType = "table_scan"
 	PlanTypeIndexRange PlanType = "index_range"
+	PlanTypeFullTextScan PlanType = "fulltext_scan"
+	PlanTypeVectorScan PlanType = "vector_scan"
+	PlanTypeAdaptiveHashScan PlanType = "adaptive_hash_scan"
+)
+
+// JoinStrategy is the algorithm used to join a table into the rows built so far
+type JoinStrategy string
+
+const (
+	JoinStrategyNestedLoop JoinStrategy = "nested_loop"
+	JoinStrategyHash       JoinStrategy = "hash_join"
+)
+
+// Cost model constants, in units of reading one row during a table scan
+const (
+	costRowScan   = 1.0
+	costIndexRow  = 1.5
+	costIndexOnly = 0.5 // reading a covered row from its index entry
+	costHashBuild = 1.5
+	costSortRow   = 0.1
+	costGroupRow  = 0.5 // hashing a row into its group
 )
 
 type ExecutionPlan struct {
-	Type        PlanType
-	Table       string
-	IndexName   string
-	IndexColumn string
-	IndexValue  interface{}
-	Where       Expression
-	OrderBy     []Expression
-	Limit       int
-	Offset      int
-	EstimatedCost int
+	Type            PlanType
+	Table           string
+	TableName       string      // the name the query refers to Table by: its alias, or the table name
+	IndexName       string
+	IndexColumn     string
+	IndexValue      interface{} // the lookup value, the MATCH query of a fulltext_scan or the distance call of a vector_scan
+	PrefixColumns   []string      // the leading columns of a multi-column index the lookup fixes
+	PrefixValues    []interface{} // the values it fixes them to; the range, if any, is on the next column
+	RangeLower      interface{} // nil when the range is open below
+	RangeUpper      interface{} // nil when the range is open above
+	LowerExclusive  bool        // the range holds values above RangeLower but not RangeLower itself
+	UpperExclusive  bool        // the range holds values below RangeUpper but not RangeUpper itself
+	Ordered         bool        // rows come back already sorted by OrderBy
+	Reverse         bool        // an index range is read from its highest key down
+	TopN            bool        // the sort keeps only the first Offset+Limit rows
+	IndexOnly       bool        // the index stores every column the query reads
+	RankByRelevance bool        // without ORDER BY, sort rows by MATCH relevance
+	Aggregate       bool        // rows are grouped by GroupBy before HAVING, ORDER BY and LIMIT
+	Joins           []*JoinPlan // in execution order, which may differ from the query
+	Where           Expression
+	GroupBy         []Expression
+	Having          Expression
+	OrderBy         []Expression
+	Ordering        []SortOrder
+	Distinct        bool
+	DistinctOn      []Expression
+	Limit           int
+	HasLimit        bool
+	Offset          int
+	ScanRows        float64 // rows read by the access path
+	ScanCost        float64
+	InputRows       float64 // rows reaching the aggregate
+	GroupRows       float64 // groups formed, before HAVING
+	EstimatedRows   float64
+	EstimatedCost   float64
+}
+
+// JoinPlan describes one step of a join pipeline
+type JoinPlan struct {
+	Table         string
+	Name          string // the name the query refers to Table by
+	Position      int // position of Table in the FROM clause, base table first
+	Type          JoinType
+	Strategy      JoinStrategy
+	Conditions    []Expression // all must hold for a joined row
+	LeftTable     string       // hash join: table supplying the probe value
+	LeftName      string
+	LeftPosition  int
+	LeftColumn    string
+	RightColumn   string // hash join: column of Table the hash table is built on
+	ScanRows      float64
+	EstimatedRows float64
+	EstimatedCost float64
 }
 
 type Planner struct {
 	storage *storage.Storage
+	derived map[string][]string // columns of the statement's derived tables, by the name they are read under
+	params  []interface{}       // values of the parameters of the subquery being planned
 }
 
 func NewPlanner(storage *storage.Storage) *Planner {
@@ -40,70 +108,952 @@ func NewPlanner(storage *storage.Storage) *Planner {
 
 func (p *Planner) PlanSelect(stmt *SelectStatement) (*ExecutionPlan, error) {
 	plan := &ExecutionPlan{
-		Table:   stmt.Table,
-		Where:   stmt.Where,
-		OrderBy: stmt.OrderBy,
-		Limit:   stmt.Limit,
-		Offset:  stmt.Offset,
+		Table:      stmt.Table,
+		TableName:  sourceName(stmt.Table, stmt.TableAlias),
+		Where:      stmt.Where,
+		OrderBy:    stmt.OrderBy,
+		Ordering:   stmt.Ordering,
+		Distinct:   stmt.Distinct,
+		DistinctOn: stmt.DistinctOn,
+		Limit:      stmt.Limit,
+		HasLimit:   stmt.HasLimit,
+		Offset:     stmt.Offset,
+	}
+
+	// Grouping discards the order rows are read in, so an aggregate query
+	// reads them by the cheapest path and sorts and limits its groups. Rows
+	// removed by DISTINCT do not count towards LIMIT, so the access path
+	// cannot stop after LIMIT rows either.
+	aggregate := isAggregateQuery(stmt)
+	access := stmt
+	if aggregate || stmt.Distinct {
+		unlimited := *stmt
+		unlimited.Limit = 0
+		unlimited.HasLimit = false
+		if aggregate {
+			unlimited.OrderBy = nil
+		}
+		access = &unlimited
+	}
+
+	var stats *TableStatistics
+	if len(stmt.Joins) > 0 {
+		p.planJoins(plan, access)
+	} else {
+		stats = loadTableStatistics(p.storage, stmt.Table)
+		p.chooseAccessPath(plan, access, stats)
+
+		// A full-text scan returns its rows best match first, so ranking is
+		// only needed when it does not cover every MATCH in the query
+		if len(stmt.OrderBy) == 0 && !aggregate {
+			matches, _ := collectMatches(stmt.Where)
+			plan.RankByRelevance = len(matches) > 1 || (len(matches) == 1 && plan.Type != PlanTypeFullTextScan)
+		}
+	}
+	if aggregate {
+		p.planAggregate(plan, stmt, stats)
+	}
+
+	// Sorting for a LIMIT only has to keep the rows it returns
+	plan.TopN = stmt.HasLimit && len(stmt.OrderBy) > 0 && !plan.Ordered && !stmt.Distinct
+	if plan.TopN {
+		plan.EstimatedCost += topNCost(plan.EstimatedRows, stmt.Offset+stmt.Limit) - sortCost(plan.EstimatedRows, stmt.OrderBy)
+	}
+	return plan, nil
+}
+
+// planAggregate adds the grouping of an aggregate query to its plan: the
+// rows are hashed into groups, which are filtered by HAVING and sorted
+func (p *Planner) planAggregate(plan *ExecutionPlan, stmt *SelectStatement, stats *TableStatistics) {
+	plan.Aggregate = true
+	plan.GroupBy = stmt.GroupBy
+	plan.Having = stmt.Having
+	plan.InputRows = plan.EstimatedRows
+	plan.GroupRows = 1
+	if len(stmt.GroupBy) > 0 {
+		plan.GroupRows = estimateGroups(stmt.GroupBy, plan.InputRows, stats)
+	}
+	plan.EstimatedRows = plan.GroupRows * p.estimateSelectivity(stmt.Having, nil)
+	plan.EstimatedCost += plan.InputRows*costGroupRow + sortCost(plan.EstimatedRows, stmt.OrderBy)
+}
+
+// estimateGroups estimates how many groups rows fall into: the product of
+// the distinct values of the grouped columns, counting NULL as one, or a
+// fixed fraction of the rows when a key is not a column with statistics
+func estimateGroups(keys []Expression, rows float64, stats *TableStatistics) float64 {
+	groups := 1.0
+	for _, key := range keys {
+		var colStats *ColumnStatistics
+		if ident, ok := key.(*Identifier); ok {
+			colStats = stats.column(ident.Value)
+		}
+		if colStats == nil {
+			groups = rows * defaultGroupFraction
+			break
+		}
+		distinct := float64(colStats.DistinctCount)
+		if colStats.NullFraction > 0 {
+			distinct++
+		}
+		groups *= distinct
+	}
+	return math.Min(math.Max(groups, 1), rows)
+}
+
+// chooseAccessPath picks the cheapest of a table scan and the index scans
+// made possible by the WHERE clause
+func (p *Planner) chooseAccessPath(plan *ExecutionPlan, stmt *SelectStatement, stats *TableStatistics) {
+	rows := stats.rowCount()
+	plan.Type = PlanTypeTableScan
+	plan.EstimatedRows = rows * p.estimateSelectivity(stmt.Where, stats)
+	plan.ScanRows = rows
+	plan.ScanCost = rows * costRowScan
+	plan.EstimatedCost = plan.ScanCost + sortCost(plan.EstimatedRows, stmt.OrderBy)
+	probe := indexProbeCost(rows)
+
+	// A nearest-neighbour query reads only its LIMIT closest rows from a
+	// vector index built with the same metric
+	if len(stmt.OrderBy) > 0 && stmt.Limit > 0 && stmt.sortOrder(0) == (SortOrder{}) {
+		if call, isDistance, err := parseDistance(stmt.OrderBy[0]); isDistance && err == nil {
+			if idx := p.findVectorIndex(stmt.Table, call.column, call.metric, stmt.Where); idx != "" {
+				matched := math.Min(float64(stmt.Limit+stmt.Offset), rows)
+				cost := probe + matched*costIndexRow
+				if cost < plan.EstimatedCost {
+					plan.Type = PlanTypeVectorScan
+					plan.IndexName = idx
+					plan.IndexColumn = call.column
+					plan.IndexValue = call
+					plan.Ordered = len(stmt.OrderBy) == 1
+					plan.ScanRows = matched
+					plan.ScanCost = cost
+					plan.EstimatedCost = cost
+				}
+			}
+		}
 	}
 
+	p.chooseOrderedIndexScan(plan, stmt, rows)
 	if stmt.Where == nil {
-		plan.Type = PlanTypeTableScan
-		plan.EstimatedCost = 1000
-		return plan, nil
+		return
+	}
+	indexManager := p.storage.GetIndexManager()
+
+	for _, eq := range p.extractEqualities(stmt.Where) {
+		idx := p.findIndex(stmt.Table, eq.column, stmt.Where)
+		if idx == "" {
+			continue
+		}
+		value, ok := p.indexLookupValue(stmt.Table, eq.column, eq.value)
+		if !ok {
+			continue
+		}
+		matched := rows * stats.column(eq.column).equalSelectivity(eq.value)
+		indexOnly := p.coversQuery(idx, stmt)
+		scanCost := probe + matched*indexRowCost(indexOnly)
+		cost := scanCost
+		if !p.hasOrderBy(stmt.OrderBy[:min(1, len(stmt.OrderBy))], eq.column) {
+			cost += sortCost(matched, stmt.OrderBy)
+		}
+		if cost < plan.EstimatedCost {
+			plan.Type = PlanTypeIndexScan
+			plan.IndexName = idx
+			plan.IndexColumn = eq.column
+			plan.IndexValue = value
+			plan.Ordered = false
+			plan.Reverse = false
+			plan.IndexOnly = indexOnly
+			plan.ScanRows = matched
+			plan.ScanCost = scanCost
+			plan.EstimatedCost = cost
+		}
 	}
 
-	columnName, columnValue, canUseIndex := p.extractIndexableColumn(stmt.Where)
-	if !canUseIndex || columnName == "" || columnValue == nil {
-		plan.Type = PlanTypeTableScan
-		plan.EstimatedCost = 1000
-		return plan, nil
+	// A column with no index may have an adaptive hash index, built because
+	// it is often looked up
+	adaptive := p.storage.GetAdaptiveHashIndexes()
+	for _, eq := range p.extractEqualities(stmt.Where) {
+		if !adaptive.Has(stmt.Table, eq.column) || p.findIndex(stmt.Table, eq.column, stmt.Where) != "" {
+			continue
+		}
+		value, ok := p.indexLookupValue(stmt.Table, eq.column, eq.value)
+		if !ok {
+			continue
+		}
+		matched := rows * stats.column(eq.column).equalSelectivity(eq.value)
+		scanCost := probe + matched*costIndexRow
+		cost := scanCost + sortCost(matched, stmt.OrderBy)
+		if cost < plan.EstimatedCost {
+			plan.Type = PlanTypeAdaptiveHashScan
+			plan.IndexName = ""
+			plan.IndexColumn = eq.column
+			plan.IndexValue = value
+			plan.Ordered = false
+			plan.Reverse = false
+			plan.IndexOnly = false
+			plan.ScanRows = matched
+			plan.ScanCost = scanCost
+			plan.EstimatedCost = cost
+		}
+	}
+
+	for _, conjunct := range splitConjuncts(stmt.Where) {
+		match, isMatch, err := parseMatch(conjunct)
+		if !isMatch || err != nil {
+			continue
+		}
+		idx := p.findFullTextIndex(stmt.Table, match.column, stmt.Where)
+		if idx == "" {
+			continue
+		}
+		matched := rows * defaultMatchSelectivity
+		cost := probe + matched*costIndexRow + sortCost(matched, stmt.OrderBy)
+		if cost < plan.EstimatedCost {
+			plan.Type = PlanTypeFullTextScan
+			plan.IndexName = idx
+			plan.IndexColumn = match.column
+			plan.IndexValue = match.query
+			plan.Ordered = false
+			plan.Reverse = false
+			plan.IndexOnly = false
+			plan.ScanRows = matched
+			plan.ScanCost = probe + matched*costIndexRow
+			plan.EstimatedCost = cost
+		}
+	}
+
+	for _, r := range p.extractIndexableRanges(stmt.Where) {
+		idx := p.findIndex(stmt.Table, r.column, stmt.Where)
+		if idx == "" {
+			continue
+		}
+		if indexType, err := indexManager.GetIndexType(idx); err != nil || indexType != storage.IndexTypeBTree {
+			continue
+		}
+		lower, okLower := p.indexLookupValue(stmt.Table, r.column, r.lower)
+		upper, okUpper := p.indexLookupValue(stmt.Table, r.column, r.upper)
+		if !okLower || !okUpper {
+			continue
+		}
+		matched := rows * stats.column(r.column).rangeSelectivity(r.lower, r.upper)
+		indexOnly := p.coversQuery(idx, stmt)
+		scanCost := probe + matched*indexRowCost(indexOnly)
+		cost := scanCost
+		// The range holds no NULLs, so it is in the order of a sole ORDER BY
+		// key on its column whatever the key's NULL placement
+		ordered := len(stmt.OrderBy) == 1 && p.hasOrderBy(stmt.OrderBy, r.column)
+		if !ordered {
+			cost += sortCost(matched, stmt.OrderBy)
+		}
+		if cost < plan.EstimatedCost {
+			plan.Type = PlanTypeIndexRange
+			plan.IndexName = idx
+			plan.IndexColumn = r.column
+			plan.IndexValue = nil
+			plan.RangeLower = lower
+			plan.RangeUpper = upper
+			plan.LowerExclusive = r.lowerExclusive
+			plan.UpperExclusive = r.upperExclusive
+			plan.Ordered = ordered
+			plan.Reverse = ordered && stmt.sortOrder(0).Descending
+			plan.IndexOnly = indexOnly
+			plan.ScanRows = matched
+			plan.ScanCost = scanCost
+			plan.EstimatedCost = cost
+		}
+	}
+
+	p.chooseCompositeIndexScan(plan, stmt, stats)
+}
+
+// chooseCompositeIndexScan reads a multi-column B-tree over the entries
+// whose leading columns equal values the WHERE clause fixes them to, bounded
+// by a range on the column after them if the clause has one
+func (p *Planner) chooseCompositeIndexScan(plan *ExecutionPlan, stmt *SelectStatement, stats *TableStatistics) {
+	rows := stats.rowCount()
+	equalities := make(map[string]interface{})
+	for _, eq := range p.extractEqualities(stmt.Where) {
+		if _, seen := equalities[eq.column]; !seen {
+			equalities[eq.column] = eq.value
+		}
+	}
+	ranges := make(map[string]*indexRange)
+	for _, r := range p.extractIndexableRanges(stmt.Where) {
+		ranges[r.column] = r
 	}
 
 	indexManager := p.storage.GetIndexManager()
-	allIndexes := indexManager.ListIndexes()
-	
-	var foundIndex string
-	
-	for _, idx := range allIndexes {
-		if idx == fmt.Sprintf("%s_%s_idx", stmt.Table, columnName) {
-			foundIndex = idx
+	indexes := indexManager.ListIndexes()
+	sort.Strings(indexes)
+	for _, idx := range indexes {
+		definition, err := loadIndexDefinition(p.storage, idx)
+		if err != nil || definition.Table != stmt.Table || len(definition.Columns) == 0 || !indexApplies(definition, stmt.Where) {
+			continue
+		}
+		if indexType, err := indexManager.GetIndexType(idx); err != nil || indexType != storage.IndexTypeBTree {
+			continue
+		}
+
+		var prefixColumns []string
+		var prefixValues []interface{}
+		var bounds *indexRange
+		var lower, upper interface{}
+		selectivity := 1.0
+		for _, column := range definition.Columns {
+			if value, ok := equalities[column]; ok {
+				if converted, ok := p.indexLookupValue(stmt.Table, column, value); ok {
+					prefixColumns = append(prefixColumns, column)
+					prefixValues = append(prefixValues, converted)
+					selectivity *= stats.column(column).equalSelectivity(value)
+					continue
+				}
+			}
+			if r, ok := ranges[column]; ok {
+				var okLower, okUpper bool
+				lower, okLower = p.indexLookupValue(stmt.Table, column, r.lower)
+				upper, okUpper = p.indexLookupValue(stmt.Table, column, r.upper)
+				if okLower && okUpper {
+					bounds = r
+					selectivity *= stats.column(column).rangeSelectivity(r.lower, r.upper)
+				}
+			}
 			break
 		}
-		
-		indexMetadataKey := fmt.Sprintf("_index_metadata:%s", idx)
-		indexMetadata, err := p.storage.Get(indexMetadataKey)
-		if err == nil {
-			metadata := string(indexMetadata)
-			if strings.Contains(metadata, fmt.Sprintf("table:%s", stmt.Table)) && 
-			   strings.Contains(metadata, fmt.Sprintf("column:%s", columnName)) {
-				foundIndex = idx
-				break
+		if len(prefixColumns) == 0 && bounds == nil {
+			continue
+		}
+
+		matched := rows * selectivity
+		indexOnly := p.coversQuery(idx, stmt)
+		scanCost := indexProbeCost(rows) + matched*indexRowCost(indexOnly)
+		cost := scanCost
+		ordered := bounds != nil && len(stmt.OrderBy) == 1 && p.hasOrderBy(stmt.OrderBy, bounds.column)
+		if !ordered {
+			cost += sortCost(matched, stmt.OrderBy)
+		}
+		if cost < plan.EstimatedCost {
+			plan.Type = PlanTypeIndexRange
+			plan.IndexName = idx
+			plan.IndexColumn = ""
+			plan.IndexValue = nil
+			plan.PrefixColumns = prefixColumns
+			plan.PrefixValues = prefixValues
+			plan.RangeLower = nil
+			plan.RangeUpper = nil
+			plan.LowerExclusive = false
+			plan.UpperExclusive = false
+			if bounds != nil {
+				plan.IndexColumn = bounds.column
+				plan.RangeLower = lower
+				plan.RangeUpper = upper
+				plan.LowerExclusive = bounds.lowerExclusive
+				plan.UpperExclusive = bounds.upperExclusive
 			}
+			plan.Ordered = ordered
+			plan.Reverse = ordered && stmt.sortOrder(0).Descending
+			plan.IndexOnly = indexOnly
+			plan.ScanRows = matched
+			plan.ScanCost = scanCost
+			plan.EstimatedCost = cost
 		}
 	}
+}
 
-	if foundIndex != "" {
-		plan.Type = PlanTypeIndexScan
-		plan.IndexName = foundIndex
-		plan.IndexColumn = columnName
-		plan.IndexValue = columnValue
-		plan.EstimatedCost = 10
-	} else {
-		plan.Type = PlanTypeTableScan
-		plan.EstimatedCost = 1000
-		return plan, nil
+// chooseOrderedIndexScan reads the whole of a B-tree on the sole ORDER BY
+// column when that is cheaper than sorting. The index holds every row in
+// order but those with no entry, which are NULL in the column and are read
+// from the table.
+func (p *Planner) chooseOrderedIndexScan(plan *ExecutionPlan, stmt *SelectStatement, rows float64) {
+	if len(stmt.OrderBy) != 1 {
+		return
+	}
+	ident, ok := stmt.OrderBy[0].(*Identifier)
+	if !ok {
+		return
+	}
+	idx := p.findIndex(stmt.Table, ident.Value, stmt.Where)
+	if indexType, err := p.storage.GetIndexManager().GetIndexType(idx); idx == "" || err != nil || indexType != storage.IndexTypeBTree {
+		return
 	}
 
-	if p.hasOrderBy(stmt.OrderBy, columnName) {
-		plan.EstimatedCost = 5
+	scanCost := indexProbeCost(rows) + rows*costIndexRow
+	if scanCost < plan.EstimatedCost {
+		plan.Type = PlanTypeIndexRange
+		plan.IndexName = idx
+		plan.IndexColumn = ident.Value
+		plan.Ordered = true
+		plan.Reverse = stmt.sortOrder(0).Descending
+		plan.ScanRows = rows
+		plan.ScanCost = scanCost
+		plan.EstimatedCost = scanCost
 	}
+}
 
-	if stmt.Limit > 0 && stmt.Limit < 100 {
-		plan.EstimatedCost = max(1, plan.EstimatedCost-2)
+// indexRowCost returns the cost of reading one row found through an index
+func indexRowCost(indexOnly bool) float64 {
+	if indexOnly {
+		return costIndexOnly
 	}
+	return costIndexRow
+}
 
-	return plan, nil
+// planJoins orders the joined tables and picks a join strategy for each step.
+// Inner joins are reordered greedily so the smallest intermediate results are
+// produced first; outer joins keep the order written in the query.
+func (p *Planner) planJoins(plan *ExecutionPlan, stmt *SelectStatement) {
+	tables := []string{stmt.Table}
+	names := []string{sourceName(stmt.Table, stmt.TableAlias)}
+	types := []JoinType{JoinTypeInner}
+	var conditions []Expression
+	for _, join := range stmt.Joins {
+		tables = append(tables, join.Table)
+		names = append(names, sourceName(join.Table, join.Alias))
+		types = append(types, join.Type)
+		conditions = append(conditions, join.Condition)
+	}
+
+	stats := make([]*TableStatistics, len(tables))
+	columns := make([][]string, len(tables))
+	for i, table := range tables {
+		stats[i] = loadTableStatistics(p.storage, table)
+		columns[i] = p.tableColumns(table)
+	}
+
+	order := make([]int, len(tables))
+	for i := range order {
+		order[i] = i
+	}
+	stepConditions := make([][]Expression, len(tables))
+	for i, condition := range conditions {
+		stepConditions[i+1] = []Expression{condition}
+	}
+
+	if reordered, reorderedConditions, ok := p.reorderJoins(tables, names, types, conditions, columns, stats); ok {
+		order = reordered
+		stepConditions = reorderedConditions
+	}
+
+	base := order[0]
+	plan.Type = PlanTypeTableScan
+	plan.Table = tables[base]
+	plan.TableName = names[base]
+	leftRows := stats[base].rowCount()
+	plan.ScanRows = leftRows
+	plan.ScanCost = leftRows * costRowScan
+	plan.EstimatedCost = plan.ScanCost
+	joined := map[int]bool{base: true}
+
+	for _, pos := range order[1:] {
+		step := &JoinPlan{
+			Table:      tables[pos],
+			Name:       names[pos],
+			Position:   pos,
+			Type:       types[pos],
+			Strategy:   JoinStrategyNestedLoop,
+			Conditions: stepConditions[pos],
+		}
+		rightRows := stats[pos].rowCount()
+		step.ScanRows = rightRows
+		selectivity := 1.0
+
+		for _, condition := range step.Conditions {
+			leftPos, leftColumn, rightColumn, ok := equiJoinColumns(condition, pos, joined, names, columns)
+			if !ok {
+				selectivity *= defaultRangeSelectivity
+				continue
+			}
+			selectivity *= joinSelectivity(stats[leftPos].column(leftColumn), stats[pos].column(rightColumn))
+			if step.Strategy != JoinStrategyHash && step.Type != JoinTypeRight {
+				step.Strategy = JoinStrategyHash
+				step.LeftTable = tables[leftPos]
+				step.LeftName = names[leftPos]
+				step.LeftPosition = leftPos
+				step.LeftColumn = leftColumn
+				step.RightColumn = rightColumn
+			}
+		}
+
+		step.EstimatedRows = leftRows * rightRows * selectivity
+		if step.Type == JoinTypeLeft && step.EstimatedRows < leftRows {
+			step.EstimatedRows = leftRows
+		}
+		if step.Type == JoinTypeRight && step.EstimatedRows < rightRows {
+			step.EstimatedRows = rightRows
+		}
+		if step.Strategy == JoinStrategyHash {
+			step.EstimatedCost = rightRows*(costRowScan+costHashBuild) + leftRows + step.EstimatedRows
+		} else {
+			step.EstimatedCost = rightRows*costRowScan + leftRows*rightRows
+		}
+
+		plan.Joins = append(plan.Joins, step)
+		plan.EstimatedCost += step.EstimatedCost
+		leftRows = step.EstimatedRows
+		joined[pos] = true
+	}
+
+	plan.EstimatedRows = leftRows * p.estimateSelectivity(stmt.Where, nil)
+	plan.EstimatedCost += sortCost(plan.EstimatedRows, stmt.OrderBy)
+}
+
+// reorderJoins greedily orders a chain of inner joins, starting from the
+// smallest table and repeatedly adding the table that yields the fewest rows.
+// It reports false when the joins cannot be reordered safely.
+func (p *Planner) reorderJoins(tables, names []string, types []JoinType, conditions []Expression, columns [][]string, stats []*TableStatistics) ([]int, [][]Expression, bool) {
+	seen := make(map[string]bool)
+	for i, table := range tables {
+		if seen[table] || types[i] != JoinTypeInner {
+			return nil, nil, false
+		}
+		seen[table] = true
+	}
+
+	condTables := make([]map[int]bool, len(conditions))
+	for i, condition := range conditions {
+		refs, ok := referencedTables(condition, names, columns)
+		if !ok {
+			return nil, nil, false
+		}
+		condTables[i] = refs
+	}
+
+	start := 0
+	for i := range tables {
+		if stats[i].rowCount() < stats[start].rowCount() {
+			start = i
+		}
+	}
+
+	order := []int{start}
+	joined := map[int]bool{start: true}
+	applied := make([]bool, len(conditions))
+	stepConditions := make([][]Expression, len(tables))
+	currentRows := stats[start].rowCount()
+
+	for len(order) < len(tables) {
+		best, bestRows, bestConnected := -1, 0.0, false
+		var bestConds []int
+		for t := range tables {
+			if joined[t] {
+				continue
+			}
+			var conds []int
+			selectivity := 1.0
+			for c, refs := range condTables {
+				if applied[c] || !refs[t] || !coveredBy(refs, joined, t) {
+					continue
+				}
+				conds = append(conds, c)
+				if leftPos, leftColumn, rightColumn, ok := equiJoinColumns(conditions[c], t, joined, names, columns); ok {
+					selectivity *= joinSelectivity(stats[leftPos].column(leftColumn), stats[t].column(rightColumn))
+				} else {
+					selectivity *= defaultRangeSelectivity
+				}
+			}
+			connected := len(conds) > 0
+			rows := currentRows * stats[t].rowCount() * selectivity
+			if best == -1 || (connected && !bestConnected) || (connected == bestConnected && rows < bestRows) {
+				best, bestRows, bestConnected, bestConds = t, rows, connected, conds
+			}
+		}
+
+		for _, c := range bestConds {
+			applied[c] = true
+			stepConditions[best] = append(stepConditions[best], conditions[c])
+		}
+		order = append(order, best)
+		joined[best] = true
+		currentRows = bestRows
+	}
+
+	// Conditions that reference only one table are checked at the last step
+	last := order[len(order)-1]
+	for c, done := range applied {
+		if !done {
+			stepConditions[last] = append(stepConditions[last], conditions[c])
+		}
+	}
+
+	return order, stepConditions, true
+}
+
+// referencedTables resolves the columns used by an expression to the tables
+// that own them. Resolution fails if a column is unknown or ambiguous.
+func referencedTables(expr Expression, names []string, columns [][]string) (map[int]bool, bool) {
+	refs := make(map[int]bool)
+	ok := true
+	walkIdentifiers(expr, func(name string) {
+		pos, _ := resolveColumn(name, names, columns)
+		if pos < 0 {
+			ok = false
+			return
+		}
+		refs[pos] = true
+	})
+	return refs, ok
+}
+
+// resolveColumn returns the position of the only table having the column,
+// or -1, and the column's name within its table. A column qualified by a
+// table's name or alias is looked for in that table alone.
+func resolveColumn(name string, names []string, columns [][]string) (int, string) {
+	qualifier, column := splitColumnName(name)
+	found := -1
+	for pos, cols := range columns {
+		if qualifier != "" && names[pos] != qualifier {
+			continue
+		}
+		for _, col := range cols {
+			if col == column {
+				if found >= 0 {
+					return -1, column
+				}
+				found = pos
+			}
+		}
+	}
+	return found, column
+}
+
+func walkIdentifiers(expr Expression, visit func(string)) {
+	switch e := expr.(type) {
+	case *Identifier:
+		visit(e.Value)
+	case *BinaryExpression:
+		walkIdentifiers(e.Left, visit)
+		walkIdentifiers(e.Right, visit)
+	case *FunctionCall:
+		for _, arg := range e.Args {
+			walkIdentifiers(arg, visit)
+		}
+	default:
+		for _, operand := range operands(expr) {
+			walkIdentifiers(operand, visit)
+		}
+	}
+}
+
+func coveredBy(refs map[int]bool, joined map[int]bool, next int) bool {
+	for pos := range refs {
+		if pos != next && !joined[pos] {
+			return false
+		}
+	}
+	return true
+}
+
+// equiJoinColumns matches "a = b" where one column belongs to the table being
+// joined and the other to a table joined earlier
+func equiJoinColumns(condition Expression, pos int, joined map[int]bool, names []string, columns [][]string) (int, string, string, bool) {
+	b, ok := condition.(*BinaryExpression)
+	if !ok || b.Operator != "=" {
+		return 0, "", "", false
+	}
+	left, okLeft := b.Left.(*Identifier)
+	right, okRight := b.Right.(*Identifier)
+	if !okLeft || !okRight {
+		return 0, "", "", false
+	}
+	leftPos, leftColumn := resolveColumn(left.Value, names, columns)
+	rightPos, rightColumn := resolveColumn(right.Value, names, columns)
+	if rightPos == pos && joined[leftPos] {
+		return leftPos, leftColumn, rightColumn, true
+	}
+	if leftPos == pos && joined[rightPos] {
+		return rightPos, rightColumn, leftColumn, true
+	}
+	return 0, "", "", false
+}
+
+func joinSelectivity(left, right *ColumnStatistics) float64 {
+	if left == nil || right == nil {
+		return defaultJoinSelectivity
+	}
+	distinct := left.DistinctCount
+	if right.DistinctCount > distinct {
+		distinct = right.DistinctCount
+	}
+	if distinct == 0 {
+		return 0
+	}
+	return 1 / float64(distinct)
+}
+
+// estimateSelectivity estimates the fraction of rows satisfying expr
+func (p *Planner) estimateSelectivity(expr Expression, stats *TableStatistics) float64 {
+	if expr == nil {
+		return 1
+	}
+	if _, isMatch, _ := parseMatch(expr); isMatch {
+		return defaultMatchSelectivity
+	}
+	switch e := expr.(type) {
+	case *UnaryExpression:
+		if e.Operator == "NOT" {
+			return clampSelectivity(1 - p.estimateSelectivity(e.Operand, stats))
+		}
+	case *IsNullExpression:
+		return p.nullSelectivity(e, stats)
+	case *InExpression:
+		return p.inSelectivity(e, stats)
+	case *BetweenExpression:
+		return p.betweenSelectivity(e, stats)
+	}
+	b, ok := expr.(*BinaryExpression)
+	if !ok {
+		return defaultRangeSelectivity
+	}
+
+	switch strings.ToUpper(b.Operator) {
+	case "AND":
+		return p.estimateSelectivity(b.Left, stats) * p.estimateSelectivity(b.Right, stats)
+	case "OR":
+		left := p.estimateSelectivity(b.Left, stats)
+		right := p.estimateSelectivity(b.Right, stats)
+		return left + right - left*right
+	}
+
+	if b.Operator == "=" || b.Operator == "!=" || b.Operator == "<>" {
+		column, value, ok := columnAndLiteral(b)
+		if !ok {
+			return defaultRangeSelectivity
+		}
+		colStats := stats.column(column)
+		eq := colStats.equalSelectivity(value)
+		if b.Operator == "=" {
+			return eq
+		}
+		return colStats.nonNullFraction() - eq
+	}
+
+	column, value, operator, ok := comparisonOperands(b)
+	if !ok {
+		return defaultRangeSelectivity
+	}
+	colStats := stats.column(column)
+	if colStats == nil {
+		return defaultRangeSelectivity
+	}
+	switch operator {
+	case "<":
+		return colStats.lessSelectivity(value)
+	case "<=":
+		return colStats.lessSelectivity(value) + colStats.equalSelectivity(value)
+	case ">":
+		return clampSelectivity(colStats.nonNullFraction() - colStats.lessSelectivity(value) - colStats.equalSelectivity(value))
+	default:
+		return clampSelectivity(colStats.nonNullFraction() - colStats.lessSelectivity(value))
+	}
+}
+
+// nullSelectivity estimates the fraction of rows where a column IS [NOT]
+// NULL
+func (p *Planner) nullSelectivity(e *IsNullExpression, stats *TableStatistics) float64 {
+	ident, ok := e.Expr.(*Identifier)
+	if !ok {
+		return defaultRangeSelectivity
+	}
+	fraction := defaultEqualSelectivity
+	if colStats := stats.column(ident.Value); colStats != nil {
+		fraction = colStats.NullFraction
+	}
+	if e.Not {
+		return 1 - fraction
+	}
+	return fraction
+}
+
+// inSelectivity estimates the fraction of rows where a column is [NOT] IN a
+// list of literals, as the sum of the list's equalities
+func (p *Planner) inSelectivity(e *InExpression, stats *TableStatistics) float64 {
+	ident, ok := e.Left.(*Identifier)
+	if !ok {
+		return defaultRangeSelectivity
+	}
+	colStats := stats.column(ident.Value)
+	selectivity := 0.0
+	for _, item := range e.List {
+		value, ok := literalValue(item)
+		if !ok {
+			return defaultRangeSelectivity
+		}
+		selectivity += colStats.equalSelectivity(value)
+	}
+	if e.Not {
+		return clampSelectivity(colStats.nonNullFraction() - selectivity)
+	}
+	return clampSelectivity(selectivity)
+}
+
+// betweenSelectivity estimates the fraction of rows where a column is [NOT]
+// BETWEEN two literals
+func (p *Planner) betweenSelectivity(e *BetweenExpression, stats *TableStatistics) float64 {
+	ident, okColumn := e.Expr.(*Identifier)
+	lower, okLower := literalValue(e.Lower)
+	upper, okUpper := literalValue(e.Upper)
+	if !okColumn || !okLower || !okUpper {
+		return defaultRangeSelectivity
+	}
+	colStats := stats.column(ident.Value)
+	selectivity := colStats.rangeSelectivity(lower, upper)
+	if e.Not {
+		return clampSelectivity(colStats.nonNullFraction() - selectivity)
+	}
+	return selectivity
+}
+
+// columnAndLiteral matches "column op literal" in either operand order
+func columnAndLiteral(b *BinaryExpression) (string, interface{}, bool) {
+	if ident, ok := b.Left.(*Identifier); ok {
+		if value, ok := literalValue(b.Right); ok {
+			return ident.Value, value, true
+		}
+	}
+	if ident, ok := b.Right.(*Identifier); ok {
+		if value, ok := literalValue(b.Left); ok {
+			return ident.Value, value, true
+		}
+	}
+	return "", nil, false
+}
+
+func clampSelectivity(s float64) float64 {
+	if s < 0 {
+		return 0
+	}
+	if s > 1 {
+		return 1
+	}
+	return s
+}
+
+func indexProbeCost(rows float64) float64 {
+	return math.Log2(rows+1) + 1
+}
+
+func sortCost(rows float64, orderBy []Expression) float64 {
+	if len(orderBy) == 0 || rows <= 1 {
+		return 0
+	}
+	return rows * math.Log2(rows) * costSortRow
+}
+
+// topNCost is the cost of keeping the first n of rows in a bounded heap
+func topNCost(rows float64, n int) float64 {
+	if rows <= 1 {
+		return 0
+	}
+	return rows * math.Log2(math.Min(float64(n), rows)+1) * costSortRow
+}
+
+// findIndex returns the name of an index on table.key that supports
+// equality lookups and can answer a query with the given WHERE clause, or ""
+// if none exists. The key is a column name or the text of an expression.
+func (p *Planner) findIndex(table, key string, where Expression) string {
+	return p.findIndexOfType(table, key, where, func(idx string, indexType storage.IndexType) bool {
+		return !rowKeyedIndex(indexType)
+	})
+}
+
+// findFullTextIndex returns the name of a full-text index on table.column
+// that can answer a query with the given WHERE clause, or "" if none exists
+func (p *Planner) findFullTextIndex(table, columnName string, where Expression) string {
+	return p.findIndexOfType(table, columnName, where, func(idx string, indexType storage.IndexType) bool {
+		return indexType == storage.IndexTypeFullText
+	})
+}
+
+// findVectorIndex returns the name of a vector index on table.column using
+// metric that can answer a query with the given WHERE clause, or "" if none
+// exists
+func (p *Planner) findVectorIndex(table, columnName string, metric storage.VectorMetric, where Expression) string {
+	indexManager := p.storage.GetIndexManager()
+	return p.findIndexOfType(table, columnName, where, func(idx string, indexType storage.IndexType) bool {
+		if indexType != storage.IndexTypeVector {
+			return false
+		}
+		info, err := indexManager.GetIndexInfo(idx)
+		return err == nil && info["metric"] == string(metric)
+	})
+}
+
+// findIndexOfType returns an index on table.key accepted by accept. Partial
+// indexes are only returned when the WHERE clause implies their predicate.
+func (p *Planner) findIndexOfType(table, key string, where Expression, accept func(idx string, indexType storage.IndexType) bool) string {
+	indexManager := p.storage.GetIndexManager()
+	indexes := indexManager.ListIndexes()
+	sort.Strings(indexes)
+	for _, idx := range indexes {
+		indexType, err := indexManager.GetIndexType(idx)
+		if err != nil || !accept(idx, indexType) {
+			continue
+		}
+
+		definition, err := loadIndexDefinition(p.storage, idx)
+		if err != nil {
+			if idx == fmt.Sprintf("%s_%s_idx", table, key) {
+				return idx
+			}
+			continue
+		}
+		if definition.Table == table && definition.key() == key && indexApplies(definition, where) {
+			return idx
+		}
+	}
+	return ""
+}
+
+// indexLookupValue converts a value compared with an indexed column to the
+// type the column's values are stored as, so that its index key matches
+// theirs. It reports false when an index lookup would not find the rows the
+// comparison holds for: a number compared with a text column matches every
+// text form of the number, such as '10.0' for 10, and values of unrelated
+// types do not compare at all. The missing bound of a range stays nil.
+func (p *Planner) indexLookupValue(table, key string, value interface{}) (interface{}, bool) {
+	if value == nil {
+		return nil, true
+	}
+	schema, err := loadTableSchema(p.storage, table)
+	if err != nil {
+		return value, true
+	}
+	// Expression keys are looked up by the value as it is
+	column := schema.column(key)
+	if column == nil {
+		return value, true
+	}
+	columnType, ok := columnValueType(column.Type)
+	if !ok {
+		return value, untypedLookupValue(value)
+	}
+
+	valueType, _ := storage.ValueTypeOf(value)
+	switch {
+	case valueType == columnType, isNumericType(valueType) && isNumericType(columnType):
+		return value, true
+	case valueType == storage.ValueText:
+		converted, err := convertText(value.(string), columnType)
+		return converted, err == nil
+	}
+	return nil, false
+}
+
+// untypedLookupValue reports whether a value compared with a column with no
+// declared type is found by looking up its own key. The column's values keep
+// the types they were written with, such as the integer 10 read back from
+// the text format, and a comparison converts text to the type of the other
+// side, so '10' equals the stored 10 but has another key. Only text that
+// reads as no other type is certain to match values of its own type alone.
+func untypedLookupValue(value interface{}) bool {
+	text, ok := value.(string)
+	if !ok {
+		return false
+	}
+	for _, valueType := range []storage.ValueType{storage.ValueInt, storage.ValueBool, storage.ValueTimestamp, storage.ValueVector} {
+		if _, err := convertText(text, valueType); err == nil {
+			return false
+		}
+	}
+	return true
 }
 
 func (p *Planner) PlanInsert(stmt *InsertStatement) (*ExecutionPlan, error) {
@@ -126,24 +1076,13 @@ func (p *Planner) PlanUpdate(stmt *UpdateStatement) (*ExecutionPlan, error) {
 	if stmt.Where != nil {
 		columnName, columnValue, canUseIndex := p.extractIndexableColumn(stmt.Where)
 		if canUseIndex && columnName != "" && columnValue != nil {
-			indexManager := p.storage.GetIndexManager()
-			allIndexes := indexManager.ListIndexes()
-			
-			for _, idx := range allIndexes {
-				indexMetadataKey := fmt.Sprintf("_index_metadata:%s", idx)
-				indexMetadata, err := p.storage.Get(indexMetadataKey)
-				if err == nil {
-					metadata := string(indexMetadata)
-					if strings.Contains(metadata, fmt.Sprintf("table:%s", stmt.Table)) && 
-					   strings.Contains(metadata, fmt.Sprintf("column:%s", columnName)) {
-						plan.Type = PlanTypeIndexScan
-						plan.IndexName = idx
-						plan.IndexColumn = columnName
-						plan.IndexValue = columnValue
-						plan.EstimatedCost = 100
-						break
-					}
-				}
+			idx := p.findIndex(stmt.Table, columnName, stmt.Where)
+			if value, ok := p.indexLookupValue(stmt.Table, columnName, columnValue); idx != "" && ok {
+				plan.Type = PlanTypeIndexScan
+				plan.IndexName = idx
+				plan.IndexColumn = columnName
+				plan.IndexValue = value
+				plan.EstimatedCost = 100
 			}
 		}
 	}
@@ -162,24 +1101,13 @@ func (p *Planner) PlanDelete(stmt *DeleteStatement) (*ExecutionPlan, error) {
 	if stmt.Where != nil {
 		columnName, columnValue, canUseIndex := p.extractIndexableColumn(stmt.Where)
 		if canUseIndex && columnName != "" && columnValue != nil {
-			indexManager := p.storage.GetIndexManager()
-			allIndexes := indexManager.ListIndexes()
-			
-			for _, idx := range allIndexes {
-				indexMetadataKey := fmt.Sprintf("_index_metadata:%s", idx)
-				indexMetadata, err := p.storage.Get(indexMetadataKey)
-				if err == nil {
-					metadata := string(indexMetadata)
-					if strings.Contains(metadata, fmt.Sprintf("table:%s", stmt.Table)) && 
-					   strings.Contains(metadata, fmt.Sprintf("column:%s", columnName)) {
-						plan.Type = PlanTypeIndexScan
-						plan.IndexName = idx
-						plan.IndexColumn = columnName
-						plan.IndexValue = columnValue
-						plan.EstimatedCost = 100
-						break
-					}
-				}
+			idx := p.findIndex(stmt.Table, columnName, stmt.Where)
+			if value, ok := p.indexLookupValue(stmt.Table, columnName, columnValue); idx != "" && ok {
+				plan.Type = PlanTypeIndexScan
+				plan.IndexName = idx
+				plan.IndexColumn = columnName
+				plan.IndexValue = value
+				plan.EstimatedCost = 100
 			}
 		}
 	}
@@ -188,43 +1116,177 @@ func (p *Planner) PlanDelete(stmt *DeleteStatement) (*ExecutionPlan, error) {
 }
 
 func (p *Planner) extractIndexableColumn(where Expression) (string, interface{}, bool) {
-	switch w := where.(type) {
-	case *BinaryExpression:
-		if w.Operator == "=" {
-			leftIdent, okLeft := w.Left.(*Identifier)
-			if okLeft {
-				rightVal := p.evaluateExpression(w.Right)
-				if rightVal != nil {
-					return leftIdent.Value, rightVal, true
-				}
+	equalities := p.extractEqualities(where)
+	if len(equalities) == 0 {
+		return "", nil, false
+	}
+	return equalities[0].column, equalities[0].value, true
+}
+
+// indexEquality is a "column = value" conjunct of a WHERE clause, where the
+// column may also be an indexable expression
+type indexEquality struct {
+	column string
+	value  interface{}
+}
+
+// extractEqualities collects the equality conjuncts comparing a column
+// against a non-NULL value
+func (p *Planner) extractEqualities(where Expression) []indexEquality {
+	var equalities []indexEquality
+	for _, conjunct := range splitConjuncts(where) {
+		w, ok := conjunct.(*BinaryExpression)
+		if !ok || w.Operator != "=" {
+			continue
+		}
+		leftIdent, okLeft := w.Left.(*Identifier)
+		if okLeft {
+			rightVal := p.evaluateExpression(w.Right)
+			if rightVal != nil {
+				equalities = append(equalities, indexEquality{leftIdent.Value, rightVal})
+				continue
 			}
-			rightIdent, okRight := w.Right.(*Identifier)
-			if okRight {
-				leftVal := p.evaluateExpression(w.Left)
-				if leftVal != nil {
-					return rightIdent.Value, leftVal, true
-				}
+		}
+		rightIdent, okRight := w.Right.(*Identifier)
+		if okRight {
+			leftVal := p.evaluateExpression(w.Left)
+			if leftVal != nil {
+				equalities = append(equalities, indexEquality{rightIdent.Value, leftVal})
+				continue
 			}
 		}
+		// An expression such as lower(email) can be looked up in an index
+		// on that expression
+		if key, value, operator, ok := predicateOperands(w); ok && operator == "=" {
+			equalities = append(equalities, indexEquality{key, value})
+		}
 	}
-	return "", nil, false
+	return equalities
 }
 
-func (p *Planner) evaluateExpression(expr Expression) interface{} {
+// indexRange is a pair of bounds on one column gathered from a WHERE clause.
+// A bound set by a strict comparison excludes its value.
+type indexRange struct {
+	column         string
+	lower          interface{}
+	upper          interface{}
+	lowerExclusive bool
+	upperExclusive bool
+}
+
+// extractIndexableRanges collects bounds from comparisons of a column against
+// a literal. When a column has several bounds on one side, the last is used:
+// the range only narrows the candidate rows, which are still filtered by the
+// WHERE clause.
+func (p *Planner) extractIndexableRanges(where Expression) []*indexRange {
+	var ranges []*indexRange
+	byColumn := make(map[string]*indexRange)
+
+	var conjuncts []Expression
+	for _, conjunct := range splitConjuncts(where) {
+		// BETWEEN bounds a range as the two comparisons it stands for do
+		if between, ok := conjunct.(*BetweenExpression); ok && !between.Not {
+			conjuncts = append(conjuncts,
+				&BinaryExpression{Left: between.Expr, Operator: ">=", Right: between.Lower},
+				&BinaryExpression{Left: between.Expr, Operator: "<=", Right: between.Upper})
+			continue
+		}
+		conjuncts = append(conjuncts, conjunct)
+	}
+
+	for _, conjunct := range conjuncts {
+		w, ok := conjunct.(*BinaryExpression)
+		if !ok {
+			continue
+		}
+		column, value, operator, ok := comparisonOperands(w)
+		if !ok {
+			continue
+		}
+		r, exists := byColumn[column]
+		if !exists {
+			r = &indexRange{column: column}
+			byColumn[column] = r
+			ranges = append(ranges, r)
+		}
+		switch operator {
+		case ">", ">=":
+			r.lower = value
+			r.lowerExclusive = operator == ">"
+		case "<", "<=":
+			r.upper = value
+			r.upperExclusive = operator == "<"
+		}
+	}
+
+	return ranges
+}
+
+// comparisonOperands matches "column op literal" or "literal op column" for a
+// range operator, flipping the operator so the column is always on the left.
+// The column may also be an indexable expression.
+func comparisonOperands(w *BinaryExpression) (string, interface{}, string, bool) {
+	flipped := map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<="}
+	if _, ok := flipped[w.Operator]; !ok {
+		return "", nil, "", false
+	}
+	if key, ok := indexableKey(w.Left); ok {
+		if value, ok := literalValue(w.Right); ok {
+			return key, value, w.Operator, true
+		}
+	}
+	if key, ok := indexableKey(w.Right); ok {
+		if value, ok := literalValue(w.Left); ok {
+			return key, value, flipped[w.Operator], true
+		}
+	}
+	return "", nil, "", false
+}
+
+// literalValue returns the value of a non-NULL literal expression
+func literalValue(expr Expression) (interface{}, bool) {
 	switch e := expr.(type) {
 	case *StringLiteral:
-		return e.Value
+		return e.Value, true
 	case *NumberLiteral:
-		return e.Value
+		return e.Value, true
 	case *BooleanLiteral:
-		return e.Value
-	case *NullLiteral:
-		return nil
-	case *Identifier:
-		return e.Value
+		return e.Value, true
 	default:
-		return fmt.Sprintf("%v", expr)
+		return nil, false
+	}
+}
+
+// splitConjuncts flattens a tree of AND expressions into its operands
+func splitConjuncts(expr Expression) []Expression {
+	if expr == nil {
+		return nil
+	}
+	if b, ok := expr.(*BinaryExpression); ok && strings.EqualFold(b.Operator, "AND") {
+		return append(splitConjuncts(b.Left), splitConjuncts(b.Right)...)
+	}
+	return []Expression{expr}
+}
+
+func (p *Planner) evaluateExpression(expr Expression) interface{} {
+	// Only a literal, or a parameter of the correlated subquery being
+	// planned, can be looked up in an index: a column or computed expression
+	// has no value before rows are read
+	if param, ok := expr.(*parameter); ok && param.index < len(p.params) {
+		return p.params[param.index]
 	}
+	value, _ := literalValue(expr)
+	return value
+}
+
+// tableColumns returns the columns of a stored or derived table, or nil if
+// there is no such table
+func (p *Planner) tableColumns(table string) []string {
+	if columns, ok := p.derived[table]; ok {
+		return columns
+	}
+	columns, _ := tableColumns(p.storage, table)
+	return columns
 }
 
 func (p *Planner) hasOrderBy(orderBy []Expression, columnName string) bool {
@@ -237,10 +1299,3 @@ func (p *Planner) hasOrderBy(orderBy []Expression, columnName string) bool {
 	}
 	return false
 }
-
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
-}
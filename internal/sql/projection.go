@@ -0,0 +1,388 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+// selectSource is a table a SELECT reads, with the name the query refers to
+// it by and its columns
+type selectSource struct {
+	table   string
+	name    string
+	columns []string
+}
+
+// sourceName returns the name a query refers to a table by: its alias if it
+// was given one, else its own name
+func sourceName(table, alias string) string {
+	if alias != "" {
+		return alias
+	}
+	return table
+}
+
+// splitColumnName splits a column reference written "table.column" into the
+// table and the column. The table is empty for an unqualified reference.
+func splitColumnName(name string) (string, string) {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// hasColumn reports whether the source's table has the column
+func (s *selectSource) hasColumn(column string) bool {
+	for _, name := range s.columns {
+		if name == column {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareSelect checks the tables and columns a SELECT refers to and returns
+// its tables in FROM clause order
+func (e *Executor) prepareSelect(stmt *SelectStatement) ([]selectSource, error) {
+	return e.prepareQuery(stmt, &queryScope{})
+}
+
+// prepareQuery checks the tables and columns of a SELECT, adding its tables
+// to scope, which links a subquery to the query enclosing it. DISTINCT ON,
+// GROUP BY and ORDER BY keys naming a field's alias, and no column, are
+// replaced by that field. The rows of a single-table query hold bare column
+// names, so its qualified references are reduced to them; a join's rows name
+// each column by its table, so they are kept. Columns of enclosing queries
+// are replaced by parameters of the subquery, and its own subqueries are
+// prepared.
+func (e *Executor) prepareQuery(stmt *SelectStatement, scope *queryScope) ([]selectSource, error) {
+	add := func(table, alias string, subquery *SelectStatement) error {
+		var columns []string
+		var err error
+		if subquery != nil {
+			columns, err = e.prepareDerivedTable(table, alias, subquery)
+		} else {
+			columns, err = tableColumns(e.storage, table)
+		}
+		if err != nil {
+			return err
+		}
+		name := sourceName(table, alias)
+		for _, source := range scope.sources {
+			if source.name == name {
+				return fmt.Errorf("table name '%s' is used more than once, give each an alias", name)
+			}
+		}
+		scope.sources = append(scope.sources, selectSource{table: table, name: name, columns: columns})
+		return nil
+	}
+	if err := add(stmt.Table, stmt.TableAlias, stmt.Subquery); err != nil {
+		return nil, err
+	}
+	for _, join := range stmt.Joins {
+		if err := add(join.Table, join.Alias, join.Subquery); err != nil {
+			return nil, err
+		}
+	}
+
+	substituteAliases := func(exprs []Expression) {
+		for i, expr := range exprs {
+			ident, ok := expr.(*Identifier)
+			if !ok || scope.resolve(&Identifier{Value: ident.Value}) == nil {
+				continue
+			}
+			for j, alias := range stmt.Aliases {
+				if alias != "" && alias == ident.Value {
+					exprs[i] = stmt.Fields[j]
+					break
+				}
+			}
+		}
+	}
+	substituteAliases(stmt.DistinctOn)
+	substituteAliases(stmt.GroupBy)
+	substituteAliases(stmt.OrderBy)
+
+	// DISTINCT ON keeps the first row of each key, so which row that is has
+	// to be decided by ORDER BY starting with the keys
+	for i, expr := range stmt.OrderBy[:min(len(stmt.OrderBy), len(stmt.DistinctOn))] {
+		matched := false
+		for _, key := range stmt.DistinctOn {
+			matched = matched || key.String() == expr.String()
+		}
+		if !matched {
+			return nil, fmt.Errorf("DISTINCT ON keys must match the first ORDER BY keys, but ORDER BY key %d is %s", i+1, expr.String())
+		}
+	}
+
+	expressions := []*Expression{&stmt.Where, &stmt.Having}
+	for _, exprs := range [][]Expression{stmt.Fields, stmt.DistinctOn, stmt.GroupBy, stmt.OrderBy} {
+		for i := range exprs {
+			expressions = append(expressions, &exprs[i])
+		}
+	}
+	for _, join := range stmt.Joins {
+		expressions = append(expressions, &join.Condition)
+	}
+	for _, expr := range expressions {
+		if err := e.prepareSubqueries(*expr, scope); err != nil {
+			return nil, err
+		}
+		*expr = scope.bindOuterColumns(*expr)
+	}
+	for _, expr := range expressions {
+		if err := visitIdentifiers(*expr, scope.resolve); err != nil {
+			return nil, err
+		}
+	}
+	return scope.sources, nil
+}
+
+// queryScope holds the tables of a query being prepared, which the columns
+// of the query and of its subqueries are looked up in
+type queryScope struct {
+	sources []selectSource
+	outer   *queryScope   // the scope of the enclosing query of a subquery
+	params  *[]Expression // the parameters of a subquery, gathered as its columns are looked up
+}
+
+// resolve checks that a column reference names a column of one of the
+// scope's tables, reducing a qualified reference to the bare column name in
+// a query of one table
+func (s *queryScope) resolve(ident *Identifier) error {
+	qualifier, column := splitColumnName(ident.Value)
+	if qualifier != "" {
+		var source *selectSource
+		for i := range s.sources {
+			if s.sources[i].name == qualifier {
+				source = &s.sources[i]
+			}
+		}
+		if source == nil {
+			return fmt.Errorf("table or alias '%s' is not in the FROM clause", qualifier)
+		}
+		if column != "*" && !source.hasColumn(column) {
+			return fmt.Errorf("column '%s' does not exist", ident.Value)
+		}
+		if len(s.sources) == 1 {
+			ident.Value = column
+		}
+		return nil
+	}
+
+	if column == "*" {
+		return nil
+	}
+	owners := 0
+	for i := range s.sources {
+		if s.sources[i].hasColumn(column) {
+			owners++
+		}
+	}
+	if owners == 0 {
+		return fmt.Errorf("column '%s' does not exist", column)
+	}
+	if owners > 1 {
+		return fmt.Errorf("column reference '%s' is ambiguous", column)
+	}
+	return nil
+}
+
+// owns reports whether a column reference is to the scope's own tables: it
+// is qualified by one of their names, or names a column one of them has.
+// Whether the column exists is left to resolve.
+func (s *queryScope) owns(ident *Identifier) bool {
+	qualifier, column := splitColumnName(ident.Value)
+	for i := range s.sources {
+		if (qualifier != "" && s.sources[i].name == qualifier) || (qualifier == "" && s.sources[i].hasColumn(column)) {
+			return true
+		}
+	}
+	return false
+}
+
+// visible reports whether a column reference is to the tables of the scope
+// or of a query enclosing it
+func (s *queryScope) visible(ident *Identifier) bool {
+	return s.owns(ident) || (s.outer != nil && s.outer.visible(ident))
+}
+
+// bindOuterColumns replaces the references in an expression to columns of
+// enclosing queries with parameters of the subquery
+func (s *queryScope) bindOuterColumns(expr Expression) Expression {
+	if s.outer == nil {
+		return expr
+	}
+	switch e := expr.(type) {
+	case *Identifier:
+		if _, column := splitColumnName(e.Value); column == "*" || s.owns(e) || !s.outer.visible(e) {
+			return e
+		}
+		return s.parameter(e)
+	case *FunctionCall:
+		call := &FunctionCall{Name: e.Name, Args: make([]Expression, len(e.Args)), Distinct: e.Distinct}
+		for i, arg := range e.Args {
+			call.Args[i] = s.bindOuterColumns(arg)
+		}
+		return call
+	}
+	ops := operands(expr)
+	if ops == nil {
+		return expr
+	}
+	for i, operand := range ops {
+		ops[i] = s.bindOuterColumns(operand)
+	}
+	return withOperands(expr, ops)
+}
+
+// parameter returns the parameter of the subquery standing in for a column
+// of an enclosing query, adding it if the subquery has none for the column.
+// A column of a query further out is passed in through a parameter of each
+// subquery in between.
+func (s *queryScope) parameter(ident *Identifier) *parameter {
+	var value Expression = &Identifier{Value: ident.Value}
+	if !s.outer.owns(ident) {
+		value = s.outer.parameter(ident)
+	}
+	for i, param := range *s.params {
+		if param.String() == value.String() {
+			return &parameter{index: i, name: ident.Value}
+		}
+	}
+	*s.params = append(*s.params, value)
+	return &parameter{index: len(*s.params) - 1, name: ident.Value}
+}
+
+// visitIdentifiers calls visit on every column reference in an expression,
+// stopping at the first error
+func visitIdentifiers(expr Expression, visit func(*Identifier) error) error {
+	switch e := expr.(type) {
+	case *Identifier:
+		return visit(e)
+	case *BinaryExpression:
+		if err := visitIdentifiers(e.Left, visit); err != nil {
+			return err
+		}
+		return visitIdentifiers(e.Right, visit)
+	case *FunctionCall:
+		for _, arg := range e.Args {
+			if err := visitIdentifiers(arg, visit); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, operand := range operands(expr) {
+			if err := visitIdentifiers(operand, visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// projectRows evaluates a SELECT's fields over its rows, returning the
+// result's column names and one value per column for each row
+func (e *Executor) projectRows(stmt *SelectStatement, sources []selectSource, rows [][]interface{}) ([]string, [][]interface{}, error) {
+	columns, fields := selectFields(stmt, sources)
+	projected := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		values := make([]interface{}, len(fields))
+		for j, field := range fields {
+			value, err := e.evaluate(row, field)
+			if err != nil {
+				return nil, nil, err
+			}
+			values[j] = value
+		}
+		projected[i] = values
+	}
+	return columns, projected, nil
+}
+
+// distinctRows keeps the first of the rows that have the same values for
+// the DISTINCT ON keys, or for every field of a SELECT DISTINCT. Values are
+// compared under the collations of their columns, and NULLs are equal.
+func (e *Executor) distinctRows(stmt *SelectStatement, sources []selectSource, rows [][]interface{}) ([][]interface{}, error) {
+	start := time.Now()
+	keys := stmt.DistinctOn
+	if len(keys) == 0 {
+		_, keys = selectFields(stmt, sources)
+	}
+	collations := make([]storage.Collation, len(keys))
+	for i, expr := range keys {
+		collations[i] = e.expressionCollation(expr)
+	}
+
+	seen := make(map[string]bool)
+	var distinct [][]interface{}
+	var key strings.Builder
+	for _, row := range rows {
+		key.Reset()
+		for i, expr := range keys {
+			value, err := e.evaluate(row, expr)
+			if err != nil {
+				return nil, err
+			}
+			key.WriteString(groupKey(value, collations[i]))
+		}
+		if !seen[key.String()] {
+			seen[key.String()] = true
+			distinct = append(distinct, row)
+		}
+	}
+	e.profile.record(opDistinct, len(distinct), 0, time.Since(start))
+	return distinct, nil
+}
+
+// selectFields returns the column names and expressions of a SELECT's
+// result. A field of * or table.* expands to the columns of every table or
+// of the one table. Fields are named by their alias, else by their column,
+// else by their text.
+func selectFields(stmt *SelectStatement, sources []selectSource) ([]string, []Expression) {
+	var columns []string
+	var fields []Expression
+	for i, field := range stmt.Fields {
+		if ident, ok := field.(*Identifier); ok {
+			qualifier, column := splitColumnName(ident.Value)
+			if column == "*" {
+				for _, source := range sources {
+					if qualifier != "" && source.name != qualifier {
+						continue
+					}
+					for _, name := range source.columns {
+						reference := name
+						if len(sources) > 1 {
+							reference = source.name + "." + name
+						}
+						columns = append(columns, name)
+						fields = append(fields, &Identifier{Value: reference})
+					}
+				}
+				continue
+			}
+		}
+
+		name := ""
+		if i < len(stmt.Aliases) {
+			name = stmt.Aliases[i]
+		}
+		if name == "" {
+			named := field
+			if grouped, ok := field.(*groupedValue); ok {
+				named = grouped.Expression
+			}
+			if ident, ok := named.(*Identifier); ok {
+				_, name = splitColumnName(ident.Value)
+			} else {
+				name = named.String()
+			}
+		}
+		columns = append(columns, name)
+		fields = append(fields, field)
+	}
+	return columns, fields
+}
@@ -0,0 +1,270 @@
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"startdb/internal/storage"
+)
+
+// Kinds of inconsistency reported by CheckIndexes
+const (
+	IndexProblemMissing  = "missing"  // a row has no entry in the index
+	IndexProblemDangling = "dangling" // an entry points at a row that does not exist
+	IndexProblemStale    = "stale"    // an entry holds a value the row no longer has
+)
+
+// IndexProblem is one inconsistency between an index and its table
+type IndexProblem struct {
+	Kind   string
+	RowKey string
+	Detail string
+}
+
+// IndexReport is the result of checking one index against its table
+type IndexReport struct {
+	Index    string
+	Table    string
+	Column   string
+	Type     storage.IndexType
+	Rows     int // rows with a value in the indexed column
+	Entries  int
+	Problems []IndexProblem
+}
+
+// indexNames returns the names of every index recorded in the metadata
+func indexNames(store *storage.Storage) ([]string, error) {
+	keys, err := store.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	var names []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, "_index_metadata:") {
+			names = append(names, strings.TrimPrefix(key, "_index_metadata:"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CheckIndexes compares every index with the rows of its table and reports
+// rows the index is missing, entries pointing at rows that no longer exist
+// and entries holding outdated values
+func CheckIndexes(store *storage.Storage) ([]*IndexReport, error) {
+	names, err := indexNames(store)
+	if err != nil {
+		return nil, err
+	}
+
+	executor := NewExecutor(store)
+	var reports []*IndexReport
+	for _, name := range names {
+		report, err := executor.checkIndex(name)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// checkIndex compares one index with the rows of its table
+func (e *Executor) checkIndex(indexName string) (*IndexReport, error) {
+	definition, err := loadIndexDefinition(e.storage, indexName)
+	if err != nil {
+		return nil, err
+	}
+	report := &IndexReport{
+		Index:  indexName,
+		Table:  definition.Table,
+		Column: definition.key(),
+		Type:   definition.Type,
+	}
+
+	// Each row's expected entry: the row's text for row-keyed indexes and
+	// the encoded column value otherwise
+	expected := make(map[string]string)
+	rowsByKey := make(map[string][]interface{})
+	e.useCollations(definition.Table)
+	collation := e.definitionCollation(definition)
+	rows, err := e.loadTableRows(definition.Table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rows from table '%s': %w", definition.Table, err)
+	}
+	for _, row := range rows {
+		value := e.indexedValue(definition, row)
+		if value == nil {
+			continue
+		}
+		key := rowKey(definition.Table, row)
+		rowsByKey[key] = row
+		if rowKeyedIndex(definition.Type) {
+			expected[key] = columnText(value)
+		} else {
+			expected[key] = e.indexEntryKey(value, collation, key)
+		}
+	}
+	report.Rows = len(expected)
+
+	var entries []storage.KeyValue
+	if e.storage.GetIndexManager().Exists(indexName) {
+		entries, err = e.storage.GetIndexManager().GetAll(indexName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	report.Entries = len(entries)
+
+	// A row whose entry is stale is reported once, not also as missing
+	found := make(map[string]bool)
+	for _, entry := range entries {
+		key, indexed := entry.Key, string(entry.Value)
+		if !rowKeyedIndex(definition.Type) {
+			key, _ = decodeIndexEntry(entry.Value)
+			indexed = entry.Key
+		}
+
+		want, exists := expected[key]
+		switch {
+		case !exists && strings.HasPrefix(key, definition.Table+":") && e.rowExists(key):
+			report.Problems = append(report.Problems, IndexProblem{
+				Kind:   IndexProblemStale,
+				RowKey: key,
+				Detail: fmt.Sprintf("indexed as %s but the row has no value", describeIndexed(definition.Type, indexed)),
+			})
+		case !exists:
+			report.Problems = append(report.Problems, IndexProblem{
+				Kind:   IndexProblemDangling,
+				RowKey: key,
+				Detail: fmt.Sprintf("indexed as %s but the row does not exist", describeIndexed(definition.Type, indexed)),
+			})
+		case indexed != want:
+			found[key] = true
+			report.Problems = append(report.Problems, IndexProblem{
+				Kind:   IndexProblemStale,
+				RowKey: key,
+				Detail: fmt.Sprintf("indexed as %s but the row has %s", describeIndexed(definition.Type, indexed), describeIndexed(definition.Type, want)),
+			})
+		case !rowKeyedIndex(definition.Type) && string(entry.Value) != string(e.indexEntry(definition, key, rowsByKey[key])):
+			found[key] = true
+			report.Problems = append(report.Problems, IndexProblem{
+				Kind:   IndexProblemStale,
+				RowKey: key,
+				Detail: "included column values differ from the row",
+			})
+		default:
+			found[key] = true
+		}
+	}
+
+	var missing []string
+	for key := range expected {
+		if !found[key] {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	for _, key := range missing {
+		report.Problems = append(report.Problems, IndexProblem{
+			Kind:   IndexProblemMissing,
+			RowKey: key,
+			Detail: fmt.Sprintf("row value %s is not indexed", describeIndexed(definition.Type, expected[key])),
+		})
+	}
+
+	return report, nil
+}
+
+func (e *Executor) rowExists(key string) bool {
+	exists, err := e.storage.Exists(key)
+	return err == nil && exists
+}
+
+// describeIndexed renders an indexed value for a report, decoding the typed
+// keys of value-keyed indexes
+func describeIndexed(indexType storage.IndexType, indexed string) string {
+	if !rowKeyedIndex(indexType) {
+		if values, err := storage.DecodeKey(storage.IndexEntryValue(indexed)); err == nil && len(values) > 0 {
+			literals := make([]string, len(values))
+			for i, value := range values {
+				literals[i] = formatLiteral(value)
+			}
+			if len(literals) == 1 {
+				return literals[0]
+			}
+			return "(" + strings.Join(literals, ", ") + ")"
+		}
+	}
+	return formatLiteral(indexed)
+}
+
+// executeReindex rebuilds an index, every index of a table, or every index
+// when no name is given
+func (e *Executor) executeReindex(stmt *ReindexStatement) (*QueryResult, error) {
+	names, err := indexNames(e.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if stmt.Name != "" {
+		if _, err := e.storage.Get(fmt.Sprintf("_index_metadata:%s", stmt.Name)); err == nil {
+			names = []string{stmt.Name}
+		} else if _, err := e.storage.Get(fmt.Sprintf("_table_metadata:%s", stmt.Name)); err == nil {
+			var tableIndexes []string
+			for _, name := range names {
+				definition, err := loadIndexDefinition(e.storage, name)
+				if err == nil && definition.Table == stmt.Name {
+					tableIndexes = append(tableIndexes, name)
+				}
+			}
+			names = tableIndexes
+		} else {
+			return nil, fmt.Errorf("index or table '%s' does not exist", stmt.Name)
+		}
+	}
+
+	result := &QueryResult{
+		Columns: []string{"index", "table", "rows"},
+	}
+	for _, name := range names {
+		definition, err := loadIndexDefinition(e.storage, name)
+		if err != nil {
+			return nil, err
+		}
+		indexed, err := e.rebuildIndex(name, definition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rebuild index '%s': %w", name, err)
+		}
+		result.Rows = append(result.Rows, []interface{}{name, definition.Table, indexed})
+	}
+
+	result.Count = len(result.Rows)
+	return result, nil
+}
+
+// rebuildIndex rebuilds an index from its table's rows. The new index is
+// built online beside the old one, which keeps serving reads and taking
+// writes until the new one replaces it.
+func (e *Executor) rebuildIndex(indexName string, definition *indexDefinition) (int, error) {
+	index, err := e.newIndex(definition)
+	if err != nil {
+		return 0, err
+	}
+	indexed, err := e.populateIndex(indexName, definition, index)
+	if err != nil {
+		return 0, err
+	}
+	if err := e.applyIndexBloomFilter(indexName, definition); err != nil {
+		return indexed, fmt.Errorf("failed to create Bloom filter: %w", err)
+	}
+
+	if definition.Type == storage.IndexTypeVector {
+		if err := e.saveIndexSnapshot(indexName); err != nil {
+			return indexed, fmt.Errorf("failed to save index: %w", err)
+		}
+	}
+	return indexed, nil
+}
@@ -0,0 +1,103 @@
+package sql
+
+import (
+	"fmt"
+
+	"startdb/internal/storage"
+)
+
+// RestoreIndexes recreates the indexes recorded in the database's metadata,
+// which are only held in memory while it is open. Vector indexes are loaded
+// from their saved graph and brought up to date with the table. Other
+// indexes are loaded from the entries SaveIndexes stored when the database
+// was last closed, or rebuilt from the table's rows if it changed since.
+// Saved usage statistics are reloaded. An index that cannot be restored is
+// left out, so the database still opens; the reason for each is returned
+// in skipped. It returns the number of indexes restored.
+func RestoreIndexes(store *storage.Storage) (restored int, skipped []error, err error) {
+	names, err := indexNames(store)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	executor := NewExecutor(store)
+	indexManager := store.GetIndexManager()
+
+	for _, indexName := range names {
+		if indexManager.Exists(indexName) {
+			continue
+		}
+
+		definition, err := loadIndexDefinition(store, indexName)
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		if _, err := store.Get(fmt.Sprintf("_table_metadata:%s", definition.Table)); err != nil {
+			continue
+		}
+
+		if err := executor.restoreIndex(indexName, definition); err != nil {
+			skipped = append(skipped, fmt.Errorf("index '%s' was not restored: %w", indexName, err))
+			continue
+		}
+		restoreIndexUsage(store, indexName)
+		restored++
+	}
+
+	return restored, skipped, nil
+}
+
+// restoreIndex loads an index from its snapshot, falling back to building
+// it from the table's rows
+func (e *Executor) restoreIndex(indexName string, definition *indexDefinition) error {
+	indexManager := e.storage.GetIndexManager()
+	if snapshot, err := e.storage.Get(indexSnapshotKey(indexName)); err == nil {
+		if definition.Type == storage.IndexTypeVector {
+			if err := indexManager.RestoreVectorIndex(indexName, snapshot); err == nil {
+				return e.syncVectorIndex(indexName, definition)
+			}
+		} else if index, err := e.newIndex(definition); err == nil {
+			if err := indexManager.RestoreIndex(indexName, definition.Type, index, snapshot); err == nil {
+				indexManager.MarkSaved(indexName)
+				return e.applyIndexBloomFilter(indexName, definition)
+			}
+		}
+	}
+
+	if _, err := e.buildIndex(indexName, definition); err != nil {
+		indexManager.DropIndex(indexName)
+		return err
+	}
+	return nil
+}
+
+// SaveIndexes stores the entries of every index that has changed since it
+// was last saved, so reopening the database loads them rather than
+// rebuilding the index. Vector indexes save their own snapshots.
+func SaveIndexes(store *storage.Storage) error {
+	indexManager := store.GetIndexManager()
+	for _, indexName := range indexManager.ListIndexes() {
+		indexType, err := indexManager.GetIndexType(indexName)
+		if err != nil || indexType == storage.IndexTypeVector || indexManager.Saved(indexName) {
+			continue
+		}
+		snapshot, err := indexManager.SnapshotIndex(indexName)
+		if err != nil {
+			return err
+		}
+		if err := store.Put(indexSnapshotKey(indexName), snapshot); err != nil {
+			return fmt.Errorf("failed to save index '%s': %w", indexName, err)
+		}
+		indexManager.MarkSaved(indexName)
+	}
+	return nil
+}
+
+// indexChanged removes the stored snapshot of an index the first time the
+// index changes after it was saved or restored
+func (e *Executor) indexChanged(indexName string) {
+	if e.storage.GetIndexManager().ClearSaved(indexName) {
+		e.storage.Delete(indexSnapshotKey(indexName))
+	}
+}
@@ -0,0 +1,84 @@
+package sql
+
+import (
+	"strings"
+	"testing"
+
+	"startdb/internal/storage"
+)
+
+// reopen returns an executor over a new Storage on the same engine, as when
+// the database is closed and opened again, with its indexes restored
+func reopen(t *testing.T, engine storage.Engine) (*Executor, []error) {
+	t.Helper()
+	store := storage.New(engine)
+	_, skipped, err := RestoreIndexes(store)
+	if err != nil {
+		t.Fatalf("RestoreIndexes failed: %v", err)
+	}
+	return NewExecutor(store), skipped
+}
+
+func TestRestoreIndexesFromSnapshot(t *testing.T) {
+	engine := storage.NewMemoryEngine()
+	e := NewExecutor(storage.New(engine))
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT, name TEXT)",
+		"INSERT INTO t VALUES (1, 5, 'a'), (2, 5, 'b'), (3, 7, 'c')",
+		"CREATE INDEX t_v ON t (v)",
+		"CREATE INDEX t_name ON t (name) USING HASH",
+	)
+	if err := SaveIndexes(e.storage); err != nil {
+		t.Fatalf("SaveIndexes failed: %v", err)
+	}
+
+	e, skipped := reopen(t, engine)
+	if len(skipped) != 0 {
+		t.Fatalf("Expected every index to be restored, got %v", skipped)
+	}
+	indexManager := e.storage.GetIndexManager()
+	if !indexManager.Saved("t_v") || !indexManager.Saved("t_name") {
+		t.Fatal("Expected the indexes to be loaded from their snapshots")
+	}
+	expectRows(t, e, "SELECT id FROM t WHERE v = 5 ORDER BY id", "1", "2")
+	expectRows(t, e, "SELECT id FROM t WHERE name = 'c'", "3")
+
+	// A write removes the snapshot, so a database not closed cleanly
+	// rebuilds the index rather than loading stale entries
+	mustExec(t, e, "INSERT INTO t VALUES (4, 5, 'd')")
+	if indexManager.Saved("t_v") {
+		t.Fatal("Expected the write to mark the index as changed")
+	}
+	if _, err := e.storage.Get(indexSnapshotKey("t_v")); err == nil {
+		t.Fatal("Expected the write to remove the stored snapshot")
+	}
+
+	e, _ = reopen(t, engine)
+	if e.storage.GetIndexManager().Saved("t_v") {
+		t.Fatal("Expected the index to be rebuilt")
+	}
+	expectRows(t, e, "SELECT id FROM t WHERE v = 5 ORDER BY id", "1", "2", "4")
+	expectConsistentIndexes(t, e)
+}
+
+func TestRestoreIndexesSkipsFailures(t *testing.T) {
+	engine := storage.NewMemoryEngine()
+	e := NewExecutor(storage.New(engine))
+	mustExec(t, e,
+		"CREATE TABLE t (id INT, v INT)",
+		"INSERT INTO t VALUES (1, 5)",
+		"CREATE INDEX t_v ON t (v)",
+	)
+	if err := e.storage.Put("_index_metadata:t_bad", []byte("{")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	e, skipped := reopen(t, engine)
+	if len(skipped) != 1 || !strings.Contains(skipped[0].Error(), "t_bad") {
+		t.Fatalf("Expected t_bad to be skipped, got %v", skipped)
+	}
+	if !e.storage.GetIndexManager().Exists("t_v") {
+		t.Fatal("Expected the other index to be restored")
+	}
+	expectRows(t, e, "SELECT id FROM t WHERE v = 5", "1")
+}
@@ -0,0 +1,480 @@
+package sql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+// tableSchema gives each column of a table the ID its values are stored
+// under in encoded rows, and the declared type values are converted to. IDs
+// are never reused, so rows written under an older schema still decode.
+type tableSchema struct {
+	Columns []schemaColumn `json:"columns"`
+	NextID  uint32         `json:"next_id"`
+}
+
+// schemaColumn is a column of a table schema. Type is empty for a column
+// with no declared type, whose values keep the type they were written with.
+type schemaColumn struct {
+	ID   uint32 `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+}
+
+func tableSchemaKey(table string) string {
+	return fmt.Sprintf("_table_schema:%s", table)
+}
+
+// newTableSchema returns the schema of a new table with the given column
+// definitions, numbering the columns from 1
+func newTableSchema(columns []ColumnDefinition) *tableSchema {
+	schema := &tableSchema{NextID: 1}
+	for _, column := range columns {
+		schema.addColumn(column.Name, column.Type)
+	}
+	return schema
+}
+
+// loadTableSchema reads a table's schema. Tables created before rows were
+// encoded by column ID have none stored; their schema is derived from the
+// column names in the table metadata, with the declared types that were
+// recorded for VECTOR columns.
+func loadTableSchema(store *storage.Storage, table string) (*tableSchema, error) {
+	data, err := store.Get(tableSchemaKey(table))
+	if err == nil {
+		var schema tableSchema
+		if err := json.Unmarshal(data, &schema); err != nil {
+			return nil, fmt.Errorf("table '%s' has a malformed schema: %w", table, err)
+		}
+		return &schema, nil
+	}
+
+	metadata, err := loadTableMetadata(store, table)
+	if err != nil {
+		return nil, err
+	}
+	schema := &tableSchema{NextID: 1}
+	for _, column := range metadata.Columns {
+		schema.addColumn(column.Name, column.Type)
+	}
+	return schema, nil
+}
+
+func saveTableSchema(store *storage.Storage, table string, schema *tableSchema) error {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	return store.Put(tableSchemaKey(table), data)
+}
+
+// addColumn adds a column to the schema under the next free ID
+func (s *tableSchema) addColumn(name, columnType string) *schemaColumn {
+	if s.NextID == 0 {
+		s.NextID = 1
+	}
+	s.Columns = append(s.Columns, schemaColumn{ID: s.NextID, Name: name, Type: columnType})
+	s.NextID++
+	return &s.Columns[len(s.Columns)-1]
+}
+
+// column returns the column with the given name, or nil
+func (s *tableSchema) column(name string) *schemaColumn {
+	for i := range s.Columns {
+		if s.Columns[i].Name == name {
+			return &s.Columns[i]
+		}
+	}
+	return nil
+}
+
+// columnByID returns the column with the given ID, or nil
+func (s *tableSchema) columnByID(id uint32) *schemaColumn {
+	for i := range s.Columns {
+		if s.Columns[i].ID == id {
+			return &s.Columns[i]
+		}
+	}
+	return nil
+}
+
+// textValue converts the text form of a column's value, as stored in
+// covering index entries, back to a value of the column's type
+func (s *tableSchema) textValue(name, text string) interface{} {
+	if column := s.column(name); column != nil {
+		if _, ok := columnValueType(column.Type); ok {
+			return coerceValue(text, column.Type)
+		}
+	}
+	return legacyValue(text)
+}
+
+// tableSchema returns a table's schema, cached for the life of the executor
+func (e *Executor) tableSchema(table string) (*tableSchema, error) {
+	if schema, exists := e.schemas[table]; exists {
+		return schema, nil
+	}
+	schema, err := loadTableSchema(e.storage, table)
+	if err != nil {
+		return nil, err
+	}
+	if e.schemas == nil {
+		e.schemas = make(map[string]*tableSchema)
+	}
+	e.schemas[table] = schema
+	return schema, nil
+}
+
+// encodeRow encodes a row of a table in the binary row format. Values are
+// first converted, in place, to their columns' declared types where that
+// loses nothing, so the caller goes on to index the values that were
+// stored. Columns missing from the schema are added to it.
+func (e *Executor) encodeRow(table string, rowData []interface{}) ([]byte, error) {
+	schema, err := e.tableSchema(table)
+	if err != nil {
+		return nil, err
+	}
+
+	added := false
+	fields := make([]storage.RowField, 0, len(rowData)/2)
+	for i := 1; i+1 < len(rowData); i += 2 {
+		name, _ := rowData[i].(string)
+		column := schema.column(name)
+		if column == nil {
+			column = schema.addColumn(name, "")
+			added = true
+		}
+		value := coerceValue(rowData[i+1], column.Type)
+		if _, ok := storage.ValueTypeOf(value); !ok {
+			value = columnText(value)
+		}
+		rowData[i+1] = value
+		fields = append(fields, storage.RowField{Column: column.ID, Value: value})
+	}
+	if added {
+		if err := saveTableSchema(e.storage, table, schema); err != nil {
+			return nil, fmt.Errorf("failed to store table schema: %w", err)
+		}
+	}
+
+	// Fields are stored in column order, so rows read back list their
+	// columns in the order the table declares them
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].Column < fields[j].Column
+	})
+
+	var id string
+	if len(rowData) > 0 {
+		id = columnText(rowData[0])
+	}
+	return storage.EncodeRow(id, fields)
+}
+
+// decodeRow decodes a stored row of a table into its ID followed by
+// alternating column names and values. Rows still in the pipe-delimited
+// text format are parsed as such.
+func (e *Executor) decodeRow(table string, data []byte) ([]interface{}, error) {
+	if !storage.IsEncodedRow(data) {
+		return parseLegacyRow(string(data)), nil
+	}
+
+	id, fields, err := storage.DecodeRow(data)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := e.tableSchema(table)
+	if err != nil {
+		return nil, err
+	}
+
+	rowData := make([]interface{}, 0, 1+2*len(fields))
+	rowData = append(rowData, id)
+	for _, field := range fields {
+		column := schema.columnByID(field.Column)
+		if column == nil {
+			// Another executor may have added the column since the
+			// schema was cached
+			delete(e.schemas, table)
+			if schema, err = e.tableSchema(table); err != nil {
+				return nil, err
+			}
+			if column = schema.columnByID(field.Column); column == nil {
+				return nil, fmt.Errorf("%w: table '%s' has no column with ID %d", storage.ErrCorruptRow, table, field.Column)
+			}
+		}
+		rowData = append(rowData, column.Name, field.Value)
+	}
+	return rowData, nil
+}
+
+// readRow reads and decodes the row of a table stored under key. It reports
+// false when no row is stored there, as when an index entry outlives the
+// row it pointed to; a row that cannot be decoded is an error.
+func (e *Executor) readRow(table, key string) ([]interface{}, bool, error) {
+	value, err := e.storage.Get(key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read row '%s': %w", key, err)
+	}
+	rowData, err := e.decodeRow(table, value)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read row '%s': %w", key, err)
+	}
+	return rowData, true, nil
+}
+
+// readRows reads the rows of a table stored under keys, in order. Keys of
+// other tables and keys with no row stored are skipped.
+func (e *Executor) readRows(table string, keys []string) ([][]interface{}, error) {
+	var rows [][]interface{}
+	tablePrefix := table + ":"
+	for _, key := range keys {
+		if !strings.HasPrefix(key, tablePrefix) {
+			continue
+		}
+		rowData, ok, err := e.readRow(table, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rows = append(rows, rowData)
+		}
+	}
+	return rows, nil
+}
+
+// parseLegacyRow parses a row in the pipe-delimited text format, which held
+// the row ID followed by alternating column names and values. The format
+// kept no types, so each value is read back as the type its text was
+// written from: integers, floats and booleans that format back to the same
+// text, "<nil>" for NULL, and strings otherwise.
+func parseLegacyRow(data string) []interface{} {
+	var rowData []interface{}
+	for _, part := range strings.Split(data, "|") {
+		if part == "" {
+			continue
+		}
+		if len(rowData) > 0 && len(rowData)%2 == 0 {
+			rowData = append(rowData, legacyValue(part))
+		} else {
+			rowData = append(rowData, part)
+		}
+	}
+	return rowData
+}
+
+func legacyValue(text string) interface{} {
+	switch text {
+	case "<nil>":
+		return nil
+	case "true", "false":
+		return text == "true"
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil && strconv.FormatInt(i, 10) == text {
+		return i
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil && fmt.Sprintf("%v", f) == text {
+		return f
+	}
+	return text
+}
+
+// Families of declared column types, by the value type their values are
+// stored as
+var columnTypeFamilies = map[string]storage.ValueType{
+	"INT": storage.ValueInt, "INTEGER": storage.ValueInt, "BIGINT": storage.ValueInt,
+	"SMALLINT": storage.ValueInt, "TINYINT": storage.ValueInt,
+	"FLOAT": storage.ValueFloat, "REAL": storage.ValueFloat, "DOUBLE": storage.ValueFloat,
+	"NUMERIC": storage.ValueFloat, "DECIMAL": storage.ValueFloat,
+	"TEXT": storage.ValueText, "VARCHAR": storage.ValueText, "CHAR": storage.ValueText, "STRING": storage.ValueText,
+	"BOOL": storage.ValueBool, "BOOLEAN": storage.ValueBool,
+	"BLOB": storage.ValueBytes, "BYTES": storage.ValueBytes, "BYTEA": storage.ValueBytes,
+	"TIMESTAMP": storage.ValueTimestamp, "DATETIME": storage.ValueTimestamp, "DATE": storage.ValueTimestamp,
+	"VECTOR": storage.ValueVector,
+}
+
+// columnValueType returns the value type of a declared column type, ignoring
+// any parameters such as VARCHAR(20)'s length. It reports false for an
+// empty or unknown type.
+func columnValueType(columnType string) (storage.ValueType, bool) {
+	name := strings.ToUpper(strings.TrimSpace(columnType))
+	if i := strings.Index(name, "("); i >= 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	valueType, ok := columnTypeFamilies[name]
+	return valueType, ok
+}
+
+// timestampLayouts are the text forms accepted for timestamp values
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// coerceValue converts a value to the type of a declared column type when
+// the conversion loses nothing, such as the float 3 to the integer 3 or the
+// text '2024-01-31' to a timestamp. Other values, NULL and values of columns
+// with no known type are returned unchanged.
+func coerceValue(value interface{}, columnType string) interface{} {
+	valueType, ok := columnValueType(columnType)
+	if !ok || value == nil {
+		return value
+	}
+
+	switch valueType {
+	case storage.ValueInt:
+		switch v := value.(type) {
+		case float64:
+			if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+				return int64(v)
+			}
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return i
+			}
+		case bool:
+			if v {
+				return int64(1)
+			}
+			return int64(0)
+		}
+	case storage.ValueFloat:
+		switch v := value.(type) {
+		case int64:
+			return float64(v)
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f
+			}
+		}
+	case storage.ValueText:
+		switch v := value.(type) {
+		case int64, float64, bool, time.Time:
+			return columnText(v)
+		case []byte:
+			return string(v)
+		}
+	case storage.ValueBool:
+		switch v := value.(type) {
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b
+			}
+		case int64:
+			if v == 0 || v == 1 {
+				return v == 1
+			}
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1
+			}
+		}
+	case storage.ValueBytes:
+		if s, ok := value.(string); ok {
+			return []byte(s)
+		}
+	case storage.ValueTimestamp:
+		switch v := value.(type) {
+		case string:
+			for _, layout := range timestampLayouts {
+				if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+					return t.UTC()
+				}
+			}
+		case time.Time:
+			return v.UTC()
+		}
+	case storage.ValueVector:
+		if vector, err := toVector(value); err == nil {
+			return vector
+		}
+	}
+	return value
+}
+
+// RowMigrationReport summarises a run of MigrateRows
+type RowMigrationReport struct {
+	Tables   int
+	Rows     int // rows examined
+	Migrated int // rows rewritten from the text format
+}
+
+// MigrateRows rewrites every row still stored in the pipe-delimited text
+// format in the binary row format, and stores the catalog record and schema
+// of tables created before those were kept. Rows are read in either format,
+// so migrating is not required, but until it is done old rows keep the types
+// guessed from their text and any corruption the text format caused.
+func MigrateRows(store *storage.Storage) (*RowMigrationReport, error) {
+	e := NewExecutor(store)
+	tables, err := e.tableNames()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RowMigrationReport{Tables: len(tables)}
+	keys, err := store.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+	for _, table := range tables {
+		if data, err := store.Get(tableMetadataKey(table)); err == nil && !strings.HasPrefix(string(data), "{") {
+			metadata, err := loadTableMetadata(store, table)
+			if err != nil {
+				return report, err
+			}
+			if err := saveTableMetadata(store, metadata); err != nil {
+				return report, fmt.Errorf("failed to store metadata of table '%s': %w", table, err)
+			}
+		}
+		if _, err := store.Get(tableSchemaKey(table)); err != nil {
+			schema, err := e.tableSchema(table)
+			if err != nil {
+				return report, err
+			}
+			if err := saveTableSchema(store, table, schema); err != nil {
+				return report, fmt.Errorf("failed to store schema of table '%s': %w", table, err)
+			}
+		}
+
+		tablePrefix := table + ":"
+		for _, key := range keys {
+			if !strings.HasPrefix(key, tablePrefix) {
+				continue
+			}
+			data, err := store.Get(key)
+			if err != nil {
+				continue
+			}
+			report.Rows++
+			if storage.IsEncodedRow(data) {
+				continue
+			}
+			rowData, err := e.decodeRow(table, data)
+			if err != nil {
+				return report, fmt.Errorf("failed to read row '%s': %w", key, err)
+			}
+			encoded, err := e.encodeRow(table, rowData)
+			if err != nil {
+				return report, fmt.Errorf("failed to encode row '%s': %w", key, err)
+			}
+			if err := store.Put(key, encoded); err != nil {
+				return report, fmt.Errorf("failed to write row '%s': %w", key, err)
+			}
+			report.Migrated++
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,491 @@
+package sql
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+// sortMemoryBytes is the default memory budget of a sort fed by a table
+// scan. Rows beyond it are sorted in runs that are spilled to temporary
+// files and merged.
+const sortMemoryBytes = 64 << 20
+
+// sortOrder returns the direction of the i-th ORDER BY key
+func (s *SelectStatement) sortOrder(i int) SortOrder {
+	if i < len(s.Ordering) {
+		return s.Ordering[i]
+	}
+	return SortOrder{}
+}
+
+// orderKeyText appends an ORDER BY key's direction to its text, leaving out
+// what is implied: ASC, and NULLs sorting as the largest values
+func orderKeyText(text string, order SortOrder) string {
+	if order.Descending {
+		text += " DESC"
+	}
+	if order.NullsFirst != order.Descending {
+		if order.NullsFirst {
+			text += " NULLS FIRST"
+		} else {
+			text += " NULLS LAST"
+		}
+	}
+	return text
+}
+
+// rowSorter orders rows by the keys of an ORDER BY clause, comparing each
+// key under the collation of its column and in its own direction
+type rowSorter struct {
+	e          *Executor
+	keys       []Expression
+	orders     []SortOrder
+	collations []storage.Collation
+}
+
+func (e *Executor) newRowSorter(stmt *SelectStatement) *rowSorter {
+	s := &rowSorter{e: e, keys: stmt.OrderBy}
+	for i, expr := range stmt.OrderBy {
+		s.orders = append(s.orders, stmt.sortOrder(i))
+		s.collations = append(s.collations, e.expressionCollation(expr))
+	}
+	return s
+}
+
+// sortRows orders rows by the ORDER BY keys of a SELECT. Rows that tie on
+// every key keep their order.
+func (e *Executor) sortRows(rows [][]interface{}, stmt *SelectStatement) error {
+	return e.newRowSorter(stmt).sort(rows)
+}
+
+// topRows returns the first n rows in ORDER BY order. Only the best n rows
+// seen so far are kept, in a heap with the worst of them on top, so rows are
+// not all sorted. Ties go to the earlier row, as in a stable sort.
+func (e *Executor) topRows(rows [][]interface{}, stmt *SelectStatement, n int) ([][]interface{}, error) {
+	s := e.newRowSorter(stmt)
+	if n >= len(rows) {
+		return rows, s.sort(rows)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	top := &topHeap{sorter: s}
+	for i, row := range rows {
+		keys, err := s.keyValues(row)
+		if err != nil {
+			return nil, err
+		}
+		entry := &topEntry{row: row, keys: keys, position: i}
+		if top.Len() < n {
+			heap.Push(top, entry)
+		} else if top.before(entry, top.entries[0]) {
+			top.entries[0] = entry
+			heap.Fix(top, 0)
+		}
+	}
+
+	sort.Slice(top.entries, func(i, j int) bool {
+		return top.before(top.entries[i], top.entries[j])
+	})
+	result := make([][]interface{}, len(top.entries))
+	for i, entry := range top.entries {
+		result[i] = entry.row
+	}
+	return result, nil
+}
+
+// topEntry is a row kept by topRows, with its key values and its position
+// among the rows
+type topEntry struct {
+	row      []interface{}
+	keys     []interface{}
+	position int
+}
+
+// topHeap holds the rows kept by topRows, the row that sorts last on top
+type topHeap struct {
+	sorter  *rowSorter
+	entries []*topEntry
+}
+
+// before reports whether row a sorts before row b
+func (h *topHeap) before(a, b *topEntry) bool {
+	if c := h.sorter.compare(a.keys, b.keys); c != 0 {
+		return c < 0
+	}
+	return a.position < b.position
+}
+
+func (h *topHeap) Len() int           { return len(h.entries) }
+func (h *topHeap) Less(i, j int) bool { return h.before(h.entries[j], h.entries[i]) }
+func (h *topHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *topHeap) Push(x any)         { h.entries = append(h.entries, x.(*topEntry)) }
+func (h *topHeap) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// keyValues evaluates the ORDER BY keys over a row. Values keep their
+// types, so text is ordered as text even when it reads as a number.
+func (s *rowSorter) keyValues(row []interface{}) ([]interface{}, error) {
+	values := make([]interface{}, len(s.keys))
+	for i, expr := range s.keys {
+		value, err := s.e.evaluate(row, expr)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// compare compares the key values of two rows. NULLs sort first or last
+// whatever the direction of their key.
+func (s *rowSorter) compare(a, b []interface{}) int {
+	for i, order := range s.orders {
+		var c int
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil || b[i] == nil:
+			c = 1
+			if (a[i] == nil) == order.NullsFirst {
+				c = -1
+			}
+		default:
+			c = compareCollated(a[i], b[i], s.collations[i])
+			if order.Descending {
+				c = -c
+			}
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// sort orders rows in memory
+func (s *rowSorter) sort(rows [][]interface{}) error {
+	keys := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		rowKeys, err := s.keyValues(row)
+		if err != nil {
+			return err
+		}
+		keys[i] = rowKeys
+	}
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return s.compare(keys[order[a]], keys[order[b]]) < 0
+	})
+
+	sorted := make([][]interface{}, len(rows))
+	for i, index := range order {
+		sorted[i] = rows[index]
+	}
+	copy(rows, sorted)
+	return nil
+}
+
+// spillsSort reports whether a SELECT sorts the rows of a table scan as they
+// are read, so a sort larger than the memory budget is spilled to disk
+// instead of the whole table being held first. Aggregates, top-N sorts and
+// scans that build adaptive hash indexes need every row, and are sorted in
+// memory.
+func (e *Executor) spillsSort(stmt *SelectStatement, plan *ExecutionPlan, agg *aggregation, hot []string) bool {
+	if len(stmt.OrderBy) == 0 || plan.Ordered || plan.TopN || plan.RankByRelevance {
+		return false
+	}
+	if _, isDerived := e.derived[plan.Table]; isDerived {
+		return false
+	}
+	return plan.Type == PlanTypeTableScan && agg == nil && len(hot) == 0
+}
+
+// scanSorted reads the rows of the plan's table matching the WHERE clause,
+// in ORDER BY order. Rows are fed to a run builder as they are scanned.
+func (e *Executor) scanSorted(stmt *SelectStatement, plan *ExecutionPlan) ([][]interface{}, error) {
+	start := time.Now()
+	runs := e.newRunBuilder(stmt)
+	defer runs.close()
+
+	var sortTime time.Duration
+	scanned, matched := 0, 0
+	keysScanned, err := e.forEachTableRow(plan.Table, func(row []interface{}) error {
+		scanned++
+		if stmt.Where != nil {
+			matches, err := e.evaluateWhere(row, stmt.Where)
+			if err != nil || !matches {
+				return err
+			}
+		}
+		matched++
+		added := time.Now()
+		err := runs.add(row)
+		sortTime += time.Since(added)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.profile.record(opScan, scanned, keysScanned, time.Since(start)-sortTime)
+	if stmt.Where != nil {
+		e.profile.record(opFilter, matched, 0, 0)
+	}
+
+	finished := time.Now()
+	rows, err := runs.finish()
+	if err != nil {
+		return nil, err
+	}
+	e.profile.record(opSort, len(rows), 0, sortTime+time.Since(finished))
+	return rows, nil
+}
+
+// runBuilder sorts rows fed to it one at a time. Rows are held until they
+// fill the memory budget, then sorted and spilled to a temporary file as a
+// run; the runs are merged once every row has been added.
+type runBuilder struct {
+	sorter *rowSorter
+	budget int
+	rows   [][]interface{}
+	size   int
+	dir    string
+	runs   []*sortRun
+	total  int
+}
+
+func (e *Executor) newRunBuilder(stmt *SelectStatement) *runBuilder {
+	return &runBuilder{sorter: e.newRowSorter(stmt), budget: e.sortMemory}
+}
+
+// add adds a row, spilling the rows held once they fill the budget
+func (b *runBuilder) add(row []interface{}) error {
+	b.rows = append(b.rows, row)
+	b.size += rowMemory(row)
+	b.total++
+	if b.size < b.budget {
+		return nil
+	}
+	return b.spill()
+}
+
+// spill sorts the rows held and writes them to a new run
+func (b *runBuilder) spill() error {
+	if b.dir == "" {
+		dir, err := os.MkdirTemp("", "startdb-sort-")
+		if err != nil {
+			return fmt.Errorf("failed to create sort directory: %w", err)
+		}
+		b.dir = dir
+	}
+	if err := b.sorter.sort(b.rows); err != nil {
+		return err
+	}
+	run, err := spillRun(b.dir, len(b.runs), b.rows)
+	if err != nil {
+		return err
+	}
+	b.runs = append(b.runs, run)
+	b.rows, b.size = nil, 0
+	return nil
+}
+
+// finish returns every row added, in order. Rows that never filled the
+// budget are sorted in memory; otherwise the last rows are spilled too and
+// the runs merged. Ties between runs go to the earlier run, keeping the
+// sort stable.
+func (b *runBuilder) finish() ([][]interface{}, error) {
+	if len(b.runs) == 0 {
+		return b.rows, b.sorter.sort(b.rows)
+	}
+	if len(b.rows) > 0 {
+		if err := b.spill(); err != nil {
+			return nil, err
+		}
+	}
+
+	merge := &runMerge{sorter: b.sorter}
+	for _, run := range b.runs {
+		if err := merge.advance(run); err != nil {
+			return nil, err
+		}
+	}
+	rows := make([][]interface{}, 0, b.total)
+	for merge.Len() > 0 {
+		head := heap.Pop(merge).(*runHead)
+		rows = append(rows, head.row)
+		if err := merge.advance(head.run); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// close removes the runs
+func (b *runBuilder) close() {
+	for _, run := range b.runs {
+		run.file.Close()
+	}
+	if b.dir != "" {
+		os.RemoveAll(b.dir)
+	}
+}
+
+// rowMemory estimates the bytes a row holds in memory
+func rowMemory(row []interface{}) int {
+	size := 24 + 16*len(row)
+	for _, value := range row {
+		switch v := value.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		case []float64:
+			size += 8 * len(v)
+		case time.Time:
+			size += 24
+		}
+	}
+	return size
+}
+
+// sortRun is a sorted run of rows spilled to a temporary file. Each row is
+// stored in the binary row format, its values numbered by their position in
+// the row, after its length.
+type sortRun struct {
+	index  int
+	file   *os.File
+	reader *bufio.Reader
+}
+
+func spillRun(dir string, index int, rows [][]interface{}) (*sortRun, error) {
+	file, err := os.CreateTemp(dir, "run-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sort run: %w", err)
+	}
+	run := &sortRun{index: index, file: file}
+
+	writer := bufio.NewWriter(file)
+	for _, row := range rows {
+		fields := make([]storage.RowField, len(row))
+		for i, value := range row {
+			fields[i] = storage.RowField{Column: uint32(i), Value: value}
+		}
+		data, err := storage.EncodeRow("", fields)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to spill sort run: %w", err)
+		}
+		writer.Write(binary.AppendUvarint(nil, uint64(len(data))))
+		writer.Write(data)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to spill sort run: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read sort run: %w", err)
+	}
+	run.reader = bufio.NewReader(file)
+	return run, nil
+}
+
+// next reads the run's next row, or returns nil at its end
+func (r *sortRun) next() ([]interface{}, error) {
+	length, err := binary.ReadUvarint(r.reader)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sort run: %w", err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, fmt.Errorf("failed to read sort run: %w", err)
+	}
+	_, fields, err := storage.DecodeRow(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sort run: %w", err)
+	}
+	row := make([]interface{}, len(fields))
+	for _, field := range fields {
+		if int(field.Column) >= len(row) {
+			return nil, fmt.Errorf("failed to read sort run: %w", storage.ErrCorruptRow)
+		}
+		row[field.Column] = field.Value
+	}
+	return row, nil
+}
+
+// runHead is the next row of a sort run waiting to be merged
+type runHead struct {
+	run  *sortRun
+	row  []interface{}
+	keys []interface{}
+}
+
+// runMerge is a heap of the next rows of the runs being merged, smallest
+// first
+type runMerge struct {
+	sorter *rowSorter
+	heads  []*runHead
+}
+
+func (m *runMerge) Len() int { return len(m.heads) }
+func (m *runMerge) Less(i, j int) bool {
+	if c := m.sorter.compare(m.heads[i].keys, m.heads[j].keys); c != 0 {
+		return c < 0
+	}
+	return m.heads[i].run.index < m.heads[j].run.index
+}
+func (m *runMerge) Swap(i, j int) { m.heads[i], m.heads[j] = m.heads[j], m.heads[i] }
+func (m *runMerge) Push(x any)    { m.heads = append(m.heads, x.(*runHead)) }
+func (m *runMerge) Pop() any {
+	last := m.heads[len(m.heads)-1]
+	m.heads = m.heads[:len(m.heads)-1]
+	return last
+}
+
+// advance pushes the run's next row onto the heap, if it has one
+func (m *runMerge) advance(run *sortRun) error {
+	row, err := run.next()
+	if err != nil || row == nil {
+		return err
+	}
+	keys, err := m.sorter.keyValues(row)
+	if err != nil {
+		return err
+	}
+	heap.Push(m, &runHead{run: run, row: row, keys: keys})
+	return nil
+}
+
+// orderText renders the ORDER BY keys of a plan for EXPLAIN
+func orderText(keys []Expression, ordering []SortOrder) string {
+	parts := make([]string, len(keys))
+	for i, expr := range keys {
+		var order SortOrder
+		if i < len(ordering) {
+			order = ordering[i]
+		}
+		parts[i] = orderKeyText(expr.String(), order)
+	}
+	return strings.Join(parts, ", ")
+}
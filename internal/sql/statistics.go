@@ -0,0 +1,290 @@
+package sql
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+// Default figures used when a table has not been analyzed yet
+const (
+	defaultRowCount         = 1000
+	defaultEqualSelectivity = 0.01
+	defaultRangeSelectivity = 1.0 / 3.0
+	defaultJoinSelectivity  = 0.1
+	defaultMatchSelectivity = 0.05
+	defaultGroupFraction    = 0.1 // groups per row when a key has no statistics
+	histogramBuckets        = 10
+)
+
+// TableStatistics holds the statistics gathered by ANALYZE for one table
+type TableStatistics struct {
+	Table    string                       `json:"table"`
+	RowCount int                          `json:"row_count"`
+	Columns  map[string]*ColumnStatistics `json:"columns"`
+	Analyzed time.Time                    `json:"analyzed"`
+}
+
+// ColumnStatistics describes the value distribution of a single column
+type ColumnStatistics struct {
+	DistinctCount int               `json:"distinct_count"`
+	NullFraction  float64           `json:"null_fraction"`
+	Min           interface{}       `json:"min"`
+	Histogram     []HistogramBucket `json:"histogram"`
+}
+
+// HistogramBucket is one bucket of an equi-depth histogram. Buckets are
+// ordered, and each covers the values above the previous bucket's upper
+// bound (or Min for the first bucket) up to and including Upper.
+type HistogramBucket struct {
+	Upper interface{} `json:"upper"`
+	Count int         `json:"count"`
+}
+
+func tableStatisticsKey(table string) string {
+	return fmt.Sprintf("_table_stats:%s", table)
+}
+
+// loadTableStatistics reads the statistics stored for a table, returning nil
+// if the table has not been analyzed
+func loadTableStatistics(store *storage.Storage, table string) *TableStatistics {
+	data, err := store.Get(tableStatisticsKey(table))
+	if err != nil {
+		return nil
+	}
+	var stats TableStatistics
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil
+	}
+	return &stats
+}
+
+func saveTableStatistics(store *storage.Storage, stats *TableStatistics) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return store.Put(tableStatisticsKey(stats.Table), data)
+}
+
+// buildTableStatistics computes statistics for the given rows
+func buildTableStatistics(table string, columns []string, rows [][]interface{}) *TableStatistics {
+	stats := &TableStatistics{
+		Table:    table,
+		RowCount: len(rows),
+		Columns:  make(map[string]*ColumnStatistics),
+		Analyzed: time.Now(),
+	}
+
+	for _, column := range columns {
+		var values []interface{}
+		for _, row := range rows {
+			value := rowColumnValue(row, column)
+			if value != nil {
+				values = append(values, normalizeValue(value))
+			}
+		}
+		stats.Columns[column] = buildColumnStatistics(values, len(rows))
+	}
+
+	return stats
+}
+
+func buildColumnStatistics(values []interface{}, rowCount int) *ColumnStatistics {
+	colStats := &ColumnStatistics{}
+	if rowCount > 0 {
+		colStats.NullFraction = float64(rowCount-len(values)) / float64(rowCount)
+	}
+	if len(values) == 0 {
+		return colStats
+	}
+
+	keys := make([]string, len(values))
+	for i, value := range values {
+		keys[i] = storage.EncodeKey(value)
+	}
+	sort.Sort(byEncodedKey{keys, values})
+
+	distinct := 1
+	for i := 1; i < len(keys); i++ {
+		if keys[i] != keys[i-1] {
+			distinct++
+		}
+	}
+	colStats.DistinctCount = distinct
+	colStats.Min = values[0]
+
+	buckets := histogramBuckets
+	if len(values) < buckets {
+		buckets = len(values)
+	}
+	start := 0
+	for b := 0; b < buckets; b++ {
+		end := (b + 1) * len(values) / buckets
+		// Keep equal values in the same bucket
+		for end < len(values) && keys[end] == keys[end-1] {
+			end++
+		}
+		if end <= start {
+			continue
+		}
+		colStats.Histogram = append(colStats.Histogram, HistogramBucket{
+			Upper: values[end-1],
+			Count: end - start,
+		})
+		start = end
+	}
+
+	return colStats
+}
+
+type byEncodedKey struct {
+	keys   []string
+	values []interface{}
+}
+
+func (b byEncodedKey) Len() int           { return len(b.keys) }
+func (b byEncodedKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byEncodedKey) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+}
+
+// rowCount returns the analyzed row count, or a default for unanalyzed tables
+func (s *TableStatistics) rowCount() float64 {
+	if s == nil {
+		return defaultRowCount
+	}
+	return float64(s.RowCount)
+}
+
+func (s *TableStatistics) column(name string) *ColumnStatistics {
+	if s == nil {
+		return nil
+	}
+	return s.Columns[name]
+}
+
+// equalSelectivity estimates the fraction of rows where the column equals value
+func (c *ColumnStatistics) equalSelectivity(value interface{}) float64 {
+	if c == nil {
+		return defaultEqualSelectivity
+	}
+	if c.DistinctCount == 0 {
+		return 0
+	}
+	key := storage.EncodeKey(normalizeValue(value))
+	if len(c.Histogram) > 0 {
+		if key < storage.EncodeKey(c.Min) || key > storage.EncodeKey(c.Histogram[len(c.Histogram)-1].Upper) {
+			return 0
+		}
+	}
+	return (1 - c.NullFraction) / float64(c.DistinctCount)
+}
+
+// lessSelectivity estimates the fraction of rows where the column is below value
+func (c *ColumnStatistics) lessSelectivity(value interface{}) float64 {
+	if c == nil {
+		return defaultRangeSelectivity
+	}
+	if len(c.Histogram) == 0 {
+		return 0
+	}
+
+	value = normalizeValue(value)
+	key := storage.EncodeKey(value)
+	total := 0
+	for _, bucket := range c.Histogram {
+		total += bucket.Count
+	}
+
+	below := 0.0
+	lower := c.Min
+	for _, bucket := range c.Histogram {
+		upperKey := storage.EncodeKey(bucket.Upper)
+		if key > upperKey {
+			below += float64(bucket.Count)
+			lower = bucket.Upper
+			continue
+		}
+		if key > storage.EncodeKey(lower) {
+			below += float64(bucket.Count) * bucketFraction(lower, bucket.Upper, value)
+		}
+		break
+	}
+
+	return (1 - c.NullFraction) * below / float64(total)
+}
+
+// rangeSelectivity estimates the fraction of rows with the column between
+// lower and upper, either of which may be nil for an open bound
+func (c *ColumnStatistics) rangeSelectivity(lower, upper interface{}) float64 {
+	if c == nil {
+		if lower != nil && upper != nil {
+			return defaultRangeSelectivity * defaultRangeSelectivity
+		}
+		return defaultRangeSelectivity
+	}
+	high := c.nonNullFraction()
+	if upper != nil {
+		high = c.lessSelectivity(upper) + c.equalSelectivity(upper)
+	}
+	low := 0.0
+	if lower != nil {
+		low = c.lessSelectivity(lower)
+	}
+	if high < low {
+		return 0
+	}
+	return high - low
+}
+
+// nonNullFraction returns the fraction of rows where the column is not NULL
+func (c *ColumnStatistics) nonNullFraction() float64 {
+	if c == nil {
+		return 1
+	}
+	return 1 - c.NullFraction
+}
+
+// bucketFraction estimates how far value lies between lower and upper,
+// interpolating linearly for numbers and assuming the midpoint otherwise
+func bucketFraction(lower, upper, value interface{}) float64 {
+	lo, okLo := numericValue(lower)
+	hi, okHi := numericValue(upper)
+	v, okV := numericValue(value)
+	if !okLo || !okHi || !okV || hi <= lo {
+		return 0.5
+	}
+	return (v - lo) / (hi - lo)
+}
+
+// numericValue returns an integer or float as a float64, reporting false
+// for any other value
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// normalizeValue converts the untyped strings held by stored rows into the
+// numbers or booleans they represent
+func normalizeValue(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+		if s == "true" || s == "false" {
+			return s == "true"
+		}
+	}
+	return value
+}
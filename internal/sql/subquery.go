@@ -0,0 +1,484 @@
+package sql
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"startdb/internal/storage"
+)
+
+// parameter stands in a subquery for a column of an enclosing query. Its
+// value is that of the column in the enclosing query's current row, taken
+// each time the subquery runs.
+type parameter struct {
+	index int    // position of the value in the subquery's parameters
+	name  string // the column reference the parameter replaced
+}
+
+func (p *parameter) expressionNode() {}
+func (p *parameter) String() string {
+	return p.name
+}
+
+// nestedQuery is a subquery or derived table prepared to run, with the
+// result of an uncorrelated one kept after its first run
+type nestedQuery struct {
+	sources         []selectSource
+	rows            [][]interface{}
+	ran             bool
+	members         *memberSet // the values of an uncorrelated IN subquery
+	semiJoin        *semiJoin  // the matches of a correlated EXISTS, once planned
+	semiJoinPlanned bool
+}
+
+// prepareDerivedTable prepares the SELECT of a table in a FROM clause and
+// returns the columns it is read with, named as the SELECT names its fields
+func (e *Executor) prepareDerivedTable(table, alias string, stmt *SelectStatement) ([]string, error) {
+	query, err := e.prepareNested(stmt, &queryScope{})
+	if err != nil {
+		return nil, err
+	}
+	columns, _ := selectFields(stmt, query.sources)
+	seen := make(map[string]bool)
+	for _, column := range columns {
+		if seen[column] {
+			return nil, fmt.Errorf("subquery '%s' has more than one column named '%s'", alias, column)
+		}
+		seen[column] = true
+	}
+
+	if e.derived == nil {
+		e.derived = make(map[string]*SelectStatement)
+	}
+	if e.planner.derived == nil {
+		e.planner.derived = make(map[string][]string)
+	}
+	e.derived[table] = stmt
+	e.planner.derived[table] = columns
+	return columns, nil
+}
+
+// prepareSubqueries prepares the subqueries in an expression of a query,
+// whose tables are in scope
+func (e *Executor) prepareSubqueries(expr Expression, scope *queryScope) error {
+	switch x := expr.(type) {
+	case *SubqueryExpression:
+		return e.prepareSubquery(x, scope, true)
+	case *ExistsExpression:
+		return e.prepareSubquery(x.Subquery, scope, false)
+	case *InExpression:
+		if x.Subquery != nil {
+			if err := e.prepareSubqueries(x.Left, scope); err != nil {
+				return err
+			}
+			return e.prepareSubquery(x.Subquery, scope, true)
+		}
+	case *FunctionCall:
+		for _, arg := range x.Args {
+			if err := e.prepareSubqueries(arg, scope); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, operand := range operands(expr) {
+		if err := e.prepareSubqueries(operand, scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareSubquery prepares a subquery of a query whose tables are in scope.
+// The subquery's references to those tables become its parameters. A
+// subquery used as a value, or as the list of IN, must return one column.
+func (e *Executor) prepareSubquery(sub *SubqueryExpression, scope *queryScope, scalar bool) error {
+	query, err := e.prepareNested(sub.Select, &queryScope{outer: scope, params: &sub.Params})
+	if err != nil {
+		return err
+	}
+	if columns, _ := selectFields(sub.Select, query.sources); scalar && len(columns) != 1 {
+		return fmt.Errorf("subquery must return only one column, but returns %d", len(columns))
+	}
+	return nil
+}
+
+// prepareNested prepares a subquery or derived table once per statement
+func (e *Executor) prepareNested(stmt *SelectStatement, scope *queryScope) (*nestedQuery, error) {
+	if query, ok := e.queries[stmt]; ok {
+		return query, nil
+	}
+	sources, err := e.prepareQuery(stmt, scope)
+	if err != nil {
+		return nil, err
+	}
+	if e.queries == nil {
+		e.queries = make(map[*SelectStatement]*nestedQuery)
+	}
+	query := &nestedQuery{sources: sources}
+	e.queries[stmt] = query
+	return query, nil
+}
+
+// subquery returns a prepared subquery. One in a statement that does not
+// read a table, such as the values of an INSERT, is prepared when first run
+// and cannot refer to any column.
+func (e *Executor) subquery(sub *SubqueryExpression, scalar bool) (*nestedQuery, error) {
+	if query, ok := e.queries[sub.Select]; ok {
+		return query, nil
+	}
+	if err := e.prepareSubquery(sub, &queryScope{}, scalar); err != nil {
+		return nil, err
+	}
+	return e.queries[sub.Select], nil
+}
+
+// runNested runs a prepared subquery or derived table with the values of
+// its parameters, leaving the state of the query it is nested in as it was
+func (e *Executor) runNested(stmt *SelectStatement, sources []selectSource, params []interface{}) ([]string, [][]interface{}, error) {
+	collations, lastPlan, profile, outer := e.collations, e.lastPlan, e.profile, e.params
+	defer func() {
+		e.collations, e.lastPlan, e.profile, e.params = collations, lastPlan, profile, outer
+		e.planner.params = outer
+	}()
+	e.profile = nil
+	e.params = params
+	e.planner.params = params
+	return e.runSelect(stmt, sources)
+}
+
+// runSubquery returns the rows of a subquery for a row of the query it is
+// nested in. An uncorrelated subquery runs once per statement.
+func (e *Executor) runSubquery(rowData []interface{}, sub *SubqueryExpression, scalar bool) (*nestedQuery, [][]interface{}, error) {
+	query, err := e.subquery(sub, scalar)
+	if err != nil {
+		return nil, nil, err
+	}
+	if query.ran {
+		return query, query.rows, nil
+	}
+
+	params := make([]interface{}, len(sub.Params))
+	for i, param := range sub.Params {
+		params[i], err = e.evaluate(rowData, param)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	_, rows, err := e.runNested(sub.Select, query.sources, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sub.Params) == 0 {
+		query.rows, query.ran = rows, true
+	}
+	return query, rows, nil
+}
+
+// evaluateSubquery evaluates a subquery used as a value: the value of its
+// one row, or NULL if it returns none
+func (e *Executor) evaluateSubquery(rowData []interface{}, sub *SubqueryExpression) (interface{}, error) {
+	_, rows, err := e.runSubquery(rowData, sub, true)
+	if err != nil {
+		return nil, err
+	}
+	switch len(rows) {
+	case 0:
+		return nil, nil
+	case 1:
+		return rows[0][0], nil
+	default:
+		return nil, fmt.Errorf("more than one row returned by a subquery used as an expression")
+	}
+}
+
+// evaluateInSubquery evaluates [NOT] IN over the rows of a subquery, with
+// the same NULL handling as IN over a list of values
+func (e *Executor) evaluateInSubquery(rowData []interface{}, in *InExpression) (interface{}, error) {
+	value, err := e.evaluate(rowData, in.Left)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	query, rows, err := e.runSubquery(rowData, in.Subquery, true)
+	if err != nil {
+		return nil, err
+	}
+
+	collation := e.expressionCollation(in.Left)
+	if len(in.Subquery.Params) == 0 {
+		if query.members == nil {
+			query.members = newMemberSet(rows, collation)
+		}
+		found, err := e.containsMember(query.members, value, collation)
+		return logicNot(found, in.Not), err
+	}
+	found, err := e.matchAny(value, rows, collation)
+	return logicNot(found, in.Not), err
+}
+
+// matchAny compares a value with the first column of each row: true if it
+// equals one, else unknown if any is NULL, else false
+func (e *Executor) matchAny(value interface{}, rows [][]interface{}, collation storage.Collation) (interface{}, error) {
+	var found interface{} = false
+	for _, row := range rows {
+		equal, err := e.compareOperands(value, row[0], "=", collation)
+		if err != nil {
+			return nil, err
+		}
+		found = logicOr(found, equal)
+		if found == true {
+			break
+		}
+	}
+	return found, nil
+}
+
+// memberSet holds the values an uncorrelated IN subquery returns, keyed for
+// lookup when they are all of one kind
+type memberSet struct {
+	rows    [][]interface{}
+	kind    string          // the kind of every non-NULL value, see valueKind
+	keys    map[string]bool // nil if the values are of more than one kind
+	hasNull bool
+}
+
+func newMemberSet(rows [][]interface{}, collation storage.Collation) *memberSet {
+	set := &memberSet{rows: rows, keys: make(map[string]bool)}
+	for _, row := range rows {
+		value := row[0]
+		if value == nil {
+			set.hasNull = true
+			continue
+		}
+		kind := valueKind(value)
+		if set.kind == "" {
+			set.kind = kind
+		}
+		if kind == "" || kind != set.kind {
+			set.keys = nil
+			break
+		}
+		set.keys[groupKey(value, collation)] = true
+	}
+	return set
+}
+
+// containsMember looks a value up in a member set. A value of another kind
+// than the set's is compared with each member, as they may still be equal
+// once coerced.
+func (e *Executor) containsMember(set *memberSet, value interface{}, collation storage.Collation) (interface{}, error) {
+	if set.keys == nil || valueKind(value) != set.kind {
+		return e.matchAny(value, set.rows, collation)
+	}
+	if set.keys[groupKey(value, collation)] {
+		return true, nil
+	}
+	if set.hasNull {
+		return nil, nil
+	}
+	return false, nil
+}
+
+// valueKind names the kinds of values whose group keys are equal exactly
+// when the values are: numbers, strings and booleans. Other values have no
+// kind.
+func valueKind(value interface{}) string {
+	switch value.(type) {
+	case int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	return ""
+}
+
+// evaluateExists evaluates [NOT] EXISTS: whether the subquery returns any
+// row. A correlated subquery matching on equalities with the enclosing
+// query's columns is run once, as a semi-join.
+func (e *Executor) evaluateExists(rowData []interface{}, exists *ExistsExpression) (interface{}, error) {
+	query, err := e.subquery(exists.Subquery, false)
+	if err != nil {
+		return nil, err
+	}
+	if !query.semiJoinPlanned {
+		query.semiJoin = planSemiJoin(exists.Subquery)
+		query.semiJoinPlanned = true
+	}
+	if query.semiJoin != nil {
+		found, err := e.probeSemiJoin(rowData, exists.Subquery, query)
+		return found != exists.Not, err
+	}
+
+	_, rows, err := e.runSubquery(rowData, exists.Subquery, false)
+	if err != nil {
+		return nil, err
+	}
+	return (len(rows) > 0) != exists.Not, nil
+}
+
+// semiJoin decorrelates an EXISTS subquery whose WHERE clause matches its
+// columns to the enclosing query's with equalities: the subquery's rows
+// that pass the rest of its WHERE clause are read once, and the key
+// columns of each are kept. A row of the enclosing query then has a match
+// if its values for the key are among them.
+type semiJoin struct {
+	filter  Expression   // the conditions not on the enclosing query's columns
+	columns []Expression // the subquery's columns of the key
+	params  []*parameter // the parameters each is matched to
+	keys    map[string]bool
+}
+
+// planSemiJoin returns the semi-join an EXISTS subquery can run as, or nil
+// if it has to run once per row: it must read one table, return rows rather
+// than groups, and match the enclosing query only by equalities of its
+// columns with the enclosing query's
+func planSemiJoin(sub *SubqueryExpression) *semiJoin {
+	stmt := sub.Select
+	if len(sub.Params) == 0 || len(stmt.Joins) > 0 || isAggregateQuery(stmt) || stmt.HasLimit || stmt.Offset > 0 {
+		return nil
+	}
+	join := &semiJoin{}
+	var filter []Expression
+	for _, conjunct := range splitConjuncts(stmt.Where) {
+		if !containsParameter(conjunct) {
+			filter = append(filter, conjunct)
+			continue
+		}
+		b, ok := conjunct.(*BinaryExpression)
+		if !ok || b.Operator != "=" {
+			return nil
+		}
+		column, param := b.Left, b.Right
+		if _, isParam := column.(*parameter); isParam {
+			column, param = param, column
+		}
+		ident, isIdent := column.(*Identifier)
+		p, isParam := param.(*parameter)
+		if !isIdent || !isParam {
+			return nil
+		}
+		join.columns = append(join.columns, ident)
+		join.params = append(join.params, p)
+	}
+	if len(join.columns) == 0 {
+		return nil
+	}
+	join.filter = joinConjuncts(filter)
+	return join
+}
+
+// probeSemiJoin reports whether a row of the enclosing query has a match in
+// an EXISTS subquery run as a semi-join, reading the subquery's keys the
+// first time
+func (e *Executor) probeSemiJoin(rowData []interface{}, sub *SubqueryExpression, query *nestedQuery) (bool, error) {
+	join := query.semiJoin
+	table := query.sources[0].table
+	if join.keys == nil {
+		probe := &SelectStatement{
+			Fields:     join.columns,
+			Table:      sub.Select.Table,
+			TableAlias: sub.Select.TableAlias,
+			Subquery:   sub.Select.Subquery,
+			Where:      join.filter,
+		}
+		_, rows, err := e.runNested(probe, query.sources, nil)
+		if err != nil {
+			return false, err
+		}
+		join.keys = make(map[string]bool)
+		values := make([]interface{}, len(join.columns))
+		for _, row := range rows {
+			copy(values, row)
+			if key, ok := e.semiJoinKey(table, join.columns, values); ok {
+				join.keys[key] = true
+			}
+		}
+	}
+
+	values := make([]interface{}, len(join.params))
+	for i, param := range join.params {
+		value, err := e.evaluate(rowData, sub.Params[param.index])
+		if err != nil {
+			return false, err
+		}
+		values[i] = value
+	}
+	key, ok := e.semiJoinKey(table, join.columns, values)
+	return ok && join.keys[key], nil
+}
+
+// semiJoinKey encodes the values of a semi-join's key columns under their
+// collations. A key with a NULL matches nothing, so has no encoding.
+func (e *Executor) semiJoinKey(table string, columns []Expression, values []interface{}) (string, bool) {
+	var key strings.Builder
+	for i, value := range values {
+		if value == nil {
+			return "", false
+		}
+		_, column := splitColumnName(columns[i].(*Identifier).Value)
+		encoded := e.joinKey(value, e.columnCollation(table, column))
+		fmt.Fprintf(&key, "%d:%s", len(encoded), encoded)
+	}
+	return key.String(), true
+}
+
+// joinConjuncts combines conditions with AND, returning nil for none
+func joinConjuncts(conjuncts []Expression) Expression {
+	var expr Expression
+	for _, conjunct := range conjuncts {
+		if expr == nil {
+			expr = conjunct
+		} else {
+			expr = &BinaryExpression{Left: expr, Operator: "AND", Right: conjunct}
+		}
+	}
+	return expr
+}
+
+// containsParameter reports whether an expression refers to a column of an
+// enclosing query
+func containsParameter(expr Expression) bool {
+	switch e := expr.(type) {
+	case *parameter:
+		return true
+	case *FunctionCall:
+		for _, arg := range e.Args {
+			if containsParameter(arg) {
+				return true
+			}
+		}
+	default:
+		for _, operand := range operands(expr) {
+			if containsParameter(operand) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// derivedRows returns the rows of a derived table, running its SELECT the
+// first time. Each row is numbered and holds the SELECT's fields by name.
+func (e *Executor) derivedRows(stmt *SelectStatement) ([][]interface{}, error) {
+	query := e.queries[stmt]
+	if !query.ran {
+		columns, rows, err := e.runNested(stmt, query.sources, nil)
+		if err != nil {
+			return nil, err
+		}
+		query.rows = make([][]interface{}, len(rows))
+		for i, values := range rows {
+			row := []interface{}{strconv.Itoa(i + 1)}
+			for j, column := range columns {
+				row = append(row, column, values[j])
+			}
+			query.rows[i] = row
+		}
+		query.ran = true
+	}
+	return slices.Clone(query.rows), nil
+}
@@ -0,0 +1,219 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+func indexSnapshotKey(indexName string) string {
+	return fmt.Sprintf("_index_data:%s", indexName)
+}
+
+// vectorDimensions returns n for a VECTOR(n) column type
+func vectorDimensions(columnType string) (int, bool) {
+	upper := strings.ToUpper(columnType)
+	if !strings.HasPrefix(upper, "VECTOR(") || !strings.HasSuffix(upper, ")") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(upper[len("VECTOR(") : len(upper)-1])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// toVector converts a vector value or its text form to a vector
+func toVector(value interface{}) ([]float64, error) {
+	switch v := value.(type) {
+	case []float64:
+		return v, nil
+	case string:
+		return storage.ParseVector(v)
+	default:
+		return nil, fmt.Errorf("invalid vector: %v", value)
+	}
+}
+
+// columnText returns the text a row-keyed index stores for a column value
+func columnText(value interface{}) string {
+	switch v := value.(type) {
+	case []float64:
+		return storage.FormatVector(v)
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// distanceCall is a distance(column, [..], 'metric') expression
+type distanceCall struct {
+	column string
+	vector []float64
+	metric storage.VectorMetric
+}
+
+func (d *distanceCall) String() string {
+	return fmt.Sprintf("distance(%s, %s, '%s')", d.column, storage.FormatVector(d.vector), d.metric)
+}
+
+// parseDistance returns the distance call expr represents. The second result
+// reports whether expr is a distance call at all. The metric defaults to l2.
+func parseDistance(expr Expression) (*distanceCall, bool, error) {
+	call, ok := expr.(*FunctionCall)
+	if !ok || !strings.EqualFold(call.Name, "DISTANCE") {
+		return nil, false, nil
+	}
+	if len(call.Args) != 2 && len(call.Args) != 3 {
+		return nil, true, fmt.Errorf("distance expects a column, a vector and an optional metric")
+	}
+
+	column, ok := call.Args[0].(*Identifier)
+	if !ok {
+		return nil, true, fmt.Errorf("distance expects a column as its first argument")
+	}
+	result := &distanceCall{column: column.Value, metric: storage.VectorMetricL2}
+
+	switch v := call.Args[1].(type) {
+	case *VectorLiteral:
+		result.vector = v.Values
+	case *StringLiteral:
+		vector, err := storage.ParseVector(v.Value)
+		if err != nil {
+			return nil, true, err
+		}
+		result.vector = vector
+	default:
+		return nil, true, fmt.Errorf("distance expects a vector as its second argument")
+	}
+
+	if len(call.Args) == 3 {
+		name, ok := call.Args[2].(*StringLiteral)
+		if !ok {
+			return nil, true, fmt.Errorf("distance expects a metric name as its third argument")
+		}
+		metric, err := storage.ParseVectorMetric(name.Value)
+		if err != nil {
+			return nil, true, err
+		}
+		result.metric = metric
+	}
+
+	return result, true, nil
+}
+
+// evaluateDistance computes a row's distance from the call's vector, or nil
+// if the row has no vector of matching dimensions
+func (e *Executor) evaluateDistance(rowData []interface{}, call *distanceCall) interface{} {
+	value := rowColumnValue(rowData, call.column)
+	if value == nil {
+		return nil
+	}
+	vector, err := toVector(value)
+	if err != nil || len(vector) != len(call.vector) {
+		return nil
+	}
+	return storage.VectorDistance(call.metric, vector, call.vector)
+}
+
+// scanVector reads the rows nearest to the plan's distance call through a
+// vector index, closest first. When the query has a WHERE clause the index is
+// searched again for more candidates until Offset+Limit rows pass it.
+func (e *Executor) scanVector(plan *ExecutionPlan) ([][]interface{}, int, error) {
+	call := plan.IndexValue.(*distanceCall)
+	indexManager := e.storage.GetIndexManager()
+
+	var rows [][]interface{}
+	keysScanned := 0
+	wanted := plan.Limit + plan.Offset
+	for fetch := wanted; ; fetch *= 2 {
+		matches, err := indexManager.SearchNearest(plan.IndexName, call.vector, fetch)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to search index '%s': %w", plan.IndexName, err)
+		}
+		keysScanned += len(matches)
+
+		rowKeys := make([]string, len(matches))
+		for i, match := range matches {
+			rowKeys[i] = match.Key
+		}
+		candidates, err := e.readRows(plan.Table, rowKeys)
+		if err != nil {
+			return nil, 0, err
+		}
+		rows = rows[:0]
+		for _, rowData := range candidates {
+			if plan.Where != nil {
+				if ok, err := e.evaluateWhere(rowData, plan.Where); err != nil || !ok {
+					continue
+				}
+			}
+			rows = append(rows, rowData)
+		}
+
+		if len(rows) >= wanted || len(matches) < fetch {
+			return rows, keysScanned, nil
+		}
+	}
+}
+
+// saveIndexSnapshot persists the graph of a vector index so it does not have
+// to be rebuilt when the database is reopened
+func (e *Executor) saveIndexSnapshot(indexName string) error {
+	snapshot, err := e.storage.GetIndexManager().SnapshotIndex(indexName)
+	if err != nil {
+		return err
+	}
+	return e.storage.Put(indexSnapshotKey(indexName), snapshot)
+}
+
+// syncVectorIndex brings a vector index loaded from a snapshot up to date
+// with its table, re-saving the snapshot if anything changed
+func (e *Executor) syncVectorIndex(indexName string, definition *indexDefinition) error {
+	indexManager := e.storage.GetIndexManager()
+	rows, err := e.loadTableRows(definition.Table)
+	if err != nil {
+		return err
+	}
+
+	changed := 0
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		key := rowKey(definition.Table, row)
+		value := e.indexedValue(definition, row)
+		if value == nil {
+			continue
+		}
+		seen[key] = true
+		text := columnText(value)
+		if indexed, found := indexManager.Search(indexName, key); !found || string(indexed) != text {
+			if vector, err := storage.ParseVector(text); err == nil {
+				indexManager.Insert(indexName, key, []byte(storage.FormatVector(vector)))
+				changed++
+			}
+		}
+	}
+
+	entries, err := indexManager.GetAll(indexName)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !seen[entry.Key] {
+			indexManager.Delete(indexName, entry.Key)
+			changed++
+		}
+	}
+
+	if changed > 0 {
+		if err := e.saveIndexSnapshot(indexName); err != nil {
+			return fmt.Errorf("failed to save index '%s': %w", indexName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package sql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"startdb/internal/storage"
+)
+
+// workloadKey is the key under which the recorded workload is saved
+const workloadKey = "_workload"
+
+// recordWorkload records a statement that ran successfully in the workload
+// store. Only statements that read or write table rows are recorded.
+func (e *Executor) recordWorkload(stmt Statement, elapsed time.Duration) {
+	shape, ok := e.queryShape(stmt)
+	if !ok {
+		return
+	}
+	recorded := e.storage.GetWorkload().Record(shape, elapsed)
+	if recorded%autoIndexInterval == 0 {
+		e.autoIndex()
+	}
+}
+
+// queryShape describes a statement for the workload store: its normalized
+// text, the columns it filters on and reads, and what the access path it
+// ran with cost
+func (e *Executor) queryShape(stmt Statement) (storage.QueryShape, bool) {
+	shape := storage.QueryShape{Fingerprint: normalizeStatement(stmt)}
+	var where Expression
+	switch s := stmt.(type) {
+	case *SelectStatement:
+		if s.Subquery != nil {
+			return shape, false
+		}
+		shape.Kind, shape.Table = "SELECT", s.Table
+		if len(s.Joins) == 0 {
+			where = s.Where
+			shape.Columns = selectedColumns(s)
+		}
+	case *InsertStatement:
+		shape.Kind, shape.Table = "INSERT", s.Table
+	case *UpdateStatement:
+		shape.Kind, shape.Table, where = "UPDATE", s.Table, s.Where
+	case *DeleteStatement:
+		shape.Kind, shape.Table, where = "DELETE", s.Table, s.Where
+	default:
+		return shape, false
+	}
+
+	stats := loadTableStatistics(e.storage, shape.Table)
+	shape.TableRows = stats.rowCount()
+	shape.AccessCost = shape.TableRows * costRowScan
+	if plan := e.lastPlan; plan != nil && plan.Table == shape.Table {
+		if plan.ScanCost > 0 {
+			shape.AccessCost = plan.ScanCost
+		} else if plan.Type == PlanTypeIndexScan {
+			shape.AccessCost = indexProbeCost(shape.TableRows) + shape.TableRows*stats.column(plan.IndexColumn).equalSelectivity(plan.IndexValue)*costIndexRow
+		}
+	}
+	if where != nil {
+		shape.Predicates = e.shapePredicates(shape.Kind, shape.Table, where, stats)
+	}
+	return shape, true
+}
+
+// shapePredicates returns the table columns a WHERE clause compares against
+// literals, which are the columns an index could be looked up by. UPDATE
+// and DELETE only look up their first equality in an index, so only it is
+// returned for them.
+func (e *Executor) shapePredicates(kind, table string, where Expression, stats *TableStatistics) []storage.ShapePredicate {
+	columns, err := tableColumns(e.storage, table)
+	if err != nil {
+		return nil
+	}
+	isColumn := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		isColumn[column] = true
+	}
+
+	var predicates []storage.ShapePredicate
+	seen := make(map[string]bool)
+	for _, eq := range e.planner.extractEqualities(where) {
+		if !isColumn[eq.column] || seen[eq.column] {
+			continue
+		}
+		seen[eq.column] = true
+		predicates = append(predicates, storage.ShapePredicate{
+			Column:      eq.column,
+			Operator:    "=",
+			Selectivity: stats.column(eq.column).equalSelectivity(eq.value),
+		})
+		if kind != "SELECT" {
+			return predicates
+		}
+	}
+	if kind != "SELECT" {
+		return predicates
+	}
+	for _, r := range e.planner.extractIndexableRanges(where) {
+		if !isColumn[r.column] || seen[r.column] {
+			continue
+		}
+		seen[r.column] = true
+		predicates = append(predicates, storage.ShapePredicate{
+			Column:      r.column,
+			Operator:    "range",
+			Selectivity: stats.column(r.column).rangeSelectivity(r.lower, r.upper),
+		})
+	}
+	return predicates
+}
+
+// selectedColumns returns the columns a single-table SELECT reads, or nil
+// when it reads every column
+func selectedColumns(stmt *SelectStatement) []string {
+	var columns []string
+	for _, field := range stmt.Fields {
+		if ident, ok := field.(*Identifier); ok && ident.Value == "*" {
+			return nil
+		}
+		columns = append(columns, referencedColumns(field)...)
+	}
+	columns = append(columns, referencedColumns(stmt.Where)...)
+	columns = append(columns, referencedColumns(stmt.Having)...)
+	for _, expr := range append(append(append([]Expression{}, stmt.DistinctOn...), stmt.GroupBy...), stmt.OrderBy...) {
+		columns = append(columns, referencedColumns(expr)...)
+	}
+
+	seen := make(map[string]bool)
+	var unique []string
+	for _, column := range columns {
+		if !seen[column] {
+			seen[column] = true
+			unique = append(unique, column)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// normalizeStatement returns a statement's text with every literal replaced
+// by "?", so statements differing only in their values share a shape. The
+// rows of a multi-row INSERT are collapsed into one.
+func normalizeStatement(stmt Statement) string {
+	var b strings.Builder
+	switch s := stmt.(type) {
+	case *SelectStatement:
+		fields := make([]string, len(s.Fields))
+		for i, field := range s.Fields {
+			fields[i] = normalizeExpression(field)
+		}
+		b.WriteString("SELECT ")
+		if s.Distinct {
+			b.WriteString("DISTINCT ")
+			if len(s.DistinctOn) > 0 {
+				keys := make([]string, len(s.DistinctOn))
+				for i, expr := range s.DistinctOn {
+					keys[i] = normalizeExpression(expr)
+				}
+				fmt.Fprintf(&b, "ON (%s) ", strings.Join(keys, ", "))
+			}
+		}
+		fmt.Fprintf(&b, "%s FROM %s", strings.Join(fields, ", "), normalizeSource(s.Table, s.TableAlias, s.Subquery))
+		for _, join := range s.Joins {
+			fmt.Fprintf(&b, " %s JOIN %s ON %s", join.Type, normalizeSource(join.Table, join.Alias, join.Subquery), normalizeExpression(join.Condition))
+		}
+		writeWhere(&b, s.Where)
+		if len(s.GroupBy) > 0 {
+			keys := make([]string, len(s.GroupBy))
+			for i, expr := range s.GroupBy {
+				keys[i] = normalizeExpression(expr)
+			}
+			fmt.Fprintf(&b, " GROUP BY %s", strings.Join(keys, ", "))
+		}
+		if s.Having != nil {
+			fmt.Fprintf(&b, " HAVING %s", normalizeExpression(s.Having))
+		}
+		if len(s.OrderBy) > 0 {
+			keys := make([]string, len(s.OrderBy))
+			for i, expr := range s.OrderBy {
+				keys[i] = orderKeyText(normalizeExpression(expr), s.sortOrder(i))
+			}
+			fmt.Fprintf(&b, " ORDER BY %s", strings.Join(keys, ", "))
+		}
+		if s.HasLimit {
+			b.WriteString(" LIMIT ?")
+		}
+		if s.Offset > 0 {
+			b.WriteString(" OFFSET ?")
+		}
+	case *InsertStatement:
+		fmt.Fprintf(&b, "INSERT INTO %s", s.Table)
+		if len(s.Columns) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(s.Columns, ", "))
+		}
+		if len(s.Values) > 0 {
+			values := make([]string, len(s.Values[0]))
+			for i, value := range s.Values[0] {
+				values[i] = normalizeExpression(value)
+			}
+			fmt.Fprintf(&b, " VALUES (%s)", strings.Join(values, ", "))
+		}
+	case *UpdateStatement:
+		columns := make([]string, 0, len(s.Set))
+		for column := range s.Set {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		for i, column := range columns {
+			columns[i] = column + " = " + normalizeExpression(s.Set[column])
+		}
+		fmt.Fprintf(&b, "UPDATE %s SET %s", s.Table, strings.Join(columns, ", "))
+		writeWhere(&b, s.Where)
+	case *DeleteStatement:
+		fmt.Fprintf(&b, "DELETE FROM %s", s.Table)
+		writeWhere(&b, s.Where)
+	default:
+		return stmt.String()
+	}
+	return b.String()
+}
+
+// normalizeSource returns the text of a table a SELECT reads. A derived
+// table is written as its normalized SELECT and alias.
+func normalizeSource(table, alias string, subquery *SelectStatement) string {
+	if subquery == nil {
+		return table
+	}
+	return "(" + normalizeStatement(subquery) + ") AS " + alias
+}
+
+func writeWhere(b *strings.Builder, where Expression) {
+	if where != nil {
+		fmt.Fprintf(b, " WHERE %s", normalizeExpression(where))
+	}
+}
+
+// normalizeExpression returns an expression's text with its literals
+// replaced by "?"
+func normalizeExpression(expr Expression) string {
+	switch e := expr.(type) {
+	case nil:
+		return ""
+	case *StringLiteral, *NumberLiteral, *BooleanLiteral, *VectorLiteral:
+		return "?"
+	case *BinaryExpression:
+		return normalizeExpression(e.Left) + " " + strings.ToUpper(e.Operator) + " " + normalizeExpression(e.Right)
+	case *FunctionCall:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = normalizeExpression(arg)
+		}
+		if e.Distinct {
+			return e.Name + "(DISTINCT " + strings.Join(args, ", ") + ")"
+		}
+		return e.Name + "(" + strings.Join(args, ", ") + ")"
+	case *SubqueryExpression:
+		return "(" + normalizeStatement(e.Select) + ")"
+	case *ExistsExpression:
+		if e.Not {
+			return "NOT EXISTS " + normalizeExpression(e.Subquery)
+		}
+		return "EXISTS " + normalizeExpression(e.Subquery)
+	default:
+		if in, ok := expr.(*InExpression); ok && in.Subquery != nil {
+			if in.Not {
+				return normalizeExpression(in.Left) + " NOT IN " + normalizeExpression(in.Subquery)
+			}
+			return normalizeExpression(in.Left) + " IN " + normalizeExpression(in.Subquery)
+		}
+		ops := operands(expr)
+		for i, operand := range ops {
+			ops[i] = &Identifier{Value: normalizeExpression(operand)}
+		}
+		return withOperands(expr, ops).String()
+	}
+}
+
+// SaveWorkload stores the recorded workload so it survives the database
+// being closed. A workload that has not changed since it was stored is not
+// rewritten.
+func SaveWorkload(store *storage.Storage) error {
+	shapes := store.GetWorkload().Shapes()
+	if len(shapes) == 0 {
+		if _, err := store.Get(workloadKey); err != nil {
+			return nil
+		}
+	}
+	data, err := json.Marshal(shapes)
+	if err != nil {
+		return err
+	}
+	if saved, err := store.Get(workloadKey); err == nil && bytes.Equal(saved, data) {
+		return nil
+	}
+	if err := store.Put(workloadKey, data); err != nil {
+		return fmt.Errorf("failed to save workload: %w", err)
+	}
+	return nil
+}
+
+// LoadWorkload loads the workload saved when the database was last closed
+func LoadWorkload(store *storage.Storage) error {
+	data, err := store.Get(workloadKey)
+	if err != nil {
+		return nil
+	}
+	var shapes []storage.QueryShape
+	if err := json.Unmarshal(data, &shapes); err != nil {
+		return fmt.Errorf("saved workload is malformed: %w", err)
+	}
+	store.GetWorkload().SetShapes(shapes)
+	return nil
+}
@@ -0,0 +1,353 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tuning of the adaptive hash index. A column's heat is its recent rate of
+// equality lookups: each lookup adds one, and the total halves every
+// adaptiveHalfLife. A column is indexed once its heat reaches
+// adaptiveBuildHeat, and the index is evicted when the heat cools below
+// adaptiveEvictHeat. A column with no index is forgotten when its heat cools
+// below adaptiveForgetHeat, so a single lookup is remembered for a half-life.
+const (
+	adaptiveHalfLife   = time.Minute
+	adaptiveBuildHeat  = 8.0
+	adaptiveEvictHeat  = 1.0
+	adaptiveForgetHeat = 0.5
+	adaptiveMaxIndexes = 16
+
+	// adaptiveHashConfigKey records whether the adaptive hash index is
+	// enabled for the database. It is enabled unless the key is "off".
+	adaptiveHashConfigKey = "_adaptive_hash_index"
+)
+
+// columnRef names a column of a table
+type columnRef struct {
+	table  string
+	column string
+}
+
+// columnHeat tracks the equality lookups of one column
+type columnHeat struct {
+	heat    float64
+	updated time.Time
+	lookups int64
+	hits    int64 // lookups answered by the adaptive index
+}
+
+// at returns the column's heat decayed to now
+func (h *columnHeat) at(now time.Time) float64 {
+	halfLives := float64(now.Sub(h.updated)) / float64(adaptiveHalfLife)
+	return h.heat * math.Pow(0.5, halfLives)
+}
+
+// adaptiveIndex maps the encoded values of a column to the keys of the rows
+// holding them
+type adaptiveIndex struct {
+	index    *HashIndex
+	built    time.Time
+	lastUsed time.Time
+}
+
+// AdaptiveHashIndexStats describes an adaptive hash index and how often it
+// answered lookups of its column
+type AdaptiveHashIndexStats struct {
+	Table    string
+	Column   string
+	Keys     int
+	Lookups  int64 // equality lookups of the column, including those made before the index was built
+	Hits     int64 // lookups the index answered
+	HitRate  float64
+	Heat     float64
+	Built    time.Time
+	LastUsed time.Time
+}
+
+// AdaptiveHashIndexes builds in-memory hash indexes on table columns that
+// have no index but are often looked up by equality, and evicts them when
+// the lookups stop. The caller reports lookups and row changes; rows are
+// read and written by the caller, which keeps the indexes in step with them.
+type AdaptiveHashIndexes struct {
+	engine   Engine
+	enabled  bool
+	heat     map[columnRef]*columnHeat
+	indexes  map[columnRef]*adaptiveIndex
+	versions map[string]int64 // per table, advanced by every row change
+	mu       sync.Mutex
+}
+
+// NewAdaptiveHashIndexes creates the adaptive hash indexes of the database
+// stored in engine, enabled unless the database has turned them off
+func NewAdaptiveHashIndexes(engine Engine) *AdaptiveHashIndexes {
+	enabled := true
+	if value, err := engine.Get(adaptiveHashConfigKey); err == nil && string(value) == "off" {
+		enabled = false
+	}
+	return &AdaptiveHashIndexes{
+		engine:   engine,
+		enabled:  enabled,
+		heat:     make(map[columnRef]*columnHeat),
+		indexes:  make(map[columnRef]*adaptiveIndex),
+		versions: make(map[string]int64),
+	}
+}
+
+// Enabled reports whether adaptive hash indexes are built for the database
+func (a *AdaptiveHashIndexes) Enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.enabled
+}
+
+// SetEnabled turns adaptive hash indexing on or off for the database.
+// Turning it off discards every adaptive index.
+func (a *AdaptiveHashIndexes) SetEnabled(enabled bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	value := "on"
+	if !enabled {
+		value = "off"
+	}
+	if err := a.engine.Put(adaptiveHashConfigKey, []byte(value)); err != nil {
+		return err
+	}
+	a.enabled = enabled
+	if !enabled {
+		a.heat = make(map[columnRef]*columnHeat)
+		a.indexes = make(map[columnRef]*adaptiveIndex)
+	}
+	return nil
+}
+
+// RecordLookup counts an equality lookup of a column with no regular
+// index, noting whether the adaptive index answered it. It reports whether
+// the column has become hot enough to be indexed and is not yet.
+func (a *AdaptiveHashIndexes) RecordLookup(table, column string, hit bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.enabled {
+		return false
+	}
+	now := time.Now()
+	ref := columnRef{table, column}
+	h, exists := a.heat[ref]
+	if !exists {
+		h = &columnHeat{}
+		a.heat[ref] = h
+	}
+	h.heat = h.at(now) + 1
+	h.updated = now
+	h.lookups++
+	if idx, exists := a.indexes[ref]; exists && hit {
+		h.hits++
+		idx.lastUsed = now
+	}
+
+	a.evictCold(now)
+	_, indexed := a.indexes[ref]
+	return !indexed && h.heat >= adaptiveBuildHeat
+}
+
+// evictCold discards the indexes of columns that have cooled down, and
+// forgets cold columns without an index. The caller holds mu.
+func (a *AdaptiveHashIndexes) evictCold(now time.Time) {
+	for ref, h := range a.heat {
+		heat := h.at(now)
+		if _, indexed := a.indexes[ref]; indexed {
+			if heat < adaptiveEvictHeat {
+				delete(a.indexes, ref)
+			}
+		} else if heat < adaptiveForgetHeat {
+			delete(a.heat, ref)
+		}
+	}
+}
+
+// TableVersion returns a number that changes whenever a row of the table
+// changes. A caller building an index takes it before reading the rows.
+func (a *AdaptiveHashIndexes) TableVersion(table string) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.versions[table]
+}
+
+// Install adds an adaptive index on table.column mapping each encoded value
+// to the keys of the rows holding it. The rows must have been read after
+// TableVersion returned version; if the table has changed since, the index
+// would be stale and is not installed. Once the most indexes are held, the
+// coldest is evicted to make room.
+func (a *AdaptiveHashIndexes) Install(table, column string, version int64, entries map[string][]string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ref := columnRef{table, column}
+	if !a.enabled || a.versions[table] != version || a.heat[ref] == nil {
+		return false
+	}
+
+	now := time.Now()
+	if _, exists := a.indexes[ref]; !exists && len(a.indexes) >= adaptiveMaxIndexes {
+		var coldest columnRef
+		coldestHeat := math.Inf(1)
+		for other := range a.indexes {
+			if heat := a.heat[other].at(now); heat < coldestHeat {
+				coldest, coldestHeat = other, heat
+			}
+		}
+		delete(a.indexes, coldest)
+	}
+
+	index := NewHashIndex(0)
+	for key, rowKeys := range entries {
+		sort.Strings(rowKeys)
+		index.Insert(key, []byte(strings.Join(rowKeys, "\n")))
+	}
+	a.indexes[ref] = &adaptiveIndex{index: index, built: now}
+	return true
+}
+
+// Has reports whether table.column has an adaptive index
+func (a *AdaptiveHashIndexes) Has(table, column string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, exists := a.indexes[columnRef{table, column}]
+	return exists
+}
+
+// Lookup returns the keys of the rows whose column holds the encoded value
+// key. It reports false if the column has no adaptive index.
+func (a *AdaptiveHashIndexes) Lookup(table, column, key string) ([]string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	idx, exists := a.indexes[columnRef{table, column}]
+	if !exists {
+		return nil, false
+	}
+	value, found := idx.index.Search(key)
+	if !found {
+		return nil, true
+	}
+	return strings.Split(string(value), "\n"), true
+}
+
+// UpdateRow keeps a table's adaptive indexes in step with a change to one
+// of its rows. oldKey and newKey return the encoded value of a column before
+// and after the change, false if it had none; either is nil when the row
+// was inserted or deleted.
+func (a *AdaptiveHashIndexes) UpdateRow(table, rowKey string, oldKey, newKey func(column string) (string, bool)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.versions[table]++
+	for ref, idx := range a.indexes {
+		if ref.table != table {
+			continue
+		}
+		if oldKey != nil {
+			if key, ok := oldKey(ref.column); ok {
+				removeRowKey(idx.index, key, rowKey)
+			}
+		}
+		if newKey != nil {
+			if key, ok := newKey(ref.column); ok {
+				addRowKey(idx.index, key, rowKey)
+			}
+		}
+	}
+}
+
+// addRowKey adds a row key to the keys held under an index key
+func addRowKey(index *HashIndex, key, rowKey string) {
+	value, found := index.Search(key)
+	if !found {
+		index.Insert(key, []byte(rowKey))
+		return
+	}
+	rowKeys := strings.Split(string(value), "\n")
+	for _, existing := range rowKeys {
+		if existing == rowKey {
+			return
+		}
+	}
+	index.Insert(key, []byte(strings.Join(append(rowKeys, rowKey), "\n")))
+}
+
+// removeRowKey removes a row key from the keys held under an index key
+func removeRowKey(index *HashIndex, key, rowKey string) {
+	value, found := index.Search(key)
+	if !found {
+		return
+	}
+	rowKeys := strings.Split(string(value), "\n")
+	for i, existing := range rowKeys {
+		if existing == rowKey {
+			rowKeys = append(rowKeys[:i], rowKeys[i+1:]...)
+			break
+		}
+	}
+	if len(rowKeys) == 0 {
+		index.Delete(key)
+	} else {
+		index.Insert(key, []byte(strings.Join(rowKeys, "\n")))
+	}
+}
+
+// DropTable discards the adaptive indexes and lookup counts of a table
+func (a *AdaptiveHashIndexes) DropTable(table string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for ref := range a.heat {
+		if ref.table == table {
+			delete(a.heat, ref)
+			delete(a.indexes, ref)
+		}
+	}
+	a.versions[table]++
+}
+
+// Stats describes each adaptive index, ordered by table and column.
+// Indexes that have cooled down are evicted first.
+func (a *AdaptiveHashIndexes) Stats() []AdaptiveHashIndexStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.evictCold(now)
+	var stats []AdaptiveHashIndexStats
+	for ref, idx := range a.indexes {
+		h := a.heat[ref]
+		stat := AdaptiveHashIndexStats{
+			Table:    ref.table,
+			Column:   ref.column,
+			Keys:     idx.index.Size(),
+			Lookups:  h.lookups,
+			Hits:     h.hits,
+			Heat:     h.at(now),
+			Built:    idx.built,
+			LastUsed: idx.lastUsed,
+		}
+		if h.lookups > 0 {
+			stat.HitRate = float64(h.hits) / float64(h.lookups)
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Table != stats[j].Table {
+			return stats[i].Table < stats[j].Table
+		}
+		return stats[i].Column < stats[j].Column
+	})
+	return stats
+}
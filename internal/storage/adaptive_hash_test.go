@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveHashIndexBuildsForHotColumn(t *testing.T) {
+	a := NewAdaptiveHashIndexes(NewMemoryEngine())
+
+	hot := false
+	lookups := 0
+	for !hot {
+		lookups++
+		if lookups > 100 {
+			t.Fatal("Column never became hot")
+		}
+		hot = a.RecordLookup("users", "city", false)
+	}
+	// Heat decays between lookups, so it can take one more than the
+	// threshold to get there
+	if lookups < int(adaptiveBuildHeat) || lookups > int(adaptiveBuildHeat)+1 {
+		t.Fatalf("Expected the column to become hot after about %v lookups, took %d", adaptiveBuildHeat, lookups)
+	}
+
+	version := a.TableVersion("users")
+	if !a.Install("users", "city", version, map[string][]string{"paris": {"users:2", "users:1"}}) {
+		t.Fatal("Install failed")
+	}
+	if a.RecordLookup("users", "city", true) {
+		t.Fatal("An indexed column should not be reported hot again")
+	}
+
+	rowKeys, exists := a.Lookup("users", "city", "paris")
+	if !exists || !reflect.DeepEqual(rowKeys, []string{"users:1", "users:2"}) {
+		t.Fatalf("Expected both rows, got %v (exists %v)", rowKeys, exists)
+	}
+	if rowKeys, exists := a.Lookup("users", "city", "rome"); !exists || rowKeys != nil {
+		t.Fatalf("Expected no rows for a missing value, got %v", rowKeys)
+	}
+
+	stats := a.Stats()
+	if len(stats) != 1 || stats[0].Lookups != int64(lookups)+1 || stats[0].Hits != 1 || stats[0].Keys != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestAdaptiveHashIndexColumnsLookedUpTogether(t *testing.T) {
+	a := NewAdaptiveHashIndexes(NewMemoryEngine())
+
+	// The lookups of each column must not cool the other below being
+	// remembered
+	for i := 0; i < int(adaptiveBuildHeat)+1; i++ {
+		cityHot := a.RecordLookup("users", "city", false)
+		nameHot := a.RecordLookup("users", "name", false)
+		if cityHot && nameHot {
+			return
+		}
+	}
+	t.Fatal("Columns looked up together never became hot")
+}
+
+func TestAdaptiveHashIndexUpdateRow(t *testing.T) {
+	a := NewAdaptiveHashIndexes(NewMemoryEngine())
+	for i := 0; i < int(adaptiveBuildHeat); i++ {
+		a.RecordLookup("users", "city", false)
+	}
+	a.Install("users", "city", a.TableVersion("users"), map[string][]string{"paris": {"users:1"}})
+
+	city := func(value string) func(string) (string, bool) {
+		return func(column string) (string, bool) { return value, column == "city" }
+	}
+	a.UpdateRow("users", "users:2", nil, city("paris"))
+	a.UpdateRow("users", "users:1", city("paris"), city("rome"))
+
+	if rowKeys, _ := a.Lookup("users", "city", "paris"); !reflect.DeepEqual(rowKeys, []string{"users:2"}) {
+		t.Fatalf("Expected users:2 under paris, got %v", rowKeys)
+	}
+	if rowKeys, _ := a.Lookup("users", "city", "rome"); !reflect.DeepEqual(rowKeys, []string{"users:1"}) {
+		t.Fatalf("Expected users:1 under rome, got %v", rowKeys)
+	}
+
+	a.UpdateRow("users", "users:2", city("paris"), nil)
+	if rowKeys, _ := a.Lookup("users", "city", "paris"); rowKeys != nil {
+		t.Fatalf("Expected no rows under paris after the delete, got %v", rowKeys)
+	}
+}
+
+func TestAdaptiveHashIndexRejectsStaleBuild(t *testing.T) {
+	a := NewAdaptiveHashIndexes(NewMemoryEngine())
+	for i := 0; i < int(adaptiveBuildHeat); i++ {
+		a.RecordLookup("users", "city", false)
+	}
+	version := a.TableVersion("users")
+	a.UpdateRow("users", "users:1", nil, nil)
+	if a.Install("users", "city", version, nil) {
+		t.Fatal("An index built from rows read before a change should not be installed")
+	}
+	if a.Has("users", "city") {
+		t.Fatal("Stale index was installed")
+	}
+}
+
+func TestAdaptiveHashIndexEvictedWhenCold(t *testing.T) {
+	a := NewAdaptiveHashIndexes(NewMemoryEngine())
+	for i := 0; i < int(adaptiveBuildHeat); i++ {
+		a.RecordLookup("users", "city", false)
+	}
+	a.Install("users", "city", a.TableVersion("users"), nil)
+
+	// Ten half-lives without a lookup cool the column far below the
+	// eviction threshold
+	a.heat[columnRef{"users", "city"}].updated = time.Now().Add(-10 * adaptiveHalfLife)
+	if len(a.Stats()) != 0 || a.Has("users", "city") {
+		t.Fatal("Expected the cold index to be evicted")
+	}
+}
+
+func TestAdaptiveHashIndexToggle(t *testing.T) {
+	engine := NewMemoryEngine()
+	a := NewAdaptiveHashIndexes(engine)
+	for i := 0; i < int(adaptiveBuildHeat); i++ {
+		a.RecordLookup("users", "city", false)
+	}
+	a.Install("users", "city", a.TableVersion("users"), nil)
+
+	if err := a.SetEnabled(false); err != nil {
+		t.Fatalf("SetEnabled failed: %v", err)
+	}
+	if a.Has("users", "city") || a.RecordLookup("users", "city", false) {
+		t.Fatal("Disabling should discard indexes and stop tracking lookups")
+	}
+	if NewAdaptiveHashIndexes(engine).Enabled() {
+		t.Fatal("The setting should be kept by the database")
+	}
+}
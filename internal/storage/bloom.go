@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// minBloomCapacity is the fewest keys a Bloom filter is sized for, so small
+// tables do not have to rebuild their filter as soon as they grow
+const minBloomCapacity = 1024
+
+// BloomFilter is a probabilistic set of keys used to skip lookups of keys
+// that do not exist. It can report a key that was never added, at roughly
+// its target false-positive rate, but never misses one that was. Bits cannot
+// be cleared, so removed keys keep answering "maybe" until the filter is
+// rebuilt.
+type BloomFilter struct {
+	bits     []uint64
+	hashes   int
+	capacity int
+	fpRate   float64
+	added    int
+	removed  int
+	mu       sync.RWMutex
+
+	checks  atomic.Int64
+	skipped atomic.Int64
+}
+
+// BloomFilterStats describes a Bloom filter and how useful it has been
+type BloomFilterStats struct {
+	Bits         int
+	Hashes       int
+	Keys         int // keys added less keys removed
+	Capacity     int
+	TargetFPR    float64
+	EstimatedFPR float64
+	Checks       int64
+	Skipped      int64 // lookups answered without reading the key
+}
+
+// ValidateFalsePositiveRate checks that a false-positive rate is usable
+func ValidateFalsePositiveRate(fpRate float64) error {
+	if !(fpRate > 0 && fpRate < 1) {
+		return fmt.Errorf("false-positive rate must be between 0 and 1, got %g", fpRate)
+	}
+	return nil
+}
+
+// NewBloomFilter creates a filter sized to hold capacity keys at the target
+// false-positive rate
+func NewBloomFilter(capacity int, fpRate float64) *BloomFilter {
+	if capacity < minBloomCapacity {
+		capacity = minBloomCapacity
+	}
+	if ValidateFalsePositiveRate(fpRate) != nil {
+		fpRate = 0.01
+	}
+
+	// m = -n ln p / (ln 2)^2 bits and k = m/n ln 2 hash functions
+	bits := int(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	hashes := int(math.Round(float64(bits) / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilter{
+		bits:     make([]uint64, (bits+63)/64),
+		hashes:   hashes,
+		capacity: capacity,
+		fpRate:   fpRate,
+	}
+}
+
+// positions calls visit with each bit a key sets, derived from two halves of
+// one 64-bit hash
+func (bf *BloomFilter) positions(key string, visit func(bit uint64) bool) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	size := uint64(len(bf.bits) * 64)
+	for i := 0; i < bf.hashes; i++ {
+		if !visit((h1 + uint64(i)*h2) % size) {
+			return
+		}
+	}
+}
+
+// Add records a key in the filter
+func (bf *BloomFilter) Add(key string) {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+
+	bf.positions(key, func(bit uint64) bool {
+		bf.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+	bf.added++
+}
+
+// Removed notes that a key added to the filter has been removed
+func (bf *BloomFilter) Removed() {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+
+	bf.removed++
+}
+
+// MayContain reports whether a key may have been added. False means the key
+// was definitely never added.
+func (bf *BloomFilter) MayContain(key string) bool {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+
+	found := true
+	bf.positions(key, func(bit uint64) bool {
+		found = bf.bits[bit/64]&(1<<(bit%64)) != 0
+		return found
+	})
+	bf.checks.Add(1)
+	if !found {
+		bf.skipped.Add(1)
+	}
+	return found
+}
+
+// NeedsRebuild reports whether the filter has outgrown its capacity, or has
+// seen enough removals, that its false-positive rate has drifted well past
+// the target
+func (bf *BloomFilter) NeedsRebuild() bool {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+
+	return bf.added > bf.capacity || (bf.removed > minBloomCapacity && bf.removed > bf.added/2)
+}
+
+// Stats returns the filter's layout and usage
+func (bf *BloomFilter) Stats() BloomFilterStats {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+
+	size := float64(len(bf.bits) * 64)
+	estimated := math.Pow(1-math.Exp(-float64(bf.hashes)*float64(bf.added)/size), float64(bf.hashes))
+	return BloomFilterStats{
+		Bits:         len(bf.bits) * 64,
+		Hashes:       bf.hashes,
+		Keys:         bf.added - bf.removed,
+		Capacity:     bf.capacity,
+		TargetFPR:    bf.fpRate,
+		EstimatedFPR: estimated,
+		Checks:       bf.checks.Load(),
+		Skipped:      bf.skipped.Load(),
+	}
+}
+
+// newBloomFilterFor builds a filter over keys, sized to leave room for the
+// set to double before it needs rebuilding
+func newBloomFilterFor(keys []string, fpRate float64) *BloomFilter {
+	filter := NewBloomFilter(2*len(keys), fpRate)
+	for _, key := range keys {
+		filter.Add(key)
+	}
+	return filter
+}
+
+// rebuild returns a new filter over keys with the same target rate, keeping
+// the usage counters
+func (bf *BloomFilter) rebuild(keys []string) *BloomFilter {
+	filter := newBloomFilterFor(keys, bf.fpRate)
+	filter.checks.Store(bf.checks.Load())
+	filter.skipped.Store(bf.skipped.Load())
+	return filter
+}
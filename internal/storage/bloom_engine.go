@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// bloomConfigPrefix prefixes the keys recording which tables have a filter
+const bloomConfigPrefix = "_bloom_filter:"
+
+// bloomConfig is the stored configuration of a table's Bloom filter
+type bloomConfig struct {
+	FPRate float64 `json:"fp_rate"`
+}
+
+// BloomEngine wraps an engine with optional per-table Bloom filters over the
+// row keys, so reads of rows that do not exist are answered without reaching
+// the engine. A table's rows are the keys prefixed with "<table>:". Filters
+// are kept in memory; which tables have one is stored in the engine, and
+// the filters are rebuilt from the keys when the engine is opened.
+type BloomEngine struct {
+	engine  Engine
+	filters map[string]*BloomFilter
+	mu      sync.RWMutex
+}
+
+// NewBloomEngine wraps an engine, rebuilding the filters recorded in it
+func NewBloomEngine(engine Engine) *BloomEngine {
+	be := &BloomEngine{
+		engine:  engine,
+		filters: make(map[string]*BloomFilter),
+	}
+
+	keys, err := engine.Keys()
+	if err != nil {
+		return be
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, bloomConfigPrefix) {
+			continue
+		}
+		data, err := engine.Get(key)
+		if err != nil {
+			continue
+		}
+		var config bloomConfig
+		if json.Unmarshal(data, &config) != nil {
+			continue
+		}
+		table := strings.TrimPrefix(key, bloomConfigPrefix)
+		be.filters[table] = newBloomFilterFor(tableKeys(keys, table), config.FPRate)
+	}
+	return be
+}
+
+// tableKeys returns the row keys of a table among keys
+func tableKeys(keys []string, table string) []string {
+	prefix := table + ":"
+	var rows []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			rows = append(rows, key)
+		}
+	}
+	return rows
+}
+
+// keyTable returns the table a key belongs to
+func keyTable(key string) string {
+	if i := strings.Index(key, ":"); i > 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// EnableTableFilter builds a Bloom filter over a table's rows with the given
+// target false-positive rate and records it so it is rebuilt on reopening.
+// An existing filter is replaced.
+func (be *BloomEngine) EnableTableFilter(table string, fpRate float64) error {
+	if err := ValidateFalsePositiveRate(fpRate); err != nil {
+		return err
+	}
+	config, err := json.Marshal(&bloomConfig{FPRate: fpRate})
+	if err != nil {
+		return err
+	}
+
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	keys, err := be.engine.Keys()
+	if err != nil {
+		return fmt.Errorf("failed to get keys: %w", err)
+	}
+	if err := be.engine.Put(bloomConfigPrefix+table, config); err != nil {
+		return fmt.Errorf("failed to store Bloom filter configuration: %w", err)
+	}
+	be.filters[table] = newBloomFilterFor(tableKeys(keys, table), fpRate)
+	return nil
+}
+
+// DisableTableFilter removes a table's Bloom filter
+func (be *BloomEngine) DisableTableFilter(table string) error {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	if _, exists := be.filters[table]; !exists {
+		return nil
+	}
+	delete(be.filters, table)
+	if err := be.engine.Delete(bloomConfigPrefix + table); err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// TableFilters returns the tables with a Bloom filter, sorted
+func (be *BloomEngine) TableFilters() []string {
+	be.mu.RLock()
+	defer be.mu.RUnlock()
+
+	tables := make([]string, 0, len(be.filters))
+	for table := range be.filters {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// TableFilterStats returns the statistics of a table's Bloom filter
+func (be *BloomEngine) TableFilterStats(table string) (BloomFilterStats, bool) {
+	be.mu.RLock()
+	defer be.mu.RUnlock()
+
+	filter, exists := be.filters[table]
+	if !exists {
+		return BloomFilterStats{}, false
+	}
+	return filter.Stats(), true
+}
+
+// excludes reports whether a key's table filter proves the key is absent
+func (be *BloomEngine) excludes(key string) bool {
+	be.mu.RLock()
+	defer be.mu.RUnlock()
+
+	filter, exists := be.filters[keyTable(key)]
+	return exists && !filter.MayContain(key)
+}
+
+// write applies a write to the engine, adding the keys it writes to their
+// tables' filters first so a concurrent read never misses them, and noting
+// the keys it deletes. The lock is held across both so a filter rebuilt
+// meanwhile cannot miss the write. Filters that have outgrown their capacity
+// are rebuilt afterwards.
+func (be *BloomEngine) write(added, removed []string, apply func() error) error {
+	be.mu.RLock()
+	for _, key := range added {
+		if filter, exists := be.filters[keyTable(key)]; exists {
+			filter.Add(key)
+		}
+	}
+	err := apply()
+	if err == nil {
+		for _, key := range removed {
+			if filter, exists := be.filters[keyTable(key)]; exists {
+				filter.Removed()
+			}
+		}
+	}
+	stale := make(map[string]bool)
+	for _, keys := range [][]string{added, removed} {
+		for _, key := range keys {
+			table := keyTable(key)
+			if filter, exists := be.filters[table]; exists && filter.NeedsRebuild() {
+				stale[table] = true
+			}
+		}
+	}
+	be.mu.RUnlock()
+
+	for table := range stale {
+		be.rebuildFilter(table)
+	}
+	return err
+}
+
+// rebuildFilter rebuilds a table's filter from its current rows. Writes are
+// held off meanwhile so none is missing from the new filter.
+func (be *BloomEngine) rebuildFilter(table string) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	filter, exists := be.filters[table]
+	if !exists || !filter.NeedsRebuild() {
+		return
+	}
+	keys, err := be.engine.Keys()
+	if err != nil {
+		return
+	}
+	be.filters[table] = filter.rebuild(tableKeys(keys, table))
+}
+
+func (be *BloomEngine) Get(key string) ([]byte, error) {
+	if be.excludes(key) {
+		return nil, ErrKeyNotFound
+	}
+	return be.engine.Get(key)
+}
+
+func (be *BloomEngine) Put(key string, value []byte) error {
+	return be.write([]string{key}, nil, func() error {
+		return be.engine.Put(key, value)
+	})
+}
+
+func (be *BloomEngine) Delete(key string) error {
+	return be.write(nil, []string{key}, func() error {
+		return be.engine.Delete(key)
+	})
+}
+
+func (be *BloomEngine) Exists(key string) (bool, error) {
+	if be.excludes(key) {
+		return false, nil
+	}
+	return be.engine.Exists(key)
+}
+
+func (be *BloomEngine) Keys() ([]string, error) {
+	return be.engine.Keys()
+}
+
+func (be *BloomEngine) Close() error {
+	return be.engine.Close()
+}
+
+func (be *BloomEngine) BeginTransaction() *Transaction {
+	return be.engine.BeginTransaction()
+}
+
+func (be *BloomEngine) CommitTransaction(tx *Transaction) error {
+	var writes, deletes []string
+	for key := range tx.GetWriteSet() {
+		writes = append(writes, key)
+	}
+	for key := range tx.GetDeletedSet() {
+		deletes = append(deletes, key)
+	}
+	return be.write(writes, deletes, func() error {
+		return be.engine.CommitTransaction(tx)
+	})
+}
+
+func (be *BloomEngine) AbortTransaction(tx *Transaction) error {
+	return be.engine.AbortTransaction(tx)
+}
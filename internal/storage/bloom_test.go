@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := NewBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		filter.Add(fmt.Sprintf("users:%d", i))
+	}
+	for i := 0; i < 10000; i++ {
+		if !filter.MayContain(fmt.Sprintf("users:%d", i)) {
+			t.Fatalf("users:%d was added but is reported absent", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 10000; i < 20000; i++ {
+		if filter.MayContain(fmt.Sprintf("users:%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.03 {
+		t.Fatalf("False-positive rate %.4f is far above the 0.01 target", rate)
+	}
+
+	stats := filter.Stats()
+	if stats.Checks != 20000 || stats.Skipped != int64(10000-falsePositives) {
+		t.Fatalf("Unexpected usage counters: %+v", stats)
+	}
+	if ValidateFalsePositiveRate(0) == nil || ValidateFalsePositiveRate(1) == nil {
+		t.Fatal("Expected rates of 0 and 1 to be rejected")
+	}
+}
+
+func TestBloomEngineSkipsMissingKeys(t *testing.T) {
+	engine := NewMemoryEngine()
+	engine.Put("users:1", []byte("1|name|Ann"))
+	bloom := NewBloomEngine(engine)
+
+	if err := bloom.EnableTableFilter("users", 0.01); err != nil {
+		t.Fatalf("EnableTableFilter failed: %v", err)
+	}
+	if err := bloom.Put("users:2", []byte("2|name|Bob")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	for _, key := range []string{"users:1", "users:2"} {
+		if _, err := bloom.Get(key); err != nil {
+			t.Fatalf("Get %s failed: %v", key, err)
+		}
+	}
+	if _, err := bloom.Get("users:3"); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if exists, err := bloom.Exists("users:3"); err != nil || exists {
+		t.Fatalf("Expected users:3 not to exist, got %v, %v", exists, err)
+	}
+	if stats, _ := bloom.TableFilterStats("users"); stats.Skipped == 0 {
+		t.Fatalf("Expected lookups to be skipped, got %+v", stats)
+	}
+
+	if err := bloom.Delete("users:1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := bloom.Get("users:1"); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound after delete, got %v", err)
+	}
+
+	// Tables without a filter read straight through
+	engine.Put("orders:1", []byte("1"))
+	if _, err := bloom.Get("orders:1"); err != nil {
+		t.Fatalf("Get orders:1 failed: %v", err)
+	}
+}
+
+func TestBloomEngineRebuildsFilters(t *testing.T) {
+	engine := NewMemoryEngine()
+	bloom := NewBloomEngine(engine)
+	if err := bloom.EnableTableFilter("users", 0.01); err != nil {
+		t.Fatalf("EnableTableFilter failed: %v", err)
+	}
+
+	// Growing past the filter's capacity rebuilds it without losing keys
+	for i := 0; i < 5000; i++ {
+		bloom.Put(fmt.Sprintf("users:%d", i), []byte("x"))
+	}
+	if stats, _ := bloom.TableFilterStats("users"); stats.Capacity < 5000 {
+		t.Fatalf("Expected the filter to have grown, got %+v", stats)
+	}
+
+	tx := bloom.BeginTransaction()
+	tx.Put("users:9999", []byte("x"))
+	if err := bloom.CommitTransaction(tx); err != nil {
+		t.Fatalf("CommitTransaction failed: %v", err)
+	}
+
+	// Reopening rebuilds the filter from the stored configuration
+	reopened := NewBloomEngine(engine)
+	if tables := reopened.TableFilters(); len(tables) != 1 || tables[0] != "users" {
+		t.Fatalf("Expected the users filter to be restored, got %v", tables)
+	}
+	for _, key := range []string{"users:0", "users:4999", "users:9999"} {
+		if _, err := reopened.Get(key); err != nil {
+			t.Fatalf("Get %s after reopening failed: %v", key, err)
+		}
+	}
+
+	if err := reopened.DisableTableFilter("users"); err != nil {
+		t.Fatalf("DisableTableFilter failed: %v", err)
+	}
+	if len(NewBloomEngine(engine).TableFilters()) != 0 {
+		t.Fatal("Expected no filters after disabling")
+	}
+}
+
+func TestIndexBloomFilter(t *testing.T) {
+	im := NewIndexManager()
+	if err := im.CreateHashIndex("users_email", 0); err != nil {
+		t.Fatalf("CreateHashIndex failed: %v", err)
+	}
+	im.Insert("users_email", "a@x", []byte("users:1"))
+	if err := im.SetBloomFilter("users_email", 0.01); err != nil {
+		t.Fatalf("SetBloomFilter failed: %v", err)
+	}
+	im.Insert("users_email", "b@x", []byte("users:2"))
+
+	for _, key := range []string{"a@x", "b@x"} {
+		if _, found := im.Search("users_email", key); !found {
+			t.Fatalf("Search for %s failed", key)
+		}
+	}
+	if _, found := im.Search("users_email", "c@x"); found {
+		t.Fatal("Expected c@x not to be found")
+	}
+
+	info, err := im.GetIndexInfo("users_email")
+	if err != nil {
+		t.Fatalf("GetIndexInfo failed: %v", err)
+	}
+	if info["bloom_checks"].(int64) != 3 {
+		t.Fatalf("Expected 3 filter checks, got %v", info["bloom_checks"])
+	}
+
+	if err := im.CreateFullTextIndex("docs_body"); err != nil {
+		t.Fatalf("CreateFullTextIndex failed: %v", err)
+	}
+	if err := im.SetBloomFilter("docs_body", 0.01); err == nil {
+		t.Fatal("Expected Bloom filters to be rejected for full-text indexes")
+	}
+}
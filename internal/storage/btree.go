@@ -104,6 +104,8 @@ func (bt *BTree) splitChild(parent *BTreeNode, index int) {
 			newNode.Children[i].Parent = newNode
 		}
 	}
+	medianKey := child.Keys[minDegree-1]
+	medianValue := child.Values[minDegree-1]
 	child.Keys = child.Keys[:minDegree-1]
 	child.Values = child.Values[:minDegree-1]
 	if !child.IsLeaf {
@@ -117,8 +119,8 @@ func (bt *BTree) splitChild(parent *BTreeNode, index int) {
 		parent.Values[i] = parent.Values[i-1]
 		parent.Children[i+1] = parent.Children[i]
 	}
-	parent.Keys[index] = child.Keys[minDegree-1]
-	parent.Values[index] = child.Values[minDegree-1]
+	parent.Keys[index] = medianKey
+	parent.Values[index] = medianValue
 	parent.Children[index+1] = newNode
 }
 
@@ -314,22 +316,20 @@ func (bt *BTree) rangeFromNode(node *BTreeNode, start, end string, result *[]Key
 	for i < len(node.Keys) && node.Keys[i] < start {
 		i++
 	}
-	if !node.IsLeaf {
-		for j := 0; j <= i; j++ {
-			bt.rangeFromNode(node.Children[j], start, end, result)
+	for ; i < len(node.Keys); i++ {
+		if !node.IsLeaf {
+			bt.rangeFromNode(node.Children[i], start, end, result)
+		}
+		if node.Keys[i] > end {
+			return
 		}
-	}
-	for i < len(node.Keys) && node.Keys[i] <= end {
 		*result = append(*result, KeyValue{
 			Key:   node.Keys[i],
 			Value: node.Values[i],
 		})
-		i++
 	}
 	if !node.IsLeaf {
-		for j := i; j < len(node.Children); j++ {
-			bt.rangeFromNode(node.Children[j], start, end, result)
-		}
+		bt.rangeFromNode(node.Children[i], start, end, result)
 	}
 }
 
@@ -367,6 +367,89 @@ func (bt *BTree) getAllFromNode(node *BTreeNode, result *[]KeyValue) {
 	}
 }
 
+// BulkLoadBTree builds a B-Tree bottom-up from entries sorted by key. The
+// leaves are packed in one pass and the keys separating them become the level
+// above, repeating until a single root remains, which is much cheaper than
+// inserting the entries one at a time.
+func BulkLoadBTree(minDegree int, entries []KeyValue) (*BTree, error) {
+	if minDegree < 2 {
+		return nil, fmt.Errorf("minimum degree must be at least 2, got %d", minDegree)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Key < entries[i-1].Key {
+			return nil, fmt.Errorf("bulk load entries are not sorted: %q follows %q", entries[i].Key, entries[i-1].Key)
+		}
+	}
+
+	bt := NewBTree(minDegree)
+	if len(entries) == 0 {
+		return bt, nil
+	}
+
+	level := entries
+	var children []*BTreeNode
+	for {
+		nodes, separators := packLevel(minDegree, level, children)
+		if len(nodes) == 1 {
+			bt.Root = nodes[0]
+			break
+		}
+		level, children = separators, nodes
+	}
+	bt.size = len(entries)
+	return bt, nil
+}
+
+// packLevel packs the entries of one level into as few nodes as the B-Tree
+// invariants allow, spreading them evenly so every node holds between
+// minDegree-1 and 2*minDegree-1 keys. The key between each pair of nodes is
+// returned to become an entry of the level above. children, nil for the leaf
+// level, holds one more node than there are entries.
+func packLevel(minDegree int, entries []KeyValue, children []*BTreeNode) ([]*BTreeNode, []KeyValue) {
+	maxKeys := 2*minDegree - 1
+	count := 1
+	if len(entries) > maxKeys {
+		count = (len(entries) + 2*minDegree) / (2 * minDegree)
+	}
+	keys := len(entries) - (count - 1)
+	perNode, extra := keys/count, keys%count
+
+	nodes := make([]*BTreeNode, 0, count)
+	var separators []KeyValue
+	next, nextChild := 0, 0
+	for n := 0; n < count; n++ {
+		size := perNode
+		if n < extra {
+			size++
+		}
+		node := &BTreeNode{
+			IsLeaf:    children == nil,
+			Keys:      make([]string, size),
+			Values:    make([][]byte, size),
+			MinDegree: minDegree,
+		}
+		for i := 0; i < size; i++ {
+			node.Keys[i] = entries[next+i].Key
+			node.Values[i] = entries[next+i].Value
+		}
+		next += size
+		if children != nil {
+			node.Children = make([]*BTreeNode, size+1)
+			copy(node.Children, children[nextChild:nextChild+size+1])
+			for _, child := range node.Children {
+				child.Parent = node
+			}
+			nextChild += size + 1
+		}
+		nodes = append(nodes, node)
+		if n < count-1 {
+			separators = append(separators, entries[next])
+			next++
+		}
+	}
+	return nodes, separators
+}
+
 type KeyValue struct {
 	Key   string
 	Value []byte
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// checkBTree verifies the B-Tree invariants: every node but the root holds
+// between minDegree-1 and 2*minDegree-1 keys, internal nodes have one more
+// child than keys, and all leaves are at the same depth
+func checkBTree(t *testing.T, bt *BTree) {
+	t.Helper()
+	if bt.Root == nil {
+		return
+	}
+	leafDepth := -1
+	var walk func(node *BTreeNode, depth int)
+	walk = func(node *BTreeNode, depth int) {
+		if node != bt.Root && (len(node.Keys) < bt.MinDegree-1 || len(node.Keys) > 2*bt.MinDegree-1) {
+			t.Fatalf("node at depth %d has %d keys", depth, len(node.Keys))
+		}
+		if node.IsLeaf {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				t.Fatalf("leaves at depths %d and %d", leafDepth, depth)
+			}
+			return
+		}
+		if len(node.Children) != len(node.Keys)+1 {
+			t.Fatalf("node with %d keys has %d children", len(node.Keys), len(node.Children))
+		}
+		for _, child := range node.Children {
+			if child.Parent != node {
+				t.Fatal("child does not point at its parent")
+			}
+			walk(child, depth+1)
+		}
+	}
+	walk(bt.Root, 0)
+}
+
+func TestBulkLoadBTree(t *testing.T) {
+	for _, minDegree := range []int{2, 3, 5} {
+		for n := 0; n <= 300; n += 7 {
+			var entries []KeyValue
+			for i := 0; i < n; i++ {
+				entries = append(entries, KeyValue{Key: fmt.Sprintf("k%04d", i), Value: []byte(fmt.Sprintf("v%d", i))})
+			}
+
+			bt, err := BulkLoadBTree(minDegree, entries)
+			if err != nil {
+				t.Fatalf("BulkLoadBTree failed: %v", err)
+			}
+			checkBTree(t, bt)
+			if bt.Size() != n {
+				t.Fatalf("Expected size %d, got %d", n, bt.Size())
+			}
+
+			all := bt.GetAll()
+			if len(all) != n {
+				t.Fatalf("Expected %d entries, got %d", n, len(all))
+			}
+			for i, entry := range all {
+				if entry.Key != entries[i].Key || string(entry.Value) != string(entries[i].Value) {
+					t.Fatalf("Entry %d: expected %s, got %s", i, entries[i].Key, entry.Key)
+				}
+				if value, found := bt.Search(entry.Key); !found || string(value) != string(entry.Value) {
+					t.Fatalf("Search for %s failed", entry.Key)
+				}
+			}
+
+			if n > 20 {
+				if got := bt.Range("k0010", "k0019"); len(got) != 10 {
+					t.Fatalf("Expected 10 entries in range, got %d", len(got))
+				}
+			}
+		}
+	}
+}
+
+func TestBulkLoadBTreeAcceptsInserts(t *testing.T) {
+	var entries []KeyValue
+	for i := 0; i < 100; i += 2 {
+		entries = append(entries, KeyValue{Key: fmt.Sprintf("k%03d", i), Value: []byte("even")})
+	}
+	bt, err := BulkLoadBTree(3, entries)
+	if err != nil {
+		t.Fatalf("BulkLoadBTree failed: %v", err)
+	}
+
+	for i := 1; i < 100; i += 2 {
+		bt.Insert(fmt.Sprintf("k%03d", i), []byte("odd"))
+	}
+	checkBTree(t, bt)
+
+	all := bt.GetAll()
+	if len(all) != 100 || bt.Size() != 100 {
+		t.Fatalf("Expected 100 entries, got %d (size %d)", len(all), bt.Size())
+	}
+	if !sort.SliceIsSorted(all, func(i, j int) bool { return all[i].Key < all[j].Key }) {
+		t.Fatal("Entries are not in key order after inserts")
+	}
+
+	for i := 0; i < 100; i += 3 {
+		if !bt.Delete(fmt.Sprintf("k%03d", i)) {
+			t.Fatalf("Delete of k%03d failed", i)
+		}
+	}
+	checkBTree(t, bt)
+	if _, found := bt.Search("k003"); found {
+		t.Fatal("Deleted key should not be found")
+	}
+}
+
+func TestBulkLoadBTreeDuplicates(t *testing.T) {
+	entries := []KeyValue{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2")},
+		{Key: "b", Value: []byte("3")},
+		{Key: "b", Value: []byte("4")},
+		{Key: "c", Value: []byte("5")},
+		{Key: "c", Value: []byte("6")},
+		{Key: "d", Value: []byte("7")},
+	}
+	bt, err := BulkLoadBTree(2, entries)
+	if err != nil {
+		t.Fatalf("BulkLoadBTree failed: %v", err)
+	}
+	checkBTree(t, bt)
+	if got := bt.Range("b", "c"); len(got) != 5 {
+		t.Fatalf("Expected 5 entries between b and c, got %d", len(got))
+	}
+}
+
+func TestBulkLoadBTreeRejectsUnsortedInput(t *testing.T) {
+	entries := []KeyValue{{Key: "b"}, {Key: "a"}}
+	if _, err := BulkLoadBTree(3, entries); err == nil {
+		t.Fatal("Expected an error for unsorted entries")
+	}
+	if _, err := BulkLoadBTree(1, nil); err == nil {
+		t.Fatal("Expected an error for a minimum degree below 2")
+	}
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Collation decides how strings compare: which are equal and how they sort.
+// Values of other types are not affected by a collation.
+type Collation string
+
+const (
+	// CollationBinary compares strings byte by byte
+	CollationBinary Collation = "binary"
+	// CollationNoCase ignores the case of ASCII letters
+	CollationNoCase Collation = "nocase"
+	// CollationUnicode ignores case and accents: letters are case-folded
+	// across all scripts, and precomposed Latin letters and combining marks
+	// are reduced to their base letters
+	CollationUnicode Collation = "unicode"
+)
+
+// ParseCollation returns the collation with the given name. An empty name
+// is the binary collation.
+func ParseCollation(name string) (Collation, error) {
+	switch collation := Collation(strings.ToLower(name)); collation {
+	case "":
+		return CollationBinary, nil
+	case CollationBinary, CollationNoCase, CollationUnicode:
+		return collation, nil
+	}
+	return "", fmt.Errorf("unknown collation '%s' (use binary, nocase or unicode)", name)
+}
+
+// Key returns the sort key of a string: two strings are equal under the
+// collation when their keys are, and sort in the byte order of their keys
+func (c Collation) Key(s string) string {
+	switch c {
+	case CollationNoCase:
+		return strings.Map(func(r rune) rune {
+			if r >= 'A' && r <= 'Z' {
+				return r + ('a' - 'A')
+			}
+			return r
+		}, s)
+	case CollationUnicode:
+		return unicodeKey(s)
+	}
+	return s
+}
+
+// KeyValue returns a value with strings replaced by their sort key, ready
+// for EncodeKey
+func (c Collation) KeyValue(value interface{}) interface{} {
+	if s, ok := value.(string); ok && c != CollationBinary && c != "" {
+		return c.Key(s)
+	}
+	return value
+}
+
+// Compare compares two strings under the collation, returning -1, 0 or 1
+func (c Collation) Compare(a, b string) int {
+	return strings.Compare(c.Key(a), c.Key(b))
+}
+
+// Like reports whether value matches a LIKE pattern under the collation. In
+// the pattern "%" matches any run of characters and "_" any one character;
+// escape, if not zero, makes the character after it match literally.
+func (c Collation) Like(value, pattern string, escape rune) bool {
+	var runes []rune
+	var literal []bool
+	pending := false
+	for _, r := range pattern {
+		if escape != 0 && r == escape && !pending {
+			pending = true
+			continue
+		}
+		if !pending && (r == '%' || r == '_') {
+			runes = append(runes, r)
+			literal = append(literal, false)
+		} else {
+			for _, k := range c.Key(string(r)) {
+				runes = append(runes, k)
+				literal = append(literal, true)
+			}
+		}
+		pending = false
+	}
+	return likeMatch([]rune(c.Key(value)), runes, literal)
+}
+
+// likeMatch matches value against a pattern, backtracking to the last "%"
+// on a mismatch
+func likeMatch(value, pattern []rune, literal []bool) bool {
+	v, p := 0, 0
+	star, mark := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && !literal[p] && pattern[p] == '%':
+			star, mark = p, v
+			p++
+		case p < len(pattern) && (pattern[p] == value[v] || (!literal[p] && pattern[p] == '_')):
+			v++
+			p++
+		case star >= 0:
+			mark++
+			v = mark
+			p = star + 1
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && !literal[p] && pattern[p] == '%' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// unicodeBase maps precomposed Latin letters to the letters they are built
+// on, after case folding
+var unicodeBase = map[rune]string{}
+
+func init() {
+	for base, letters := range map[string]string{
+		"a": "àáâãäåāăąǎ", "c": "çćĉċč", "d": "ďđð", "e": "èéêëēĕėęě",
+		"g": "ĝğġģ", "h": "ĥħ", "i": "ìíîïĩīĭįıǐ", "j": "ĵ", "k": "ķ",
+		"l": "ĺļľŀł", "n": "ñńņňŉ", "o": "òóôõöøōŏőǒ", "r": "ŕŗř",
+		"s": "śŝşšſ", "t": "ţťŧ", "u": "ùúûüũūŭůűųǔ", "w": "ŵ", "y": "ýÿŷ",
+		"z": "źżž", "ae": "æ", "oe": "œ", "ss": "ß", "th": "þ",
+	} {
+		for _, letter := range letters {
+			unicodeBase[letter] = base
+		}
+	}
+}
+
+// unicodeKey folds the case of every letter, drops combining marks and
+// reduces precomposed Latin letters to their base letters
+func unicodeKey(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if r == 'ς' {
+			r = 'σ'
+		}
+		if base, ok := unicodeBase[r]; ok {
+			b.WriteString(base)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
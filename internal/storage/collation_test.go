@@ -0,0 +1,82 @@
+package storage
+
+import "testing"
+
+func TestParseCollation(t *testing.T) {
+	for name, expected := range map[string]Collation{
+		"":        CollationBinary,
+		"BINARY":  CollationBinary,
+		"nocase":  CollationNoCase,
+		"Unicode": CollationUnicode,
+	} {
+		collation, err := ParseCollation(name)
+		if err != nil || collation != expected {
+			t.Fatalf("ParseCollation(%q) = %q, %v; expected %q", name, collation, err, expected)
+		}
+	}
+	if _, err := ParseCollation("latin1"); err == nil {
+		t.Fatal("Expected an error for an unknown collation")
+	}
+}
+
+func TestCollationCompare(t *testing.T) {
+	tests := []struct {
+		collation Collation
+		a, b      string
+		expected  int
+	}{
+		{CollationBinary, "Alice", "alice", -1},
+		{CollationNoCase, "Alice", "aLiCe", 0},
+		{CollationNoCase, "Émile", "émile", -1}, // only ASCII letters are folded
+		{CollationNoCase, "apple", "Banana", -1},
+		{CollationUnicode, "Émile", "emile", 0},
+		{CollationUnicode, "Straße", "STRASSE", 0},
+		{CollationUnicode, "Ὀδυσσεύς", "ὈΔΥΣΣΕΎΣ", 0},
+		{CollationUnicode, "café", "CAFÉ", 0},
+		{CollationUnicode, "résumé", "rose", -1},
+	}
+	for _, test := range tests {
+		if got := test.collation.Compare(test.a, test.b); got != test.expected {
+			t.Fatalf("%s: Compare(%q, %q) = %d, expected %d", test.collation, test.a, test.b, got, test.expected)
+		}
+	}
+}
+
+func TestCollationKeyValue(t *testing.T) {
+	if EncodeKey(CollationNoCase.KeyValue("ABC")) != EncodeKey(CollationNoCase.KeyValue("abc")) {
+		t.Fatal("Expected equal strings under the collation to encode to the same key")
+	}
+	if EncodeKey(CollationBinary.KeyValue("ABC")) == EncodeKey(CollationBinary.KeyValue("abc")) {
+		t.Fatal("Expected the binary collation to keep case")
+	}
+	if CollationNoCase.KeyValue(int64(7)) != int64(7) {
+		t.Fatal("Expected values other than strings to be unchanged")
+	}
+}
+
+func TestCollationLike(t *testing.T) {
+	tests := []struct {
+		collation Collation
+		value     string
+		pattern   string
+		escape    rune
+		expected  bool
+	}{
+		{CollationBinary, "hello", "h%o", 0, true},
+		{CollationBinary, "hello", "H%", 0, false},
+		{CollationBinary, "hello", "h_llo", 0, true},
+		{CollationBinary, "hello", "h_lo", 0, false},
+		{CollationBinary, "abcabc", "%abc", 0, true},
+		{CollationBinary, "", "%", 0, true},
+		{CollationBinary, "100%", "100!%", '!', true},
+		{CollationBinary, "1000", "100!%", '!', false},
+		{CollationNoCase, "Hello World", "hello%", 0, true},
+		{CollationUnicode, "Crème Brûlée", "creme%brulee", 0, true},
+		{CollationUnicode, "naïve", "NA_VE", 0, true},
+	}
+	for _, test := range tests {
+		if got := test.collation.Like(test.value, test.pattern, test.escape); got != test.expected {
+			t.Fatalf("%s: %q LIKE %q = %v, expected %v", test.collation, test.value, test.pattern, got, test.expected)
+		}
+	}
+}
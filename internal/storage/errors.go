@@ -11,4 +11,5 @@ var (
 	ErrTransactionNotFound        = errors.New("transaction not found")
 	ErrTransactionAborted         = errors.New("transaction aborted")
 	ErrTransactionAlreadyCommitted = errors.New("transaction already committed")
+	ErrCorruptRow                 = errors.New("corrupt row")
 )
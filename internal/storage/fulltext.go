@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 ranking parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// stopWords are common English words left out of full-text indexes
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// TextMatch is a document matched by a full-text query
+type TextMatch struct {
+	Key   string
+	Score float64
+}
+
+// fullTextDocument is one indexed document
+type fullTextDocument struct {
+	text   string
+	length int // number of indexed terms
+	terms  []string
+}
+
+// FullTextIndex is an inverted index over text documents. Documents are
+// keyed by row key; each term maps to the positions where it occurs in every
+// document containing it, so phrases can be matched.
+type FullTextIndex struct {
+	postings    map[string]map[string][]int
+	docs        map[string]*fullTextDocument
+	totalLength int
+	mu          sync.RWMutex
+}
+
+// NewFullTextIndex creates an empty full-text index
+func NewFullTextIndex() *FullTextIndex {
+	return &FullTextIndex{
+		postings: make(map[string]map[string][]int),
+		docs:     make(map[string]*fullTextDocument),
+	}
+}
+
+// tokenize splits text into lowercased words made of letters and digits.
+// Stop words are kept so that word positions stay meaningful.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Insert indexes value as the text of the document key, replacing any text
+// previously indexed for it
+func (fi *FullTextIndex) Insert(key string, value []byte) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	fi.remove(key)
+
+	doc := &fullTextDocument{text: string(value)}
+	for position, word := range tokenize(doc.text) {
+		if stopWords[word] {
+			continue
+		}
+		postings, exists := fi.postings[word]
+		if !exists {
+			postings = make(map[string][]int)
+			fi.postings[word] = postings
+		}
+		if _, seen := postings[key]; !seen {
+			doc.terms = append(doc.terms, word)
+		}
+		postings[key] = append(postings[key], position)
+		doc.length++
+	}
+
+	fi.docs[key] = doc
+	fi.totalLength += doc.length
+}
+
+// Search returns the text indexed for a document key
+func (fi *FullTextIndex) Search(key string) ([]byte, bool) {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+
+	doc, exists := fi.docs[key]
+	if !exists {
+		return nil, false
+	}
+	return []byte(doc.text), true
+}
+
+// Delete removes a document from the index
+func (fi *FullTextIndex) Delete(key string) bool {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.remove(key)
+}
+
+func (fi *FullTextIndex) remove(key string) bool {
+	doc, exists := fi.docs[key]
+	if !exists {
+		return false
+	}
+	for _, term := range doc.terms {
+		postings := fi.postings[term]
+		delete(postings, key)
+		if len(postings) == 0 {
+			delete(fi.postings, term)
+		}
+	}
+	fi.totalLength -= doc.length
+	delete(fi.docs, key)
+	return true
+}
+
+// GetAll returns every indexed document with its text
+func (fi *FullTextIndex) GetAll() []KeyValue {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+
+	result := make([]KeyValue, 0, len(fi.docs))
+	for key, doc := range fi.docs {
+		result = append(result, KeyValue{Key: key, Value: []byte(doc.text)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// Size returns the number of indexed documents
+func (fi *FullTextIndex) Size() int {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	return len(fi.docs)
+}
+
+// TermCount returns the number of distinct indexed terms
+func (fi *FullTextIndex) TermCount() int {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	return len(fi.postings)
+}
+
+// Match returns the documents matching query, best BM25 score first
+func (fi *FullTextIndex) Match(query *TextQuery) []TextMatch {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+
+	positions := func(term, key string) []int {
+		return fi.postings[term][key]
+	}
+
+	// Every match contains a term of a required clause, or of some optional
+	// clause when nothing is required
+	candidates := make(map[string]bool)
+	if required := query.requiredClause(); required != nil {
+		for key := range fi.postings[required.terms[0]] {
+			candidates[key] = true
+		}
+	} else {
+		for _, clause := range query.clauses {
+			if clause.occur == occurShould {
+				for key := range fi.postings[clause.terms[0]] {
+					candidates[key] = true
+				}
+			}
+		}
+	}
+
+	var matches []TextMatch
+	for key := range candidates {
+		if !query.matches(key, positions) {
+			continue
+		}
+		matches = append(matches, TextMatch{Key: key, Score: fi.score(query, key)})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Key < matches[j].Key
+	})
+	return matches
+}
+
+// score computes the BM25 score of a document for the terms of the query's
+// required and optional clauses
+func (fi *FullTextIndex) score(query *TextQuery, key string) float64 {
+	docCount := float64(len(fi.docs))
+	avgLength := float64(fi.totalLength) / docCount
+	docLength := float64(fi.docs[key].length)
+
+	score := 0.0
+	for _, clause := range query.clauses {
+		if clause.occur == occurMustNot {
+			continue
+		}
+		for _, term := range clause.terms {
+			postings := fi.postings[term]
+			tf := float64(len(postings[key]))
+			if tf == 0 {
+				continue
+			}
+			df := float64(len(postings))
+			idf := math.Log(1 + (docCount-df+0.5)/(df+0.5))
+			norm := 1 - bm25B
+			if avgLength > 0 {
+				norm += bm25B * docLength / avgLength
+			}
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return score
+}
+
+// textOccur says how a query clause must occur in a matching document
+type textOccur int
+
+const (
+	occurShould textOccur = iota
+	occurMust
+	occurMustNot
+)
+
+// textClause is a word or phrase of a full-text query. The offsets give the
+// position of each term relative to the start of the phrase.
+type textClause struct {
+	terms   []string
+	offsets []int
+	occur   textOccur
+}
+
+// TextQuery is a parsed full-text query
+type TextQuery struct {
+	clauses []textClause
+}
+
+// ParseTextQuery parses a full-text query. A query is a list of words and
+// "quoted phrases". Prefixing a word or phrase with + requires it and
+// prefixing it with - excludes it; AND requires the clauses on both sides and
+// NOT excludes the clause after it. OR, the default, makes clauses optional,
+// and a document matches when it contains every required clause, no excluded
+// clause, and at least one optional clause if nothing is required.
+// Words split by punctuation, such as "full-text", are matched as phrases.
+func ParseTextQuery(query string) (*TextQuery, error) {
+	var raw []string
+	for i := 0; i < len(query); {
+		switch {
+		case query[i] == ' ' || query[i] == '\t' || query[i] == '\n':
+			i++
+		default:
+			start := i
+			if query[i] == '+' || query[i] == '-' {
+				i++
+			}
+			if i < len(query) && query[i] == '"' {
+				end := strings.IndexByte(query[i+1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated phrase in full-text query")
+				}
+				i += end + 2
+			} else {
+				for i < len(query) && query[i] != ' ' && query[i] != '\t' && query[i] != '\n' {
+					i++
+				}
+			}
+			raw = append(raw, query[start:i])
+		}
+	}
+
+	q := &TextQuery{}
+	pendingOccur := occurShould
+	for _, token := range raw {
+		switch token {
+		case "AND":
+			if len(q.clauses) == 0 {
+				return nil, fmt.Errorf("AND must follow a word or phrase in full-text query")
+			}
+			if last := &q.clauses[len(q.clauses)-1]; last.occur == occurShould {
+				last.occur = occurMust
+			}
+			pendingOccur = occurMust
+			continue
+		case "OR":
+			continue
+		case "NOT":
+			pendingOccur = occurMustNot
+			continue
+		}
+
+		occur := pendingOccur
+		pendingOccur = occurShould
+		switch token[0] {
+		case '+':
+			occur = occurMust
+			token = token[1:]
+		case '-':
+			occur = occurMustNot
+			token = token[1:]
+		}
+		token = strings.Trim(token, `"`)
+
+		clause := textClause{occur: occur}
+		first := -1
+		for position, word := range tokenize(token) {
+			if stopWords[word] {
+				continue
+			}
+			if first < 0 {
+				first = position
+			}
+			clause.terms = append(clause.terms, word)
+			clause.offsets = append(clause.offsets, position-first)
+		}
+		if len(clause.terms) > 0 {
+			q.clauses = append(q.clauses, clause)
+		}
+	}
+
+	return q, nil
+}
+
+// Empty reports whether the query has no searchable terms, which happens when
+// it consists only of stop words
+func (q *TextQuery) Empty() bool {
+	for _, clause := range q.clauses {
+		if clause.occur != occurMustNot {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether text satisfies the query
+func (q *TextQuery) Matches(text string) bool {
+	positions := make(map[string][]int)
+	for position, word := range tokenize(text) {
+		positions[word] = append(positions[word], position)
+	}
+	return q.matches("", func(term, _ string) []int {
+		return positions[term]
+	})
+}
+
+func (q *TextQuery) requiredClause() *textClause {
+	for i := range q.clauses {
+		if q.clauses[i].occur == occurMust {
+			return &q.clauses[i]
+		}
+	}
+	return nil
+}
+
+// matches evaluates the query against one document, looking up term
+// positions through the given function
+func (q *TextQuery) matches(key string, positions func(term, key string) []int) bool {
+	if q.Empty() {
+		return false
+	}
+
+	required := false
+	optionalFound := false
+	for i := range q.clauses {
+		clause := &q.clauses[i]
+		found := clause.presentIn(key, positions)
+		switch clause.occur {
+		case occurMust:
+			if !found {
+				return false
+			}
+			required = true
+		case occurMustNot:
+			if found {
+				return false
+			}
+		default:
+			optionalFound = optionalFound || found
+		}
+	}
+	return required || optionalFound
+}
+
+// presentIn reports whether the clause's term, or its whole phrase in order,
+// occurs in the document
+func (c *textClause) presentIn(key string, positions func(term, key string) []int) bool {
+	starts := positions(c.terms[0], key)
+	if len(c.terms) == 1 {
+		return len(starts) > 0
+	}
+
+	for _, start := range starts {
+		found := true
+		for i := 1; i < len(c.terms) && found; i++ {
+			found = false
+			for _, p := range positions(c.terms[i], key) {
+				if p == start+c.offsets[i] {
+					found = true
+					break
+				}
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"testing"
+)
+
+func newTestFullTextIndex() *FullTextIndex {
+	index := NewFullTextIndex()
+	index.Insert("docs:1", []byte("The quick brown fox jumps over the lazy dog"))
+	index.Insert("docs:2", []byte("A quick brown dog outpaces a quick red fox"))
+	index.Insert("docs:3", []byte("Lazy afternoons in the garden"))
+	index.Insert("docs:4", []byte("Brown bread, brown rice and brown sugar"))
+	return index
+}
+
+func matchKeys(t *testing.T, index *FullTextIndex, query string) []string {
+	t.Helper()
+	q, err := ParseTextQuery(query)
+	if err != nil {
+		t.Fatalf("ParseTextQuery(%q) failed: %v", query, err)
+	}
+	var keys []string
+	for _, match := range index.Match(q) {
+		keys = append(keys, match.Key)
+	}
+	return keys
+}
+
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool)
+	for _, key := range a {
+		seen[key] = true
+	}
+	for _, key := range b {
+		if !seen[key] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFullTextIndexTerms(t *testing.T) {
+	index := newTestFullTextIndex()
+
+	if index.Size() != 4 {
+		t.Fatalf("Expected 4 documents, got %d", index.Size())
+	}
+
+	if keys := matchKeys(t, index, "LAZY"); !sameKeys(keys, []string{"docs:1", "docs:3"}) {
+		t.Fatalf("Expected lowercased match on docs 1 and 3, got %v", keys)
+	}
+
+	if keys := matchKeys(t, index, "the"); len(keys) != 0 {
+		t.Fatalf("Stop words should not match anything, got %v", keys)
+	}
+
+	if keys := matchKeys(t, index, "garden rice"); !sameKeys(keys, []string{"docs:3", "docs:4"}) {
+		t.Fatalf("Expected either term to match, got %v", keys)
+	}
+}
+
+func TestFullTextIndexBooleanAndPhrase(t *testing.T) {
+	index := newTestFullTextIndex()
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"+quick +lazy", []string{"docs:1"}},
+		{"quick AND lazy", []string{"docs:1"}},
+		{"brown -fox", []string{"docs:4"}},
+		{"brown NOT fox", []string{"docs:4"}},
+		{`"brown fox"`, []string{"docs:1"}},
+		{`"fox jumps over the lazy dog"`, []string{"docs:1"}},
+		{`"lazy fox"`, nil},
+		{`+"quick brown" -lazy`, []string{"docs:2"}},
+	}
+
+	for _, test := range tests {
+		keys := matchKeys(t, index, test.query)
+		if !sameKeys(keys, test.expected) {
+			t.Fatalf("Query %q: expected %v, got %v", test.query, test.expected, keys)
+		}
+	}
+
+	if _, err := ParseTextQuery(`"unterminated`); err == nil {
+		t.Fatal("Expected error for an unterminated phrase")
+	}
+}
+
+func TestFullTextIndexRanking(t *testing.T) {
+	index := newTestFullTextIndex()
+
+	keys := matchKeys(t, index, "brown")
+	if len(keys) != 3 || keys[0] != "docs:4" {
+		t.Fatalf("Expected docs:4 to rank first for 'brown', got %v", keys)
+	}
+
+	keys = matchKeys(t, index, "quick fox")
+	if len(keys) != 2 || keys[0] != "docs:2" {
+		t.Fatalf("Expected docs:2 to rank first for 'quick fox', got %v", keys)
+	}
+}
+
+func TestFullTextIndexUpdateAndDelete(t *testing.T) {
+	index := newTestFullTextIndex()
+
+	index.Insert("docs:3", []byte("Sunny mornings"))
+	if keys := matchKeys(t, index, "lazy"); !sameKeys(keys, []string{"docs:1"}) {
+		t.Fatalf("Reindexed document should no longer match old text, got %v", keys)
+	}
+	if keys := matchKeys(t, index, "sunny"); !sameKeys(keys, []string{"docs:3"}) {
+		t.Fatalf("Reindexed document should match new text, got %v", keys)
+	}
+
+	if !index.Delete("docs:4") {
+		t.Fatal("Delete should report an indexed document")
+	}
+	if index.Delete("docs:4") {
+		t.Fatal("Delete should not report a missing document")
+	}
+	if keys := matchKeys(t, index, "rice"); len(keys) != 0 {
+		t.Fatalf("Deleted document should not match, got %v", keys)
+	}
+	if index.Size() != 3 {
+		t.Fatalf("Expected 3 documents, got %d", index.Size())
+	}
+
+	q, _ := ParseTextQuery(`+"red fox" +quick`)
+	if !q.Matches("a quick red fox") || q.Matches("a red quick fox") {
+		t.Fatal("TextQuery.Matches should agree with the index")
+	}
+}
@@ -2,14 +2,43 @@ package storage
 
 import (
 	"hash/fnv"
+	"sort"
+	"strings"
 	"sync"
 )
 
-// HashIndex implements a hash-based index for fast equality lookups
+// Load factors, in entries per bucket, at which a hash index grows or shrinks
+const (
+	hashMaxLoadFactor = 2.0
+	hashMinLoadFactor = 0.5
+)
+
+// HashIndex implements a hash-based index for fast equality lookups. It grows
+// and shrinks by linear hashing: when the load factor passes a threshold a
+// single bucket is split or merged, so the cost of resizing is spread across
+// inserts and deletes instead of rehashing the whole index at once. Entries
+// keyed by a value and a row key are placed by their value, so the entries of
+// rows holding the same value share a bucket.
 type HashIndex struct {
 	buckets []map[string][]byte
 	mu      sync.RWMutex
 	size    int
+
+	initial int // bucket count the index was created with, and never shrinks below
+	level   int // number of times the bucket count has doubled from initial
+	next    int // next bucket to split in the current round
+	splits  int
+	merges  int
+}
+
+// HashIndexStats describes the layout of a hash index
+type HashIndexStats struct {
+	BucketCount  int
+	LoadFactor   float64
+	Level        int
+	SplitPointer int
+	Splits       int
+	Merges       int
 }
 
 // NewHashIndex creates a new hash index with the specified number of buckets
@@ -20,6 +49,7 @@ func NewHashIndex(bucketCount int) *HashIndex {
 	return &HashIndex{
 		buckets: make([]map[string][]byte, bucketCount),
 		size:    0,
+		initial: bucketCount,
 	}
 }
 
@@ -30,9 +60,17 @@ func (hi *HashIndex) hash(key string) uint32 {
 	return h.Sum32()
 }
 
-// getBucket returns the bucket index for a given key
+// getBucket returns the bucket index for a given key. Buckets before the
+// split pointer have already been split this round, so their keys are
+// addressed with the next round's modulus.
 func (hi *HashIndex) getBucket(key string) int {
-	return int(hi.hash(key)) % len(hi.buckets)
+	h := hi.hash(IndexEntryValue(key))
+	roundSize := uint32(hi.initial << hi.level)
+	bucket := h % roundSize
+	if int(bucket) < hi.next {
+		bucket = h % (roundSize * 2)
+	}
+	return int(bucket)
 }
 
 // Insert inserts a key-value pair into the hash index
@@ -51,6 +89,63 @@ func (hi *HashIndex) Insert(key string, value []byte) {
 	}
 
 	hi.buckets[bucketIdx][key] = value
+
+	if hi.loadFactor() > hashMaxLoadFactor {
+		hi.split()
+	}
+}
+
+// loadFactor returns the average number of entries per bucket
+func (hi *HashIndex) loadFactor() float64 {
+	return float64(hi.size) / float64(len(hi.buckets))
+}
+
+// split splits the bucket at the split pointer, moving the keys that the
+// next round's modulus addresses to a new bucket at the end
+func (hi *HashIndex) split() {
+	roundSize := hi.initial << hi.level
+	old := hi.buckets[hi.next]
+	hi.buckets = append(hi.buckets, nil)
+	hi.next++
+	if hi.next == roundSize {
+		hi.level++
+		hi.next = 0
+	}
+	hi.splits++
+
+	last := len(hi.buckets) - 1
+	for key, value := range old {
+		if hi.getBucket(key) == last {
+			if hi.buckets[last] == nil {
+				hi.buckets[last] = make(map[string][]byte)
+			}
+			hi.buckets[last][key] = value
+			delete(old, key)
+		}
+	}
+}
+
+// merge undoes the last split, folding the last bucket back into the bucket
+// it was split from
+func (hi *HashIndex) merge() {
+	if hi.next == 0 {
+		hi.level--
+		hi.next = hi.initial << hi.level
+	}
+	hi.next--
+	hi.merges++
+
+	last := hi.buckets[len(hi.buckets)-1]
+	hi.buckets = hi.buckets[:len(hi.buckets)-1]
+	if len(last) == 0 {
+		return
+	}
+	if hi.buckets[hi.next] == nil {
+		hi.buckets[hi.next] = make(map[string][]byte, len(last))
+	}
+	for key, value := range last {
+		hi.buckets[hi.next][key] = value
+	}
 }
 
 // Search searches for a key in the hash index
@@ -68,6 +163,24 @@ func (hi *HashIndex) Search(key string) ([]byte, bool) {
 	return value, exists
 }
 
+// SearchValue returns the entries keyed by an encoded value and a row key,
+// one per row holding the value, in key order
+func (hi *HashIndex) SearchValue(valueKey string) []KeyValue {
+	hi.mu.RLock()
+	defer hi.mu.RUnlock()
+
+	var result []KeyValue
+	for key, value := range hi.buckets[hi.getBucket(valueKey)] {
+		if strings.HasPrefix(key, valueKey) && key != valueKey {
+			result = append(result, KeyValue{Key: key, Value: value})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
 // Delete deletes a key from the hash index
 func (hi *HashIndex) Delete(key string) bool {
 	hi.mu.Lock()
@@ -82,6 +195,12 @@ func (hi *HashIndex) Delete(key string) bool {
 	if _, exists := bucket[key]; exists {
 		delete(bucket, key)
 		hi.size--
+		// Shrinking starts once half the buckets' worth of entries remain, so
+		// merging two buckets per delete can return an emptied index to its
+		// initial size
+		for i := 0; i < 2 && len(hi.buckets) > hi.initial && hi.loadFactor() < hashMinLoadFactor; i++ {
+			hi.merge()
+		}
 		return true
 	}
 
@@ -120,9 +239,24 @@ func (hi *HashIndex) Clear() {
 	hi.mu.Lock()
 	defer hi.mu.Unlock()
 
-	for i := range hi.buckets {
-		hi.buckets[i] = nil
-	}
+	hi.buckets = make([]map[string][]byte, hi.initial)
 	hi.size = 0
+	hi.level = 0
+	hi.next = 0
+}
+
+// Stats returns the current layout of the hash index
+func (hi *HashIndex) Stats() HashIndexStats {
+	hi.mu.RLock()
+	defer hi.mu.RUnlock()
+
+	return HashIndexStats{
+		BucketCount:  len(hi.buckets),
+		LoadFactor:   hi.loadFactor(),
+		Level:        hi.level,
+		SplitPointer: hi.next,
+		Splits:       hi.splits,
+		Merges:       hi.merges,
+	}
 }
 
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashIndexGrowsAndShrinks(t *testing.T) {
+	hi := NewHashIndex(4)
+
+	for i := 0; i < 1000; i++ {
+		before := hi.Stats().BucketCount
+		hi.Insert(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))
+		if after := hi.Stats().BucketCount; after > before+1 {
+			t.Fatalf("Insert grew the index from %d to %d buckets at once", before, after)
+		}
+	}
+
+	stats := hi.Stats()
+	if stats.BucketCount <= 4 || stats.Splits != stats.BucketCount-4 {
+		t.Fatalf("Expected the index to grow by splitting, got %+v", stats)
+	}
+	if stats.LoadFactor > hashMaxLoadFactor {
+		t.Fatalf("Load factor %.2f exceeds %.2f", stats.LoadFactor, hashMaxLoadFactor)
+	}
+	for i := 0; i < 1000; i++ {
+		value, found := hi.Search(fmt.Sprintf("key%d", i))
+		if !found || string(value) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("key%d not found after growth", i)
+		}
+	}
+
+	for i := 0; i < 990; i++ {
+		before := hi.Stats().BucketCount
+		if !hi.Delete(fmt.Sprintf("key%d", i)) {
+			t.Fatalf("Delete of key%d failed", i)
+		}
+		if after := hi.Stats().BucketCount; after < before-2 {
+			t.Fatalf("Delete shrank the index from %d to %d buckets", before, after)
+		}
+	}
+
+	stats = hi.Stats()
+	if stats.Merges == 0 || stats.BucketCount >= 500 {
+		t.Fatalf("Expected the index to shrink by merging, got %+v", stats)
+	}
+	if hi.Size() != 10 {
+		t.Fatalf("Expected 10 entries, got %d", hi.Size())
+	}
+	for i := 990; i < 1000; i++ {
+		if _, found := hi.Search(fmt.Sprintf("key%d", i)); !found {
+			t.Fatalf("key%d not found after shrinking", i)
+		}
+	}
+	if len(hi.GetAll()) != 10 {
+		t.Fatalf("Expected GetAll to return 10 entries, got %d", len(hi.GetAll()))
+	}
+}
+
+func TestHashIndexNeverShrinksBelowInitialBuckets(t *testing.T) {
+	hi := NewHashIndex(8)
+	for i := 0; i < 100; i++ {
+		hi.Insert(fmt.Sprintf("key%d", i), nil)
+	}
+	for i := 0; i < 100; i++ {
+		hi.Delete(fmt.Sprintf("key%d", i))
+	}
+
+	stats := hi.Stats()
+	if stats.BucketCount != 8 || stats.Level != 0 || stats.SplitPointer != 0 {
+		t.Fatalf("Expected the index to return to 8 buckets, got %+v", stats)
+	}
+}
+
+func TestIndexInfoReportsHashLayout(t *testing.T) {
+	im := NewIndexManager()
+	if err := im.CreateHashIndex("h", 2); err != nil {
+		t.Fatalf("CreateHashIndex failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		im.Insert("h", fmt.Sprintf("key%d", i), nil)
+	}
+
+	info, err := im.GetIndexInfo("h")
+	if err != nil {
+		t.Fatalf("GetIndexInfo failed: %v", err)
+	}
+	if info["bucket_count"].(int) <= 2 || info["splits"].(int) == 0 {
+		t.Fatalf("Expected the index to have grown, got %v", info)
+	}
+	if _, ok := im.GetIndexStats()["h"]["load_factor"]; !ok {
+		t.Fatal("Expected GetIndexStats to report the load factor")
+	}
+}
@@ -1,16 +1,21 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 )
 
 // IndexType represents the type of index
 type IndexType string
 
 const (
-	IndexTypeBTree IndexType = "BTREE"
-	IndexTypeHash  IndexType = "HASH"
+	IndexTypeBTree    IndexType = "BTREE"
+	IndexTypeHash     IndexType = "HASH"
+	IndexTypeFullText IndexType = "FULLTEXT"
+	IndexTypeVector   IndexType = "HNSW"
 )
 
 // Index interface for different index types
@@ -26,16 +31,39 @@ type Index interface {
 type IndexEntry struct {
 	Index Index
 	Type  IndexType
+	Bloom *BloomFilter // optional filter over the index keys, to skip searches for absent keys
+}
+
+// RowChange is a write made to a table while one of its indexes was being
+// built. Row holds the row's stored data, or nil when the row was deleted.
+type RowChange struct {
+	Key string
+	Row []byte
+}
+
+// indexBuild is the side log of an index being built online: the writes made
+// to its table since the build started, in the order they were made
+type indexBuild struct {
+	Type    IndexType
+	changes []RowChange
 }
 
 type IndexManager struct {
 	indexes map[string]*IndexEntry
+	builds  map[string]*indexBuild
+	saved   map[string]bool // indexes whose entries match their stored snapshot
 	mu      sync.RWMutex
+
+	usage   map[string]*IndexUsage
+	usageMu sync.Mutex
 }
 
 func NewIndexManager() *IndexManager {
 	return &IndexManager{
 		indexes: make(map[string]*IndexEntry),
+		builds:  make(map[string]*indexBuild),
+		saved:   make(map[string]bool),
+		usage:   make(map[string]*IndexUsage),
 	}
 }
 
@@ -73,6 +101,63 @@ func (im *IndexManager) CreateHashIndex(name string, bucketCount int) error {
 	return nil
 }
 
+// CreateFullTextIndex creates an inverted index for MATCH queries. Its
+// entries are keyed by row key and hold the indexed text.
+func (im *IndexManager) CreateFullTextIndex(name string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	
+	if _, exists := im.indexes[name]; exists {
+		return fmt.Errorf("index '%s' already exists", name)
+	}
+	
+	im.indexes[name] = &IndexEntry{
+		Index: NewFullTextIndex(),
+		Type:  IndexTypeFullText,
+	}
+	return nil
+}
+
+// CreateVectorIndex creates an HNSW index for nearest-neighbour queries over
+// vectors of the given dimensions. Its entries are keyed by row key and hold
+// the indexed vector as text.
+func (im *IndexManager) CreateVectorIndex(name string, dimensions int, metric VectorMetric) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	
+	if _, exists := im.indexes[name]; exists {
+		return fmt.Errorf("index '%s' already exists", name)
+	}
+	
+	im.indexes[name] = &IndexEntry{
+		Index: NewVectorIndex(dimensions, metric),
+		Type:  IndexTypeVector,
+	}
+	return nil
+}
+
+// RestoreVectorIndex creates a vector index from a snapshot taken with
+// SnapshotIndex
+func (im *IndexManager) RestoreVectorIndex(name string, snapshot []byte) error {
+	vectorIdx, err := LoadVectorIndex(snapshot)
+	if err != nil {
+		return err
+	}
+	
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	
+	if _, exists := im.indexes[name]; exists {
+		return fmt.Errorf("index '%s' already exists", name)
+	}
+	
+	im.indexes[name] = &IndexEntry{
+		Index: vectorIdx,
+		Type:  IndexTypeVector,
+	}
+	return nil
+}
+
 func (im *IndexManager) DropIndex(name string) error {
 	im.mu.Lock()
 	defer im.mu.Unlock()
@@ -82,19 +167,36 @@ func (im *IndexManager) DropIndex(name string) error {
 	}
 	
 	delete(im.indexes, name)
+	delete(im.saved, name)
+	
+	im.usageMu.Lock()
+	delete(im.usage, name)
+	im.usageMu.Unlock()
 	return nil
 }
 
 func (im *IndexManager) Insert(indexName, key string, value []byte) error {
 	im.mu.RLock()
 	entry, exists := im.indexes[indexName]
-	im.mu.RUnlock()
-	
 	if !exists {
+		im.mu.RUnlock()
 		return fmt.Errorf("index '%s' does not exist", indexName)
 	}
 	
+	// The lock is held so the Bloom filter cannot be rebuilt between adding
+	// the key to it and inserting the entry
+	start := time.Now()
+	if entry.Bloom != nil {
+		entry.Bloom.Add(IndexEntryValue(key))
+	}
 	entry.Index.Insert(key, value)
+	stale := entry.Bloom != nil && entry.Bloom.NeedsRebuild()
+	im.mu.RUnlock()
+	im.recordWrite(indexName, true, time.Since(start))
+	
+	if stale {
+		im.rebuildBloomFilter(indexName)
+	}
 	return nil
 }
 
@@ -107,22 +209,113 @@ func (im *IndexManager) Search(indexName, key string) ([]byte, bool) {
 		return nil, false
 	}
 	
-	return entry.Index.Search(key)
+	if entry.Bloom != nil && !entry.Bloom.MayContain(IndexEntryValue(key)) {
+		im.recordRead(indexName, false, 0)
+		return nil, false
+	}
+	value, found := entry.Index.Search(key)
+	if found {
+		im.recordRead(indexName, false, 1)
+	} else {
+		im.recordRead(indexName, false, 0)
+	}
+	return value, found
+}
+
+// SearchEntries returns the entries of a B-tree or hash index keyed by an
+// encoded value and a row key, one per row holding the value
+func (im *IndexManager) SearchEntries(indexName, valueKey string) []KeyValue {
+	im.mu.RLock()
+	entry, exists := im.indexes[indexName]
+	im.mu.RUnlock()
+	
+	if !exists {
+		return nil
+	}
+	
+	if entry.Bloom != nil && !entry.Bloom.MayContain(valueKey) {
+		im.recordRead(indexName, false, 0)
+		return nil
+	}
+	var entries []KeyValue
+	switch index := entry.Index.(type) {
+	case *BTree:
+		entries = index.Range(IndexEntryBounds(valueKey))
+	case *HashIndex:
+		entries = index.SearchValue(valueKey)
+	}
+	im.recordRead(indexName, false, len(entries))
+	return entries
 }
 
 func (im *IndexManager) Delete(indexName, key string) error {
 	im.mu.RLock()
 	entry, exists := im.indexes[indexName]
+	if !exists {
+		im.mu.RUnlock()
+		return fmt.Errorf("index '%s' does not exist", indexName)
+	}
+	
+	start := time.Now()
+	stale := false
+	if entry.Index.Delete(key) && entry.Bloom != nil {
+		entry.Bloom.Removed()
+		stale = entry.Bloom.NeedsRebuild()
+	}
 	im.mu.RUnlock()
+	im.recordWrite(indexName, false, time.Since(start))
+	
+	if stale {
+		im.rebuildBloomFilter(indexName)
+	}
+	return nil
+}
+
+// SetBloomFilter builds a Bloom filter over the keys of a B-tree or hash
+// index with the given target false-positive rate, so searches for keys the
+// index does not hold skip the index. An existing filter is replaced.
+func (im *IndexManager) SetBloomFilter(indexName string, fpRate float64) error {
+	if err := ValidateFalsePositiveRate(fpRate); err != nil {
+		return err
+	}
+	
+	im.mu.Lock()
+	defer im.mu.Unlock()
 	
+	entry, exists := im.indexes[indexName]
 	if !exists {
 		return fmt.Errorf("index '%s' does not exist", indexName)
 	}
+	if entry.Type != IndexTypeBTree && entry.Type != IndexTypeHash {
+		return fmt.Errorf("Bloom filters are not supported for %s indexes", strings.ToLower(string(entry.Type)))
+	}
 	
-	entry.Index.Delete(key)
+	entry.Bloom = newBloomFilterFor(indexKeys(entry.Index), fpRate)
 	return nil
 }
 
+// rebuildBloomFilter rebuilds an index's Bloom filter from its current keys
+func (im *IndexManager) rebuildBloomFilter(indexName string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	
+	entry, exists := im.indexes[indexName]
+	if !exists || entry.Bloom == nil || !entry.Bloom.NeedsRebuild() {
+		return
+	}
+	entry.Bloom = entry.Bloom.rebuild(indexKeys(entry.Index))
+}
+
+// indexKeys returns the values the entries of an index are keyed by
+func indexKeys(index Index) []string {
+	entries := index.GetAll()
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = IndexEntryValue(entry.Key)
+	}
+	return keys
+}
+
 func (im *IndexManager) Range(indexName, start, end string) ([]KeyValue, error) {
 	im.mu.RLock()
 	entry, exists := im.indexes[indexName]
@@ -134,11 +327,163 @@ func (im *IndexManager) Range(indexName, start, end string) ([]KeyValue, error)
 	
 	// Range queries only work with B-Tree indexes
 	if entry.Type != IndexTypeBTree {
-		return nil, fmt.Errorf("range queries are not supported for hash indexes")
+		return nil, fmt.Errorf("range queries are not supported for %s indexes", strings.ToLower(string(entry.Type)))
 	}
 	
 	btree := entry.Index.(*BTree)
-	return btree.Range(start, end), nil
+	entries := btree.Range(start, end)
+	im.recordRead(indexName, true, len(entries))
+	return entries, nil
+}
+
+// SearchText runs a full-text query against a FULLTEXT index and returns the
+// matching row keys, best match first
+func (im *IndexManager) SearchText(indexName, query string) ([]TextMatch, error) {
+	im.mu.RLock()
+	entry, exists := im.indexes[indexName]
+	im.mu.RUnlock()
+	
+	if !exists {
+		return nil, fmt.Errorf("index '%s' does not exist", indexName)
+	}
+	
+	if entry.Type != IndexTypeFullText {
+		return nil, fmt.Errorf("index '%s' is not a full-text index", indexName)
+	}
+	
+	textQuery, err := ParseTextQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	
+	matches := entry.Index.(*FullTextIndex).Match(textQuery)
+	im.recordRead(indexName, false, len(matches))
+	return matches, nil
+}
+
+// SearchNearest returns the k vectors of a vector index closest to query,
+// closest first
+func (im *IndexManager) SearchNearest(indexName string, query []float64, k int) ([]VectorMatch, error) {
+	im.mu.RLock()
+	entry, exists := im.indexes[indexName]
+	im.mu.RUnlock()
+	
+	if !exists {
+		return nil, fmt.Errorf("index '%s' does not exist", indexName)
+	}
+	
+	if entry.Type != IndexTypeVector {
+		return nil, fmt.Errorf("index '%s' is not a vector index", indexName)
+	}
+	
+	matches, err := entry.Index.(*VectorIndex).Nearest(query, k)
+	if err != nil {
+		return nil, err
+	}
+	im.recordRead(indexName, false, len(matches))
+	return matches, nil
+}
+
+// snapshotEntry is an index entry as stored in a snapshot. Keys are held
+// as bytes because encoded keys are not valid UTF-8.
+type snapshotEntry struct {
+	Key   []byte `json:"k"`
+	Value []byte `json:"v"`
+}
+
+// SnapshotIndex serializes an index so it can be persisted. A vector index
+// is saved with its graph; other indexes are saved as their entries.
+func (im *IndexManager) SnapshotIndex(indexName string) ([]byte, error) {
+	im.mu.RLock()
+	entry, exists := im.indexes[indexName]
+	im.mu.RUnlock()
+	
+	if !exists {
+		return nil, fmt.Errorf("index '%s' does not exist", indexName)
+	}
+	
+	if entry.Type == IndexTypeVector {
+		return entry.Index.(*VectorIndex).Snapshot()
+	}
+	
+	entries := entry.Index.GetAll()
+	snapshot := make([]snapshotEntry, len(entries))
+	for i, kv := range entries {
+		snapshot[i] = snapshotEntry{Key: []byte(kv.Key), Value: kv.Value}
+	}
+	return json.Marshal(snapshot)
+}
+
+// RestoreIndex installs index, which must be empty, under name after
+// loading it with the entries of a snapshot taken with SnapshotIndex. A
+// B-tree is bulk-loaded. Vector indexes are restored with
+// RestoreVectorIndex.
+func (im *IndexManager) RestoreIndex(name string, indexType IndexType, index Index, snapshot []byte) error {
+	var saved []snapshotEntry
+	if err := json.Unmarshal(snapshot, &saved); err != nil {
+		return fmt.Errorf("index '%s' has a malformed snapshot: %w", name, err)
+	}
+	entries := make([]KeyValue, len(saved))
+	for i, entry := range saved {
+		entries[i] = KeyValue{Key: string(entry.Key), Value: entry.Value}
+	}
+	
+	if tree, ok := index.(*BTree); ok {
+		loaded, err := BulkLoadBTree(tree.MinDegree, entries)
+		if err != nil {
+			return fmt.Errorf("index '%s' has a malformed snapshot: %w", name, err)
+		}
+		index = loaded
+	} else {
+		for _, entry := range entries {
+			index.Insert(entry.Key, entry.Value)
+		}
+	}
+	
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	
+	if _, exists := im.indexes[name]; exists {
+		return fmt.Errorf("index '%s' already exists", name)
+	}
+	
+	im.indexes[name] = &IndexEntry{
+		Index: index,
+		Type:  indexType,
+	}
+	return nil
+}
+
+// MarkSaved records that an index's entries match the snapshot just stored
+// or restored for it
+func (im *IndexManager) MarkSaved(name string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	
+	if _, exists := im.indexes[name]; exists {
+		im.saved[name] = true
+	}
+}
+
+// ClearSaved records that an index has changed since its snapshot was
+// stored, reporting whether it matched the snapshot until now. The caller
+// removes the stored snapshot the first time, so a database that is not
+// closed cleanly does not restore stale entries.
+func (im *IndexManager) ClearSaved(name string) bool {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	
+	saved := im.saved[name]
+	delete(im.saved, name)
+	return saved
+}
+
+// Saved reports whether an index's entries match its stored snapshot
+func (im *IndexManager) Saved(name string) bool {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	
+	return im.saved[name]
 }
 
 func (im *IndexManager) GetAll(indexName string) ([]KeyValue, error) {
@@ -185,13 +530,38 @@ func (im *IndexManager) GetIndexInfo(indexName string) (map[string]interface{},
 		info["min_degree"] = btree.MinDegree
 		info["is_empty"] = btree.Root == nil
 	} else if entry.Type == IndexTypeHash {
-		hashIdx := entry.Index.(*HashIndex)
-		info["bucket_count"] = len(hashIdx.buckets)
+		hashStats := entry.Index.(*HashIndex).Stats()
+		info["bucket_count"] = hashStats.BucketCount
+		info["load_factor"] = hashStats.LoadFactor
+		info["level"] = hashStats.Level
+		info["split_pointer"] = hashStats.SplitPointer
+		info["splits"] = hashStats.Splits
+		info["merges"] = hashStats.Merges
+	} else if entry.Type == IndexTypeFullText {
+		info["terms"] = entry.Index.(*FullTextIndex).TermCount()
+	} else if entry.Type == IndexTypeVector {
+		vectorIdx := entry.Index.(*VectorIndex)
+		info["dimensions"] = vectorIdx.Dimensions()
+		info["metric"] = string(vectorIdx.Metric())
+		info["deleted"] = vectorIdx.DeletedCount()
+	}
+	if entry.Bloom != nil {
+		addBloomStats(info, entry.Bloom.Stats())
 	}
 	
 	return info, nil
 }
 
+// addBloomStats adds the statistics of an index's Bloom filter to its info
+func addBloomStats(info map[string]interface{}, stats BloomFilterStats) {
+	info["bloom_fpr"] = stats.TargetFPR
+	info["bloom_estimated_fpr"] = stats.EstimatedFPR
+	info["bloom_bits"] = stats.Bits
+	info["bloom_hashes"] = stats.Hashes
+	info["bloom_checks"] = stats.Checks
+	info["bloom_skipped"] = stats.Skipped
+}
+
 func (im *IndexManager) Exists(indexName string) bool {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
@@ -217,11 +587,26 @@ func (im *IndexManager) ClearIndex(indexName string) error {
 		}
 	} else if entry.Type == IndexTypeHash {
 		hashIdx := entry.Index.(*HashIndex)
-		newHashIdx := NewHashIndex(len(hashIdx.buckets))
+		newHashIdx := NewHashIndex(hashIdx.initial)
 		im.indexes[indexName] = &IndexEntry{
 			Index: newHashIdx,
 			Type:  IndexTypeHash,
 		}
+	} else if entry.Type == IndexTypeFullText {
+		im.indexes[indexName] = &IndexEntry{
+			Index: NewFullTextIndex(),
+			Type:  IndexTypeFullText,
+		}
+	} else if entry.Type == IndexTypeVector {
+		vectorIdx := entry.Index.(*VectorIndex)
+		im.indexes[indexName] = &IndexEntry{
+			Index: NewVectorIndex(vectorIdx.Dimensions(), vectorIdx.Metric()),
+			Type:  IndexTypeVector,
+		}
+	}
+	
+	if entry.Bloom != nil {
+		im.indexes[indexName].Bloom = entry.Bloom.rebuild(nil)
 	}
 	
 	return nil
@@ -243,9 +628,25 @@ func (im *IndexManager) GetIndexStats() map[string]map[string]interface{} {
 			stat["min_degree"] = btree.MinDegree
 			stat["is_empty"] = btree.Root == nil
 		} else if entry.Type == IndexTypeHash {
-			hashIdx := entry.Index.(*HashIndex)
-			stat["bucket_count"] = len(hashIdx.buckets)
+			hashStats := entry.Index.(*HashIndex).Stats()
+			stat["bucket_count"] = hashStats.BucketCount
+			stat["load_factor"] = hashStats.LoadFactor
+			stat["level"] = hashStats.Level
+			stat["split_pointer"] = hashStats.SplitPointer
+			stat["splits"] = hashStats.Splits
+			stat["merges"] = hashStats.Merges
+		} else if entry.Type == IndexTypeFullText {
+			stat["terms"] = entry.Index.(*FullTextIndex).TermCount()
+		} else if entry.Type == IndexTypeVector {
+			vectorIdx := entry.Index.(*VectorIndex)
+			stat["dimensions"] = vectorIdx.Dimensions()
+			stat["metric"] = string(vectorIdx.Metric())
+			stat["deleted"] = vectorIdx.DeletedCount()
+		}
+		if entry.Bloom != nil {
+			addBloomStats(stat, entry.Bloom.Stats())
 		}
+		addUsageStats(stat, im.GetIndexUsage(name))
 		
 		stats[name] = stat
 	}
@@ -264,4 +665,100 @@ func (im *IndexManager) GetIndexType(indexName string) (IndexType, error) {
 	}
 	
 	return entry.Type, nil
-}
\ No newline at end of file
+}
+
+// BeginBuild starts capturing the writes made to an index's table while the
+// index is built outside the manager. The name may belong to an index in use,
+// which keeps serving reads until FinishBuild replaces it.
+func (im *IndexManager) BeginBuild(name string, indexType IndexType) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if _, building := im.builds[name]; building {
+		return fmt.Errorf("index '%s' is already being built", name)
+	}
+
+	im.builds[name] = &indexBuild{Type: indexType}
+	return nil
+}
+
+// BuildingIndexes returns the names of the indexes being built
+func (im *IndexManager) BuildingIndexes() []string {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	names := make([]string, 0, len(im.builds))
+	for name := range im.builds {
+		names = append(names, name)
+	}
+	return names
+}
+
+// LogRowChange records a write to a row in the side log of an index being
+// built. It reports false, recording nothing, when the index is not being
+// built.
+func (im *IndexManager) LogRowChange(name, key string, row []byte) bool {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	build, building := im.builds[name]
+	if !building {
+		return false
+	}
+
+	build.changes = append(build.changes, RowChange{Key: key, Row: row})
+	return true
+}
+
+// TakeRowChanges removes and returns the writes logged so far for an index
+// being built, so a long build can merge them before it finishes
+func (im *IndexManager) TakeRowChanges(name string) ([]RowChange, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	build, building := im.builds[name]
+	if !building {
+		return nil, fmt.Errorf("index '%s' is not being built", name)
+	}
+
+	changes := build.changes
+	build.changes = nil
+	return changes, nil
+}
+
+// FinishBuild merges the writes still in an index's side log into the built
+// index with apply and installs it under name, replacing any index in use
+// and rebuilding its Bloom filter, if it had one, for the new index.
+// Writes are held off while the log is merged, so none are lost between the
+// build and the switch.
+func (im *IndexManager) FinishBuild(name string, index Index, apply func(index Index, changes []RowChange)) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	build, building := im.builds[name]
+	if !building {
+		return fmt.Errorf("index '%s' is not being built", name)
+	}
+
+	if len(build.changes) > 0 {
+		apply(index, build.changes)
+	}
+	replacement := &IndexEntry{
+		Index: index,
+		Type:  build.Type,
+	}
+	if current, exists := im.indexes[name]; exists && current.Bloom != nil {
+		replacement.Bloom = current.Bloom.rebuild(indexKeys(index))
+	}
+	im.indexes[name] = replacement
+	delete(im.builds, name)
+	return nil
+}
+
+// AbortBuild stops capturing writes for an index whose build failed
+func (im *IndexManager) AbortBuild(name string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	delete(im.builds, name)
+}
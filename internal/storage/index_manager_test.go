@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestIndexBuildSideLog(t *testing.T) {
+	im := NewIndexManager()
+
+	if err := im.BeginBuild("users_age", IndexTypeHash); err != nil {
+		t.Fatalf("BeginBuild failed: %v", err)
+	}
+	if err := im.BeginBuild("users_age", IndexTypeHash); err == nil {
+		t.Fatal("Expected an error starting a second build of the same index")
+	}
+	if im.Exists("users_age") {
+		t.Fatal("Index being built should not be visible")
+	}
+	if names := im.BuildingIndexes(); len(names) != 1 || names[0] != "users_age" {
+		t.Fatalf("Expected users_age to be building, got %v", names)
+	}
+
+	im.LogRowChange("users_age", "users:1", []byte("1|age|30"))
+	changes, err := im.TakeRowChanges("users_age")
+	if err != nil {
+		t.Fatalf("TakeRowChanges failed: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Key != "users:1" {
+		t.Fatalf("Expected the logged change, got %v", changes)
+	}
+
+	im.LogRowChange("users_age", "users:2", nil)
+	if im.LogRowChange("other", "users:3", nil) {
+		t.Fatal("Changes should not be logged for an index that is not being built")
+	}
+
+	index := NewHashIndex(0)
+	index.Insert("30", []byte("users:1"))
+	var applied []RowChange
+	err = im.FinishBuild("users_age", index, func(index Index, changes []RowChange) {
+		applied = append(applied, changes...)
+	})
+	if err != nil {
+		t.Fatalf("FinishBuild failed: %v", err)
+	}
+	if len(applied) != 1 || applied[0].Key != "users:2" || applied[0].Row != nil {
+		t.Fatalf("Expected the remaining change to be applied, got %v", applied)
+	}
+
+	if value, found := im.Search("users_age", "30"); !found || string(value) != "users:1" {
+		t.Fatal("Built index should be installed")
+	}
+	if len(im.BuildingIndexes()) != 0 {
+		t.Fatal("No index should be building after FinishBuild")
+	}
+	if im.LogRowChange("users_age", "users:4", nil) {
+		t.Fatal("Changes should not be logged once the build has finished")
+	}
+}
+
+func TestIndexBuildReplacesIndexInUse(t *testing.T) {
+	im := NewIndexManager()
+	if err := im.CreateBTreeIndex("users_name", 3); err != nil {
+		t.Fatalf("CreateBTreeIndex failed: %v", err)
+	}
+	im.Insert("users_name", "old", []byte("users:1"))
+
+	if err := im.BeginBuild("users_name", IndexTypeBTree); err != nil {
+		t.Fatalf("BeginBuild failed: %v", err)
+	}
+	if _, found := im.Search("users_name", "old"); !found {
+		t.Fatal("Index in use should keep serving reads during a rebuild")
+	}
+
+	rebuilt, err := BulkLoadBTree(3, []KeyValue{{Key: "new", Value: []byte("users:1")}})
+	if err != nil {
+		t.Fatalf("BulkLoadBTree failed: %v", err)
+	}
+	if err := im.FinishBuild("users_name", rebuilt, func(Index, []RowChange) {}); err != nil {
+		t.Fatalf("FinishBuild failed: %v", err)
+	}
+	if _, found := im.Search("users_name", "old"); found {
+		t.Fatal("Rebuilt index should replace the old one")
+	}
+	if _, found := im.Search("users_name", "new"); !found {
+		t.Fatal("Rebuilt index should be installed")
+	}
+
+	if err := im.BeginBuild("users_name", IndexTypeBTree); err != nil {
+		t.Fatalf("BeginBuild failed: %v", err)
+	}
+	im.AbortBuild("users_name")
+	if _, found := im.Search("users_name", "new"); !found {
+		t.Fatal("Aborting a rebuild should leave the index in use")
+	}
+}
+
+func TestIndexUsageTracking(t *testing.T) {
+	im := NewIndexManager()
+	if err := im.CreateBTreeIndex("users_age", 3); err != nil {
+		t.Fatalf("CreateBTreeIndex failed: %v", err)
+	}
+	if im.GetIndexUsage("users_age").Used() {
+		t.Fatal("New index should not be used")
+	}
+
+	im.Insert("users_age", "30", []byte("users:1"))
+	im.Insert("users_age", "40", []byte("users:2"))
+	im.Delete("users_age", "40")
+	im.Search("users_age", "30")
+	im.Search("users_age", "99")
+	im.Insert("users_age", "50", []byte("users:3"))
+	if _, err := im.Range("users_age", "00", "99"); err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+
+	usage := im.GetIndexUsage("users_age")
+	if usage.Lookups != 2 || usage.RangeScans != 1 || usage.RowsReturned != 3 {
+		t.Fatalf("Unexpected read counts: %+v", usage)
+	}
+	if usage.Inserts != 3 || usage.Deletes != 1 || usage.LastUsed.IsZero() {
+		t.Fatalf("Unexpected write counts: %+v", usage)
+	}
+	if stats := im.GetIndexStats()["users_age"]; stats["lookups"] != int64(2) || stats["range_scans"] != int64(1) {
+		t.Fatalf("Expected GetIndexStats to report usage, got %v", stats)
+	}
+
+	im.SetIndexUsage("users_age", IndexUsage{Lookups: 7})
+	if im.GetIndexUsage("users_age").Lookups != 7 {
+		t.Fatal("SetIndexUsage should replace the usage record")
+	}
+	im.DropIndex("users_age")
+	if im.GetIndexUsage("users_age").Used() {
+		t.Fatal("Dropping an index should discard its usage")
+	}
+}
+
+func TestSearchEntriesReturnsEveryRowOfAValue(t *testing.T) {
+	im := NewIndexManager()
+	if err := im.CreateBTreeIndex("t_v_btree", 3); err != nil {
+		t.Fatalf("CreateBTreeIndex failed: %v", err)
+	}
+	if err := im.CreateHashIndex("t_v_hash", 0); err != nil {
+		t.Fatalf("CreateHashIndex failed: %v", err)
+	}
+
+	five, seven := EncodeKey(5.0), EncodeKey(7.0)
+	for _, name := range []string{"t_v_btree", "t_v_hash"} {
+		im.Insert(name, IndexEntryKey(five, "t:1"), []byte("t:1"))
+		im.Insert(name, IndexEntryKey(five, "t:2"), []byte("t:2"))
+		im.Insert(name, IndexEntryKey(seven, "t:3"), []byte("t:3"))
+
+		entries := im.SearchEntries(name, five)
+		if len(entries) != 2 || string(entries[0].Value) != "t:1" || string(entries[1].Value) != "t:2" {
+			t.Fatalf("%s: expected the entries of t:1 and t:2, got %v", name, entries)
+		}
+
+		if err := im.Delete(name, IndexEntryKey(five, "t:2")); err != nil {
+			t.Fatalf("%s: Delete failed: %v", name, err)
+		}
+		entries = im.SearchEntries(name, five)
+		if len(entries) != 1 || string(entries[0].Value) != "t:1" {
+			t.Fatalf("%s: expected only the entry of t:1 after deleting t:2, got %v", name, entries)
+		}
+		if entries := im.SearchEntries(name, EncodeKey(6.0)); len(entries) != 0 {
+			t.Fatalf("%s: expected no entries for a missing value, got %v", name, entries)
+		}
+	}
+}
+
+func TestIndexSnapshotRoundTrip(t *testing.T) {
+	im := NewIndexManager()
+	if err := im.CreateBTreeIndex("t_v", 3); err != nil {
+		t.Fatalf("CreateBTreeIndex failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		key := IndexEntryKey(EncodeKey(float64(i%5)), "t:"+string(rune('a'+i)))
+		im.Insert("t_v", key, []byte("row"))
+	}
+	snapshot, err := im.SnapshotIndex("t_v")
+	if err != nil {
+		t.Fatalf("SnapshotIndex failed: %v", err)
+	}
+
+	restored := NewIndexManager()
+	if err := restored.RestoreIndex("t_v", IndexTypeBTree, NewBTree(3), snapshot); err != nil {
+		t.Fatalf("RestoreIndex failed: %v", err)
+	}
+	if entries := restored.SearchEntries("t_v", EncodeKey(2.0)); len(entries) != 4 {
+		t.Fatalf("Expected 4 entries for the value 2 after restoring, got %d", len(entries))
+	}
+	if restored.Saved("t_v") {
+		t.Fatal("A restored index should not be marked saved until MarkSaved")
+	}
+	restored.MarkSaved("t_v")
+	if !restored.ClearSaved("t_v") || restored.ClearSaved("t_v") {
+		t.Fatal("ClearSaved should report the saved state once")
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"time"
+)
+
+// IndexUsage records how an index has been used: the reads it answered and
+// what keeping it up to date on writes has cost
+type IndexUsage struct {
+	Lookups         int64         `json:"lookups"`
+	RangeScans      int64         `json:"range_scans"`
+	RowsReturned    int64         `json:"rows_returned"`
+	LastUsed        time.Time     `json:"last_used"`
+	Inserts         int64         `json:"inserts"`
+	Deletes         int64         `json:"deletes"`
+	MaintenanceTime time.Duration `json:"maintenance_ns"`
+}
+
+// Used reports whether the index has answered any read
+func (u IndexUsage) Used() bool {
+	return u.Lookups > 0 || u.RangeScans > 0
+}
+
+// recordRead counts a lookup or range scan of an index returning rows entries
+func (im *IndexManager) recordRead(indexName string, rangeScan bool, rows int) {
+	im.usageMu.Lock()
+	defer im.usageMu.Unlock()
+
+	usage := im.usageOf(indexName)
+	if rangeScan {
+		usage.RangeScans++
+	} else {
+		usage.Lookups++
+	}
+	usage.RowsReturned += int64(rows)
+	usage.LastUsed = time.Now()
+}
+
+// recordWrite counts an insert into or delete from an index and the time it
+// took
+func (im *IndexManager) recordWrite(indexName string, insert bool, elapsed time.Duration) {
+	im.usageMu.Lock()
+	defer im.usageMu.Unlock()
+
+	usage := im.usageOf(indexName)
+	if insert {
+		usage.Inserts++
+	} else {
+		usage.Deletes++
+	}
+	usage.MaintenanceTime += elapsed
+}
+
+// usageOf returns the usage record of an index, creating it if needed. The
+// caller holds usageMu.
+func (im *IndexManager) usageOf(indexName string) *IndexUsage {
+	usage, exists := im.usage[indexName]
+	if !exists {
+		usage = &IndexUsage{}
+		im.usage[indexName] = usage
+	}
+	return usage
+}
+
+// GetIndexUsage returns how an index has been used
+func (im *IndexManager) GetIndexUsage(indexName string) IndexUsage {
+	im.usageMu.Lock()
+	defer im.usageMu.Unlock()
+
+	if usage, exists := im.usage[indexName]; exists {
+		return *usage
+	}
+	return IndexUsage{}
+}
+
+// SetIndexUsage replaces the usage record of an index, such as with one
+// saved before the database was closed
+func (im *IndexManager) SetIndexUsage(indexName string, usage IndexUsage) {
+	im.usageMu.Lock()
+	defer im.usageMu.Unlock()
+
+	im.usage[indexName] = &usage
+}
+
+// addUsageStats adds an index's usage to its statistics
+func addUsageStats(stat map[string]interface{}, usage IndexUsage) {
+	stat["lookups"] = usage.Lookups
+	stat["range_scans"] = usage.RangeScans
+	stat["rows_returned"] = usage.RowsReturned
+	stat["last_used"] = usage.LastUsed
+	stat["inserts"] = usage.Inserts
+	stat["deletes"] = usage.Deletes
+	stat["maintenance_time"] = usage.MaintenanceTime
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Type tags prefixed to every encoded index key. Values of different types
+// sort NULL < boolean < number < string < timestamp.
+const (
+	keyTagNull   byte = 0x01
+	keyTagBool   byte = 0x02
+	keyTagNumber byte = 0x03
+	keyTagString byte = 0x04
+	keyTagTime   byte = 0x05
+)
+
+// Strings are terminated so that a shorter string sorts before any longer
+// string it prefixes, and embedded zero bytes are escaped so the terminator
+// stays unambiguous inside composite keys.
+const (
+	keyStringEscape     = "\x00\xff"
+	keyStringTerminator = "\x00\x01"
+)
+
+// EncodeKey encodes one or more values into an index key whose byte order
+// matches SQL comparison order. Multiple values produce a composite key that
+// sorts by the first value, then the second, and so on.
+func EncodeKey(values ...interface{}) string {
+	var b strings.Builder
+	for _, value := range values {
+		encodeKeyValue(&b, value)
+	}
+	return b.String()
+}
+
+func encodeKeyValue(b *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		b.WriteByte(keyTagNull)
+	case bool:
+		b.WriteByte(keyTagBool)
+		if v {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case float64:
+		encodeKeyNumber(b, v)
+	case float32:
+		encodeKeyNumber(b, float64(v))
+	case int:
+		encodeKeyInteger(b, int64(v))
+	case int32:
+		encodeKeyInteger(b, int64(v))
+	case int64:
+		encodeKeyInteger(b, v)
+	case string:
+		encodeKeyString(b, v)
+	case []byte:
+		encodeKeyString(b, string(v))
+	case time.Time:
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(v.UnixNano())^(1<<63))
+		b.WriteByte(keyTagTime)
+		b.Write(buf[:])
+	default:
+		encodeKeyString(b, fmt.Sprintf("%v", v))
+	}
+}
+
+// Numbers are encoded as the nearest float64 followed by the amount an
+// integer differs from it. Integers beyond 2^53 that round to the same float
+// keep distinct keys in their own order, while an integer and a float of
+// equal value still encode identically.
+func encodeKeyNumber(b *strings.Builder, f float64) {
+	encodeKeyNumberParts(b, f, 0)
+}
+
+func encodeKeyInteger(b *strings.Builder, i int64) {
+	f := float64(i)
+	// float64(i) may round up to 2^63, which has no int64 form; the
+	// difference is small, so wrapping arithmetic still computes it
+	base := uint64(1) << 63
+	if f < math.MaxInt64 {
+		base = uint64(int64(f))
+	}
+	encodeKeyNumberParts(b, f, int16(uint64(i)-base))
+}
+
+func encodeKeyNumberParts(b *strings.Builder, f float64, offset int16) {
+	if f == 0 {
+		f = 0 // fold -0 into +0
+	}
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	var buf [10]byte
+	binary.BigEndian.PutUint64(buf[:8], bits)
+	binary.BigEndian.PutUint16(buf[8:], uint16(offset)^(1<<15))
+	b.WriteByte(keyTagNumber)
+	b.Write(buf[:])
+}
+
+func encodeKeyString(b *strings.Builder, s string) {
+	b.WriteByte(keyTagString)
+	b.WriteString(strings.ReplaceAll(s, "\x00", keyStringEscape))
+	b.WriteString(keyStringTerminator)
+}
+
+// DecodeKey decodes a key produced by EncodeKey back into its values.
+// Numbers are returned as float64, except integers a float64 cannot hold
+// exactly, which are returned as int64. Timestamps are returned in UTC.
+func DecodeKey(key string) ([]interface{}, error) {
+	var values []interface{}
+	for i := 0; i < len(key); {
+		tag := key[i]
+		i++
+		switch tag {
+		case keyTagNull:
+			values = append(values, nil)
+		case keyTagBool:
+			if i >= len(key) {
+				return nil, fmt.Errorf("truncated boolean in key")
+			}
+			values = append(values, key[i] == 1)
+			i++
+		case keyTagNumber:
+			if i+10 > len(key) {
+				return nil, fmt.Errorf("truncated number in key")
+			}
+			bits := binary.BigEndian.Uint64([]byte(key[i : i+8]))
+			if bits&(1<<63) != 0 {
+				bits &^= 1 << 63
+			} else {
+				bits = ^bits
+			}
+			f := math.Float64frombits(bits)
+			offset := int16(binary.BigEndian.Uint16([]byte(key[i+8:i+10])) ^ (1 << 15))
+			if offset == 0 {
+				values = append(values, f)
+			} else {
+				base := uint64(1) << 63
+				if f < math.MaxInt64 {
+					base = uint64(int64(f))
+				}
+				values = append(values, int64(base+uint64(int64(offset))))
+			}
+			i += 10
+		case keyTagString:
+			var s strings.Builder
+			for {
+				if i+1 >= len(key) {
+					return nil, fmt.Errorf("unterminated string in key")
+				}
+				if key[i] == 0x00 {
+					if key[i+1] == 0x01 {
+						i += 2
+						break
+					}
+					s.WriteByte(0x00)
+					i += 2
+					continue
+				}
+				s.WriteByte(key[i])
+				i++
+			}
+			values = append(values, s.String())
+		case keyTagTime:
+			if i+8 > len(key) {
+				return nil, fmt.Errorf("truncated timestamp in key")
+			}
+			nanos := int64(binary.BigEndian.Uint64([]byte(key[i:i+8])) ^ (1 << 63))
+			values = append(values, time.Unix(0, nanos).UTC())
+			i += 8
+		default:
+			return nil, fmt.Errorf("unknown key type tag 0x%02x", tag)
+		}
+	}
+	return values, nil
+}
+
+// IndexEntryKey returns the key of a row's entry in an index keyed by value:
+// the encoded value followed by the encoded row key. Rows holding the same
+// value each have their own entry, and a value's entries sort together.
+func IndexEntryKey(valueKey, rowKey string) string {
+	return valueKey + EncodeKey(rowKey)
+}
+
+// IndexEntryValue returns the encoded value an index entry key was built
+// from, or the key itself if it does not end in a row key
+func IndexEntryValue(key string) string {
+	last := -1
+	for i := 0; i < len(key); {
+		end := keyValueEnd(key, i)
+		if end < 0 {
+			return key
+		}
+		last, i = i, end
+	}
+	if last <= 0 || key[last] != keyTagString {
+		return key
+	}
+	return key[:last]
+}
+
+// IndexEntryBounds returns inclusive bounds spanning the entry keys of every
+// row holding the encoded value. Encoded values never prefix one another, so
+// only that value's entries start with it.
+func IndexEntryBounds(valueKey string) (string, string) {
+	return valueKey, valueKey + "\xff"
+}
+
+// keyValueEnd returns the position just past the encoded value starting at
+// position i of key, or -1 if no whole value starts there
+func keyValueEnd(key string, i int) int {
+	end := -1
+	switch key[i] {
+	case keyTagNull:
+		end = i + 1
+	case keyTagBool:
+		end = i + 2
+	case keyTagNumber:
+		end = i + 11
+	case keyTagTime:
+		end = i + 9
+	case keyTagString:
+		for j := i + 1; j+1 < len(key); j++ {
+			if key[j] == 0x00 {
+				if key[j+1] == 0x01 {
+					return j + 2
+				}
+				j++
+			}
+		}
+	}
+	if end > len(key) {
+		return -1
+	}
+	return end
+}
+
+// KeyTypeBounds returns inclusive bounds spanning every encoded key whose
+// leading value has the same type as the leading value of key. It is used to
+// complete a range that is open on one side.
+func KeyTypeBounds(key string) (string, string) {
+	if key == "" {
+		return "", ""
+	}
+	tag := key[0]
+	return string([]byte{tag}), string([]byte{tag + 1})
+}
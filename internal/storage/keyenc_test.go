@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEncodeKeyOrdering(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ordered := []interface{}{
+		nil,
+		false,
+		true,
+		-1e9,
+		-2.5,
+		0.0,
+		1.0,
+		9.0,
+		10.0,
+		1e12,
+		"",
+		"1",
+		"a",
+		"a\x00b",
+		"ab",
+		"b",
+		base.Add(-time.Hour),
+		base,
+	}
+
+	for i := 1; i < len(ordered); i++ {
+		prev := EncodeKey(ordered[i-1])
+		curr := EncodeKey(ordered[i])
+		if prev >= curr {
+			t.Fatalf("Expected %v to sort before %v", ordered[i-1], ordered[i])
+		}
+	}
+
+	if EncodeKey(1.0) == EncodeKey("1") {
+		t.Fatal("Number 1 and string \"1\" should encode differently")
+	}
+
+	if EncodeKey(int64(7)) != EncodeKey(7.0) {
+		t.Fatal("Integers and floats with the same value should encode identically")
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC)
+	key := EncodeKey(nil, true, -3.25, "x\x00y", ts)
+
+	values, err := DecodeKey(key)
+	if err != nil {
+		t.Fatalf("DecodeKey failed: %v", err)
+	}
+	if len(values) != 5 {
+		t.Fatalf("Expected 5 values, got %d", len(values))
+	}
+	if values[0] != nil || values[1] != true || values[2] != -3.25 || values[3] != "x\x00y" {
+		t.Fatalf("Unexpected decoded values: %v", values)
+	}
+	if !values[4].(time.Time).Equal(ts) {
+		t.Fatalf("Expected %v, got %v", ts, values[4])
+	}
+}
+
+func TestBTreeRangeWithEncodedKeys(t *testing.T) {
+	btree := NewBTree(2)
+	for i := 0; i < 50; i++ {
+		btree.Insert(EncodeKey(float64(i)), []byte{byte(i)})
+	}
+	btree.Insert(EncodeKey("10"), []byte("string"))
+
+	start := EncodeKey(9.0)
+	_, end := KeyTypeBounds(start)
+	result := btree.Range(start, end)
+
+	if len(result) != 41 {
+		t.Fatalf("Expected 41 numeric keys >= 9, got %d", len(result))
+	}
+	for i, kv := range result {
+		if kv.Value[0] != byte(i+9) {
+			t.Fatalf("Expected value %d at position %d, got %d", i+9, i, kv.Value[0])
+		}
+	}
+}
+
+func TestIndexEntryKeys(t *testing.T) {
+	values := []interface{}{nil, true, 5.0, "a\x00b", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for _, value := range values {
+		valueKey := EncodeKey(value)
+		entry := IndexEntryKey(valueKey, "t:1")
+		if IndexEntryValue(entry) != valueKey {
+			t.Fatalf("Expected the value key of %v back from its entry key", value)
+		}
+		start, end := IndexEntryBounds(valueKey)
+		if entry < start || entry > end {
+			t.Fatalf("Entry key of %v falls outside its value's bounds", value)
+		}
+	}
+
+	if IndexEntryValue(EncodeKey("plain")) != EncodeKey("plain") {
+		t.Fatal("A bare value key should be returned unchanged")
+	}
+	if IndexEntryKey(EncodeKey("a"), "t:9") >= EncodeKey("a\x00") {
+		t.Fatal("Entries of a value should sort before the next value")
+	}
+}
+
+func TestEncodeKeyLargeIntegers(t *testing.T) {
+	ordered := []interface{}{
+		int64(math.MinInt64),
+		int64(-9007199254740993),
+		-9007199254740992.0,
+		int64(9007199254740992),
+		int64(9007199254740993),
+		9007199254740994.0,
+		int64(9007199254740995),
+		int64(math.MaxInt64 - 1),
+		int64(math.MaxInt64),
+		1e19,
+	}
+	for i := 1; i < len(ordered); i++ {
+		if EncodeKey(ordered[i-1]) >= EncodeKey(ordered[i]) {
+			t.Fatalf("Expected %v to sort before %v", ordered[i-1], ordered[i])
+		}
+	}
+
+	for _, value := range []interface{}{int64(9007199254740993), int64(math.MaxInt64), int64(math.MinInt64 + 1), 2.5} {
+		values, err := DecodeKey(EncodeKey(value))
+		if err != nil {
+			t.Fatalf("DecodeKey failed: %v", err)
+		}
+		if len(values) != 1 || values[0] != value {
+			t.Fatalf("Expected %v back, got %v", value, values)
+		}
+	}
+}
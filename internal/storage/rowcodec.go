@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Encoded rows start with rowFormatMagic, which can never begin a row in the
+// older pipe-delimited text format since those start with the row ID, and
+// then the format version.
+const (
+	rowFormatMagic byte = 0xFE
+
+	// RowFormatVersion is the version of the row format written by EncodeRow
+	RowFormatVersion byte = 1
+)
+
+// ValueType tags the type of each value in an encoded row
+type ValueType byte
+
+const (
+	ValueNull ValueType = iota
+	ValueInt
+	ValueFloat
+	ValueText
+	ValueBool
+	ValueBytes
+	ValueTimestamp
+	ValueVector
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case ValueNull:
+		return "NULL"
+	case ValueInt:
+		return "INT"
+	case ValueFloat:
+		return "FLOAT"
+	case ValueText:
+		return "TEXT"
+	case ValueBool:
+		return "BOOL"
+	case ValueBytes:
+		return "BYTES"
+	case ValueTimestamp:
+		return "TIMESTAMP"
+	case ValueVector:
+		return "VECTOR"
+	}
+	return fmt.Sprintf("ValueType(%d)", byte(t))
+}
+
+// RowField is one column value of an encoded row. Columns are identified by
+// the ID the table's schema gives them rather than by name, so renaming a
+// column does not rewrite its rows.
+type RowField struct {
+	Column uint32
+	Value  interface{}
+}
+
+// IsEncodedRow reports whether data holds a row in the binary row format
+func IsEncodedRow(data []byte) bool {
+	return len(data) >= 2 && data[0] == rowFormatMagic
+}
+
+// ValueTypeOf returns the type a value is encoded as. Values are int64,
+// float64, string, bool, []byte, time.Time, []float64 vectors or nil; other
+// integer and float types are widened.
+func ValueTypeOf(value interface{}) (ValueType, bool) {
+	switch value.(type) {
+	case nil:
+		return ValueNull, true
+	case int, int32, int64:
+		return ValueInt, true
+	case float32, float64:
+		return ValueFloat, true
+	case string:
+		return ValueText, true
+	case bool:
+		return ValueBool, true
+	case []byte:
+		return ValueBytes, true
+	case time.Time:
+		return ValueTimestamp, true
+	case []float64:
+		return ValueVector, true
+	}
+	return 0, false
+}
+
+// EncodeRow encodes a row's ID and column values in the binary row format:
+//
+//	magic, version, len(id) id, field count,
+//	then per field: column ID, value type, value
+//
+// Lengths, counts and column IDs are unsigned varints. Integers and
+// timestamps (nanoseconds since the Unix epoch) are signed varints, floats
+// are 8 bytes big-endian, text and bytes are length-prefixed and vectors are
+// a count followed by that many floats.
+func EncodeRow(id string, fields []RowField) ([]byte, error) {
+	buf := make([]byte, 0, 16+len(id)+8*len(fields))
+	buf = append(buf, rowFormatMagic, RowFormatVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(id)))
+	buf = append(buf, id...)
+	buf = binary.AppendUvarint(buf, uint64(len(fields)))
+
+	for _, field := range fields {
+		valueType, ok := ValueTypeOf(field.Value)
+		if !ok {
+			return nil, fmt.Errorf("column %d: cannot encode value of type %T", field.Column, field.Value)
+		}
+		buf = binary.AppendUvarint(buf, uint64(field.Column))
+		buf = append(buf, byte(valueType))
+
+		switch v := field.Value.(type) {
+		case int:
+			buf = binary.AppendVarint(buf, int64(v))
+		case int32:
+			buf = binary.AppendVarint(buf, int64(v))
+		case int64:
+			buf = binary.AppendVarint(buf, v)
+		case float32:
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(float64(v)))
+		case float64:
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(v))
+		case string:
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		case bool:
+			if v {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case []byte:
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		case time.Time:
+			buf = binary.AppendVarint(buf, v.UnixNano())
+		case []float64:
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			for _, component := range v {
+				buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(component))
+			}
+		}
+	}
+	return buf, nil
+}
+
+// rowDecoder reads the parts of an encoded row, failing on truncated data
+type rowDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *rowDecoder) uvarint() (uint64, error) {
+	value, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("%w: bad varint at offset %d", ErrCorruptRow, d.pos)
+	}
+	d.pos += n
+	return value, nil
+}
+
+func (d *rowDecoder) varint() (int64, error) {
+	value, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("%w: bad varint at offset %d", ErrCorruptRow, d.pos)
+	}
+	d.pos += n
+	return value, nil
+}
+
+func (d *rowDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("%w: %d bytes expected at offset %d, %d left", ErrCorruptRow, n, d.pos, len(d.data)-d.pos)
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *rowDecoder) float() (float64, error) {
+	b, err := d.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+}
+
+// DecodeRow decodes a row written by EncodeRow, returning its ID and fields
+// in the order they were written. Timestamps are returned in UTC.
+func DecodeRow(data []byte) (string, []RowField, error) {
+	if !IsEncodedRow(data) {
+		return "", nil, fmt.Errorf("%w: not an encoded row", ErrCorruptRow)
+	}
+	if data[1] != RowFormatVersion {
+		return "", nil, fmt.Errorf("unsupported row format version %d", data[1])
+	}
+	d := &rowDecoder{data: data, pos: 2}
+
+	idLength, err := d.uvarint()
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := d.bytes(idLength)
+	if err != nil {
+		return "", nil, err
+	}
+	count, err := d.uvarint()
+	if err != nil {
+		return "", nil, err
+	}
+	// Every field takes at least two bytes, which bounds a corrupt count
+	if count > uint64(len(data)-d.pos)/2 {
+		return "", nil, fmt.Errorf("%w: %d fields cannot fit in %d bytes", ErrCorruptRow, count, len(data)-d.pos)
+	}
+
+	fields := make([]RowField, 0, count)
+	for i := uint64(0); i < count; i++ {
+		column, err := d.uvarint()
+		if err != nil {
+			return "", nil, err
+		}
+		if column > math.MaxUint32 {
+			return "", nil, fmt.Errorf("%w: column ID %d out of range", ErrCorruptRow, column)
+		}
+		tag, err := d.bytes(1)
+		if err != nil {
+			return "", nil, err
+		}
+
+		var value interface{}
+		switch ValueType(tag[0]) {
+		case ValueNull:
+		case ValueInt:
+			value, err = d.varint()
+		case ValueFloat:
+			value, err = d.float()
+		case ValueText, ValueBytes:
+			var length uint64
+			var b []byte
+			if length, err = d.uvarint(); err == nil {
+				b, err = d.bytes(length)
+			}
+			if ValueType(tag[0]) == ValueText {
+				value = string(b)
+			} else {
+				value = append([]byte{}, b...)
+			}
+		case ValueBool:
+			var b []byte
+			if b, err = d.bytes(1); err == nil {
+				value = b[0] != 0
+			}
+		case ValueTimestamp:
+			var nanos int64
+			if nanos, err = d.varint(); err == nil {
+				value = time.Unix(0, nanos).UTC()
+			}
+		case ValueVector:
+			var n uint64
+			if n, err = d.uvarint(); err == nil && n > uint64(len(data)-d.pos)/8 {
+				err = fmt.Errorf("%w: vector of %d components cannot fit in %d bytes", ErrCorruptRow, n, len(data)-d.pos)
+			}
+			if err == nil {
+				vector := make([]float64, n)
+				for j := range vector {
+					if vector[j], err = d.float(); err != nil {
+						break
+					}
+				}
+				value = vector
+			}
+		default:
+			err = fmt.Errorf("%w: unknown value type %d", ErrCorruptRow, tag[0])
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		fields = append(fields, RowField{Column: uint32(column), Value: value})
+	}
+
+	if d.pos != len(data) {
+		return "", nil, fmt.Errorf("%w: %d trailing bytes", ErrCorruptRow, len(data)-d.pos)
+	}
+	return string(id), fields, nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRowCodecRoundTrip(t *testing.T) {
+	when := time.Date(2024, 2, 29, 13, 45, 30, 123456789, time.FixedZone("CET", 3600))
+	fields := []RowField{
+		{Column: 1, Value: int64(42)},
+		{Column: 2, Value: int64(math.MinInt64)},
+		{Column: 3, Value: 3.25},
+		{Column: 4, Value: math.Inf(-1)},
+		{Column: 5, Value: "pipes | and\x00zero bytes"},
+		{Column: 6, Value: ""},
+		{Column: 7, Value: "数据库"},
+		{Column: 8, Value: true},
+		{Column: 9, Value: false},
+		{Column: 10, Value: []byte{0, 1, 0xFE, 0xFF}},
+		{Column: 11, Value: []byte{}},
+		{Column: 12, Value: nil},
+		{Column: 13, Value: when},
+		{Column: 14, Value: []float64{0.5, -1, 2}},
+		{Column: math.MaxUint32, Value: "last"},
+	}
+
+	data, err := EncodeRow("1700000000000000000", fields)
+	if err != nil {
+		t.Fatalf("EncodeRow failed: %v", err)
+	}
+	if !IsEncodedRow(data) {
+		t.Fatal("Expected the row to be recognised as encoded")
+	}
+
+	id, decoded, err := DecodeRow(data)
+	if err != nil {
+		t.Fatalf("DecodeRow failed: %v", err)
+	}
+	if id != "1700000000000000000" {
+		t.Fatalf("Expected the row ID back, got %q", id)
+	}
+	if len(decoded) != len(fields) {
+		t.Fatalf("Expected %d fields, got %d", len(fields), len(decoded))
+	}
+	for i, field := range fields {
+		got := decoded[i]
+		if got.Column != field.Column {
+			t.Fatalf("Field %d: expected column %d, got %d", i, field.Column, got.Column)
+		}
+		if expected, ok := field.Value.(time.Time); ok {
+			actual, ok := got.Value.(time.Time)
+			if !ok || !actual.Equal(expected) || actual.Location() != time.UTC {
+				t.Fatalf("Field %d: expected %v in UTC, got %v", i, expected, got.Value)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got.Value, field.Value) {
+			t.Fatalf("Field %d: expected %#v, got %#v", i, field.Value, got.Value)
+		}
+	}
+}
+
+func TestRowCodecWidensNumbers(t *testing.T) {
+	data, err := EncodeRow("1", []RowField{{Column: 1, Value: 7}, {Column: 2, Value: int32(-3)}, {Column: 3, Value: float32(1.5)}})
+	if err != nil {
+		t.Fatalf("EncodeRow failed: %v", err)
+	}
+	_, fields, err := DecodeRow(data)
+	if err != nil {
+		t.Fatalf("DecodeRow failed: %v", err)
+	}
+	if fields[0].Value != int64(7) || fields[1].Value != int64(-3) || fields[2].Value != 1.5 {
+		t.Fatalf("Expected widened values, got %#v", fields)
+	}
+}
+
+func TestRowCodecEmptyRow(t *testing.T) {
+	data, err := EncodeRow("", nil)
+	if err != nil {
+		t.Fatalf("EncodeRow failed: %v", err)
+	}
+	id, fields, err := DecodeRow(data)
+	if err != nil || id != "" || len(fields) != 0 {
+		t.Fatalf("Expected an empty row back, got %q %v %v", id, fields, err)
+	}
+}
+
+func TestRowCodecRejectsUnsupportedValue(t *testing.T) {
+	if _, err := EncodeRow("1", []RowField{{Column: 1, Value: struct{}{}}}); err == nil {
+		t.Fatal("Expected an error for a value of an unsupported type")
+	}
+}
+
+func TestRowCodecLegacyRowsNotEncoded(t *testing.T) {
+	for _, legacy := range []string{"1700000000000000000|name|alice", "", "x"} {
+		if IsEncodedRow([]byte(legacy)) {
+			t.Fatalf("Expected %q to be recognised as a legacy row", legacy)
+		}
+	}
+}
+
+func TestRowCodecCorruptRows(t *testing.T) {
+	data, err := EncodeRow("42", []RowField{
+		{Column: 1, Value: "hello"},
+		{Column: 2, Value: []float64{1, 2}},
+		{Column: 3, Value: int64(300)},
+	})
+	if err != nil {
+		t.Fatalf("EncodeRow failed: %v", err)
+	}
+
+	// Every truncation of a valid row must be rejected rather than misread
+	for n := 2; n < len(data); n++ {
+		if _, _, err := DecodeRow(data[:n]); !errors.Is(err, ErrCorruptRow) {
+			t.Fatalf("Expected ErrCorruptRow for a row truncated to %d bytes, got %v", n, err)
+		}
+	}
+	if _, _, err := DecodeRow(append(data, 0)); !errors.Is(err, ErrCorruptRow) {
+		t.Fatalf("Expected ErrCorruptRow for trailing bytes, got %v", err)
+	}
+
+	badType := append([]byte(nil), data...)
+	badType[7] = 0x7F // the type tag of the first field
+	if _, _, err := DecodeRow(badType); !errors.Is(err, ErrCorruptRow) {
+		t.Fatalf("Expected ErrCorruptRow for an unknown value type, got %v", err)
+	}
+
+	newer := append([]byte(nil), data...)
+	newer[1] = RowFormatVersion + 1
+	if _, _, err := DecodeRow(newer); err == nil {
+		t.Fatal("Expected an error for an unsupported format version")
+	}
+
+	hugeCount := []byte{rowFormatMagic, RowFormatVersion, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0x0F}
+	if _, _, err := DecodeRow(hugeCount); !errors.Is(err, ErrCorruptRow) {
+		t.Fatalf("Expected ErrCorruptRow for an impossible field count, got %v", err)
+	}
+}
@@ -2,15 +2,22 @@ package storage
 
 type Storage struct {
 	engine Engine
+	bloom *BloomEngine
 	txManager *TransactionManager
 	indexManager *IndexManager
+	workload *Workload
+	adaptive *AdaptiveHashIndexes
 }
 
 func New(engine Engine) *Storage {
+	bloom := NewBloomEngine(engine)
 	return &Storage{
-		engine: engine,
+		engine: bloom,
+		bloom: bloom,
 		txManager: NewTransactionManager(),
 		indexManager: NewIndexManager(),
+		workload: NewWorkload(),
+		adaptive: NewAdaptiveHashIndexes(bloom),
 	}
 }
 
@@ -59,3 +66,19 @@ func (s *Storage) AbortTransaction(tx *Transaction) error {
 func (s *Storage) GetIndexManager() *IndexManager {
 	return s.indexManager
 }
+
+// GetBloomEngine returns the layer holding the per-table Bloom filters
+func (s *Storage) GetBloomEngine() *BloomEngine {
+	return s.bloom
+}
+
+// GetWorkload returns the record of the statements run against the database
+func (s *Storage) GetWorkload() *Workload {
+	return s.workload
+}
+
+// GetAdaptiveHashIndexes returns the hash indexes built automatically for
+// columns that are often looked up but have no index
+func (s *Storage) GetAdaptiveHashIndexes() *AdaptiveHashIndexes {
+	return s.adaptive
+}
@@ -0,0 +1,485 @@
+package storage
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// VectorMetric is the distance function used to compare vectors
+type VectorMetric string
+
+const (
+	VectorMetricL2     VectorMetric = "l2"
+	VectorMetricCosine VectorMetric = "cosine"
+	VectorMetricDot    VectorMetric = "dot"
+)
+
+// HNSW construction and search parameters
+const (
+	defaultHNSWM              = 16
+	defaultHNSWEfConstruction = 100
+	defaultHNSWEfSearch       = 50
+	hnswSeed                  = 42
+)
+
+// ParseVectorMetric returns the metric with the given name
+func ParseVectorMetric(name string) (VectorMetric, error) {
+	switch VectorMetric(strings.ToLower(name)) {
+	case VectorMetricL2:
+		return VectorMetricL2, nil
+	case VectorMetricCosine:
+		return VectorMetricCosine, nil
+	case VectorMetricDot:
+		return VectorMetricDot, nil
+	default:
+		return "", fmt.Errorf("unknown vector metric: %s (expected l2, cosine or dot)", name)
+	}
+}
+
+// VectorDistance computes the distance between two vectors of the same
+// length. Smaller is closer for every metric: dot product distance is the
+// negated inner product and cosine distance is one minus cosine similarity.
+func VectorDistance(metric VectorMetric, a, b []float64) float64 {
+	switch metric {
+	case VectorMetricCosine:
+		var dot, normA, normB float64
+		for i := range a {
+			dot += a[i] * b[i]
+			normA += a[i] * a[i]
+			normB += b[i] * b[i]
+		}
+		if normA == 0 || normB == 0 {
+			return 1
+		}
+		return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB))
+	case VectorMetricDot:
+		var dot float64
+		for i := range a {
+			dot += a[i] * b[i]
+		}
+		return -dot
+	default:
+		var sum float64
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return math.Sqrt(sum)
+	}
+}
+
+// FormatVector renders a vector as text, such as "[1,0.5,-2]"
+func FormatVector(vector []float64) string {
+	parts := make([]string, len(vector))
+	for i, v := range vector {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// ParseVector parses a vector written as numbers inside square brackets,
+// separated by commas or spaces
+func ParseVector(text string) ([]float64, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "[") || !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("invalid vector: %s", text)
+	}
+	fields := strings.FieldsFunc(text[1:len(text)-1], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	vector := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector component: %s", field)
+		}
+		vector[i] = v
+	}
+	return vector, nil
+}
+
+// VectorMatch is a vector found by a nearest-neighbour search
+type VectorMatch struct {
+	Key      string
+	Distance float64
+}
+
+// hnswNode is a vector in the HNSW graph with its neighbours on each level
+// it belongs to. Deleted nodes stay in the graph to keep it navigable but are
+// never returned.
+type hnswNode struct {
+	Key       string    `json:"key"`
+	Vector    []float64 `json:"vector"`
+	Neighbors [][]int   `json:"neighbors"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// VectorIndex is an approximate nearest-neighbour index using a hierarchical
+// navigable small world (HNSW) graph. Entries are keyed by row key.
+type VectorIndex struct {
+	dimensions     int
+	metric         VectorMetric
+	m              int
+	efConstruction int
+	nodes          []*hnswNode
+	byKey          map[string]int
+	entry          int
+	maxLevel       int
+	rng            *rand.Rand
+	mu             sync.RWMutex
+}
+
+// NewVectorIndex creates an empty vector index
+func NewVectorIndex(dimensions int, metric VectorMetric) *VectorIndex {
+	return &VectorIndex{
+		dimensions:     dimensions,
+		metric:         metric,
+		m:              defaultHNSWM,
+		efConstruction: defaultHNSWEfConstruction,
+		byKey:          make(map[string]int),
+		entry:          -1,
+		rng:            rand.New(rand.NewSource(hnswSeed)),
+	}
+}
+
+// Dimensions returns the number of components of the indexed vectors
+func (vi *VectorIndex) Dimensions() int {
+	return vi.dimensions
+}
+
+// Metric returns the distance metric the graph is built for
+func (vi *VectorIndex) Metric() VectorMetric {
+	return vi.metric
+}
+
+// Insert indexes the vector written in value for the row key, replacing any
+// vector previously indexed for it. Values that are not vectors of the
+// index's dimensions are ignored.
+func (vi *VectorIndex) Insert(key string, value []byte) {
+	vector, err := ParseVector(string(value))
+	if err != nil {
+		return
+	}
+	vi.Add(key, vector)
+}
+
+// Add indexes a vector for the row key, replacing any vector previously
+// indexed for it
+func (vi *VectorIndex) Add(key string, vector []float64) error {
+	if len(vector) != vi.dimensions {
+		return fmt.Errorf("expected a vector of %d dimensions, got %d", vi.dimensions, len(vector))
+	}
+
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	vi.remove(key)
+
+	level := vi.randomLevel()
+	id := len(vi.nodes)
+	node := &hnswNode{
+		Key:       key,
+		Vector:    append([]float64(nil), vector...),
+		Neighbors: make([][]int, level+1),
+	}
+	vi.nodes = append(vi.nodes, node)
+	vi.byKey[key] = id
+
+	if vi.entry < 0 {
+		vi.entry = id
+		vi.maxLevel = level
+		return nil
+	}
+
+	entry := vi.entry
+	for l := vi.maxLevel; l > level; l-- {
+		entry = vi.greedyClosest(vector, entry, l)
+	}
+
+	for l := min(level, vi.maxLevel); l >= 0; l-- {
+		candidates := vi.searchLayer(vector, entry, vi.efConstruction, l)
+		maxNeighbors := vi.m
+		if l == 0 {
+			maxNeighbors = 2 * vi.m
+		}
+
+		for i := 0; i < len(candidates) && i < vi.m; i++ {
+			neighbor := candidates[i].id
+			node.Neighbors[l] = append(node.Neighbors[l], neighbor)
+			other := vi.nodes[neighbor]
+			other.Neighbors[l] = append(other.Neighbors[l], id)
+			if len(other.Neighbors[l]) > maxNeighbors {
+				other.Neighbors[l] = vi.closestNodes(other.Vector, other.Neighbors[l], maxNeighbors)
+			}
+		}
+		entry = candidates[0].id
+	}
+
+	if level > vi.maxLevel {
+		vi.maxLevel = level
+		vi.entry = id
+	}
+	return nil
+}
+
+// randomLevel draws the top level of a new node from an exponentially
+// decaying distribution
+func (vi *VectorIndex) randomLevel() int {
+	return int(-math.Log(1-vi.rng.Float64()) / math.Log(float64(vi.m)))
+}
+
+// Search returns the vector indexed for a row key, as text
+func (vi *VectorIndex) Search(key string) ([]byte, bool) {
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+
+	id, exists := vi.byKey[key]
+	if !exists {
+		return nil, false
+	}
+	return []byte(FormatVector(vi.nodes[id].Vector)), true
+}
+
+// Delete removes the vector indexed for a row key
+func (vi *VectorIndex) Delete(key string) bool {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+	return vi.remove(key)
+}
+
+func (vi *VectorIndex) remove(key string) bool {
+	id, exists := vi.byKey[key]
+	if !exists {
+		return false
+	}
+	vi.nodes[id].Deleted = true
+	delete(vi.byKey, key)
+	return true
+}
+
+// GetAll returns every indexed row key with its vector as text
+func (vi *VectorIndex) GetAll() []KeyValue {
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+
+	result := make([]KeyValue, 0, len(vi.byKey))
+	for key, id := range vi.byKey {
+		result = append(result, KeyValue{Key: key, Value: []byte(FormatVector(vi.nodes[id].Vector))})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// Size returns the number of indexed vectors
+func (vi *VectorIndex) Size() int {
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+	return len(vi.byKey)
+}
+
+// DeletedCount returns the number of deleted vectors still held by the graph
+func (vi *VectorIndex) DeletedCount() int {
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+	return len(vi.nodes) - len(vi.byKey)
+}
+
+// Nearest returns up to k indexed vectors closest to query, closest first.
+// The search is approximate.
+func (vi *VectorIndex) Nearest(query []float64, k int) ([]VectorMatch, error) {
+	if len(query) != vi.dimensions {
+		return nil, fmt.Errorf("expected a query vector of %d dimensions, got %d", vi.dimensions, len(query))
+	}
+
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+
+	if vi.entry < 0 || k <= 0 {
+		return nil, nil
+	}
+
+	entry := vi.entry
+	for l := vi.maxLevel; l > 0; l-- {
+		entry = vi.greedyClosest(query, entry, l)
+	}
+
+	// Deleted nodes take up room in the candidate list, so widen the search
+	// until enough live vectors are found
+	ef := max(defaultHNSWEfSearch, k)
+	for {
+		var matches []VectorMatch
+		for _, c := range vi.searchLayer(query, entry, ef, 0) {
+			node := vi.nodes[c.id]
+			if node.Deleted {
+				continue
+			}
+			matches = append(matches, VectorMatch{Key: node.Key, Distance: c.distance})
+			if len(matches) == k {
+				break
+			}
+		}
+		if len(matches) == k || len(matches) == len(vi.byKey) || ef >= len(vi.nodes) {
+			return matches, nil
+		}
+		ef *= 2
+	}
+}
+
+// greedyClosest walks a level of the graph from entry towards query and
+// returns the closest node found
+func (vi *VectorIndex) greedyClosest(query []float64, entry, level int) int {
+	best := entry
+	bestDistance := VectorDistance(vi.metric, query, vi.nodes[entry].Vector)
+	for changed := true; changed; {
+		changed = false
+		for _, neighbor := range vi.nodes[best].Neighbors[level] {
+			d := VectorDistance(vi.metric, query, vi.nodes[neighbor].Vector)
+			if d < bestDistance {
+				best = neighbor
+				bestDistance = d
+				changed = true
+			}
+		}
+	}
+	return best
+}
+
+// searchLayer performs a best-first search of one level starting at entry
+// and returns up to ef nodes closest to query, closest first
+func (vi *VectorIndex) searchLayer(query []float64, entry, ef, level int) []vectorCandidate {
+	start := vectorCandidate{id: entry, distance: VectorDistance(vi.metric, query, vi.nodes[entry].Vector)}
+	visited := map[int]bool{entry: true}
+	candidates := &candidateHeap{items: []vectorCandidate{start}}
+	results := &candidateHeap{items: []vectorCandidate{start}, farthestFirst: true}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(vectorCandidate)
+		if results.Len() >= ef && current.distance > results.items[0].distance {
+			break
+		}
+		node := vi.nodes[current.id]
+		if level >= len(node.Neighbors) {
+			continue
+		}
+		for _, neighbor := range node.Neighbors[level] {
+			if visited[neighbor] {
+				continue
+			}
+			visited[neighbor] = true
+			d := VectorDistance(vi.metric, query, vi.nodes[neighbor].Vector)
+			if results.Len() < ef || d < results.items[0].distance {
+				heap.Push(candidates, vectorCandidate{id: neighbor, distance: d})
+				heap.Push(results, vectorCandidate{id: neighbor, distance: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sort.Slice(results.items, func(i, j int) bool {
+		return results.items[i].distance < results.items[j].distance
+	})
+	return results.items
+}
+
+// closestNodes returns the n nodes of ids closest to vector
+func (vi *VectorIndex) closestNodes(vector []float64, ids []int, n int) []int {
+	candidates := make([]vectorCandidate, len(ids))
+	for i, id := range ids {
+		candidates[i] = vectorCandidate{id: id, distance: VectorDistance(vi.metric, vector, vi.nodes[id].Vector)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	closest := make([]int, 0, n)
+	for i := 0; i < n && i < len(candidates); i++ {
+		closest = append(closest, candidates[i].id)
+	}
+	return closest
+}
+
+// vectorSnapshot is the persisted form of a vector index
+type vectorSnapshot struct {
+	Dimensions     int          `json:"dimensions"`
+	Metric         VectorMetric `json:"metric"`
+	M              int          `json:"m"`
+	EfConstruction int          `json:"ef_construction"`
+	Entry          int          `json:"entry"`
+	MaxLevel       int          `json:"max_level"`
+	Nodes          []*hnswNode  `json:"nodes"`
+}
+
+// Snapshot serializes the index graph so it can be reloaded without being
+// rebuilt
+func (vi *VectorIndex) Snapshot() ([]byte, error) {
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+
+	return json.Marshal(vectorSnapshot{
+		Dimensions:     vi.dimensions,
+		Metric:         vi.metric,
+		M:              vi.m,
+		EfConstruction: vi.efConstruction,
+		Entry:          vi.entry,
+		MaxLevel:       vi.maxLevel,
+		Nodes:          vi.nodes,
+	})
+}
+
+// LoadVectorIndex restores a vector index from a snapshot
+func LoadVectorIndex(data []byte) (*VectorIndex, error) {
+	var snapshot vectorSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid vector index snapshot: %w", err)
+	}
+
+	vi := NewVectorIndex(snapshot.Dimensions, snapshot.Metric)
+	vi.m = snapshot.M
+	vi.efConstruction = snapshot.EfConstruction
+	vi.entry = snapshot.Entry
+	vi.maxLevel = snapshot.MaxLevel
+	vi.nodes = snapshot.Nodes
+	for id, node := range vi.nodes {
+		if !node.Deleted {
+			vi.byKey[node.Key] = id
+		}
+	}
+	if vi.entry >= len(vi.nodes) {
+		return nil, fmt.Errorf("invalid vector index snapshot: entry point out of range")
+	}
+	return vi, nil
+}
+
+// vectorCandidate is a node considered during a graph search
+type vectorCandidate struct {
+	id       int
+	distance float64
+}
+
+// candidateHeap orders candidates closest first, or farthest first when
+// farthestFirst is set
+type candidateHeap struct {
+	items         []vectorCandidate
+	farthestFirst bool
+}
+
+func (h *candidateHeap) Len() int { return len(h.items) }
+func (h *candidateHeap) Less(i, j int) bool {
+	if h.farthestFirst {
+		return h.items[i].distance > h.items[j].distance
+	}
+	return h.items[i].distance < h.items[j].distance
+}
+func (h *candidateHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *candidateHeap) Push(x interface{}) { h.items = append(h.items, x.(vectorCandidate)) }
+func (h *candidateHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
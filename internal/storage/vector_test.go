@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func randomVectors(n, dimensions int, seed int64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+	vectors := make([][]float64, n)
+	for i := range vectors {
+		vectors[i] = make([]float64, dimensions)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float64()*2 - 1
+		}
+	}
+	return vectors
+}
+
+func bruteForceNearest(metric VectorMetric, vectors [][]float64, query []float64, k int) []string {
+	matches := make([]VectorMatch, len(vectors))
+	for i, v := range vectors {
+		matches[i] = VectorMatch{Key: fmt.Sprintf("v:%d", i), Distance: VectorDistance(metric, query, v)}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Distance < matches[j].Distance })
+	keys := make([]string, k)
+	for i := range keys {
+		keys[i] = matches[i].Key
+	}
+	return keys
+}
+
+func TestVectorDistance(t *testing.T) {
+	a := []float64{1, 0}
+	b := []float64{0, 2}
+
+	if d := VectorDistance(VectorMetricL2, a, b); math.Abs(d-math.Sqrt(5)) > 1e-9 {
+		t.Fatalf("Expected L2 distance sqrt(5), got %f", d)
+	}
+	if d := VectorDistance(VectorMetricCosine, a, b); math.Abs(d-1) > 1e-9 {
+		t.Fatalf("Expected cosine distance 1 for orthogonal vectors, got %f", d)
+	}
+	if d := VectorDistance(VectorMetricCosine, a, []float64{3, 0}); math.Abs(d) > 1e-9 {
+		t.Fatalf("Expected cosine distance 0 for parallel vectors, got %f", d)
+	}
+	if d := VectorDistance(VectorMetricDot, []float64{1, 2}, []float64{3, 4}); d != -11 {
+		t.Fatalf("Expected dot distance -11, got %f", d)
+	}
+
+	if _, err := ParseVectorMetric("manhattan"); err == nil {
+		t.Fatal("Expected error for an unknown metric")
+	}
+}
+
+func TestParseVector(t *testing.T) {
+	vector, err := ParseVector("[1, -0.5 ,2e3]")
+	if err != nil {
+		t.Fatalf("ParseVector failed: %v", err)
+	}
+	if len(vector) != 3 || vector[0] != 1 || vector[1] != -0.5 || vector[2] != 2000 {
+		t.Fatalf("Unexpected vector: %v", vector)
+	}
+	if FormatVector(vector) != "[1,-0.5,2000]" {
+		t.Fatalf("Unexpected formatted vector: %s", FormatVector(vector))
+	}
+	if _, err := ParseVector("1,2"); err == nil {
+		t.Fatal("Expected error for a vector without brackets")
+	}
+	if _, err := ParseVector("[1,x]"); err == nil {
+		t.Fatal("Expected error for a non-numeric component")
+	}
+}
+
+func TestVectorIndexRecall(t *testing.T) {
+	for _, metric := range []VectorMetric{VectorMetricL2, VectorMetricCosine, VectorMetricDot} {
+		vectors := randomVectors(500, 8, 1)
+		index := NewVectorIndex(8, metric)
+		for i, v := range vectors {
+			if err := index.Add(fmt.Sprintf("v:%d", i), v); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+
+		found, total := 0, 0
+		for _, query := range randomVectors(20, 8, 2) {
+			expected := make(map[string]bool)
+			for _, key := range bruteForceNearest(metric, vectors, query, 10) {
+				expected[key] = true
+			}
+			matches, err := index.Nearest(query, 10)
+			if err != nil {
+				t.Fatalf("Nearest failed: %v", err)
+			}
+			for i, match := range matches {
+				if i > 0 && match.Distance < matches[i-1].Distance {
+					t.Fatalf("Results are not ordered by distance: %v", matches)
+				}
+				if expected[match.Key] {
+					found++
+				}
+			}
+			total += 10
+		}
+
+		if recall := float64(found) / float64(total); recall < 0.9 {
+			t.Fatalf("Recall@10 with %s metric is %.2f, expected at least 0.9", metric, recall)
+		}
+	}
+}
+
+func TestVectorIndexUpdateAndDelete(t *testing.T) {
+	index := NewVectorIndex(2, VectorMetricL2)
+	index.Insert("p:1", []byte("[0,0]"))
+	index.Insert("p:2", []byte("[1,1]"))
+	index.Insert("p:3", []byte("[5,5]"))
+	index.Insert("p:bad", []byte("[1,2,3]"))
+
+	if index.Size() != 3 {
+		t.Fatalf("Expected 3 vectors, got %d", index.Size())
+	}
+
+	index.Insert("p:3", []byte("[0.1,0.1]"))
+	matches, _ := index.Nearest([]float64{0, 0}, 2)
+	if len(matches) != 2 || matches[0].Key != "p:1" || matches[1].Key != "p:3" {
+		t.Fatalf("Expected the moved vector to be second closest, got %v", matches)
+	}
+
+	if !index.Delete("p:1") {
+		t.Fatal("Delete should report an indexed vector")
+	}
+	matches, _ = index.Nearest([]float64{0, 0}, 5)
+	if len(matches) != 2 || matches[0].Key != "p:3" {
+		t.Fatalf("Deleted vector should not be returned, got %v", matches)
+	}
+	if index.DeletedCount() != 2 {
+		t.Fatalf("Expected 2 deleted graph nodes, got %d", index.DeletedCount())
+	}
+
+	if _, err := index.Nearest([]float64{0}, 1); err == nil {
+		t.Fatal("Expected error for a query of the wrong dimensions")
+	}
+}
+
+func TestVectorIndexSnapshot(t *testing.T) {
+	vectors := randomVectors(100, 4, 3)
+	index := NewVectorIndex(4, VectorMetricCosine)
+	for i, v := range vectors {
+		index.Add(fmt.Sprintf("v:%d", i), v)
+	}
+	index.Delete("v:7")
+
+	data, err := index.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	restored, err := LoadVectorIndex(data)
+	if err != nil {
+		t.Fatalf("LoadVectorIndex failed: %v", err)
+	}
+
+	if restored.Size() != 99 || restored.Metric() != VectorMetricCosine || restored.Dimensions() != 4 {
+		t.Fatalf("Restored index differs: size %d, metric %s, dimensions %d", restored.Size(), restored.Metric(), restored.Dimensions())
+	}
+
+	query := vectors[42]
+	want, _ := index.Nearest(query, 5)
+	got, _ := restored.Nearest(query, 5)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d matches, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Key != want[i].Key {
+			t.Fatalf("Restored index returned %v, expected %v", got, want)
+		}
+	}
+
+	im := NewIndexManager()
+	if err := im.RestoreVectorIndex("emb_idx", data); err != nil {
+		t.Fatalf("RestoreVectorIndex failed: %v", err)
+	}
+	matches, err := im.SearchNearest("emb_idx", query, 1)
+	if err != nil || len(matches) != 1 || matches[0].Key != "v:42" {
+		t.Fatalf("Expected v:42 from the index manager, got %v (%v)", matches, err)
+	}
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxWorkloadShapes is the most query shapes a workload keeps. Once full, the
+// shape seen least recently is forgotten to make room for a new one.
+const maxWorkloadShapes = 1000
+
+// ShapePredicate is a column a query filters on and how
+type ShapePredicate struct {
+	Column      string  `json:"column"`
+	Operator    string  `json:"operator"`    // "=" for an equality, "range" for a comparison
+	Selectivity float64 `json:"selectivity"` // estimated fraction of rows matched, as of the last run
+}
+
+// QueryShape is a statement with its literals removed, together with the
+// figures gathered each time a statement of that shape ran
+type QueryShape struct {
+	Fingerprint string           `json:"fingerprint"` // the normalized statement text
+	Kind        string           `json:"kind"`        // SELECT, INSERT, UPDATE or DELETE
+	Table       string           `json:"table"`
+	Predicates  []ShapePredicate `json:"predicates,omitempty"`
+	Columns     []string         `json:"columns,omitempty"` // columns read, nil when every column is
+	TableRows   float64          `json:"table_rows"`        // estimated rows in the table, as of the last run
+	AccessCost  float64          `json:"access_cost"`       // estimated cost of the access path the last run used
+	Count       int64            `json:"count"`
+	TotalTime   time.Duration    `json:"total_ns"`
+	MaxTime     time.Duration    `json:"max_ns"`
+	LastSeen    time.Time        `json:"last_seen"`
+}
+
+// MeanTime returns the average time a statement of the shape took
+func (q QueryShape) MeanTime() time.Duration {
+	if q.Count == 0 {
+		return 0
+	}
+	return q.TotalTime / time.Duration(q.Count)
+}
+
+// Workload records the shapes of the statements run against a database, how
+// often each ran and how long it took
+type Workload struct {
+	shapes   map[string]*QueryShape
+	recorded int64
+	mu       sync.Mutex
+}
+
+// NewWorkload creates an empty workload
+func NewWorkload() *Workload {
+	return &Workload{
+		shapes: make(map[string]*QueryShape),
+	}
+}
+
+// Record counts one run of a statement. The shape's predicates, columns and
+// estimates replace those recorded before. It returns the number of
+// statements recorded, including those of shapes since forgotten.
+func (w *Workload) Record(shape QueryShape, elapsed time.Duration) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	existing, exists := w.shapes[shape.Fingerprint]
+	if !exists {
+		if len(w.shapes) >= maxWorkloadShapes {
+			w.evictOldest()
+		}
+		existing = &QueryShape{}
+		w.shapes[shape.Fingerprint] = existing
+	}
+	count, total, longest := existing.Count, existing.TotalTime, existing.MaxTime
+	*existing = shape
+	existing.Count = count + 1
+	existing.TotalTime = total + elapsed
+	existing.MaxTime = max(longest, elapsed)
+	existing.LastSeen = time.Now()
+
+	w.recorded++
+	return w.recorded
+}
+
+// evictOldest forgets the shape seen least recently. The caller holds mu.
+func (w *Workload) evictOldest() {
+	oldest := ""
+	for fingerprint, shape := range w.shapes {
+		if oldest == "" || shape.LastSeen.Before(w.shapes[oldest].LastSeen) {
+			oldest = fingerprint
+		}
+	}
+	delete(w.shapes, oldest)
+}
+
+// Shapes returns every recorded shape, most frequent first
+func (w *Workload) Shapes() []QueryShape {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	shapes := make([]QueryShape, 0, len(w.shapes))
+	for _, shape := range w.shapes {
+		shapes = append(shapes, *shape)
+	}
+	sort.Slice(shapes, func(i, j int) bool {
+		if shapes[i].Count != shapes[j].Count {
+			return shapes[i].Count > shapes[j].Count
+		}
+		return shapes[i].Fingerprint < shapes[j].Fingerprint
+	})
+	return shapes
+}
+
+// SetShapes replaces the recorded shapes, such as with those saved before
+// the database was closed
+func (w *Workload) SetShapes(shapes []QueryShape) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.shapes = make(map[string]*QueryShape, len(shapes))
+	w.recorded = 0
+	for i := range shapes {
+		shape := shapes[i]
+		w.shapes[shape.Fingerprint] = &shape
+		w.recorded += shape.Count
+	}
+}
+
+// Clear forgets every recorded shape
+func (w *Workload) Clear() {
+	w.SetShapes(nil)
+}
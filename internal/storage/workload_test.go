@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWorkloadRecord(t *testing.T) {
+	w := NewWorkload()
+	shape := QueryShape{Fingerprint: "SELECT * FROM users WHERE age = ?", Kind: "SELECT", Table: "users"}
+
+	w.Record(shape, 2*time.Millisecond)
+	shape.Predicates = []ShapePredicate{{Column: "age", Operator: "=", Selectivity: 0.1}}
+	w.Record(shape, 4*time.Millisecond)
+	if recorded := w.Record(QueryShape{Fingerprint: "DELETE FROM users", Kind: "DELETE", Table: "users"}, time.Millisecond); recorded != 3 {
+		t.Fatalf("Expected 3 statements recorded, got %d", recorded)
+	}
+
+	shapes := w.Shapes()
+	if len(shapes) != 2 {
+		t.Fatalf("Expected 2 shapes, got %d", len(shapes))
+	}
+	got := shapes[0]
+	if got.Fingerprint != shape.Fingerprint || got.Count != 2 {
+		t.Fatalf("Expected the most frequent shape first, got %+v", got)
+	}
+	if got.TotalTime != 6*time.Millisecond || got.MaxTime != 4*time.Millisecond || got.MeanTime() != 3*time.Millisecond {
+		t.Fatalf("Unexpected timings: total %v, max %v, mean %v", got.TotalTime, got.MaxTime, got.MeanTime())
+	}
+	if len(got.Predicates) != 1 || got.Predicates[0].Column != "age" {
+		t.Fatalf("Expected the latest predicates to be kept, got %+v", got.Predicates)
+	}
+}
+
+func TestWorkloadSetShapes(t *testing.T) {
+	w := NewWorkload()
+	w.SetShapes([]QueryShape{
+		{Fingerprint: "a", Count: 5},
+		{Fingerprint: "b", Count: 2},
+	})
+	if recorded := w.Record(QueryShape{Fingerprint: "b"}, 0); recorded != 8 {
+		t.Fatalf("Expected restored counts to carry over, got %d recorded", recorded)
+	}
+	if shapes := w.Shapes(); shapes[1].Count != 3 {
+		t.Fatalf("Expected shape b to have run 3 times, got %d", shapes[1].Count)
+	}
+
+	w.Clear()
+	if len(w.Shapes()) != 0 {
+		t.Fatal("Clear should forget every shape")
+	}
+}
+
+func TestWorkloadEvictsOldestShape(t *testing.T) {
+	w := NewWorkload()
+	start := time.Now().Add(-time.Hour)
+	var shapes []QueryShape
+	for i := 0; i < maxWorkloadShapes; i++ {
+		shapes = append(shapes, QueryShape{Fingerprint: fmt.Sprintf("q%d", i), Count: 1, LastSeen: start.Add(time.Duration(i) * time.Second)})
+	}
+	w.SetShapes(shapes)
+	w.Record(QueryShape{Fingerprint: "q0"}, 0)
+	w.Record(QueryShape{Fingerprint: "new"}, 0)
+
+	seen := make(map[string]bool)
+	for _, shape := range w.Shapes() {
+		seen[shape.Fingerprint] = true
+	}
+	if len(seen) != maxWorkloadShapes {
+		t.Fatalf("Expected %d shapes, got %d", maxWorkloadShapes, len(seen))
+	}
+	if !seen["q0"] || !seen["new"] || seen["q1"] {
+		t.Fatal("Expected the least recently seen shape to be evicted")
+	}
+}